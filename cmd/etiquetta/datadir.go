@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+var datadirCmd = &cobra.Command{
+	Use:   "datadir",
+	Short: "Manage the data directory",
+}
+
+var datadirMoveCmd = &cobra.Command{
+	Use:   "move <new-dir>",
+	Short: "Move the database, license, GeoIP databases and log to a new data directory",
+	Long: `Moves Etiquetta's files from the data directory (--data) to a new one: the
+database with its WAL file, license.json, the GeoIP city and ASN databases
+and the log. Settings holding paths into the old directory, geoip_path and
+geoip_asn_path, are updated.
+
+The server must be stopped first. Files are copied and verified (checksums
+and a database integrity check) before the originals are removed, so an
+interrupted move leaves the old directory intact. Other files in the old
+directory are listed and left in place. Use --dry-run to see the plan.
+
+Start the server with --data <new-dir> afterwards, and update any service
+definition that passes --data.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDatadirMove,
+}
+
+var datadirDryRun bool
+
+func init() {
+	datadirMoveCmd.Flags().BoolVar(&datadirDryRun, "dry-run", false, "Show what would be moved without changing anything")
+
+	datadirCmd.AddCommand(datadirMoveCmd)
+}
+
+// Files kept in the data directory
+const (
+	databaseFile = "etiquetta.db"
+	licenseFile  = "license.json"
+	geoipFile    = "GeoLite2-City.mmdb"
+	geoipASNFile = "GeoLite2-ASN.mmdb"
+	logFile      = "etiquetta.log"
+	pidFile      = "etiquetta.pid"
+)
+
+// dataFile is one file to move
+type dataFile struct {
+	name     string // relative to the data directory
+	required bool
+}
+
+// dataFiles are moved in this order. The database's shared-memory file
+// isn't copied: SQLite rebuilds it, so it's only removed with the
+// originals.
+var dataFiles = []dataFile{
+	{name: licenseFile},
+	{name: geoipFile},
+	{name: geoipASNFile},
+	{name: logFile},
+	{name: databaseFile, required: true},
+	{name: databaseFile + "-wal"},
+}
+
+// pathSettings hold paths to files that may live in the data directory,
+// with the file's default name there
+var pathSettings = []struct{ key, file string }{
+	{key: "geoip_path", file: geoipFile},
+	{key: "geoip_asn_path", file: geoipASNFile},
+}
+
+// removedFiles are deleted from the old directory after a move
+var removedFiles = []string{databaseFile + "-shm", pidFile}
+
+func runDatadirMove(cmd *cobra.Command, args []string) {
+	oldDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		log.Fatalf("Invalid data directory: %v", err)
+	}
+	newDir, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatalf("Invalid new directory: %v", err)
+	}
+	if err := checkMoveTarget(oldDir, newDir); err != nil {
+		log.Fatalf("Cannot move: %v", err)
+	}
+	if err := checkServerStopped(oldDir); err != nil {
+		log.Fatalf("Cannot move: %v", err)
+	}
+
+	// Fold the WAL into the database first, so the copy doesn't depend on
+	// the WAL being copied at the same instant
+	db, err := database.New(filepath.Join(oldDir, databaseFile))
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	var busy, logFrames, checkpointed int
+	if err := db.Conn().QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil || busy != 0 {
+		db.Close()
+		log.Fatalf("Cannot move: the database is in use (checkpoint busy=%d, err=%v); stop the server first", busy, err)
+	}
+	storedPaths := make(map[string]string, len(pathSettings))
+	settingsSvc := settings.New(db.Conn())
+	for _, p := range pathSettings {
+		storedPaths[p.key], _ = settingsSvc.Get(p.key)
+	}
+	db.Close()
+
+	files := make([]string, 0, len(dataFiles)+1)
+	for _, f := range dataFiles {
+		if !fileExists(filepath.Join(oldDir, f.name)) {
+			if f.required {
+				log.Fatalf("Cannot move: %s not found in %s", f.name, oldDir)
+			}
+			continue
+		}
+		files = append(files, f.name)
+	}
+
+	// The GeoIP databases may live anywhere. When a path setting is in the
+	// old directory, it's pointed at the new one, and a file under another
+	// name is moved too.
+	newPaths := make(map[string]string, len(pathSettings))
+	for _, p := range pathSettings {
+		stored := storedPaths[p.key]
+		abs, err := filepath.Abs(stored)
+		if stored == "" || err != nil {
+			continue
+		}
+		if rel, ok := relativeTo(oldDir, abs); ok {
+			newPaths[p.key] = filepath.Join(newDir, rel)
+			if rel != p.file && fileExists(abs) && !slices.Contains(files, rel) {
+				files = append([]string{rel}, files...)
+			}
+		}
+	}
+	leftovers := unknownEntries(oldDir, files)
+
+	fmt.Printf("Moving data directory\n  from: %s\n  to:   %s\n\n", oldDir, newDir)
+	for _, name := range files {
+		fmt.Printf("  move     %s\n", name)
+	}
+	for _, p := range pathSettings {
+		if path, ok := newPaths[p.key]; ok {
+			fmt.Printf("  setting  %s = %s\n", p.key, path)
+		}
+	}
+	for _, name := range leftovers {
+		fmt.Printf("  keep     %s (not an Etiquetta file; move it yourself if needed)\n", name)
+	}
+	if datadirDryRun {
+		fmt.Println("\nDry run: nothing was changed.")
+		return
+	}
+
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", newDir, err)
+	}
+	for _, name := range files {
+		if err := copyVerified(filepath.Join(oldDir, name), filepath.Join(newDir, name)); err != nil {
+			log.Fatalf("Failed to copy %s: %v. The old directory is unchanged; remove %s before retrying.", name, err, newDir)
+		}
+	}
+
+	if err := checkMovedDatabase(filepath.Join(newDir, databaseFile), newPaths); err != nil {
+		log.Fatalf("The copied database failed verification: %v. The old directory is unchanged; remove %s before retrying.", err, newDir)
+	}
+
+	for _, name := range append(files, removedFiles...) {
+		if err := os.Remove(filepath.Join(oldDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: failed to remove %s from the old directory: %v", name, err)
+		}
+	}
+
+	fmt.Printf("\nMoved %d file(s). Start the server with --data %s\n", len(files), newDir)
+}
+
+// checkMoveTarget refuses moves onto an existing installation or into a
+// directory nested in the old one, or the other way round
+func checkMoveTarget(oldDir, newDir string) error {
+	if oldDir == newDir {
+		return errors.New("the new directory is the current data directory")
+	}
+	if _, ok := relativeTo(oldDir, newDir); ok {
+		return errors.New("the new directory is inside the current data directory")
+	}
+	if _, ok := relativeTo(newDir, oldDir); ok {
+		return errors.New("the current data directory is inside the new directory")
+	}
+	for _, f := range dataFiles {
+		if fileExists(filepath.Join(newDir, f.name)) {
+			return fmt.Errorf("%s already contains %s", newDir, f.name)
+		}
+	}
+	return nil
+}
+
+// checkServerStopped refuses to move while a server uses the directory:
+// one started with --detach leaves a PID file, and a foreground one is
+// found by asking the listen address for its health
+func checkServerStopped(oldDir string) error {
+	if raw, err := os.ReadFile(filepath.Join(oldDir, pidFile)); err == nil {
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+		if process, err := os.FindProcess(pid); pid > 0 && err == nil && process.Signal(syscall.Signal(0)) == nil {
+			return fmt.Errorf("the server is running (PID %d); stop it first", pid)
+		}
+	}
+
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/health")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var health map[string]interface{}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&health) == nil {
+		if _, ok := health["latest_schema_version"]; ok {
+			return fmt.Errorf("an Etiquetta server is answering on %s; stop it first, or pass the --listen of the server using this directory", listenAddr)
+		}
+	}
+	return nil
+}
+
+// copyVerified copies a file, syncs it to disk and compares checksums
+func copyVerified(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	srcHash := sha256.New()
+	if _, err := io.Copy(out, io.TeeReader(in, srcHash)); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	dstSum, err := fileChecksum(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(srcHash.Sum(nil), dstSum) {
+		return errors.New("checksum mismatch after copy")
+	}
+	return nil
+}
+
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// checkMovedDatabase runs SQLite's integrity check on the copy and points
+// the path settings in newPaths at the moved files
+func checkMovedDatabase(path string, newPaths map[string]string) error {
+	db, err := database.New(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.Conn().QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	settingsSvc := settings.New(db.Conn())
+	for key, value := range newPaths {
+		if err := settingsSvc.Set(key, value); err != nil {
+			return fmt.Errorf("updating %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// unknownEntries lists what else is in the directory, which isn't moved
+func unknownEntries(dir string, moving []string) []string {
+	known := make(map[string]bool)
+	for _, name := range removedFiles {
+		known[name] = true
+	}
+	for _, name := range moving {
+		known[name] = true
+	}
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, e := range entries {
+		if !known[e.Name()] {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+// relativeTo returns path relative to dir when path is inside dir
+func relativeTo(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
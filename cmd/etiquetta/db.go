@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Database maintenance",
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the database file for corruption",
+	Long: `Runs SQLite's integrity check, which reads every page of the database
+looking for damage (e.g. from power loss or failing storage), and its foreign
+key check, which finds rows referring to rows that no longer exist.
+
+Nothing is changed, and the server can keep running, though the check takes
+a while on large databases. Exits with status 1 when the database is corrupt;
+foreign key violations alone are reported as warnings.`,
+	Run: runDBCheck,
+}
+
+func init() {
+	dbCmd.AddCommand(dbCheckCmd)
+}
+
+func runDBCheck(cmd *cobra.Command, args []string) {
+	path := dataDir + "/etiquetta.db"
+	if _, err := os.Stat(path); err != nil {
+		log.Fatalf("%s not found; pass --data", path)
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	fmt.Printf("Checking %s...\n", path)
+	report, err := db.CheckIntegrity(context.Background())
+	if err != nil {
+		log.Fatalf("Check failed: %v", err)
+	}
+
+	if report.OK {
+		fmt.Println("No problems found.")
+		return
+	}
+	if len(report.Problems) > 0 {
+		fmt.Printf("\nIntegrity problems:\n")
+		for _, problem := range report.Problems {
+			fmt.Printf("  %s\n", problem)
+		}
+	}
+	if len(report.ForeignKeyViolations) > 0 {
+		fmt.Printf("\nRows referring to missing rows:\n")
+		for _, v := range report.ForeignKeyViolations {
+			row := "(no rowid)"
+			if v.RowID != nil {
+				row = fmt.Sprintf("rowid %d", *v.RowID)
+			}
+			fmt.Printf("  %s %s -> %s\n", v.Table, row, v.Parent)
+		}
+	}
+	if report.Truncated {
+		fmt.Println("  ... more problems not listed")
+	}
+
+	if report.Corrupt() {
+		fmt.Printf("\nThe database is corrupt. %s\n", report.Recovery)
+		os.Exit(1)
+	}
+	fmt.Println("\nThe database itself is intact; the rows above are leftovers of deleted rows.")
+}
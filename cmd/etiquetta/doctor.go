@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/config"
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/email"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/licensing"
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the configuration without starting the server",
+	Long: `Checks that the database, secret key, GeoIP database, email provider,
+allowed origins, session cookies and license are usable, reporting problems
+that would otherwise only show up once the server is running.
+
+Nothing is changed: pending migrations are reported, not applied. Email is
+checked by connecting to the provider; no message is sent. Exits with status
+1 when any check fails; warnings alone don't fail.`,
+	Run: runDoctor,
+}
+
+// licenseExpiryWarning is how close to expiry a license is reported
+const licenseExpiryWarning = 30 * 24 * time.Hour
+
+// doctorReport prints check results and counts problems
+type doctorReport struct {
+	errors   int
+	warnings int
+}
+
+func (r *doctorReport) ok(check, format string, args ...interface{}) {
+	fmt.Printf("  OK     %-16s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(check, format string, args ...interface{}) {
+	r.warnings++
+	fmt.Printf("  WARN   %-16s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(check, format string, args ...interface{}) {
+	r.errors++
+	fmt.Printf("  ERROR  %-16s %s\n", check, fmt.Sprintf(format, args...))
+}
+
+func runDoctor(cmd *cobra.Command, args []string) {
+	report := &doctorReport{}
+
+	fmt.Println("Etiquetta Doctor")
+	fmt.Println("================")
+	fmt.Printf("Data directory: %s\n\n", dataDir)
+
+	if settingsSvc, db := checkDatabase(report); db != nil {
+		checkSecretKey(report, settingsSvc)
+		checkGeoIP(report, settingsSvc)
+		checkEmail(report, settingsSvc)
+		checkAllowedOrigins(report, settingsSvc)
+		checkCookies(report, settingsSvc)
+		db.Close()
+	}
+	checkLicense(report)
+
+	fmt.Printf("\n%d error(s), %d warning(s)\n", report.errors, report.warnings)
+	if report.errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkDatabase opens the database and compares its schema with this
+// binary's. The settings checks need it, so they're skipped when it fails.
+func checkDatabase(report *doctorReport) (*settings.Service, *database.DB) {
+	path := dataDir + "/etiquetta.db"
+	if _, err := os.Stat(path); err != nil {
+		report.fail("database", "%s not found; run 'etiquetta init' or pass --data", path)
+		return nil, nil
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		report.fail("database", "cannot open %s: %v", path, err)
+		return nil, nil
+	}
+
+	current, err := db.SchemaVersion()
+	latest := database.LatestVersion()
+	switch {
+	case err != nil:
+		report.fail("database", "cannot read schema version: %v", err)
+	case current > latest:
+		report.fail("database", "schema v%d is newer than this binary supports (v%d); the server will refuse to start", current, latest)
+	case current < latest:
+		report.warn("database", "schema v%d, %d migration(s) pending; they run when the server starts (see 'etiquetta migrate status')", current, latest-current)
+	default:
+		report.ok("database", "schema v%d, up to date", current)
+	}
+
+	settingsSvc := settings.New(db.Conn())
+	if secretKey, _ := settingsSvc.Get("secret_key"); secretKey != "" {
+		settingsSvc.SetMasterKey(secretKey)
+	}
+	return settingsSvc, db
+}
+
+func checkSecretKey(report *doctorReport, settingsSvc *settings.Service) {
+	secretKey, err := settingsSvc.Get("secret_key")
+	switch {
+	case err != nil:
+		report.fail("secret key", "cannot read: %v", err)
+	case secretKey == "":
+		report.warn("secret key", "not set; the server will generate one when it starts")
+	default:
+		report.ok("secret key", "present")
+	}
+}
+
+func checkGeoIP(report *doctorReport, settingsSvc *settings.Service) {
+	path := settingsSvc.GetWithDefault("geoip_path", dataDir+"/GeoLite2-City.mmdb")
+	if _, err := os.Stat(path); err != nil {
+		report.warn("geoip", "%s not found; visitors won't be located (run 'etiquetta geoip download')", path)
+		return
+	}
+	geo, err := enrichment.NewGeoIP(path)
+	if err != nil {
+		report.fail("geoip", "cannot open %s: %v", path, err)
+		return
+	}
+	geo.Close()
+	report.ok("geoip", "%s", path)
+}
+
+func checkEmail(report *doctorReport, settingsSvc *settings.Service) {
+	sender := email.NewFromSettings(settingsSvc)
+	if !sender.Enabled() {
+		report.ok("email", "disabled; invitations and alerts won't be emailed")
+		return
+	}
+	if err := sender.Check(); err != nil {
+		report.fail("email", "%s: %v", sender.Provider, err)
+		return
+	}
+	report.ok("email", "%s reachable", sender.Provider)
+}
+
+func checkAllowedOrigins(report *doctorReport, settingsSvc *settings.Service) {
+	origins := config.ParseAllowedOrigins(settingsSvc.GetWithDefault("allowed_origins", "*"))
+	if _, err := config.NewOriginMatcher(origins); err != nil {
+		report.fail("allowed origins", "%v", err)
+		return
+	}
+	switch {
+	case len(origins) == 0:
+		report.warn("allowed origins", "empty; browsers can't send events from any site")
+	case origins[0] == "*":
+		report.warn("allowed origins", "any site can send events; list your sites' origins in production")
+	default:
+		report.ok("allowed origins", "%s", strings.Join(origins, ", "))
+	}
+}
+
+func checkCookies(report *doctorReport, settingsSvc *settings.Service) {
+	opts := cookieOptions(settingsSvc)
+	if err := opts.Validate(); err != nil {
+		report.fail("cookies", "%v; the server will refuse to start", err)
+		return
+	}
+	switch {
+	case strings.EqualFold(opts.SameSite, "none") && !opts.Secure:
+		report.warn("cookies", "SameSite=None requires Secure; the server forces Secure cookies")
+	case !opts.Secure:
+		report.warn("cookies", "not Secure; enable cookie_secure when the dashboard is served over https")
+	default:
+		report.ok("cookies", "Secure, SameSite=%s", opts.SameSite)
+	}
+}
+
+func checkLicense(report *doctorReport) {
+	manager := licensing.NewManager(dataDir + "/license.json")
+	license := manager.GetLicense()
+
+	switch manager.GetState() {
+	case licensing.StateMissing:
+		report.ok("license", "none; running %s", licensing.TierCommunity)
+	case licensing.StateTampered:
+		report.fail("license", "license.json is invalid or its signature doesn't match; running %s", licensing.TierCommunity)
+	case licensing.StateExpired:
+		report.warn("license", "expired; running %s", licensing.TierCommunity)
+	default:
+		if remaining := time.Until(license.ExpiresAt); remaining < licenseExpiryWarning {
+			report.warn("license", "%s, expires %s", license.Type, license.ExpiresAt.Format("2006-01-02"))
+			return
+		}
+		report.ok("license", "%s, licensed to %s until %s", license.Type, license.Licensee, license.ExpiresAt.Format("2006-01-02"))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+var errorsCmd = &cobra.Command{
+	Use:   "errors",
+	Short: "JavaScript error maintenance",
+}
+
+var errorsRehashCmd = &cobra.Command{
+	Use:   "rehash",
+	Short: "Recompute error hashes for stored errors",
+	Long: `Recomputes error_hash, which groups errors in the errors report, for every
+stored error. Versions before the fix hashed line numbers incorrectly, so
+distinct errors could be merged; run this once after upgrading.`,
+	Run: runErrorsRehash,
+}
+
+var rehashBatchSize int
+
+func init() {
+	errorsRehashCmd.Flags().IntVar(&rehashBatchSize, "batch-size", 1000, "Rows to update per transaction")
+
+	errorsCmd.AddCommand(errorsRehashCmd)
+}
+
+func runErrorsRehash(cmd *cobra.Command, args []string) {
+	db, err := database.New(dataDir + "/etiquetta.db")
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	fmt.Println("Rehashing errors...")
+	result, err := enrichment.RehashErrors(db.Conn(), rehashBatchSize, func(p enrichment.RehashProgress) {
+		fmt.Printf("  %d processed, %d changed\n", p.Processed, p.Changed)
+	})
+	if err != nil {
+		log.Fatalf("Rehash failed after %d error(s): %v", result.Processed, err)
+	}
+
+	fmt.Printf("Rehashed %d error(s); %d changed.\n", result.Processed, result.Changed)
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
 	"github.com/caioricciuti/etiquetta/internal/geoip"
 	"github.com/caioricciuti/etiquetta/internal/settings"
 )
@@ -70,7 +74,23 @@ func runGeoIPDownload(cmd *cobra.Command, args []string) {
 	fmt.Printf("Destination: %s\n", geoipPath)
 
 	downloader := geoip.NewDownloader(accountID, licenseKey, dataDir)
-	if err := downloader.Download(); err != nil {
+	downloader.Path = geoipPath
+
+	progress := make(chan geoip.Progress, 16)
+	printed := make(chan struct{})
+	downloader.Progress = progress
+	go func() {
+		defer close(printed)
+		for p := range progress {
+			printGeoIPProgress(p)
+		}
+	}()
+
+	err := downloader.Download()
+	close(progress)
+	<-printed
+	fmt.Println()
+	if err != nil {
 		log.Fatalf("Download failed: %v", err)
 	}
 
@@ -80,6 +100,19 @@ func runGeoIPDownload(cmd *cobra.Command, args []string) {
 	fmt.Println("GeoIP database downloaded successfully!")
 }
 
+// printGeoIPProgress renders a single-line progress indicator
+func printGeoIPProgress(p geoip.Progress) {
+	const mb = 1024 * 1024
+	switch {
+	case p.Phase != geoip.PhaseDownloading:
+		fmt.Printf("\r%-60s", strings.ToUpper(p.Phase[:1])+p.Phase[1:]+"...")
+	case p.Total > 0:
+		fmt.Printf("\rDownloading: %.1f / %.1f MB (%d%%)%10s", float64(p.Downloaded)/mb, float64(p.Total)/mb, p.Downloaded*100/p.Total, "")
+	default:
+		fmt.Printf("\rDownloading: %.1f MB%20s", float64(p.Downloaded)/mb, "")
+	}
+}
+
 func runGeoIPStatus(cmd *cobra.Command, args []string) {
 	db, settingsSvc := initSettingsService()
 	defer db.Close()
@@ -165,3 +198,68 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// GeoIP auto-update: how often the database's age is checked, and the age
+// at which it's downloaded again
+const (
+	geoipUpdateCheckEvery = 7 * 24 * time.Hour
+	geoipMaxAge           = 30 * 24 * time.Hour
+)
+
+// runGeoIPAutoUpdate keeps the GeoIP database fresh while geoip_auto_update
+// is on, checking at startup and then weekly. Settings are re-read on each
+// check, so turning it on or adding credentials needs no restart. Returns
+// once ctx is cancelled, after stopping any download in progress.
+func runGeoIPAutoUpdate(ctx context.Context, settingsSvc *settings.Service, enricher *enrichment.Enricher, dataDir string) {
+	ticker := time.NewTicker(geoipUpdateCheckEvery)
+	defer ticker.Stop()
+	for {
+		updateGeoIPIfStale(ctx, settingsSvc, enricher, dataDir)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateGeoIPIfStale downloads and loads the GeoIP database when it's
+// missing or older than geoipMaxAge. Failures are logged and retried at the
+// next check.
+func updateGeoIPIfStale(ctx context.Context, settingsSvc *settings.Service, enricher *enrichment.Enricher, dataDir string) {
+	if !settingsSvc.GetBool("geoip_auto_update", false) {
+		return
+	}
+	accountID, _ := settingsSvc.Get("maxmind_account_id")
+	licenseKey, _ := settingsSvc.Get("maxmind_license_key")
+	if accountID == "" || licenseKey == "" {
+		log.Printf("[geoip] Auto-update is on, but MaxMind credentials aren't configured")
+		return
+	}
+
+	downloader := geoip.NewDownloader(accountID, licenseKey, dataDir)
+	downloader.Path = settingsSvc.GetWithDefault("geoip_path", dataDir+"/GeoLite2-City.mmdb")
+	status := downloader.GetStatus()
+	if status.Exists && time.Since(status.LastModified) < geoipMaxAge {
+		return
+	}
+
+	log.Printf("[geoip] Database is missing or older than %d days; downloading", int(geoipMaxAge.Hours()/24))
+	if err := downloader.DownloadContext(ctx); err != nil {
+		if errors.Is(err, geoip.ErrDownloadInProgress) {
+			log.Printf("[geoip] Skipping auto-update: a download started from the UI is still running")
+			return
+		}
+		if ctx.Err() == nil {
+			log.Printf("[geoip] Auto-update failed: %v", err)
+		}
+		return
+	}
+	settingsSvc.Set("geoip_last_updated", time.Now().Format(time.RFC3339))
+
+	if err := enricher.ReloadGeoIP(status.Path); err != nil {
+		log.Printf("[geoip] Downloaded database failed to load: %v", err)
+		return
+	}
+	log.Printf("[geoip] Database updated")
+}
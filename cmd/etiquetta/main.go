@@ -57,6 +57,13 @@ func init() {
 	rootCmd.AddCommand(userCmd)
 	rootCmd.AddCommand(geoipCmd)
 	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(botCmd)
+	rootCmd.AddCommand(errorsCmd)
+	rootCmd.AddCommand(seedCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(datadirCmd)
+	rootCmd.AddCommand(dbCmd)
 }
 
 func main() {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending database migrations",
+	Long: `Applies any pending database migrations.
+
+Migrations also run automatically when the server starts. Use --dry-run to
+preview what would be applied without touching the database.`,
+	Run: runMigrate,
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show current and available schema versions",
+	Run:   runMigrateStatus,
+}
+
+var migrateBackfillReferrersCmd = &cobra.Command{
+	Use:   "backfill-referrers",
+	Short: "Classify referrer_type for events recorded before classification",
+	Long: `Recomputes referrer_type from the stored referrer_url for events where it is
+missing, so historical referrer reports are accurate. Safe to re-run; only
+rows without a referrer_type are touched.`,
+	Run: runMigrateBackfillReferrers,
+}
+
+var (
+	migrateDryRun     bool
+	backfillBatchSize int
+)
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "List pending migrations without applying them")
+	migrateBackfillReferrersCmd.Flags().IntVar(&backfillBatchSize, "batch-size", 1000, "Rows to update per transaction")
+
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateBackfillReferrersCmd)
+}
+
+func runMigrate(cmd *cobra.Command, args []string) {
+	db, err := database.New(dataDir + "/etiquetta.db")
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		log.Fatalf("Cannot migrate: %v", err)
+	}
+
+	if migrateDryRun {
+		if len(pending) == 0 {
+			fmt.Println("Database is up to date.")
+			return
+		}
+		fmt.Printf("%d pending migration(s):\n\n", len(pending))
+		printMigrations(pending, false)
+		return
+	}
+
+	// Migrate also verifies checksums of applied migrations, so run it even
+	// when nothing is pending
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("Database is up to date.")
+		return
+	}
+
+	fmt.Printf("Applied %d migration(s). Schema is now at v%d.\n", len(pending), database.LatestVersion())
+}
+
+func runMigrateStatus(cmd *cobra.Command, args []string) {
+	db, err := database.New(dataDir + "/etiquetta.db")
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+	latest := database.LatestVersion()
+
+	fmt.Println("Migration Status")
+	fmt.Println("================")
+	fmt.Printf("Database version: v%d\n", current)
+	fmt.Printf("Binary supports:  v%d\n", latest)
+
+	switch {
+	case current > latest:
+		fmt.Printf("\nWARNING: the database was migrated by a newer Etiquetta release.\n")
+		fmt.Printf("This binary will refuse to start. Upgrade Etiquetta or restore a backup.\n")
+		return
+	case current == latest:
+		fmt.Println("Status: up to date")
+	default:
+		fmt.Printf("Status: %d migration(s) pending\n", latest-current)
+	}
+	fmt.Println()
+
+	infos, err := db.MigrationStatus()
+	if err != nil {
+		log.Fatalf("Failed to read migrations: %v", err)
+	}
+	printMigrations(infos, true)
+}
+
+func runMigrateBackfillReferrers(cmd *cobra.Command, args []string) {
+	db, err := database.New(dataDir + "/etiquetta.db")
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	fmt.Println("Backfilling referrer_type for historical events...")
+	updated, err := db.BackfillReferrerTypes(enrichment.ClassifyReferrer, backfillBatchSize)
+	if err != nil {
+		log.Fatalf("Backfill failed after %d row(s): %v", updated, err)
+	}
+
+	fmt.Printf("Updated %d event(s).\n", updated)
+}
+
+func printMigrations(infos []database.MigrationInfo, showApplied bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if showApplied {
+		fmt.Fprintln(w, "VERSION\tSTATE\tDESCRIPTION\tROLLBACK")
+	} else {
+		fmt.Fprintln(w, "VERSION\tDESCRIPTION\tROLLBACK")
+	}
+
+	for _, m := range infos {
+		if showApplied {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			if m.Drifted {
+				state = "drifted"
+			}
+			fmt.Fprintf(w, "v%d\t%s\t%s\t%s\n", m.Version, state, m.Description, m.Rollback)
+		} else {
+			fmt.Fprintf(w, "v%d\t%s\t%s\n", m.Version, m.Description, m.Rollback)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/seed"
+)
+
+var seedCmd = &cobra.Command{
+	Use:   "seed",
+	Short: "Fill a database with synthetic events for load testing and demos",
+	Long: `Generates plausible synthetic traffic (pages, referrers, countries, devices,
+UTM campaigns, conversions and a mix of good, suspicious and bad bots) and
+inserts it through the normal batch insert path.
+
+Seeded events go to demo domains under the reserved .example TLD
+(demo1.example, demo2.example, ...), which are registered if missing. This
+command is meant for benchmark and demo instances only: it refuses to run
+against a database that already holds events for other domains unless
+--force is given. Run it with --data pointing at a scratch directory.`,
+	Run: runSeed,
+}
+
+var (
+	seedOpts  = seed.DefaultOptions()
+	seedForce bool
+)
+
+func init() {
+	f := seedCmd.Flags()
+	f.IntVar(&seedOpts.Events, "events", seedOpts.Events, "Total events to insert")
+	f.IntVar(&seedOpts.Domains, "domains", seedOpts.Domains, "Number of demo domains")
+	f.IntVar(&seedOpts.Days, "days", seedOpts.Days, "Spread events over the last N days")
+	f.IntVar(&seedOpts.Pages, "pages", seedOpts.Pages, "Distinct paths per domain")
+	f.Float64Var(&seedOpts.PageviewsPerSess, "pageviews-per-session", seedOpts.PageviewsPerSess, "Mean pageviews per session")
+	f.Float64Var(&seedOpts.BotRatio, "bot-ratio", seedOpts.BotRatio, "Share of sessions from bots (0-1)")
+	f.Float64Var(&seedOpts.CampaignRatio, "campaign-ratio", seedOpts.CampaignRatio, "Share of human sessions with UTM parameters (0-1)")
+	f.Float64Var(&seedOpts.MobileRatio, "mobile-ratio", seedOpts.MobileRatio, "Share of human sessions on phones (0-1)")
+	f.Float64Var(&seedOpts.ConversionRatio, "conversion-ratio", seedOpts.ConversionRatio, "Share of human sessions with a signup event (0-1)")
+	f.IntVar(&seedOpts.BatchSize, "batch-size", seedOpts.BatchSize, "Events per insert batch")
+	f.Int64Var(&seedOpts.Seed, "seed", seedOpts.Seed, "Random seed, for reproducible data")
+	f.BoolVar(&seedForce, "force", false, "Seed even if the database holds real traffic")
+}
+
+func runSeed(cmd *cobra.Command, args []string) {
+	if err := seedOpts.Validate(); err != nil {
+		log.Fatalf("Invalid options: %v", err)
+	}
+
+	db, err := database.New(dataDir + "/etiquetta.db")
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	var realEvents int64
+	if err := db.Conn().QueryRow("SELECT COUNT(*) FROM events WHERE domain NOT LIKE ?", "%"+seed.DomainSuffix).Scan(&realEvents); err != nil {
+		log.Fatalf("Failed to check existing events: %v", err)
+	}
+	if realEvents > 0 && !seedForce {
+		log.Fatalf("Database %s/etiquetta.db holds %d event(s) for non-demo domains; refusing to mix in synthetic data. Use a scratch --data directory, or --force.", dataDir, realEvents)
+	}
+
+	domains := seed.DomainNames(seedOpts.Domains)
+	fmt.Printf("Seeding %d event(s) over %d day(s) into %s...\n", seedOpts.Events, seedOpts.Days, strings.Join(domains, ", "))
+	reported := 0
+	result, err := seed.Run(db, seedOpts, func(p seed.Progress) {
+		if p.Inserted-reported >= 50000 || p.Inserted == seedOpts.Events {
+			fmt.Printf("  %d/%d events\n", p.Inserted, seedOpts.Events)
+			reported = p.Inserted
+		}
+	})
+	if err != nil {
+		log.Fatalf("Seeding failed after %d event(s): %v", result.Inserted, err)
+	}
+
+	fmt.Printf("Inserted %d event(s) in %d session(s).\n", result.Inserted, result.Sessions)
+}
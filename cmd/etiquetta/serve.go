@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log"
@@ -9,22 +11,31 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/caioricciuti/etiquetta/internal/alerting"
 	"github.com/caioricciuti/etiquetta/internal/api"
+	"github.com/caioricciuti/etiquetta/internal/auth"
 	"github.com/caioricciuti/etiquetta/internal/bot"
 	"github.com/caioricciuti/etiquetta/internal/config"
 	"github.com/caioricciuti/etiquetta/internal/database"
 	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
 	"github.com/caioricciuti/etiquetta/internal/licensing"
 	"github.com/caioricciuti/etiquetta/internal/settings"
 	"github.com/caioricciuti/etiquetta/ui"
 )
 
-var detach bool
+var (
+	detach                     bool
+	readOnlyOnMigrationFailure bool
+)
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
@@ -35,6 +46,8 @@ var serveCmd = &cobra.Command{
 
 func init() {
 	serveCmd.Flags().BoolVar(&detach, "detach", false, "Run server in background (detached mode)")
+	serveCmd.Flags().BoolVar(&readOnlyOnMigrationFailure, "read-only-on-migration-failure", false,
+		"Keep serving reports read-only when a migration other than the base schema fails")
 }
 
 func runServe(cmd *cobra.Command, args []string) {
@@ -51,6 +64,9 @@ func runServe(cmd *cobra.Command, args []string) {
 		}
 
 		cmdArgs := []string{"serve", "-d=false", "--data", dataDir, "--listen", listenAddr}
+		if readOnlyOnMigrationFailure {
+			cmdArgs = append(cmdArgs, "--read-only-on-migration-failure")
+		}
 		child := exec.Command(execPath, cmdArgs...)
 
 		// Redirect output to log file
@@ -93,7 +109,19 @@ func runServe(cmd *cobra.Command, args []string) {
 
 	// Run migrations
 	if err := db.Migrate(); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+		if errors.Is(err, database.ErrSchemaTooNew) {
+			log.Fatalf("Refusing to start: %v. Upgrade Etiquetta or restore a backup (see 'etiquetta migrate status').", err)
+		}
+		var migrationErr *database.MigrationError
+		if !errors.As(err, &migrationErr) {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+		logMigrationFailure(migrationErr)
+		if migrationErr.Critical() || !readOnlyOnMigrationFailure {
+			log.Fatalf("Refusing to start with an incomplete schema. Fix the error above and restart, or start with --read-only-on-migration-failure to serve existing reports meanwhile.")
+		}
+		log.Printf("WARNING: starting READ-ONLY: ingest and changes are refused until the migration succeeds")
+		db.SetMigrationFailure(migrationErr)
 	}
 
 	// Initialize settings service
@@ -110,6 +138,7 @@ func runServe(cmd *cobra.Command, args []string) {
 
 	// Load settings into config
 	geoipPath := settingsSvc.GetWithDefault("geoip_path", dataDir+"/GeoLite2-City.mmdb")
+	asnPath := settingsSvc.GetWithDefault("geoip_asn_path", dataDir+"/GeoLite2-ASN.mmdb")
 	allowedOrigins := settingsSvc.GetWithDefault("allowed_origins", "*")
 
 	// Build config from settings and flags
@@ -117,16 +146,54 @@ func runServe(cmd *cobra.Command, args []string) {
 		ListenAddr:            listenAddr,
 		DataDir:               dataDir,
 		GeoIPPath:             geoipPath,
+		GeoIPASNPath:          asnPath,
 		SessionTimeoutMinutes: settingsSvc.GetInt("session_timeout_minutes", 30),
 		TrackPerformance:      settingsSvc.GetBool("track_performance", true),
 		TrackErrors:           settingsSvc.GetBool("track_errors", true),
 		RespectDNT:            settingsSvc.GetBool("respect_dnt", true),
-		AllowedOrigins:        []string{allowedOrigins},
+		TrackDownloads:        settingsSvc.GetBool("track_downloads", true),
+		DownloadExtensions:    parseDownloadExtensions(settingsSvc.GetWithDefault("download_extensions", "")),
+		PerformanceSampleRate: sampleRate(settingsSvc, "performance_sample_rate"),
+		ErrorSampleRate:       sampleRate(settingsSvc, "error_sample_rate"),
+		ErrorMaxPerSession:    settingsSvc.GetInt("error_max_per_session", 5),
+		MaxEventLineBytes:     settingsSvc.GetInt("max_event_line_bytes", config.DefaultMaxEventLineBytes),
+		AllowedOrigins:        config.ParseAllowedOrigins(allowedOrigins),
 		SecretKey:             secretKey,
+		SessionDurationHours:  settingsSvc.GetInt("session_duration_hours", 168),
+	}
+
+	// Bad patterns are skipped rather than fatal so the dashboard stays
+	// reachable to fix the setting
+	if _, err := config.NewOriginMatcher(cfg.AllowedOrigins); err != nil {
+		log.Printf("WARNING: allowed_origins: %v", err)
+	}
+
+	// Extended performance metrics are opt-in, as they add observers to
+	// every page
+	cfg.TrackExtendedPerformance = settingsSvc.GetBool("track_extended_performance", false)
+
+	// Ingest limits per IP and, when set, per site_id
+	cfg.IngestRateLimitPerMin = settingsSvc.GetInt("ingest_rate_limit_per_min", config.DefaultIngestRateLimitPerMin)
+	cfg.IngestSiteRateLimitPerMin = settingsSvc.GetInt("ingest_site_rate_limit_per_min", 0)
+
+	// Multi-instance deployments share rate limits through Redis
+	cfg.RedisURL = os.Getenv("ETIQUETTA_REDIS_URL")
+
+	cookieOpts := cookieOptions(settingsSvc)
+	if err := cookieOpts.Validate(); err != nil {
+		log.Fatalf("Invalid cookie configuration: %v", err)
+	}
+	cfg.CookieSecure, cfg.CookieSameSite, cfg.CookieDomain = cookieOpts.Secure, cookieOpts.SameSite, cookieOpts.Domain
+	if strings.EqualFold(cfg.CookieSameSite, "none") && !cfg.CookieSecure {
+		log.Println("Warning: cookie SameSite=None requires Secure; forcing Secure cookies")
+		cfg.CookieSecure = true
 	}
 
 	// Initialize enrichment service
 	enricher := enrichment.New(cfg.GeoIPPath)
+	if err := enricher.ReloadASN(cfg.GeoIPASNPath); err != nil {
+		log.Printf("Warning: failed to load ASN database %s: %v", cfg.GeoIPASNPath, err)
+	}
 
 	// Initialize license manager
 	licenseManager := licensing.NewManager(cfg.DataDir + "/license.json")
@@ -137,22 +204,27 @@ func runServe(cmd *cobra.Command, args []string) {
 		log.Fatalf("Failed to access embedded UI: %v", err)
 	}
 
-	// Create router
-	router := api.NewRouter(db, enricher, licenseManager, cfg, uiDist)
+	// Mirror ingested events to the domains' forwarding endpoints; what's
+	// still queued is sent on shutdown
+	forwarder := forwarding.New(db.Conn())
+	go forwarder.Start()
 
-	// Start data retention cleanup goroutine
-	go func() {
-		runDataRetention(db, licenseManager)
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			runDataRetention(db, licenseManager)
-		}
-	}()
-
-	// Start bot batch analysis (every 15 minutes)
-	batchAnalyzer := bot.NewBatchAnalyzer(db.Conn(), 15*time.Minute)
-	go batchAnalyzer.Start()
+	// Create router
+	router := api.NewRouter(db, enricher, forwarder, licenseManager, cfg, uiDist)
+
+	// Background jobs write to the database, so they don't run read-only.
+	// The GeoIP updater is waited for on shutdown, so a download in
+	// progress cleans up after itself.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var geoipUpdater sync.WaitGroup
+	if db.MigrationFailure() == nil {
+		startBackgroundJobs(db, licenseManager, settingsSvc)
+		geoipUpdater.Add(1)
+		go func() {
+			defer geoipUpdater.Done()
+			runGeoIPAutoUpdate(jobsCtx, settingsSvc, enricher, cfg.DataDir)
+		}()
+	}
 
 	// Start server
 	server := &http.Server{
@@ -170,6 +242,7 @@ func runServe(cmd *cobra.Command, args []string) {
 		<-sigChan
 
 		log.Println("Shutting down server...")
+		stopJobs()
 		server.Close()
 	}()
 
@@ -180,17 +253,120 @@ func runServe(cmd *cobra.Command, args []string) {
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
+	forwarder.Stop()
+	geoipUpdater.Wait()
+}
+
+// startBackgroundJobs starts data retention, bot batch analysis and alert
+// evaluation
+func startBackgroundJobs(db *database.DB, licenseManager *licensing.Manager, settingsSvc *settings.Service) {
+	// Start data retention cleanup goroutine
+	go func() {
+		runDataRetention(db, licenseManager, settingsSvc)
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			runDataRetention(db, licenseManager, settingsSvc)
+		}
+	}()
+
+	// Start bot batch analysis (every 15 minutes)
+	batchAnalyzer := bot.NewBatchAnalyzer(db.Conn(), 15*time.Minute)
+	go batchAnalyzer.Start()
+
+	// Start alert rule evaluation (every minute)
+	alertEvaluator := alerting.NewEvaluator(db.Conn(), settingsSvc, time.Minute)
+	go alertEvaluator.Start()
+}
+
+// logMigrationFailure explains which migration failed at startup and how
+// to investigate
+func logMigrationFailure(err *database.MigrationError) {
+	log.Printf("ERROR: database migration %d (%s) failed", err.Version, err.Description)
+	log.Printf("  step:   %s", err.Step)
+	log.Printf("  error:  %v", err.Err)
+	log.Printf("  schema: left at v%d; this release needs v%d", err.Version-1, database.LatestVersion())
+	if err.Rollback != "" {
+		log.Printf("  undo:   %s", err.Rollback)
+	}
+	log.Printf("  Run 'etiquetta migrate status' to inspect, then 'etiquetta migrate' to retry once fixed.")
 }
 
-func runDataRetention(db *database.DB, lm *licensing.Manager) {
-	retentionDays := lm.GetLimit("max_retention_days")
-	if retentionDays == -1 {
-		retentionDays = 365 * 10 // 10 years for unlimited
+func runDataRetention(db *database.DB, lm *licensing.Manager, settingsSvc *settings.Service) {
+	// Settings are re-read each run so retention changes apply without a restart
+	maxDays := lm.GetLimit("max_retention_days")
+	policy := database.NewRetentionPolicy(maxDays, settingsSvc.GetInt)
+
+	days, err := db.DomainRetentionOverrides()
+	if err != nil {
+		log.Printf("Data retention cleanup failed: %v", err)
+		return
+	}
+	overrides := make(map[string]database.RetentionPolicy, len(days))
+	for domain, d := range days {
+		overrides[domain] = database.DomainRetentionPolicy(d, maxDays)
 	}
 
-	if err := db.CleanupOldData(retentionDays); err != nil {
+	if err := db.CleanupOldDataPerDomain(policy, overrides); err != nil {
 		log.Printf("Data retention cleanup failed: %v", err)
 	} else {
-		log.Printf("Data retention: cleaned up data older than %d days", retentionDays)
+		log.Printf("Data retention: cleaned up events older than %d days, performance older than %d days, errors older than %d days",
+			policy.Events, policy.Performance, policy.Errors)
+		for domain, p := range overrides {
+			log.Printf("Data retention: cleaned up %s data older than %d days", domain, p.Events)
+		}
+	}
+}
+
+// cookieOptions reads the session cookie attributes from settings. They can
+// be overridden via environment for proxy setups. Secure cookies should only
+// be forced when the browser talks HTTPS to us (directly or via a
+// TLS-terminating proxy).
+func cookieOptions(settingsSvc *settings.Service) auth.CookieOptions {
+	opts := auth.CookieOptions{
+		Secure:   settingsSvc.GetBool("cookie_secure", false),
+		SameSite: settingsSvc.GetWithDefault("cookie_samesite", "lax"),
+		Domain:   settingsSvc.GetWithDefault("cookie_domain", ""),
+	}
+	if v := os.Getenv("ETIQUETTA_SECURE_COOKIES"); v != "" {
+		opts.Secure = v == "true"
+	}
+	if v := os.Getenv("ETIQUETTA_COOKIE_SAMESITE"); v != "" {
+		opts.SameSite = v
+	}
+	if v := os.Getenv("ETIQUETTA_COOKIE_DOMAIN"); v != "" {
+		opts.Domain = v
+	}
+	return opts
+}
+
+// sampleRate reads a sampling rate setting. Values saved before rates were
+// validated that aren't a number in (0, 1] keep every beacon, with a warning.
+func sampleRate(settingsSvc *settings.Service, key string) float64 {
+	raw := settingsSvc.GetWithDefault(key, "")
+	if raw == "" {
+		return 1
+	}
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || rate <= 0 || rate > 1 {
+		log.Printf("Warning: %s must be a number above 0 and at most 1, got %q; sampling is off", key, raw)
+		return 1
+	}
+	return rate
+}
+
+// parseDownloadExtensions reads the comma-separated download_extensions
+// setting, e.g. "pdf, .zip, DMG". Empty means the built-in list.
+func parseDownloadExtensions(raw string) []string {
+	var exts []string
+	for _, ext := range strings.Split(raw, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) == 0 {
+		return config.DefaultDownloadExtensions
 	}
+	return exts
 }
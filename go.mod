@@ -3,16 +3,20 @@ module github.com/caioricciuti/etiquetta
 go 1.24.0
 
 require (
+	github.com/coder/websocket v1.8.13
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-chi/cors v1.2.1
 	github.com/golang-jwt/jwt/v5 v5.3.1
 	github.com/mssola/useragent v1.0.0
 	github.com/oschwald/geoip2-golang v1.11.0
+	github.com/redis/go-redis/v9 v9.7.3
 	golang.org/x/crypto v0.47.0
 	modernc.org/sqlite v1.34.5
 )
 
 require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
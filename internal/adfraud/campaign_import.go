@@ -0,0 +1,376 @@
+package adfraud
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxCampaignImportRows caps the campaigns read from one CSV
+const MaxCampaignImportRows = 1000
+
+// campaignImportFields are the Campaign fields a CSV column can map to
+var campaignImportFields = []string{
+	"name", "utm_source", "utm_medium", "utm_campaign",
+	"cpc", "cpm", "budget", "start_date", "end_date", "currency",
+}
+
+// campaignColumnAliases maps normalized export headers (see normalizeHeader)
+// to fields. Currency suffixes such as "(USD)" are dropped before lookup, so
+// Meta's "CPC (cost per link click) (USD)" matches "cpc".
+var campaignColumnAliases = map[string]string{
+	"campaign":        "name",
+	"campaign name":   "name",
+	"name":            "name",
+	"utm source":      "utm_source",
+	"campaign source": "utm_source",
+	"source":          "utm_source",
+	"utm medium":      "utm_medium",
+	"campaign medium": "utm_medium",
+	"medium":          "utm_medium",
+	"utm campaign":    "utm_campaign",
+	"cpc":             "cpc",
+	"avg cpc":         "cpc",
+	"average cpc":     "cpc",
+	"cost per click":  "cpc",
+	"cpm":             "cpm",
+	"avg cpm":         "cpm",
+	"average cpm":     "cpm",
+	"budget":          "budget",
+	"campaign budget": "budget",
+	"total budget":    "budget",
+	"lifetime budget": "budget",
+	"start date":      "start_date",
+	"start":           "start_date",
+	"starts":          "start_date",
+	"end date":        "end_date",
+	"end":             "end_date",
+	"ends":            "end_date",
+	"currency":        "currency",
+	"currency code":   "currency",
+}
+
+// CampaignImportRow is one data row of an imported CSV. Skip explains rows
+// that are intentionally not imported, such as totals; Err holds
+// validation errors.
+type CampaignImportRow struct {
+	Line     int
+	Campaign Campaign
+	Skip     string
+	Err      error
+}
+
+// ParseCampaignCSV reads campaigns from an ad platform export (Google Ads,
+// Microsoft Ads, Meta Ads or a hand-made sheet). Columns are matched by
+// common header names; mapping (field → header) overrides the match for any
+// of name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget,
+// start_date, end_date and currency. Report title lines above the header are
+// skipped, and comma, semicolon and tab delimiters are detected. Amounts are
+// read in major units and converted to the currency's minor units; without a
+// currency column, a code in the headers such as "Budget (EUR)" is used,
+// else DefaultCurrency.
+func ParseCampaignCSV(r io.Reader, mapping map[string]string) ([]CampaignImportRow, error) {
+	overrides := make(map[string]string, len(mapping))
+	for field, header := range mapping {
+		if !isCampaignImportField(field) {
+			return nil, fmt.Errorf("unknown campaign field %q", field)
+		}
+		overrides[normalizeHeader(header)] = field
+	}
+
+	br := bufio.NewReader(r)
+	sample, _ := br.Peek(4096)
+	reader := csv.NewReader(br)
+	reader.Comma = sniffDelimiter(sample)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var columns map[string]int
+	var headerCurrency string
+	rows := make([]CampaignImportRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if columns == nil {
+			// Exports often start with a report title and date range
+			if line > 10 {
+				break
+			}
+			columns = campaignColumns(record, overrides)
+			headerCurrency = currencyFromHeaders(record)
+			continue
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		if len(rows) >= MaxCampaignImportRows {
+			return nil, fmt.Errorf("too many rows; import at most %d campaigns at a time", MaxCampaignImportRows)
+		}
+		rows = append(rows, parseCampaignRecord(line, record, columns, headerCurrency))
+	}
+
+	if columns == nil {
+		return nil, errors.New("no campaign name column found; expected a header such as \"Campaign\" or \"Campaign name\"")
+	}
+	return rows, nil
+}
+
+// UTMKey identifies campaigns that match the same traffic. Campaigns without
+// any UTM values are keyed by name instead.
+func (c *Campaign) UTMKey() string {
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return strings.ToLower(strings.TrimSpace(*s))
+	}
+	key := value(c.UTMSource) + "\x00" + value(c.UTMMedium) + "\x00" + value(c.UTMCampaign)
+	if key == "\x00\x00" {
+		return "name:" + strings.ToLower(strings.TrimSpace(c.Name))
+	}
+	return "utm:" + key
+}
+
+func isCampaignImportField(field string) bool {
+	for _, f := range campaignImportFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// campaignColumns maps fields to column indexes, or returns nil when the
+// record isn't a header with a campaign name column
+func campaignColumns(record []string, overrides map[string]string) map[string]int {
+	columns := make(map[string]int)
+	for i, header := range record {
+		key := normalizeHeader(header)
+		field, ok := overrides[key]
+		if !ok {
+			field, ok = campaignColumnAliases[key]
+			// A field mapped explicitly doesn't also match by alias
+			if ok && mapsField(overrides, field) {
+				ok = false
+			}
+		}
+		if _, taken := columns[field]; ok && !taken {
+			columns[field] = i
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil
+	}
+	return columns
+}
+
+func mapsField(overrides map[string]string, field string) bool {
+	for _, f := range overrides {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHeader lowercases a header, drops parenthesized parts such as
+// currency codes and collapses punctuation, so "Avg. CPC" becomes "avg cpc"
+func normalizeHeader(header string) string {
+	header = strings.TrimPrefix(header, "\ufeff")
+	var b strings.Builder
+	depth := 0
+	for _, r := range strings.ToLower(header) {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// currencyFromHeaders finds an ISO 4217 code in parentheses, as Meta Ads
+// puts in its cost headers, e.g. "Amount spent (EUR)"
+func currencyFromHeaders(record []string) string {
+	for _, header := range record {
+		for _, part := range strings.Split(header, "(")[1:] {
+			code, _, ok := strings.Cut(part, ")")
+			if !ok || len(code) != 3 || code != strings.ToUpper(code) {
+				continue
+			}
+			if c, err := NormalizeCurrency(code); err == nil {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// sniffDelimiter picks the most frequent of comma, semicolon and tab
+func sniffDelimiter(sample []byte) rune {
+	best, bestCount := ',', bytes.Count(sample, []byte(","))
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(sample, []byte(string(d))); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
+
+func isBlankRecord(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCampaignRecord(line int, record []string, columns map[string]int, headerCurrency string) CampaignImportRow {
+	row := CampaignImportRow{Line: line}
+	get := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	optional := func(field string) *string {
+		if v := get(field); v != "" {
+			return &v
+		}
+		return nil
+	}
+
+	c := &row.Campaign
+	c.Name = get("name")
+	if c.Name == "" {
+		row.Err = errors.New("missing campaign name")
+		return row
+	}
+	// Google Ads appends rows such as "Total: Account"
+	if lower := strings.ToLower(c.Name); lower == "total" || strings.HasPrefix(lower, "total:") {
+		row.Skip = "totals row"
+		return row
+	}
+	c.UTMSource = optional("utm_source")
+	c.UTMMedium = optional("utm_medium")
+	c.UTMCampaign = optional("utm_campaign")
+
+	currency := get("currency")
+	if currency == "" {
+		currency = headerCurrency
+	}
+	var err error
+	if c.Currency, err = NormalizeCurrency(currency); err != nil {
+		row.Err = err
+		return row
+	}
+
+	for _, amount := range []struct {
+		field string
+		dest  *float64
+	}{{"cpc", &c.CPC}, {"cpm", &c.CPM}, {"budget", &c.Budget}} {
+		v, err := parseAmount(get(amount.field))
+		if err != nil {
+			row.Err = fmt.Errorf("%s: %v", amount.field, err)
+			return row
+		}
+		*amount.dest = ToMinorUnits(v, c.Currency)
+	}
+
+	for _, date := range []struct {
+		field string
+		dest  **int64
+		end   bool
+	}{{"start_date", &c.StartDate, false}, {"end_date", &c.EndDate, true}} {
+		v := get(date.field)
+		if v == "" {
+			continue
+		}
+		ms, err := parseImportDate(v, date.end)
+		if err != nil {
+			row.Err = fmt.Errorf("%s: %v", date.field, err)
+			return row
+		}
+		*date.dest = &ms
+	}
+	if c.StartDate != nil && c.EndDate != nil && *c.EndDate < *c.StartDate {
+		row.Err = errors.New("end_date is before start_date")
+	}
+	return row
+}
+
+// parseAmount reads a money value as exported, e.g. "$1,234.56", "1.234,56 €"
+// or "--" for none
+func parseAmount(s string) (float64, error) {
+	if s == "" || s == "--" || s == "-" {
+		return 0, nil
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= '0' && r <= '9') || r == '.' || r == ',' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	n := b.String()
+	if strings.Contains(n, "-") {
+		return 0, fmt.Errorf("%q must not be negative", s)
+	}
+
+	// The last of '.' and ',' is the decimal separator, unless commas are
+	// the only separator and each is followed by three digits
+	dot, comma := strings.LastIndex(n, "."), strings.LastIndex(n, ",")
+	if comma > dot && (dot >= 0 || (strings.Count(n, ",") == 1 && len(n)-comma-1 != 3)) {
+		n = strings.ReplaceAll(n[:comma], ".", "") + "." + n[comma+1:]
+	} else {
+		n = strings.ReplaceAll(n, ",", "")
+	}
+
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// importDateLayouts are the date formats accepted in start and end columns
+var importDateLayouts = []string{"2006-01-02", "2006/01/02", "Jan 2, 2006", "January 2, 2006"}
+
+// parseImportDate returns a date as milliseconds, at the start of the day in
+// UTC, or its last millisecond when end is set. RFC3339 times are kept as is.
+func parseImportDate(s string, end bool) (int64, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UnixMilli(), nil
+	}
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if end {
+				t = t.Add(24*time.Hour - time.Millisecond)
+			}
+			return t.UnixMilli(), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a date; use YYYY-MM-DD", s)
+}
@@ -0,0 +1,62 @@
+package adfraud
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultCurrency is used for campaigns created without a currency
+const DefaultCurrency = "USD"
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit isn't a
+// hundredth of the major unit, by number of decimals
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// Money is an amount in a currency's major units, e.g. dollars for USD
+type Money struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// NormalizeCurrency validates an ISO 4217 code, returning it in upper case,
+// or DefaultCurrency when code is empty
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency, nil
+	}
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", fmt.Errorf("currency %q must be a three-letter ISO 4217 code such as USD", code)
+	}
+	return code, nil
+}
+
+// MinorUnitExponent returns how many decimals a currency's minor unit has:
+// 2 for USD (cents), 0 for JPY, 3 for KWD
+func MinorUnitExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// MoneyFromMinor converts an amount in minor units, as campaign costs are
+// stored, rounding to a whole minor unit
+func MoneyFromMinor(minor float64, currency string) Money {
+	return Money{
+		Amount:   math.Round(minor) / math.Pow10(MinorUnitExponent(currency)),
+		Currency: currency,
+	}
+}
+
+// ToMinorUnits converts an amount in major units to minor units
+func ToMinorUnits(major float64, currency string) float64 {
+	return major * math.Pow10(MinorUnitExponent(currency))
+}
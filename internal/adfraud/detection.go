@@ -1,6 +1,7 @@
 package adfraud
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -21,7 +22,20 @@ type FraudSummary struct {
 	HumanClicks       int64         `json:"human_clicks"`
 	BotClickRate      float64       `json:"bot_click_rate"`
 	Signals           []FraudSignal `json:"signals"`
-	EstimatedWaste    float64       `json:"estimated_waste"`
+	EstimatedWaste    []Money       `json:"estimated_waste"` // one entry per campaign currency
+
+	// Clicks counted as fraud under the suspicious policy; BotClickRate is
+	// their share of the clicks the policy classifies
+	FraudClicks int64 `json:"fraud_clicks"`
+	Classification
+}
+
+// FraudOptions narrows and classifies the clicks in a fraud summary
+type FraudOptions struct {
+	// Where is an extra SQL condition on events, e.g. from the stats bot_filter
+	Where string
+	// Suspicious defaults to DefaultSuspiciousPolicy
+	Suspicious SuspiciousPolicy
 }
 
 // Detector handles fraud detection operations
@@ -35,11 +49,15 @@ func NewDetector(db *sql.DB) *Detector {
 }
 
 // GetFraudSummary returns an overview of detected fraud
-func (d *Detector) GetFraudSummary(domain string, days int) (*FraudSummary, error) {
+func (d *Detector) GetFraudSummary(ctx context.Context, domain string, days int, opts FraudOptions) (*FraudSummary, error) {
 	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UnixMilli()
+	if opts.Suspicious == "" {
+		opts.Suspicious = DefaultSuspiciousPolicy
+	}
 
 	summary := &FraudSummary{
-		Signals: make([]FraudSignal, 0),
+		Signals:        make([]FraudSignal, 0),
+		Classification: newClassification(opts.Suspicious),
 	}
 
 	// Get click counts by bot category
@@ -56,9 +74,12 @@ func (d *Detector) GetFraudSummary(domain string, days int) (*FraudSummary, erro
 		query += " AND domain = ?"
 		args = append(args, domain)
 	}
+	if opts.Where != "" {
+		query += " AND " + opts.Where
+	}
 	query += " GROUP BY bot_category"
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -83,23 +104,31 @@ func (d *Detector) GetFraudSummary(domain string, days int) (*FraudSummary, erro
 		}
 	}
 
-	if summary.TotalClicks > 0 {
-		summary.BotClickRate = float64(summary.BotClicks+summary.SuspiciousClicks) / float64(summary.TotalClicks) * 100
+	classified := summary.TotalClicks
+	summary.FraudClicks = summary.BotClicks
+	switch opts.Suspicious {
+	case SuspiciousAsBot:
+		summary.FraudClicks += summary.SuspiciousClicks
+	case SuspiciousSeparate:
+		classified -= summary.SuspiciousClicks
+	}
+	if classified > 0 {
+		summary.BotClickRate = float64(summary.FraudClicks) / float64(classified) * 100
 	}
 
 	// Detect specific fraud patterns
-	summary.Signals = append(summary.Signals, d.detectClickWithoutImpression(domain, cutoff)...)
-	summary.Signals = append(summary.Signals, d.detectCoordinateClustering(domain, cutoff)...)
-	summary.Signals = append(summary.Signals, d.detectEngagementMismatch(domain, cutoff)...)
+	summary.Signals = append(summary.Signals, d.detectClickWithoutImpression(ctx, domain, cutoff)...)
+	summary.Signals = append(summary.Signals, d.detectCoordinateClustering(ctx, domain, cutoff)...)
+	summary.Signals = append(summary.Signals, d.detectEngagementMismatch(ctx, domain, cutoff)...)
 
 	// Calculate estimated waste from campaigns
-	summary.EstimatedWaste = d.calculateWastedSpend(domain, cutoff)
+	summary.EstimatedWaste = d.calculateWastedSpend(ctx, domain, cutoff)
 
 	return summary, nil
 }
 
 // detectClickWithoutImpression finds clicks that don't have a prior pageview in the session
-func (d *Detector) detectClickWithoutImpression(domain string, cutoff int64) []FraudSignal {
+func (d *Detector) detectClickWithoutImpression(ctx context.Context, domain string, cutoff int64) []FraudSignal {
 	query := `
 		SELECT COUNT(DISTINCT e.session_id) as orphan_clicks
 		FROM events e
@@ -133,7 +162,7 @@ func (d *Detector) detectClickWithoutImpression(domain string, cutoff int64) []F
 	}
 
 	var count int64
-	d.db.QueryRow(query, args...).Scan(&count)
+	d.db.QueryRowContext(ctx, query, args...).Scan(&count)
 
 	if count > 0 {
 		return []FraudSignal{{
@@ -147,7 +176,7 @@ func (d *Detector) detectClickWithoutImpression(domain string, cutoff int64) []F
 }
 
 // detectCoordinateClustering finds suspiciously clustered click coordinates
-func (d *Detector) detectCoordinateClustering(domain string, cutoff int64) []FraudSignal {
+func (d *Detector) detectCoordinateClustering(ctx context.Context, domain string, cutoff int64) []FraudSignal {
 	// Look for >10% of clicks at the exact same coordinates
 	query := `
 		SELECT click_x, click_y, COUNT(*) as click_count,
@@ -172,7 +201,7 @@ func (d *Detector) detectCoordinateClustering(domain string, cutoff int64) []Fra
 	}
 	query += " GROUP BY click_x, click_y HAVING pct > 10 LIMIT 5"
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil
 	}
@@ -196,7 +225,7 @@ func (d *Detector) detectCoordinateClustering(domain string, cutoff int64) []Fra
 }
 
 // detectEngagementMismatch finds sessions with clicks but no engagement
-func (d *Detector) detectEngagementMismatch(domain string, cutoff int64) []FraudSignal {
+func (d *Detector) detectEngagementMismatch(ctx context.Context, domain string, cutoff int64) []FraudSignal {
 	query := `
 		SELECT COUNT(DISTINCT session_id) as count
 		FROM events
@@ -232,7 +261,7 @@ func (d *Detector) detectEngagementMismatch(domain string, cutoff int64) []Fraud
 	}
 
 	var count int64
-	d.db.QueryRow(query, args...).Scan(&count)
+	d.db.QueryRowContext(ctx, query, args...).Scan(&count)
 
 	if count > 0 {
 		return []FraudSignal{{
@@ -245,10 +274,11 @@ func (d *Detector) detectEngagementMismatch(domain string, cutoff int64) []Fraud
 	return nil
 }
 
-// calculateWastedSpend estimates money wasted on bot/fraudulent clicks
-func (d *Detector) calculateWastedSpend(domain string, cutoff int64) float64 {
+// calculateWastedSpend estimates money wasted on bot/fraudulent clicks, per
+// campaign currency
+func (d *Detector) calculateWastedSpend(ctx context.Context, domain string, cutoff int64) []Money {
 	query := `
-		SELECT COALESCE(SUM(c.cpc), 0) as waste
+		SELECT c.currency, SUM(c.cpc) as waste
 		FROM events e
 		JOIN campaigns c ON (
 			(c.utm_source IS NULL OR c.utm_source = e.utm_source)
@@ -264,8 +294,20 @@ func (d *Detector) calculateWastedSpend(domain string, cutoff int64) float64 {
 		query += " AND e.domain = ?"
 		args = append(args, domain)
 	}
+	query += " GROUP BY c.currency ORDER BY waste DESC"
 
-	var waste float64
-	d.db.QueryRow(query, args...).Scan(&waste)
-	return waste / 100 // Convert cents to dollars
+	waste := make([]Money, 0)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return waste
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var minor float64
+		if rows.Scan(&currency, &minor) == nil {
+			waste = append(waste, MoneyFromMinor(minor, currency))
+		}
+	}
+	return waste
 }
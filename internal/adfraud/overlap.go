@@ -0,0 +1,91 @@
+package adfraud
+
+import "strings"
+
+// CampaignOverlap describes two campaigns whose UTM matchers can match the
+// same events. Campaign reports don't split shared traffic, so its clicks
+// and spend are counted for both.
+type CampaignOverlap struct {
+	CampaignID   string `json:"campaign_id"`
+	CampaignName string `json:"campaign_name"`
+	OtherID      string `json:"other_id"`
+	OtherName    string `json:"other_name"`
+	// Matcher is what an event needs to be counted for both, e.g.
+	// "utm_source=google, utm_medium=cpc"
+	Matcher string `json:"matcher"`
+	// Identical is set when both campaigns match exactly the same events
+	Identical bool `json:"identical"`
+}
+
+// overlapWith reports whether c and other can match the same events. A
+// campaign matches events equal to each UTM value it sets; unset values
+// match anything, and a campaign without UTM values matches nothing.
+func (c *Campaign) overlapWith(other *Campaign) (CampaignOverlap, bool) {
+	overlap := CampaignOverlap{
+		CampaignID:   c.ID,
+		CampaignName: c.Name,
+		OtherID:      other.ID,
+		OtherName:    other.Name,
+		Identical:    true,
+	}
+	if !c.hasUTM() || !other.hasUTM() {
+		return overlap, false
+	}
+
+	var matcher []string
+	for _, field := range []struct {
+		name string
+		a, b *string
+	}{
+		{"utm_source", c.UTMSource, other.UTMSource},
+		{"utm_medium", c.UTMMedium, other.UTMMedium},
+		{"utm_campaign", c.UTMCampaign, other.UTMCampaign},
+	} {
+		switch {
+		case field.a != nil && field.b != nil:
+			if *field.a != *field.b {
+				return overlap, false
+			}
+			matcher = append(matcher, field.name+"="+*field.a)
+		case field.a != nil:
+			overlap.Identical = false
+			matcher = append(matcher, field.name+"="+*field.a)
+		case field.b != nil:
+			overlap.Identical = false
+			matcher = append(matcher, field.name+"="+*field.b)
+		}
+	}
+	overlap.Matcher = strings.Join(matcher, ", ")
+	return overlap, true
+}
+
+func (c *Campaign) hasUTM() bool {
+	return c.UTMSource != nil || c.UTMMedium != nil || c.UTMCampaign != nil
+}
+
+// FindCampaignOverlaps lists the campaigns in others that overlap c
+func FindCampaignOverlaps(c *Campaign, others []Campaign) []CampaignOverlap {
+	overlaps := make([]CampaignOverlap, 0)
+	for i := range others {
+		if others[i].ID == c.ID {
+			continue
+		}
+		if overlap, ok := c.overlapWith(&others[i]); ok {
+			overlaps = append(overlaps, overlap)
+		}
+	}
+	return overlaps
+}
+
+// CampaignOverlaps lists every pair of stored campaigns that overlap
+func (s *SpendAnalyzer) CampaignOverlaps() ([]CampaignOverlap, error) {
+	campaigns, err := s.ListCampaigns()
+	if err != nil {
+		return nil, err
+	}
+	overlaps := make([]CampaignOverlap, 0)
+	for i := range campaigns {
+		overlaps = append(overlaps, FindCampaignOverlaps(&campaigns[i], campaigns[i+1:])...)
+	}
+	return overlaps, nil
+}
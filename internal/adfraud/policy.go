@@ -0,0 +1,56 @@
+package adfraud
+
+import "github.com/caioricciuti/etiquetta/internal/bot"
+
+// SuspiciousPolicy decides how events in the suspicious bot category count
+// when traffic is split into bot and human
+type SuspiciousPolicy string
+
+const (
+	// SuspiciousAsBot counts suspicious traffic as bot (and as fraud)
+	SuspiciousAsBot SuspiciousPolicy = "bot"
+	// SuspiciousAsHuman counts suspicious traffic as human
+	SuspiciousAsHuman SuspiciousPolicy = "human"
+	// SuspiciousSeparate counts suspicious traffic as neither, leaving it
+	// out of bot and human totals and rates
+	SuspiciousSeparate SuspiciousPolicy = "separate"
+)
+
+// DefaultSuspiciousPolicy matches IsBot, which only flags scores above
+// SuspiciousMaxScore
+const DefaultSuspiciousPolicy = SuspiciousAsHuman
+
+// ParseSuspiciousPolicy validates a policy name
+func ParseSuspiciousPolicy(s string) (SuspiciousPolicy, bool) {
+	switch p := SuspiciousPolicy(s); p {
+	case SuspiciousAsBot, SuspiciousAsHuman, SuspiciousSeparate:
+		return p, true
+	}
+	return "", false
+}
+
+// botCategories returns the SQL list of categories counted as bot
+func (p SuspiciousPolicy) botCategories() string {
+	if p == SuspiciousAsBot {
+		return "('bad_bot', 'good_bot', 'suspicious')"
+	}
+	return "('bad_bot', 'good_bot')"
+}
+
+// humanCategories returns the SQL list of categories counted as human
+func (p SuspiciousPolicy) humanCategories() string {
+	if p == SuspiciousAsHuman {
+		return "('human', 'suspicious')"
+	}
+	return "('human')"
+}
+
+// Classification tells API consumers how bot categories were applied
+type Classification struct {
+	Suspicious SuspiciousPolicy `json:"suspicious_policy"`
+	Thresholds bot.Thresholds   `json:"thresholds"`
+}
+
+func newClassification(p SuspiciousPolicy) Classification {
+	return Classification{Suspicious: p, Thresholds: bot.CategoryThresholds}
+}
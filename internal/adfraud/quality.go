@@ -1,6 +1,7 @@
 package adfraud
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -20,9 +21,55 @@ type SourceQuality struct {
 	QualityScore  int     `json:"quality_score"` // 0-100, higher is better
 }
 
+// DefaultMinVisits is the minimum number of sessions a source needs to be
+// included in the quality report
+const DefaultMinVisits = 10
+
+// QualityWeights tunes how the quality score is derived
+type QualityWeights struct {
+	BotRate              float64 `json:"bot_rate"`               // points lost per % of bot traffic
+	BotScore             float64 `json:"bot_score"`              // points lost per point of avg bot score above 20
+	EngagementBonus      float64 `json:"engagement_bonus"`       // added when avg duration exceeds 30s
+	LowEngagementPenalty float64 `json:"low_engagement_penalty"` // subtracted when avg duration is under 5s
+}
+
+// DefaultQualityWeights are the weights used when none are configured
+var DefaultQualityWeights = QualityWeights{
+	BotRate:              0.5,
+	BotScore:             0.3,
+	EngagementBonus:      5,
+	LowEngagementPenalty: 10,
+}
+
+// QualityOptions controls which sources are reported and how they are scored
+type QualityOptions struct {
+	MinVisits int
+	Weights   QualityWeights
+	// Where is an extra SQL condition on events, e.g. from the stats bot_filter
+	Where string
+	// Suspicious defaults to DefaultSuspiciousPolicy
+	Suspicious SuspiciousPolicy
+}
+
+// SourceQualityReport lists qualifying sources plus how many were left out
+// for having fewer than MinVisits sessions
+type SourceQualityReport struct {
+	Sources         []SourceQuality `json:"sources"`
+	ExcludedSources int64           `json:"excluded_sources"`
+	MinVisits       int             `json:"min_visits"`
+	Weights         QualityWeights  `json:"weights"`
+	Classification
+}
+
 // GetSourceQuality returns traffic quality metrics per UTM source
-func (d *Detector) GetSourceQuality(domain string, days int) ([]SourceQuality, error) {
+func (d *Detector) GetSourceQuality(ctx context.Context, domain string, days int, opts QualityOptions) (*SourceQualityReport, error) {
 	cutoff := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UnixMilli()
+	if opts.MinVisits < 1 {
+		opts.MinVisits = 1
+	}
+	if opts.Suspicious == "" {
+		opts.Suspicious = DefaultSuspiciousPolicy
+	}
 
 	query := `
 		SELECT
@@ -30,8 +77,8 @@ func (d *Detector) GetSourceQuality(domain string, days int) ([]SourceQuality, e
 			COALESCE(utm_medium, '(none)') as utm_medium,
 			COALESCE(utm_campaign, '(none)') as utm_campaign,
 			COUNT(DISTINCT session_id) as total_visits,
-			SUM(CASE WHEN bot_category IN ('bad_bot', 'good_bot') THEN 1 ELSE 0 END) as bot_visits,
-			SUM(CASE WHEN bot_category = 'human' THEN 1 ELSE 0 END) as human_visits,
+			SUM(CASE WHEN bot_category IN `+opts.Suspicious.botCategories()+` THEN 1 ELSE 0 END) as bot_visits,
+			SUM(CASE WHEN bot_category IN `+opts.Suspicious.humanCategories()+` THEN 1 ELSE 0 END) as human_visits,
 			AVG(bot_score) as avg_bot_score,
 			AVG(CASE WHEN page_duration IS NOT NULL THEN page_duration / 1000.0 ELSE NULL END) as avg_duration
 		FROM events
@@ -43,14 +90,28 @@ func (d *Detector) GetSourceQuality(domain string, days int) ([]SourceQuality, e
 		query += " AND domain = ?"
 		args = append(args, domain)
 	}
+	if opts.Where != "" {
+		query += " AND " + opts.Where
+	}
 	query += `
 		GROUP BY utm_source, utm_medium, utm_campaign
-		HAVING total_visits >= 10
+	`
+
+	// Count the low-volume sources hidden by the threshold
+	report := &SourceQualityReport{MinVisits: opts.MinVisits, Weights: opts.Weights, Classification: newClassification(opts.Suspicious)}
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+query+" HAVING total_visits < ?)",
+		append(args, opts.MinVisits)...).Scan(&report.ExcludedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	query += `
+		HAVING total_visits >= ?
 		ORDER BY total_visits DESC
 		LIMIT 50
 	`
 
-	rows, err := d.db.Query(query, args...)
+	rows, err := d.db.QueryContext(ctx, query, append(args, opts.MinVisits)...)
 	if err != nil {
 		return nil, err
 	}
@@ -84,34 +145,35 @@ func (d *Detector) GetSourceQuality(domain string, days int) ([]SourceQuality, e
 		}
 
 		// Calculate quality score (inverse of bot rate + engagement factors)
-		sq.QualityScore = calculateQualityScore(sq)
+		sq.QualityScore = calculateQualityScore(sq, opts.Weights)
 
 		results = append(results, sq)
 	}
 
 	// Get bounce rates separately (requires aggregation)
-	d.populateBounceRates(results, domain, cutoff)
+	d.populateBounceRates(ctx, results, domain, cutoff)
 
-	return results, nil
+	report.Sources = results
+	return report, nil
 }
 
 // calculateQualityScore computes a 0-100 quality score
-func calculateQualityScore(sq SourceQuality) int {
+func calculateQualityScore(sq SourceQuality, w QualityWeights) int {
 	score := 100.0
 
-	// Penalize for bot traffic (-0.5 points per % bot rate)
-	score -= sq.BotRate * 0.5
+	// Penalize for bot traffic (default -0.5 points per % bot rate)
+	score -= sq.BotRate * w.BotRate
 
 	// Penalize for high average bot score
 	if sq.AvgBotScore > 20 {
-		score -= (sq.AvgBotScore - 20) * 0.3
+		score -= (sq.AvgBotScore - 20) * w.BotScore
 	}
 
 	// Bonus for engagement (time on site)
 	if sq.AvgDuration > 30 {
-		score += 5
+		score += w.EngagementBonus
 	} else if sq.AvgDuration < 5 {
-		score -= 10
+		score -= w.LowEngagementPenalty
 	}
 
 	// Clamp to 0-100
@@ -126,7 +188,7 @@ func calculateQualityScore(sq SourceQuality) int {
 }
 
 // populateBounceRates adds bounce rate data to source quality results
-func (d *Detector) populateBounceRates(results []SourceQuality, domain string, cutoff int64) {
+func (d *Detector) populateBounceRates(ctx context.Context, results []SourceQuality, domain string, cutoff int64) {
 	for i := range results {
 		sq := &results[i]
 
@@ -150,7 +212,7 @@ func (d *Detector) populateBounceRates(results []SourceQuality, domain string, c
 		query += " GROUP BY session_id)"
 
 		var bounceRate sql.NullFloat64
-		d.db.QueryRow(query, args...).Scan(&bounceRate)
+		d.db.QueryRowContext(ctx, query, args...).Scan(&bounceRate)
 		if bounceRate.Valid {
 			sq.BounceRate = bounceRate.Float64
 		}
@@ -12,12 +12,13 @@ type Campaign struct {
 	UTMSource   *string   `json:"utm_source,omitempty"`
 	UTMMedium   *string   `json:"utm_medium,omitempty"`
 	UTMCampaign *string   `json:"utm_campaign,omitempty"`
-	CPC         float64   `json:"cpc"`          // Cost per click in cents
-	CPM         float64   `json:"cpm"`          // Cost per 1000 impressions in cents
-	Budget      float64   `json:"budget"`       // Total budget in cents
+	CPC         float64   `json:"cpc"`          // Cost per click in minor units (e.g. cents)
+	CPM         float64   `json:"cpm"`          // Cost per 1000 impressions in minor units
+	Budget      float64   `json:"budget"`       // Total budget in minor units
 	StartDate   *int64    `json:"start_date,omitempty"`
 	EndDate     *int64    `json:"end_date,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
+	Currency    string    `json:"currency"`     // ISO 4217 code of the amounts above
 }
 
 // CampaignReport contains fraud analysis for a campaign
@@ -29,9 +30,9 @@ type CampaignReport struct {
 	SuspiciousClicks int64   `json:"suspicious_clicks"`
 	TotalImpressions int64   `json:"total_impressions"`
 	BotImpressions  int64    `json:"bot_impressions"`
-	TotalSpend      float64  `json:"total_spend"`      // In dollars
-	WastedSpend     float64  `json:"wasted_spend"`     // In dollars
-	ValidSpend      float64  `json:"valid_spend"`      // In dollars
+	TotalSpend      Money    `json:"total_spend"`
+	WastedSpend     Money    `json:"wasted_spend"`
+	ValidSpend      Money    `json:"valid_spend"`
 	FraudRate       float64  `json:"fraud_rate"`       // Percentage
 	ROIImpact       float64  `json:"roi_impact"`       // Percentage loss due to fraud
 }
@@ -132,19 +133,23 @@ func (s *SpendAnalyzer) GetCampaignReport(campaignID string, domain string) (*Ca
 
 	s.db.QueryRow(impQuery, impArgs...).Scan(&report.TotalImpressions, &report.BotImpressions)
 
-	// Calculate spend
+	// Calculate spend in minor units, then convert per the campaign currency
+	var totalSpend, wastedSpend, validSpend float64
 	if campaign.CPC > 0 {
-		report.TotalSpend = float64(report.TotalClicks) * campaign.CPC / 100
-		report.WastedSpend = float64(report.BotClicks+report.SuspiciousClicks) * campaign.CPC / 100
-		report.ValidSpend = float64(report.HumanClicks) * campaign.CPC / 100
+		totalSpend = float64(report.TotalClicks) * campaign.CPC
+		wastedSpend = float64(report.BotClicks+report.SuspiciousClicks) * campaign.CPC
+		validSpend = float64(report.HumanClicks) * campaign.CPC
 	}
 	if campaign.CPM > 0 {
-		impSpend := float64(report.TotalImpressions) * campaign.CPM / 1000 / 100
-		wastedImpSpend := float64(report.BotImpressions) * campaign.CPM / 1000 / 100
-		report.TotalSpend += impSpend
-		report.WastedSpend += wastedImpSpend
-		report.ValidSpend += impSpend - wastedImpSpend
+		impSpend := float64(report.TotalImpressions) * campaign.CPM / 1000
+		wastedImpSpend := float64(report.BotImpressions) * campaign.CPM / 1000
+		totalSpend += impSpend
+		wastedSpend += wastedImpSpend
+		validSpend += impSpend - wastedImpSpend
 	}
+	report.TotalSpend = MoneyFromMinor(totalSpend, campaign.Currency)
+	report.WastedSpend = MoneyFromMinor(wastedSpend, campaign.Currency)
+	report.ValidSpend = MoneyFromMinor(validSpend, campaign.Currency)
 
 	// Calculate fraud rate
 	totalFraudTraffic := report.BotClicks + report.SuspiciousClicks + report.BotImpressions
@@ -154,8 +159,8 @@ func (s *SpendAnalyzer) GetCampaignReport(campaignID string, domain string) (*Ca
 	}
 
 	// Calculate ROI impact
-	if report.TotalSpend > 0 {
-		report.ROIImpact = report.WastedSpend / report.TotalSpend * 100
+	if totalSpend > 0 {
+		report.ROIImpact = wastedSpend / totalSpend * 100
 	}
 
 	return report, nil
@@ -167,12 +172,12 @@ func (s *SpendAnalyzer) GetCampaign(id string) (*Campaign, error) {
 	var startDate, endDate, createdAt sql.NullInt64
 
 	err := s.db.QueryRow(`
-		SELECT id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at
+		SELECT id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at, currency
 		FROM campaigns
 		WHERE id = ?
 	`, id).Scan(
 		&c.ID, &c.Name, &c.UTMSource, &c.UTMMedium, &c.UTMCampaign,
-		&c.CPC, &c.CPM, &c.Budget, &startDate, &endDate, &createdAt,
+		&c.CPC, &c.CPM, &c.Budget, &startDate, &endDate, &createdAt, &c.Currency,
 	)
 	if err != nil {
 		return nil, err
@@ -194,7 +199,7 @@ func (s *SpendAnalyzer) GetCampaign(id string) (*Campaign, error) {
 // ListCampaigns returns all campaigns
 func (s *SpendAnalyzer) ListCampaigns() ([]Campaign, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at
+		SELECT id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at, currency
 		FROM campaigns
 		ORDER BY created_at DESC
 	`)
@@ -210,7 +215,7 @@ func (s *SpendAnalyzer) ListCampaigns() ([]Campaign, error) {
 
 		err := rows.Scan(
 			&c.ID, &c.Name, &c.UTMSource, &c.UTMMedium, &c.UTMCampaign,
-			&c.CPC, &c.CPM, &c.Budget, &startDate, &endDate, &createdAt,
+			&c.CPC, &c.CPM, &c.Budget, &startDate, &endDate, &createdAt, &c.Currency,
 		)
 		if err != nil {
 			continue
@@ -234,6 +239,10 @@ func (s *SpendAnalyzer) ListCampaigns() ([]Campaign, error) {
 
 // CreateCampaign creates a new campaign
 func (s *SpendAnalyzer) CreateCampaign(c *Campaign) error {
+	if c.Currency == "" {
+		c.Currency = DefaultCurrency
+	}
+
 	var startDate, endDate interface{}
 	if c.StartDate != nil {
 		startDate = *c.StartDate
@@ -243,10 +252,10 @@ func (s *SpendAnalyzer) CreateCampaign(c *Campaign) error {
 	}
 
 	_, err := s.db.Exec(`
-		INSERT INTO campaigns (id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO campaigns (id, name, utm_source, utm_medium, utm_campaign, cpc, cpm, budget, start_date, end_date, created_at, currency)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, c.ID, c.Name, c.UTMSource, c.UTMMedium, c.UTMCampaign,
-		c.CPC, c.CPM, c.Budget, startDate, endDate, time.Now().UnixMilli())
+		c.CPC, c.CPM, c.Budget, startDate, endDate, time.Now().UnixMilli(), c.Currency)
 
 	return err
 }
@@ -0,0 +1,158 @@
+package alerting
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+)
+
+// Bot-rate statuses
+const (
+	BotRateOK           = "ok"
+	BotRateSpike        = "spike"             // far above the baseline
+	BotRateInsufficient = "insufficient_data" // too few events to judge
+)
+
+// Bot-rate spike detection parameters. The window's bot rate is compared
+// with the median bot rate of the windows of the same length over the
+// previous botRateBaselinePeriod; the median ignores earlier spikes, so an
+// attack doesn't raise the baseline it's measured against.
+const (
+	DefaultBotRateWindow  = time.Hour
+	MaxBotRateWindow      = 24 * time.Hour
+	botRateBaselinePeriod = 7 * 24 * time.Hour
+	botRateMinEvents      = 20 // fewer events in a window is too noisy
+	botRateMinBuckets     = 6  // baseline windows with enough events
+	botSpikeMinPoints     = 15 // a spike is at least this many points above the baseline
+	botSpikeRatio         = 2  // ...and at least this multiple of it
+)
+
+// BotRateBucket is the events and bots of one window
+type BotRateBucket struct {
+	Start  int64   `json:"start"`
+	End    int64   `json:"end"`
+	Events int64   `json:"events"`
+	Bots   int64   `json:"bots"`
+	Rate   float64 `json:"rate"` // percentage of events flagged as bots
+}
+
+// BotRate is a domain's bot rate over the latest window against its
+// baseline
+type BotRate struct {
+	Domain          string          `json:"domain,omitempty"`
+	Status          string          `json:"status"`
+	Spike           bool            `json:"spike"`
+	WindowMinutes   int             `json:"window_minutes"`
+	Current         BotRateBucket   `json:"current"`
+	BaselineRate    *float64        `json:"baseline_rate"` // nil without enough history
+	BaselineBuckets int             `json:"baseline_buckets"`
+	SpikeRate       *float64        `json:"spike_rate"` // the rate from which the window is a spike
+	Series          []BotRateBucket `json:"series"`     // oldest first, the current window last
+}
+
+// Excess is how many percentage points the bot rate is above its baseline,
+// or 0 when there are too few events to judge
+func (b *BotRate) Excess() float64 {
+	if b.Status == BotRateInsufficient {
+		return 0
+	}
+	return b.Current.Rate - *b.BaselineRate
+}
+
+// CheckBotRate computes the bot rate of the window ending at end and its
+// baseline, for a domain or, with an empty domain, all domains
+func CheckBotRate(db *sql.DB, domain string, end time.Time, window time.Duration) (*BotRate, error) {
+	windowMs := window.Milliseconds()
+	buckets := int(botRateBaselinePeriod / window)
+	endMs := end.UnixMilli()
+	startMs := endMs - int64(buckets+1)*windowMs
+
+	where := "timestamp > ? AND timestamp <= ?"
+	args := []interface{}{endMs, windowMs, startMs, endMs}
+	if domain != "" {
+		where += " AND domain = ?"
+		args = append(args, domain)
+	}
+	rows, err := db.Query(`
+		SELECT (? - timestamp) / ? AS b, COUNT(*), COALESCE(SUM(CASE WHEN is_bot = 1 THEN 1 ELSE 0 END), 0)
+		FROM events
+		WHERE `+where+`
+		GROUP BY b
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Index 0 is the current window, counting back from there
+	series := make([]BotRateBucket, buckets+1)
+	for i := range series {
+		series[i].End = endMs - int64(i)*windowMs
+		series[i].Start = series[i].End - windowMs
+	}
+	for rows.Next() {
+		var i int
+		var events, bots int64
+		if err := rows.Scan(&i, &events, &bots); err != nil {
+			return nil, err
+		}
+		if i >= 0 && i < len(series) {
+			series[i].Events, series[i].Bots = events, bots
+			series[i].Rate = botPercent(bots, events)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rates := make([]float64, 0, buckets)
+	for _, b := range series[1:] {
+		if b.Events >= botRateMinEvents {
+			rates = append(rates, b.Rate)
+		}
+	}
+
+	result := &BotRate{
+		Domain:          domain,
+		Status:          BotRateInsufficient,
+		WindowMinutes:   int(window / time.Minute),
+		Current:         series[0],
+		BaselineBuckets: len(rates),
+		Series:          make([]BotRateBucket, 0, len(series)),
+	}
+	for i := len(series) - 1; i >= 0; i-- {
+		result.Series = append(result.Series, series[i])
+	}
+
+	if len(rates) >= botRateMinBuckets {
+		baseline := median(rates)
+		spikeRate := math.Min(100, math.Max(baseline+botSpikeMinPoints, baseline*botSpikeRatio))
+		result.BaselineRate, result.SpikeRate = &baseline, &spikeRate
+		if result.Current.Events >= botRateMinEvents {
+			result.Spike = result.Current.Rate >= spikeRate
+			result.Status = BotRateOK
+			if result.Spike {
+				result.Status = BotRateSpike
+			}
+		}
+	}
+	return result, nil
+}
+
+func botPercent(bots, events int64) float64 {
+	if events == 0 {
+		return 0
+	}
+	return math.Round(float64(bots)/float64(events)*1000) / 10
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
@@ -0,0 +1,143 @@
+package alerting
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+// Evaluator periodically checks alert rules and notifies on state changes
+type Evaluator struct {
+	db       *sql.DB
+	settings *settings.Service
+	interval time.Duration
+	stopCh   chan struct{}
+
+	lastTrafficCheck time.Time
+}
+
+// NewEvaluator creates a new alert rule evaluator
+func NewEvaluator(db *sql.DB, settingsSvc *settings.Service, interval time.Duration) *Evaluator {
+	return &Evaluator{
+		db:       db,
+		settings: settingsSvc,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the evaluation loop
+func (e *Evaluator) Start() {
+	log.Printf("Starting alert evaluator with %v interval", e.interval)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.evaluate()
+		case <-e.stopCh:
+			log.Println("Stopping alert evaluator")
+			return
+		}
+	}
+}
+
+// Stop halts the evaluation loop
+func (e *Evaluator) Stop() {
+	close(e.stopCh)
+}
+
+// evaluate checks every enabled rule once, and runs the traffic-drop
+// detector every trafficCheckInterval
+func (e *Evaluator) evaluate() {
+	rules, err := ListRules(e.db)
+	if err != nil {
+		log.Printf("[alerting] Failed to load rules: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := e.evaluateRule(rule, now); err != nil {
+			log.Printf("[alerting] Rule %q: %v", rule.Name, err)
+		}
+	}
+
+	if now.Sub(e.lastTrafficCheck) >= trafficCheckInterval {
+		e.lastTrafficCheck = now
+		e.checkTraffic(now)
+	}
+}
+
+// evaluateRule computes the rule's metric and handles ok <-> firing transitions.
+// A rule only fires again once its cooldown has elapsed since the last trigger,
+// so a metric hovering around the threshold doesn't flap notifications.
+func (e *Evaluator) evaluateRule(rule *Rule, now time.Time) error {
+	nowMs := now.UnixMilli()
+	start := now.Add(-time.Duration(rule.WindowMinutes) * time.Minute).UnixMilli()
+
+	value, err := MetricValue(e.db, rule.Metric, rule.Domain, start, nowMs)
+	if err != nil {
+		return err
+	}
+
+	breached := rule.breached(value)
+	newState := rule.State
+	var status string
+
+	switch {
+	case breached && rule.State != StateFiring:
+		cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+		if rule.LastTriggeredAt != nil && now.Sub(time.UnixMilli(*rule.LastTriggeredAt)) < cooldown {
+			break
+		}
+		newState, status = StateFiring, StatusFiring
+	case !breached && rule.State == StateFiring:
+		newState, status = StateOK, StatusResolved
+	}
+
+	if status == StatusFiring {
+		_, err = e.db.Exec(`UPDATE alert_rules SET state = ?, last_value = ?, last_evaluated_at = ?, last_triggered_at = ? WHERE id = ?`,
+			newState, value, nowMs, nowMs, rule.ID)
+	} else {
+		_, err = e.db.Exec(`UPDATE alert_rules SET state = ?, last_value = ?, last_evaluated_at = ? WHERE id = ?`,
+			newState, value, nowMs, rule.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if status == "" {
+		return nil
+	}
+
+	message := transitionMessage(rule, status, value)
+	if _, err := e.db.Exec(`INSERT INTO alert_history (id, rule_id, status, value, threshold, message, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		auth.GenerateID(), rule.ID, status, value, rule.Threshold, message, nowMs); err != nil {
+		log.Printf("[alerting] Failed to record history for %q: %v", rule.Name, err)
+	}
+
+	log.Printf("[alerting] %s", message)
+	e.notify(rule, status, value, now)
+	return nil
+}
+
+func transitionMessage(rule *Rule, status string, value float64) string {
+	scope := "all domains"
+	if rule.Domain != "" {
+		scope = rule.Domain
+	}
+	if status == StatusFiring {
+		return fmt.Sprintf("Alert %q is firing on %s: %s is %.2f (%s)", rule.Name, scope, rule.Metric, value, rule.Describe())
+	}
+	return fmt.Sprintf("Alert %q resolved on %s: %s is %.2f (%s)", rule.Name, scope, rule.Metric, value, rule.Describe())
+}
@@ -0,0 +1,72 @@
+package alerting
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// MetricValue computes a metric for an optional domain over [startMs, endMs]
+func MetricValue(db *sql.DB, metric, domain string, startMs, endMs int64) (float64, error) {
+	where := "timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{startMs, endMs}
+	if domain != "" {
+		where += " AND domain = ?"
+		args = append(args, domain)
+	}
+
+	switch metric {
+	case MetricPageviews:
+		return scalar(db, "SELECT COUNT(*) FROM events WHERE "+where+" AND event_type = 'pageview' AND is_bot = 0", args...)
+
+	case MetricVisitors:
+		return scalar(db, "SELECT COUNT(DISTINCT visitor_hash) FROM events WHERE "+where+" AND is_bot = 0", args...)
+
+	// Error counts are scaled up by each row's sample rate
+	case MetricErrors:
+		return scalar(db, "SELECT COALESCE(SUM(occurrences * "+database.SampleWeightSQL+"), 0) FROM errors WHERE "+where, args...)
+
+	case MetricErrorRate:
+		errs, err := scalar(db, "SELECT COALESCE(SUM(occurrences * "+database.SampleWeightSQL+"), 0) FROM errors WHERE "+where, args...)
+		if err != nil {
+			return 0, err
+		}
+		pageviews, err := scalar(db, "SELECT COUNT(*) FROM events WHERE "+where+" AND event_type = 'pageview' AND is_bot = 0", args...)
+		if err != nil {
+			return 0, err
+		}
+		if pageviews == 0 {
+			return 0, nil
+		}
+		return errs / pageviews * 100, nil
+
+	case MetricBotRate:
+		var total, bots float64
+		err := db.QueryRow("SELECT COUNT(*), COALESCE(SUM(CASE WHEN is_bot = 1 THEN 1 ELSE 0 END), 0) FROM events WHERE "+where, args...).Scan(&total, &bots)
+		if err != nil {
+			return 0, err
+		}
+		if total == 0 {
+			return 0, nil
+		}
+		return bots / total * 100, nil
+
+	case MetricBotRateExcess:
+		end := time.UnixMilli(endMs)
+		rate, err := CheckBotRate(db, domain, end, end.Sub(time.UnixMilli(startMs)))
+		if err != nil {
+			return 0, err
+		}
+		return rate.Excess(), nil
+	}
+
+	return 0, fmt.Errorf("unknown metric %q", metric)
+}
+
+func scalar(db *sql.DB, query string, args ...interface{}) (float64, error) {
+	var v float64
+	err := db.QueryRow(query, args...).Scan(&v)
+	return v, err
+}
@@ -0,0 +1,122 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/email"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
+)
+
+// webhookTimeout bounds how long a slow webhook can hold up evaluation
+const webhookTimeout = 10 * time.Second
+
+// webhookClient delivers webhooks to public addresses only, as URLs come
+// from rules and settings
+var webhookClient = forwarding.PublicClient(webhookTimeout)
+
+// WebhookPayload is the JSON body POSTed to a rule's webhook URL
+type WebhookPayload struct {
+	RuleID    string  `json:"rule_id"`
+	RuleName  string  `json:"rule_name"`
+	Status    string  `json:"status"`
+	Domain    string  `json:"domain,omitempty"`
+	Metric    string  `json:"metric"`
+	Operator  string  `json:"operator"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Window    int     `json:"window_minutes"`
+	Message   string  `json:"message"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// notification is a single message fanned out to email and/or a webhook
+type notification struct {
+	emails     []string
+	webhookURL string
+	subject    string
+	message    string
+	payload    interface{}
+}
+
+// notify sends email and webhook notifications for a rule transition
+func (e *Evaluator) notify(rule *Rule, status string, value float64, at time.Time) {
+	message := transitionMessage(rule, status, value)
+
+	e.send(notification{
+		emails:     rule.NotifyEmails,
+		webhookURL: rule.WebhookURL,
+		subject:    fmt.Sprintf("[Etiquetta] %s: %s", statusLabel(status), rule.Name),
+		message:    message,
+		payload: WebhookPayload{
+			RuleID:    rule.ID,
+			RuleName:  rule.Name,
+			Status:    status,
+			Domain:    rule.Domain,
+			Metric:    rule.Metric,
+			Operator:  rule.Operator,
+			Value:     value,
+			Threshold: rule.Threshold,
+			Window:    rule.WindowMinutes,
+			Message:   message,
+			Timestamp: at.UnixMilli(),
+		},
+	})
+}
+
+// send delivers a notification. Delivery failures are logged and never block
+// the state change that triggered them.
+func (e *Evaluator) send(n notification) {
+	if len(n.emails) > 0 {
+		sender := email.NewFromSettings(e.settings)
+		if !sender.Enabled() {
+			log.Printf("[alerting] Email provider disabled, skipping email %q", n.subject)
+		} else {
+			// Link back to the dashboard when the public URL is known; there
+			// is no request here to derive it from
+			body := n.message + "\n"
+			if base := e.settings.PublicURL(); base != "" {
+				body += "\nOpen Etiquetta: " + base + "/\n"
+			}
+			for _, to := range n.emails {
+				if err := sender.SendMessage(&email.Message{To: to, Subject: n.subject, Body: body}); err != nil {
+					log.Printf("[alerting] Failed to email %s: %v", to, err)
+				}
+			}
+		}
+	}
+
+	if n.webhookURL != "" {
+		if err := postWebhook(n.webhookURL, n.payload); err != nil {
+			log.Printf("[alerting] Webhook %s failed: %v", n.webhookURL, err)
+		}
+	}
+}
+
+func postWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func statusLabel(status string) string {
+	if status == StatusFiring {
+		return "FIRING"
+	}
+	return "RESOLVED"
+}
@@ -0,0 +1,288 @@
+package alerting
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
+)
+
+// Supported metrics
+const (
+	MetricPageviews = "pageviews"  // non-bot pageviews in the window
+	MetricVisitors  = "visitors"   // unique non-bot visitors in the window
+	MetricErrors    = "errors"     // error occurrences in the window
+	MetricErrorRate = "error_rate" // errors per 100 pageviews
+	MetricBotRate   = "bot_rate"   // percentage of events flagged as bots
+
+	// MetricBotRateExcess is the bot rate minus its baseline, in percentage
+	// points; see CheckBotRate
+	MetricBotRateExcess = "bot_rate_excess"
+)
+
+// Rule states
+const (
+	StateOK     = "ok"
+	StateFiring = "firing"
+)
+
+// Alert history statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+var validMetrics = map[string]bool{
+	MetricPageviews: true,
+	MetricVisitors:  true,
+	MetricErrors:    true,
+	MetricErrorRate: true,
+	MetricBotRate:   true,
+
+	MetricBotRateExcess: true,
+}
+
+var validOperators = map[string]string{
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// Rule is a threshold alert on a metric over a trailing window
+type Rule struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Domain          string   `json:"domain,omitempty"`
+	Metric          string   `json:"metric"`
+	Operator        string   `json:"operator"`
+	Threshold       float64  `json:"threshold"`
+	WindowMinutes   int      `json:"window_minutes"`
+	CooldownMinutes int      `json:"cooldown_minutes"`
+	NotifyEmails    []string `json:"notify_emails"`
+	WebhookURL      string   `json:"webhook_url,omitempty"`
+	Enabled         bool     `json:"enabled"`
+	State           string   `json:"state"`
+	LastValue       *float64 `json:"last_value,omitempty"`
+	LastEvaluatedAt *int64   `json:"last_evaluated_at,omitempty"`
+	LastTriggeredAt *int64   `json:"last_triggered_at,omitempty"`
+	CreatedBy       *string  `json:"created_by,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+}
+
+// Validate checks a rule's configuration and fills in defaults
+func (r *Rule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if !validMetrics[r.Metric] {
+		return fmt.Errorf("unknown metric %q", r.Metric)
+	}
+	if _, ok := validOperators[r.Operator]; !ok {
+		return fmt.Errorf("operator must be one of gt, gte, lt, lte")
+	}
+	if r.WindowMinutes <= 0 {
+		r.WindowMinutes = 10
+	}
+	if r.WindowMinutes > 7*24*60 {
+		return errors.New("window_minutes cannot exceed 7 days")
+	}
+	if r.Metric == MetricBotRateExcess && time.Duration(r.WindowMinutes)*time.Minute > MaxBotRateWindow {
+		return errors.New("window_minutes cannot exceed 1 day for bot_rate_excess")
+	}
+	if r.CooldownMinutes < 0 {
+		return errors.New("cooldown_minutes cannot be negative")
+	}
+	if r.WebhookURL != "" {
+		if err := forwarding.ValidateURL(r.WebhookURL); err != nil {
+			return fmt.Errorf("webhook_url: %w", err)
+		}
+	}
+	cleaned := make([]string, 0, len(r.NotifyEmails))
+	for _, e := range r.NotifyEmails {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "@") {
+			return fmt.Errorf("invalid email address %q", e)
+		}
+		cleaned = append(cleaned, e)
+	}
+	r.NotifyEmails = cleaned
+	return nil
+}
+
+// Describe renders the rule condition, e.g. "error_rate > 1 over 10m"
+func (r *Rule) Describe() string {
+	return fmt.Sprintf("%s %s %g over %dm", r.Metric, validOperators[r.Operator], r.Threshold, r.WindowMinutes)
+}
+
+// breached reports whether value crosses the rule's threshold
+func (r *Rule) breached(value float64) bool {
+	switch r.Operator {
+	case "gt":
+		return value > r.Threshold
+	case "gte":
+		return value >= r.Threshold
+	case "lt":
+		return value < r.Threshold
+	case "lte":
+		return value <= r.Threshold
+	}
+	return false
+}
+
+const ruleColumns = `id, name, COALESCE(domain, ''), metric, operator, threshold, window_minutes, cooldown_minutes,
+	COALESCE(notify_emails, ''), COALESCE(webhook_url, ''), is_enabled, state, last_value,
+	last_evaluated_at, last_triggered_at, created_by, created_at, updated_at`
+
+func scanRule(scanner interface{ Scan(...interface{}) error }) (*Rule, error) {
+	var r Rule
+	var emails string
+	var enabled int
+	err := scanner.Scan(&r.ID, &r.Name, &r.Domain, &r.Metric, &r.Operator, &r.Threshold,
+		&r.WindowMinutes, &r.CooldownMinutes, &emails, &r.WebhookURL, &enabled, &r.State,
+		&r.LastValue, &r.LastEvaluatedAt, &r.LastTriggeredAt, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.Enabled = enabled == 1
+	r.NotifyEmails = []string{}
+	if emails != "" {
+		r.NotifyEmails = strings.Split(emails, ",")
+	}
+	return &r, nil
+}
+
+// ListRules returns all alert rules, newest first
+func ListRules(db *sql.DB) ([]*Rule, error) {
+	rows, err := db.Query("SELECT " + ruleColumns + " FROM alert_rules ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]*Rule, 0)
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// GetRule loads a single rule
+func GetRule(db *sql.DB, id string) (*Rule, error) {
+	return scanRule(db.QueryRow("SELECT "+ruleColumns+" FROM alert_rules WHERE id = ?", id))
+}
+
+// CreateRule inserts a validated rule
+func CreateRule(db *sql.DB, r *Rule) error {
+	now := time.Now().UnixMilli()
+	r.State = StateOK
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	_, err := db.Exec(`
+		INSERT INTO alert_rules (id, name, domain, metric, operator, threshold, window_minutes, cooldown_minutes,
+			notify_emails, webhook_url, is_enabled, state, created_by, created_at, updated_at)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?)
+	`, r.ID, r.Name, r.Domain, r.Metric, r.Operator, r.Threshold, r.WindowMinutes, r.CooldownMinutes,
+		strings.Join(r.NotifyEmails, ","), r.WebhookURL, boolToInt(r.Enabled), r.State, r.CreatedBy, r.CreatedAt, r.UpdatedAt)
+	return err
+}
+
+// UpdateRule saves a rule's configuration. Changing the condition resets the
+// rule to ok so it re-evaluates from scratch.
+func UpdateRule(db *sql.DB, r *Rule) (bool, error) {
+	r.UpdatedAt = time.Now().UnixMilli()
+	result, err := db.Exec(`
+		UPDATE alert_rules SET name = ?, domain = NULLIF(?, ''), metric = ?, operator = ?, threshold = ?,
+			window_minutes = ?, cooldown_minutes = ?, notify_emails = NULLIF(?, ''), webhook_url = NULLIF(?, ''),
+			is_enabled = ?, state = ?, updated_at = ?
+		WHERE id = ?
+	`, r.Name, r.Domain, r.Metric, r.Operator, r.Threshold, r.WindowMinutes, r.CooldownMinutes,
+		strings.Join(r.NotifyEmails, ","), r.WebhookURL, boolToInt(r.Enabled), StateOK, r.UpdatedAt, r.ID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// DeleteRule removes a rule and its history
+func DeleteRule(db *sql.DB, id string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM alert_history WHERE rule_id = ?", id); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("DELETE FROM alert_rules WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, tx.Commit()
+}
+
+// HistoryEntry is a single fired/resolved transition
+type HistoryEntry struct {
+	ID        string  `json:"id"`
+	RuleID    string  `json:"rule_id"`
+	RuleName  string  `json:"rule_name"`
+	Status    string  `json:"status"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Message   string  `json:"message"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+// ListHistory returns recent alert transitions, optionally for a single rule
+func ListHistory(db *sql.DB, ruleID string, limit int) ([]HistoryEntry, error) {
+	query := `
+		SELECT h.id, h.rule_id, COALESCE(r.name, ''), h.status, h.value, h.threshold, COALESCE(h.message, ''), h.created_at
+		FROM alert_history h
+		LEFT JOIN alert_rules r ON r.id = h.rule_id`
+	var args []interface{}
+	if ruleID != "" {
+		query += " WHERE h.rule_id = ?"
+		args = append(args, ruleID)
+	}
+	query += " ORDER BY h.created_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.RuleID, &e.RuleName, &e.Status, &e.Value, &e.Threshold, &e.Message, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,257 @@
+package alerting
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
+)
+
+// Traffic statuses
+const (
+	TrafficOK           = "ok"
+	TrafficLow          = "low"               // far below the baseline
+	TrafficZero         = "zero"              // no pageviews at all
+	TrafficInsufficient = "insufficient_data" // baseline too small to judge
+)
+
+// Traffic detection parameters. The baseline is the average pageview count
+// for the same time-of-day window over the previous trafficBaselineDays, which
+// absorbs normal day/night swings.
+const (
+	trafficWindow        = time.Hour
+	trafficCheckInterval = 15 * time.Minute
+	trafficBaselineDays  = 7
+	trafficDropRatio     = 0.2 // recent below 20% of baseline counts as low
+	trafficMinBaseline   = 10  // fewer expected pageviews than this is too noisy
+)
+
+// Settings keys for traffic-drop notifications
+const (
+	TrafficEnabledKey = "traffic_alerts_enabled"
+	TrafficEmailsKey  = "traffic_alert_emails"
+	TrafficWebhookKey = "traffic_alert_webhook"
+)
+
+// ValidateTrafficSettings checks the traffic-drop settings in a settings
+// update. The webhook is held to the same rule as forwarding URLs.
+func ValidateTrafficSettings(updates map[string]string) error {
+	if raw, ok := updates[TrafficEmailsKey]; ok {
+		for _, addr := range strings.Split(raw, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr != "" && (!strings.Contains(addr, "@") || strings.ContainsAny(addr, " \r\n")) {
+				return fmt.Errorf("%s: %q is not an email address", TrafficEmailsKey, addr)
+			}
+		}
+	}
+	if raw, ok := updates[TrafficWebhookKey]; ok && raw != "" {
+		if err := forwarding.ValidateURL(raw); err != nil {
+			return fmt.Errorf("%s: %w", TrafficWebhookKey, err)
+		}
+	}
+	return nil
+}
+
+// TrafficStatus is the latest traffic-drop check for a domain
+type TrafficStatus struct {
+	Domain            string  `json:"domain"`
+	Status            string  `json:"status"`
+	RecentPageviews   int64   `json:"recent_pageviews"`
+	BaselinePageviews float64 `json:"baseline_pageviews"`
+	LastPageviewAt    *int64  `json:"last_pageview_at,omitempty"`
+	ChangedAt         int64   `json:"changed_at"`
+	CheckedAt         int64   `json:"checked_at"`
+}
+
+// Alerting reports whether the status indicates broken or missing tracking
+func (s *TrafficStatus) Alerting() bool {
+	return s.Status == TrafficLow || s.Status == TrafficZero
+}
+
+// TrafficWebhookPayload is the JSON body POSTed for traffic-drop transitions
+type TrafficWebhookPayload struct {
+	Type              string  `json:"type"`
+	Domain            string  `json:"domain"`
+	Status            string  `json:"status"`
+	RecentPageviews   int64   `json:"recent_pageviews"`
+	BaselinePageviews float64 `json:"baseline_pageviews"`
+	WindowMinutes     int     `json:"window_minutes"`
+	Message           string  `json:"message"`
+	Timestamp         int64   `json:"timestamp"`
+}
+
+// ListTrafficStatus returns the latest traffic check for each active domain
+func ListTrafficStatus(db *sql.DB) ([]TrafficStatus, error) {
+	rows, err := db.Query(`
+		SELECT t.domain, t.status, t.recent_pageviews, t.baseline_pageviews, t.last_pageview_at, t.changed_at, t.checked_at
+		FROM traffic_status t
+		JOIN domains d ON d.domain = t.domain AND d.is_active = 1
+		ORDER BY t.domain
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make([]TrafficStatus, 0)
+	for rows.Next() {
+		var s TrafficStatus
+		if err := rows.Scan(&s.Domain, &s.Status, &s.RecentPageviews, &s.BaselinePageviews, &s.LastPageviewAt, &s.ChangedAt, &s.CheckedAt); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
+
+// CheckDomainTraffic compares a domain's pageviews over the last window with
+// its baseline for the same window on previous days
+func CheckDomainTraffic(db *sql.DB, domain string, now time.Time) (*TrafficStatus, error) {
+	status := &TrafficStatus{Domain: domain, CheckedAt: now.UnixMilli()}
+
+	end := now.UnixMilli()
+	start := now.Add(-trafficWindow).UnixMilli()
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM events
+		WHERE timestamp >= ? AND timestamp <= ? AND domain = ? AND event_type = 'pageview' AND is_bot = 0
+	`, start, end, domain).Scan(&status.RecentPageviews)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]string, 0, trafficBaselineDays)
+	args := []interface{}{domain}
+	for d := 1; d <= trafficBaselineDays; d++ {
+		dayEnd := now.AddDate(0, 0, -d)
+		ranges = append(ranges, "(timestamp >= ? AND timestamp <= ?)")
+		args = append(args, dayEnd.Add(-trafficWindow).UnixMilli(), dayEnd.UnixMilli())
+	}
+	var baselineTotal int64
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM events
+		WHERE domain = ? AND event_type = 'pageview' AND is_bot = 0 AND (`+strings.Join(ranges, " OR ")+`)
+	`, args...).Scan(&baselineTotal)
+	if err != nil {
+		return nil, err
+	}
+	status.BaselinePageviews = float64(baselineTotal) / trafficBaselineDays
+
+	db.QueryRow(`
+		SELECT MAX(timestamp) FROM events WHERE domain = ? AND event_type = 'pageview' AND is_bot = 0
+	`, domain).Scan(&status.LastPageviewAt)
+
+	switch {
+	case status.BaselinePageviews < trafficMinBaseline:
+		status.Status = TrafficInsufficient
+	case status.RecentPageviews == 0:
+		status.Status = TrafficZero
+	case float64(status.RecentPageviews) < status.BaselinePageviews*trafficDropRatio:
+		status.Status = TrafficLow
+	default:
+		status.Status = TrafficOK
+	}
+	return status, nil
+}
+
+// checkTraffic runs the traffic-drop detector for every active domain and
+// notifies when a domain starts or stops receiving far less traffic than usual
+func (e *Evaluator) checkTraffic(now time.Time) {
+	if !e.settings.GetBool(TrafficEnabledKey, true) {
+		return
+	}
+
+	rows, err := e.db.Query("SELECT domain FROM domains WHERE is_active = 1")
+	if err != nil {
+		log.Printf("[alerting] Failed to load domains: %v", err)
+		return
+	}
+	var domains []string
+	for rows.Next() {
+		var d string
+		if rows.Scan(&d) == nil {
+			domains = append(domains, d)
+		}
+	}
+	rows.Close()
+
+	for _, domain := range domains {
+		current, err := CheckDomainTraffic(e.db, domain, now)
+		if err != nil {
+			log.Printf("[alerting] Traffic check for %s failed: %v", domain, err)
+			continue
+		}
+
+		var previous string
+		var changedAt int64
+		e.db.QueryRow("SELECT status, changed_at FROM traffic_status WHERE domain = ?", domain).Scan(&previous, &changedAt)
+
+		current.ChangedAt = changedAt
+		if current.Status != previous {
+			current.ChangedAt = current.CheckedAt
+		}
+
+		_, err = e.db.Exec(`
+			INSERT INTO traffic_status (domain, status, recent_pageviews, baseline_pageviews, last_pageview_at, changed_at, checked_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(domain) DO UPDATE SET
+				status = excluded.status,
+				recent_pageviews = excluded.recent_pageviews,
+				baseline_pageviews = excluded.baseline_pageviews,
+				last_pageview_at = excluded.last_pageview_at,
+				changed_at = excluded.changed_at,
+				checked_at = excluded.checked_at
+		`, current.Domain, current.Status, current.RecentPageviews, current.BaselinePageviews,
+			current.LastPageviewAt, current.ChangedAt, current.CheckedAt)
+		if err != nil {
+			log.Printf("[alerting] Failed to save traffic status for %s: %v", domain, err)
+			continue
+		}
+
+		wasAlerting := previous == TrafficLow || previous == TrafficZero
+		switch {
+		case current.Alerting() && !wasAlerting:
+			e.notifyTraffic(current, StatusFiring, now)
+		case current.Status == TrafficOK && wasAlerting:
+			e.notifyTraffic(current, StatusResolved, now)
+		}
+	}
+}
+
+func (e *Evaluator) notifyTraffic(s *TrafficStatus, status string, at time.Time) {
+	var message string
+	if status == StatusFiring {
+		message = fmt.Sprintf("Traffic drop on %s: %d pageviews in the last %d minutes, expected about %.0f. The tracking snippet may have been removed or broken.",
+			s.Domain, s.RecentPageviews, int(trafficWindow/time.Minute), s.BaselinePageviews)
+	} else {
+		message = fmt.Sprintf("Traffic recovered on %s: %d pageviews in the last %d minutes (expected about %.0f).",
+			s.Domain, s.RecentPageviews, int(trafficWindow/time.Minute), s.BaselinePageviews)
+	}
+	log.Printf("[alerting] %s", message)
+
+	var emails []string
+	for _, addr := range strings.Split(e.settings.GetWithDefault(TrafficEmailsKey, ""), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			emails = append(emails, addr)
+		}
+	}
+
+	e.send(notification{
+		emails:     emails,
+		webhookURL: e.settings.GetWithDefault(TrafficWebhookKey, ""),
+		subject:    fmt.Sprintf("[Etiquetta] %s: traffic drop on %s", statusLabel(status), s.Domain),
+		message:    message,
+		payload: TrafficWebhookPayload{
+			Type:              "traffic",
+			Domain:            s.Domain,
+			Status:            status,
+			RecentPageviews:   s.RecentPageviews,
+			BaselinePageviews: s.BaselinePageviews,
+			WindowMinutes:     int(trafficWindow / time.Minute),
+			Message:           message,
+			Timestamp:         at.UnixMilli(),
+		},
+	})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+// Settings keys for the optional dashboard allowlist. Both are lists
+// separated by commas or whitespace; when either is set, only clients in one
+// of the networks or countries may log in or use the authenticated API.
+const (
+	adminAllowedCIDRsKey     = "admin_allowed_cidrs"
+	adminAllowedCountriesKey = "admin_allowed_countries"
+)
+
+// adminAllowlist holds the parsed dashboard allowlist
+type adminAllowlist struct {
+	nets      []*net.IPNet
+	countries map[string]bool
+}
+
+// parseAdminAllowlist parses CIDRs (a bare IP is a single address) and ISO
+// country codes
+func parseAdminAllowlist(cidrs, countries string) (adminAllowlist, error) {
+	list := adminAllowlist{countries: make(map[string]bool)}
+	nets, err := parseCIDRList(adminAllowedCIDRsKey, cidrs)
+	if err != nil {
+		return list, err
+	}
+	list.nets = nets
+	for _, c := range strings.FieldsFunc(countries, isListSeparator) {
+		c = strings.ToUpper(c)
+		if len(c) != 2 || strings.Trim(c, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return list, fmt.Errorf("%s: %q is not a two-letter ISO country code", adminAllowedCountriesKey, c)
+		}
+		list.countries[c] = true
+	}
+	return list, nil
+}
+
+// parseCIDRList parses the CIDR ranges of a list setting; a bare IP is a
+// single address
+func parseCIDRList(key, raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.FieldsFunc(raw, isListSeparator) {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%s: %q is not an IP address or CIDR range", key, s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not an IP address or CIDR range", key, s)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+}
+
+// enabled reports whether the allowlist restricts anything
+func (a adminAllowlist) enabled() bool {
+	return len(a.nets) > 0 || len(a.countries) > 0
+}
+
+// allows reports whether a client IP is in the allowlist. Countries need a
+// GeoIP database; without one they match nothing.
+func (a adminAllowlist) allows(ip string, enricher *enrichment.Enricher) (bool, string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, ""
+	}
+	for _, n := range a.nets {
+		if n.Contains(parsed) {
+			return true, ""
+		}
+	}
+	var country string
+	if len(a.countries) > 0 && enricher != nil {
+		country = enricher.LookupCountry(ip)
+	}
+	return a.countries[country], country
+}
+
+// allowsClient applies the allowlist to a client IP resolved from the
+// connection's peer address. Requests made on the server itself are always
+// allowed so an operator on the host can recover from a bad list: both the
+// peer and the client it reports must be loopback, so a remote client can't
+// claim 127.0.0.1 through a local proxy.
+func (a adminAllowlist) allowsClient(ip, peer string, enricher *enrichment.Enricher) (bool, string) {
+	if isLoopbackIP(ip) && isLoopbackIP(peer) {
+		return true, ""
+	}
+	return a.allows(ip, enricher)
+}
+
+func isLoopbackIP(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.IsLoopback()
+}
+
+// checkAdminAccess is the auth middleware's access filter, enforcing the
+// admin_allowed_cidrs and admin_allowed_countries settings. The client IP is
+// the one realIP resolved, from forwarding headers only behind a trusted
+// proxy.
+func (h *Handlers) checkAdminAccess(r *http.Request) (bool, string) {
+	svc := newSettingsService(h)
+	list, err := parseAdminAllowlist(svc.GetWithDefault(adminAllowedCIDRsKey, ""), svc.GetWithDefault(adminAllowedCountriesKey, ""))
+	if err != nil {
+		// Saved values are validated, so this was edited by hand; fail closed
+		log.Printf("[auth] Invalid dashboard allowlist, denying access: %v", err)
+		return false, "Access denied: the dashboard allowlist is invalid"
+	}
+	if !list.enabled() {
+		return true, ""
+	}
+
+	allowed, country := list.allowsClient(requestClientIP(r), peerIP(r), h.enricher)
+	if !allowed {
+		log.Printf("[auth] Denied dashboard access from %s (country %q)", requestClientIP(r), country)
+		return false, "Access from this location is not allowed"
+	}
+	return true, ""
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// apiKeyUsageFlushInterval is how often counted API key usage is written to
+// api_key_usage. Counts not yet written are lost if the server stops.
+const apiKeyUsageFlushInterval = time.Minute
+
+// Days of usage GetAPIKeyUsage reports by default and at most
+const (
+	defaultAPIKeyUsageDays = 30
+	maxAPIKeyUsageDays     = 365
+)
+
+// apiKeyUsageBucket is one row of api_key_usage
+type apiKeyUsageBucket struct {
+	keyID  string
+	domain string
+	day    string // UTC, YYYY-MM-DD
+}
+
+type apiKeyUsageCount struct {
+	events   int64
+	lastUsed int64
+}
+
+// apiKeyUsage counts events ingested with API keys in memory until the next
+// flush, so ingest doesn't write a row per request. The zero value is ready
+// to use.
+type apiKeyUsage struct {
+	mu      sync.Mutex
+	pending map[apiKeyUsageBucket]*apiKeyUsageCount
+
+	// Held by flush while counts move from pending to the table, and by
+	// reports while they read both, so nothing is counted twice or missed
+	flushing sync.RWMutex
+}
+
+// record counts events ingested with keyID for each domain at t
+func (u *apiKeyUsage) record(keyID string, events map[string]int64, t time.Time) {
+	if len(events) == 0 {
+		return
+	}
+	day := t.UTC().Format("2006-01-02")
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = make(map[apiKeyUsageBucket]*apiKeyUsageCount)
+	}
+	for domain, n := range events {
+		b := apiKeyUsageBucket{keyID: keyID, domain: domain, day: day}
+		c := u.pending[b]
+		if c == nil {
+			c = &apiKeyUsageCount{}
+			u.pending[b] = c
+		}
+		c.events += n
+		c.lastUsed = t.UnixMilli()
+	}
+}
+
+// flush adds the counts so far to api_key_usage in one transaction. On
+// failure they're kept for the next flush.
+func (u *apiKeyUsage) flush(db *database.DB) {
+	u.flushing.Lock()
+	defer u.flushing.Unlock()
+
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = nil
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	err := func() error {
+		tx, err := db.Conn().Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for b, c := range pending {
+			_, err := tx.Exec(`
+				INSERT INTO api_key_usage (key_id, domain, day, events, last_used_at)
+				VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (key_id, domain, day) DO UPDATE SET
+					events = events + excluded.events,
+					last_used_at = MAX(last_used_at, excluded.last_used_at)
+			`, b.keyID, b.domain, b.day, c.events, c.lastUsed)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err == nil {
+		return
+	}
+
+	log.Printf("[api-keys] Failed to save usage of %d key/domain/day buckets, retrying at the next flush: %v", len(pending), err)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.pending == nil {
+		u.pending = pending
+		return
+	}
+	for b, c := range pending {
+		if cur := u.pending[b]; cur != nil {
+			cur.events += c.events
+			cur.lastUsed = max(cur.lastUsed, c.lastUsed)
+		} else {
+			u.pending[b] = c
+		}
+	}
+}
+
+// pendingFor sums the counts not yet flushed per key for a domain, from day
+// since (YYYY-MM-DD) on
+func (u *apiKeyUsage) pendingFor(domain, since string) map[string]apiKeyUsageCount {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := make(map[string]apiKeyUsageCount)
+	for b, c := range u.pending {
+		if b.domain != domain || b.day < since {
+			continue
+		}
+		sum := counts[b.keyID]
+		sum.events += c.events
+		sum.lastUsed = max(sum.lastUsed, c.lastUsed)
+		counts[b.keyID] = sum
+	}
+	return counts
+}
+
+// runAPIKeyUsageFlush writes counted API key usage periodically
+func (h *Handlers) runAPIKeyUsageFlush() {
+	ticker := time.NewTicker(apiKeyUsageFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.apiKeyUsage.flush(h.db)
+	}
+}
+
+// ingestAPIKey resolves the API key an ingest request carries, for usage
+// counting. Ingest doesn't require one, but a key that doesn't resolve is
+// rejected rather than silently not counted.
+func (h *Handlers) ingestAPIKey(r *http.Request) (keyID string, ok bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer "+auth.APIKeyPrefix) {
+		return "", true
+	}
+	claims, err := h.lookupAPIKey(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return "", false
+	}
+	return claims.APIKeyID, true
+}
+
+// countIngestedByDomain counts stored rows per domain
+func countIngestedByDomain(events []*database.Event, perfs []*database.Performance, errs []*database.Error) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, e := range events {
+		counts[e.Domain]++
+	}
+	for _, p := range perfs {
+		counts[p.Domain]++
+	}
+	for _, e := range errs {
+		counts[e.Domain]++
+	}
+	return counts
+}
+
+// apiKeyUsageReport is one API key's ingest for a domain
+type apiKeyUsageReport struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Prefix      string `json:"prefix"`
+	UserEmail   string `json:"user_email"`
+	Events      int64  `json:"events"`        // in the requested days
+	LastEventAt *int64 `json:"last_event_at"` // last ingest for this domain
+	LastUsedAt  *int64 `json:"last_used_at"`  // last use for anything
+}
+
+// GetAPIKeyUsage returns, per API key, the events ingested with it for a
+// domain over the last days (default 30, at most 365) and when it was last
+// used. Keys without events are listed too, to spot unused ones. Admins see
+// every key, others their own.
+func (h *Handlers) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	var domain string
+	if err := h.db.Conn().QueryRow("SELECT domain FROM domains WHERE id = ?", id).Scan(&domain); err != nil {
+		writeError(w, http.StatusNotFound, "Domain not found")
+		return
+	}
+
+	days := defaultAPIKeyUsageDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIKeyUsageDays {
+			writeError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxAPIKeyUsageDays))
+			return
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, 1-days).Format("2006-01-02")
+
+	// The stored totals plus what ingest counted since the last flush; a
+	// read doesn't flush, so it never writes
+	h.apiKeyUsage.flushing.RLock()
+	defer h.apiKeyUsage.flushing.RUnlock()
+
+	query := `
+		SELECT k.id, k.name, k.key_prefix, COALESCE(u.email, ''), k.last_used_at,
+			COALESCE(SUM(CASE WHEN s.day >= ? THEN s.events END), 0), MAX(s.last_used_at)
+		FROM api_keys k
+		LEFT JOIN users u ON u.id = k.user_id
+		LEFT JOIN api_key_usage s ON s.key_id = k.id AND s.domain = ?
+	`
+	args := []interface{}{since, domain}
+	if claims.Role != "admin" {
+		query += " WHERE k.user_id = ?"
+		args = append(args, claims.UserID)
+	}
+	rows, err := h.db.Conn().Query(query+" GROUP BY k.id ORDER BY 6 DESC, k.created_at DESC", args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	pending := h.apiKeyUsage.pendingFor(domain, since)
+	keys := make([]apiKeyUsageReport, 0)
+	for rows.Next() {
+		var k apiKeyUsageReport
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.UserEmail, &k.LastUsedAt, &k.Events, &k.LastEventAt); err != nil {
+			continue
+		}
+		if c, ok := pending[k.ID]; ok {
+			k.Events += c.events
+			if k.LastEventAt == nil || *k.LastEventAt < c.lastUsed {
+				lastUsed := c.lastUsed
+				k.LastEventAt = &lastUsed
+			}
+		}
+		keys = append(keys, k)
+	}
+	// Unflushed counts can change the order
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Events > keys[j].Events })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain": domain,
+		"days":   days,
+		"keys":   keys,
+	})
+}
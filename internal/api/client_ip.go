@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// trustedProxiesKey is the settings key listing the proxies (IP addresses or
+// CIDR ranges, separated by commas or whitespace) whose X-Forwarded-For and
+// X-Real-IP headers are believed. "none" trusts no proxy.
+const trustedProxiesKey = "trusted_proxies"
+
+// defaultTrustedProxies covers a proxy on the same host or private network,
+// such as nginx in front of Etiquetta or a Docker ingress
+const defaultTrustedProxies = "127.0.0.0/8, ::1, 10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, fc00::/7"
+
+type peerIPKey struct{}
+
+// trustedProxies resolves the client IP of each request from the connection
+// and, when the connection comes from a trusted proxy, its forwarding headers
+type trustedProxies struct {
+	nets atomic.Pointer[[]*net.IPNet]
+}
+
+// parseTrustedProxies parses the trusted_proxies setting
+func parseTrustedProxies(raw string) ([]*net.IPNet, error) {
+	if strings.EqualFold(strings.TrimSpace(raw), "none") {
+		return nil, nil
+	}
+	return parseCIDRList(trustedProxiesKey, raw)
+}
+
+// loadTrustedProxies applies the trusted_proxies setting
+func (h *Handlers) loadTrustedProxies() {
+	nets, err := parseTrustedProxies(newSettingsService(h).GetWithDefault(trustedProxiesKey, defaultTrustedProxies))
+	if err != nil {
+		// Saved values are validated, so this was edited by hand; trust nothing
+		log.Printf("[proxy] Ignoring invalid %s setting, trusting no proxy: %v", trustedProxiesKey, err)
+		nets = nil
+	}
+	h.proxies.nets.Store(&nets)
+}
+
+func (p *trustedProxies) trusts(ip net.IP) bool {
+	nets := p.nets.Load()
+	if nets == nil {
+		return false
+	}
+	for _, n := range *nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIP replaces RemoteAddr with the client IP, keeping the connection's
+// own address for peerIP. Forwarding headers count only from a trusted proxy:
+// X-Forwarded-For is read from the right, skipping trusted hops, so entries a
+// client made up itself are never reached; X-Real-IP is the fallback.
+func (p *trustedProxies) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(peer); err == nil {
+			peer = host
+		}
+		r = r.WithContext(context.WithValue(r.Context(), peerIPKey{}, peer))
+		r.RemoteAddr = p.clientIP(peer, r.Header)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *trustedProxies) clientIP(peer string, header http.Header) string {
+	ip := net.ParseIP(peer)
+	if ip == nil || !p.trusts(ip) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !p.trusts(hop) || i == 0 {
+			return hop.String()
+		}
+	}
+	if real := net.ParseIP(strings.TrimSpace(header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return peer
+}
+
+// requestClientIP returns the client IP resolved by realIP
+func requestClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// peerIP returns the address of the connection itself, which is the proxy's
+// when behind one
+func peerIP(r *http.Request) string {
+	if peer, ok := r.Context().Value(peerIPKey{}).(string); ok {
+		return peer
+	}
+	return requestClientIP(r)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestProxies(t *testing.T, raw string) *trustedProxies {
+	t.Helper()
+	nets, err := parseTrustedProxies(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &trustedProxies{}
+	p.nets.Store(&nets)
+	return p
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		want       string
+	}{
+		{"direct client", defaultTrustedProxies, "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"direct client spoofing", defaultTrustedProxies, "203.0.113.7:5000", []string{"127.0.0.1"}, "127.0.0.1", "203.0.113.7"},
+		{"local proxy", defaultTrustedProxies, "127.0.0.1:5000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"local proxy appending to a spoofed header", defaultTrustedProxies, "127.0.0.1:5000", []string{"127.0.0.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"proxy chain", defaultTrustedProxies, "10.0.0.2:5000", []string{"198.51.100.1, 203.0.113.7, 10.0.0.1"}, "", "203.0.113.7"},
+		{"repeated headers", defaultTrustedProxies, "10.0.0.2:5000", []string{"198.51.100.1", "203.0.113.7"}, "", "203.0.113.7"},
+		{"only trusted hops", defaultTrustedProxies, "127.0.0.1:5000", []string{"192.168.1.5, 10.0.0.1"}, "", "192.168.1.5"},
+		{"x-real-ip", defaultTrustedProxies, "[::1]:5000", nil, "2001:db8::7", "2001:db8::7"},
+		{"malformed header", defaultTrustedProxies, "127.0.0.1:5000", []string{"bogus"}, "", "127.0.0.1"},
+		{"custom proxy", "198.51.100.0/24", "198.51.100.9:443", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"custom list drops defaults", "198.51.100.0/24", "127.0.0.1:5000", []string{"203.0.113.7"}, "", "127.0.0.1"},
+		{"no proxies", "none", "127.0.0.1:5000", []string{"203.0.113.7"}, "", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var client, peer string
+			handler := newTestProxies(t, tt.proxies).realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				client, peer = requestClientIP(r), peerIP(r)
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if client != tt.want {
+				t.Errorf("client IP = %q, want %q", client, tt.want)
+			}
+			if wantPeer, _, _ := net.SplitHostPort(tt.remoteAddr); peer != wantPeer {
+				t.Errorf("peer IP = %q, want %q", peer, wantPeer)
+			}
+		})
+	}
+}
+
+func TestAdminAllowlistLoopback(t *testing.T) {
+	list, err := parseAdminAllowlist("198.51.100.0/24", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       bool
+	}{
+		{"on the host", "127.0.0.1:5000", "", true},
+		{"through a local proxy from the host", "127.0.0.1:5000", "127.0.0.1", true},
+		{"listed network", "198.51.100.9:5000", "", true},
+		{"listed network through a local proxy", "127.0.0.1:5000", "198.51.100.9", true},
+		{"remote client claiming loopback", "203.0.113.7:5000", "127.0.0.1", false},
+		{"remote client through a local proxy", "127.0.0.1:5000", "127.0.0.1, 203.0.113.7", false},
+	}
+
+	proxies := newTestProxies(t, defaultTrustedProxies)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allowed bool
+			handler := proxies.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				allowed, _ = list.allowsClient(requestClientIP(r), peerIP(r), nil)
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if allowed != tt.want {
+				t.Errorf("allowed = %v, want %v", allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	if _, err := parseTrustedProxies("10.0.0.0/8, proxy.local"); err == nil {
+		t.Error("host name accepted as a trusted proxy")
+	}
+}
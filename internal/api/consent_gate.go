@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// Consent modes set per domain. They decide what ingest keeps from events
+// sent without analytics consent: the tracker sends consent=1 once the
+// visitor grants it, 0 while it's denied, and nothing when no consent
+// banner is loaded.
+const (
+	consentModeOff       = "off"       // store as usual
+	consentModeRequire   = "require"   // drop
+	consentModeAnonymous = "anonymous" // store without identifiers or precise location
+	consentModeAggregate = "aggregate" // only count pageviews per day and path
+)
+
+func validConsentMode(mode string) bool {
+	switch mode {
+	case consentModeOff, consentModeRequire, consentModeAnonymous, consentModeAggregate:
+		return true
+	}
+	return false
+}
+
+// anonymizeEvent strips what could single out a visitor who hasn't
+// consented. Visitor and session are replaced with anonID, which is unique
+// to the request, so anonymous events never link to each other or to later
+// consented ones (and each counts as its own visitor).
+func anonymizeEvent(e *database.Event, anonID string) {
+	e.VisitorHash = anonID
+	e.SessionID = anonID
+	e.IPHash = nil
+	e.GeoCity = nil
+	e.GeoRegion = nil
+	e.GeoLatitude = nil
+	e.GeoLongitude = nil
+	e.ASNOrg = nil
+	e.ClickX = nil
+	e.ClickY = nil
+	e.BotClientSignals = nil
+}
+
+// countPreConsentPageviews adds pageviews to the daily per-path counts kept
+// in the aggregate consent mode
+func (h *Handlers) countPreConsentPageviews(events []*database.Event) error {
+	for _, e := range events {
+		y, m, d := e.Timestamp.UTC().Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).UnixMilli()
+		_, err := h.db.Conn().Exec(`
+			INSERT INTO pre_consent_pageviews (domain, timestamp, path, pageviews)
+			VALUES (?, ?, ?, 1)
+			ON CONFLICT (domain, timestamp, path) DO UPDATE SET pageviews = pageviews + 1
+		`, e.Domain, day, e.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStatsPreConsent returns the pageviews counted without consent for
+// domains in the aggregate consent mode, per UTC day and top paths
+func (h *Handlers) GetStatsPreConsent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+
+	// Counts are whole UTC days, so include the day the range starts in
+	where := "timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{f.startMs - f.startMs%(24*60*60*1000), f.endMs}
+	if f.domain != "" {
+		where += " AND domain = ?"
+		args = append(args, f.domain)
+	}
+
+	daily := make([]map[string]interface{}, 0)
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT date(timestamp / 1000, 'unixepoch') as day, SUM(pageviews)
+		FROM pre_consent_pageviews
+		WHERE `+where+`
+		GROUP BY day
+		ORDER BY day ASC
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var total int64
+	for rows.Next() {
+		var day string
+		var pageviews int64
+		rows.Scan(&day, &pageviews)
+		total += pageviews
+		daily = append(daily, map[string]interface{}{"date": day, "pageviews": pageviews})
+	}
+	rows.Close()
+
+	pages := make([]map[string]interface{}, 0)
+	rows, err = h.db.Conn().QueryContext(ctx, `
+		SELECT path, SUM(pageviews) as views
+		FROM pre_consent_pageviews
+		WHERE `+where+`
+		GROUP BY path
+		ORDER BY views DESC
+		LIMIT 20
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		var pageviews int64
+		rows.Scan(&path, &pageviews)
+		pages = append(pages, map[string]interface{}{"path": path, "pageviews": pageviews})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"pageviews": total,
+		"daily":     daily,
+		"pages":     pages,
+	})
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// maxCustomDimensions caps the dimensions configured per domain
+const maxCustomDimensions = 20
+
+// dimensionFilterPrefix marks filter params on a custom dimension, e.g.
+// dim.Plan=pro
+const dimensionFilterPrefix = "dim."
+
+// propsKeyPattern matches a props key, with dots for nested objects
+var propsKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_$-]+(\.[A-Za-z0-9_$-]+)*$`)
+
+// normalizeCustomDimensions validates a domain's custom dimensions, which
+// name props keys, e.g. "Plan" → "plan" or "Tier" → "user.tier". Names are
+// trimmed and must be unique regardless of case.
+func normalizeCustomDimensions(dims map[string]string) (map[string]string, error) {
+	if len(dims) > maxCustomDimensions {
+		return nil, fmt.Errorf("at most %d custom dimensions per domain", maxCustomDimensions)
+	}
+	normalized := make(map[string]string, len(dims))
+	seen := make(map[string]bool, len(dims))
+	for name, key := range dims {
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if name == "" || len(name) > 64 {
+			return nil, fmt.Errorf("custom dimension names must be 1 to 64 characters")
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("custom dimension %q is defined twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+		key = strings.TrimPrefix(key, "props.")
+		if !propsKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("custom dimension %q: %q is not a props key (use e.g. plan or user.plan)", name, key)
+		}
+		normalized[name] = key
+	}
+	return normalized, nil
+}
+
+// parseCustomDimensions reads the custom_dimensions column; invalid JSON
+// reads as no dimensions
+func parseCustomDimensions(raw *string) map[string]string {
+	dims := make(map[string]string)
+	if raw != nil {
+		json.Unmarshal([]byte(*raw), &dims)
+	}
+	return dims
+}
+
+// propsPath converts a props key to a JSON path, quoting each segment
+func propsPath(key string) string {
+	return `$."` + strings.ReplaceAll(key, ".", `"."`) + `"`
+}
+
+// dimensionExpr returns a SQL expression for a custom dimension's value on
+// an event, as text (JSON true/false read as 1/0), and its args. With no
+// domain selected, each domain defining the dimension uses its own key.
+// ok is false when no domain in scope defines the dimension.
+func (h *Handlers) dimensionExpr(ctx context.Context, name, domain string) (expr string, args []interface{}, ok bool) {
+	query := "SELECT domain, custom_dimensions FROM domains WHERE custom_dimensions IS NOT NULL"
+	var queryArgs []interface{}
+	if domain != "" {
+		query += " AND domain = ?"
+		queryArgs = append(queryArgs, domain)
+	}
+	rows, err := h.db.Conn().QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return "", nil, false
+	}
+	defer rows.Close()
+
+	var cases []string
+	for rows.Next() {
+		var d string
+		var raw *string
+		if rows.Scan(&d, &raw) != nil {
+			continue
+		}
+		for dimName, key := range parseCustomDimensions(raw) {
+			if strings.EqualFold(dimName, name) {
+				cases = append(cases, "WHEN ? THEN json_extract(props, ?)")
+				args = append(args, d, propsPath(key))
+				break
+			}
+		}
+	}
+	if len(cases) == 0 {
+		return "", nil, false
+	}
+	// json_extract fails on malformed JSON, which older clients could store
+	return "CAST(CASE WHEN json_valid(props) THEN CASE domain " + strings.Join(cases, " ") + " END END AS TEXT)", args, true
+}
+
+// dimensionCondition is a WHERE condition on a custom dimension's value
+type dimensionCondition struct {
+	sql  string
+	args []interface{}
+}
+
+// parseDimensionFilters reads dim.<Name>=<value> params. A dimension no
+// domain in scope defines matches nothing, like any unknown filter value.
+func (h *Handlers) parseDimensionFilters(r *http.Request, domain string) []dimensionCondition {
+	var conds []dimensionCondition
+	for param, values := range r.URL.Query() {
+		name := strings.TrimPrefix(param, dimensionFilterPrefix)
+		if name == param || name == "" || len(values) == 0 {
+			continue
+		}
+		expr, args, ok := h.dimensionExpr(r.Context(), name, domain)
+		if !ok {
+			conds = append(conds, dimensionCondition{sql: "0"})
+			continue
+		}
+		conds = append(conds, dimensionCondition{sql: expr + " = ?", args: append(args, values[0])})
+	}
+	return conds
+}
+
+// GetStatsDimension breaks events down by a custom dimension's value, e.g.
+// ?dimension=Plan. Events without the prop are left out.
+func (h *Handlers) GetStatsDimension(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := r.URL.Query().Get("dimension")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "dimension is required")
+		return
+	}
+	f := h.parseStatsFilter(r)
+	expr, exprArgs, ok := h.dimensionExpr(ctx, name, f.domain)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No custom dimension named %q; add it to the domain's custom_dimensions", name))
+		return
+	}
+	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
+
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT value, COUNT(*) as events, COUNT(DISTINCT visitor_hash) as visitors
+		FROM (
+			SELECT `+expr+` as value, visitor_hash
+			FROM events
+			WHERE `+where+`
+		)
+		WHERE value IS NOT NULL
+		GROUP BY value
+		ORDER BY visitors DESC
+		LIMIT 50
+	`, append(exprArgs, args...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var value string
+		var events, visitors int64
+		rows.Scan(&value, &events, &visitors)
+		result = append(result, map[string]interface{}{
+			"value":    value,
+			"events":   events,
+			"visitors": visitors,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -2,21 +2,34 @@ package api
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/adfraud"
+	"github.com/caioricciuti/etiquetta/internal/alerting"
 	"github.com/caioricciuti/etiquetta/internal/auth"
 	"github.com/caioricciuti/etiquetta/internal/bot"
 	"github.com/caioricciuti/etiquetta/internal/config"
 	"github.com/caioricciuti/etiquetta/internal/database"
 	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
 	"github.com/caioricciuti/etiquetta/internal/identification"
 	"github.com/caioricciuti/etiquetta/internal/licensing"
 )
@@ -32,9 +45,63 @@ type Handlers struct {
 	cfg            *config.Config
 	auth           *auth.Auth
 
-	// SSE subscribers
-	sseClients map[chan []byte]bool
-	sseMu      sync.RWMutex
+	// Realtime subscribers (SSE and WebSocket), mapped to their domain
+	// filter, plus recent notifications for SSE Last-Event-ID replay
+	sseClients  map[chan realtimeMessage]string
+	realtimeSeq uint64
+	replay      *replayBuffer
+	sseMu       sync.RWMutex
+
+	// GeoIP download progress, polled by the settings page
+	geoipDownload   geoipDownloadState
+	geoipDownloadMu sync.Mutex
+
+	// Cached values for embeddable share widgets
+	widgets widgetCache
+
+	// Cached per-domain event counts for the domain list
+	domainActivity domainActivityCache
+
+	// Recent accepted/rejected tracking requests for installation checks
+	ingestDiag ingestDiagnostics
+
+	// Events ingested with API keys, written to api_key_usage periodically
+	apiKeyUsage apiKeyUsage
+
+	// Oversized, slow and mostly-rejected ingest requests per client
+	ingestMonitor ingestMonitor
+
+	// Ingest requests per site_id, nil without a per-site limit
+	siteLimit limiter
+
+	// Domains logged as over their monthly event quota
+	quotaLog quotaLog
+
+	// Stored events per second, for the throughput gauge
+	throughput throughputCounter
+
+	// Public tracker script and ingest paths, resolved at router build time
+	scriptPath string
+	ingestPath string
+
+	// Security headers, reloaded when their settings change
+	securityConfig atomic.Pointer[securityHeaderConfig]
+
+	// Proxies whose forwarding headers give the client IP
+	proxies *trustedProxies
+
+	// Parsed excluded_ip_hashes setting, checked on every ingest request
+	excludedIPHashes atomic.Pointer[map[string]bool]
+
+	// respect_dnt setting, checked on every ingest request
+	respectDNT atomic.Bool
+
+	// Mirrors ingested events to per-domain forwarding URLs
+	forwarder *forwarding.Forwarder
+
+	// Bot rescore progress, polled by admins after tuning detection
+	botRescore   botRescoreState
+	botRescoreMu sync.Mutex
 }
 
 // logAudit records an admin action to the audit log (fire-and-forget)
@@ -44,10 +111,7 @@ func (h *Handlers) logAudit(r *http.Request, action, resourceType, resourceID, d
 		return
 	}
 
-	clientIP := enrichment.ExtractClientIP(r.RemoteAddr, map[string]string{
-		"X-Forwarded-For": r.Header.Get("X-Forwarded-For"),
-		"X-Real-IP":       r.Header.Get("X-Real-IP"),
-	})
+	clientIP := requestClientIP(r)
 
 	entry := &database.AuditLogEntry{
 		ID:           generateID(),
@@ -66,9 +130,28 @@ func (h *Handlers) logAudit(r *http.Request, action, resourceType, resourceID, d
 	}
 }
 
-// Health check
+// Health check. The schema version is the one cached by the startup
+// migration, so probes don't query the database.
 func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	health := map[string]interface{}{
+		"status":                "ok",
+		"schema_version":        h.db.AppliedVersion(),
+		"latest_schema_version": database.LatestVersion(),
+		"read_only":             false,
+	}
+	// Degraded: serving reads only after a migration failed. The SQL error
+	// is only logged, since this endpoint is public.
+	if failure := h.db.MigrationFailure(); failure != nil {
+		health["status"] = "degraded"
+		health["read_only"] = true
+		health["failed_migration"] = map[string]interface{}{
+			"version":     failure.Version,
+			"description": failure.Description,
+		}
+		writeJSON(w, http.StatusServiceUnavailable, health)
+		return
+	}
+	writeJSON(w, http.StatusOK, health)
 }
 
 // GetVersion returns the current version
@@ -76,46 +159,151 @@ func (h *Handlers) GetVersion(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"version": Version})
 }
 
-// ServeTrackerScript serves the JavaScript tracker
-func (h *Handlers) ServeTrackerScript(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/javascript")
-	w.Header().Set("Cache-Control", "public, max-age=86400")
+// Cache lifetimes for the tracker script. Unversioned URLs are revalidated
+// often so proxies and CDNs pick up changes; versioned URLs never change.
+const (
+	trackerScriptMaxAge          = "public, max-age=300, must-revalidate"
+	trackerScriptImmutableMaxAge = "public, max-age=31536000, immutable"
+)
 
-	// Read embedded tracker script
+// trackerScript returns the tracker with its injected configuration, plus a
+// version derived from the content for ETags and versioned URLs
+func (h *Handlers) trackerScript() ([]byte, string, error) {
 	script, err := trackerJS.ReadFile("tracker.js")
 	if err != nil {
-		http.Error(w, "Script not found", http.StatusNotFound)
-		return
+		return nil, "", err
 	}
 
-	// Inject configuration
-	config := fmt.Sprintf(`window.__ETIQUETTA_CONFIG__={endpoint:"%s",trackPerformance:%t,trackErrors:%t,respectDNT:%t};`,
-		"/i",
+	downloadExts, _ := json.Marshal(h.cfg.DownloadExtensions)
+	config := fmt.Sprintf(`window.__ETIQUETTA_CONFIG__={endpoint:"%s",trackPerformance:%t,extendedPerformance:%t,trackErrors:%t,trackDownloads:%t,downloadExtensions:%s};`,
+		h.ingestPath,
 		h.cfg.TrackPerformance && h.licenseManager.HasFeature(licensing.FeaturePerformance),
+		h.cfg.TrackExtendedPerformance,
 		h.cfg.TrackErrors && h.licenseManager.HasFeature(licensing.FeatureErrorTracking),
-		h.cfg.RespectDNT,
+		h.cfg.TrackDownloads,
+		downloadExts,
 	)
 
-	w.Write([]byte(config))
-	w.Write(script)
+	body := append([]byte(config), script...)
+	sum := sha256.Sum256(body)
+	return body, "v" + hex.EncodeToString(sum[:5]), nil
+}
+
+// versionedScriptPattern turns a script path such as /s.js into the route
+// pattern for its versioned form, /s.{version}.js. Returns "" for paths
+// without a .js suffix.
+func versionedScriptPattern(path string) string {
+	if !strings.HasSuffix(path, ".js") {
+		return ""
+	}
+	return strings.TrimSuffix(path, ".js") + ".{version}.js"
+}
+
+// versionedScriptURL returns the script path pinned to the current version,
+// or the plain path when it can't be versioned
+func (h *Handlers) versionedScriptURL() string {
+	_, version, err := h.trackerScript()
+	pattern := versionedScriptPattern(h.scriptPath)
+	if err != nil || pattern == "" {
+		return h.scriptPath
+	}
+	return strings.Replace(pattern, "{version}", version, 1)
+}
+
+// ServeTrackerScript serves the JavaScript tracker. Requests for the current
+// versioned URL (e.g. /s.v1a2b3c4d5.js) are cacheable forever; all others,
+// including stale versions, get a short max-age and an ETag for revalidation.
+func (h *Handlers) ServeTrackerScript(w http.ResponseWriter, r *http.Request) {
+	body, version, err := h.trackerScript()
+	if err != nil {
+		http.Error(w, "Script not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("ETag", `"`+version+`"`)
+	if chi.URLParam(r, "version") == version {
+		w.Header().Set("Cache-Control", trackerScriptImmutableMaxAge)
+	} else {
+		w.Header().Set("Cache-Control", trackerScriptMaxAge)
+	}
+
+	// Ask for high-entropy client hints on subsequent requests to this origin
+	w.Header().Set("Accept-CH", strings.Join(enrichment.ClientHintHeaders, ", "))
+
+	// ServeContent answers If-None-Match with 304 using the ETag above
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// respectDNTKey is the setting that drops events from visitors sending Do
+// Not Track or Global Privacy Control (default on)
+const respectDNTKey = "respect_dnt"
+
+// loadRespectDNT caches the respect_dnt setting for ingest
+func (h *Handlers) loadRespectDNT() {
+	h.respectDNT.Store(newSettingsService(h).GetBool(respectDNTKey, true))
+}
+
+// readIngestBody reads an ingest body, decompressing it when sent with
+// Content-Encoding: gzip. The limit applies to the decompressed body, which
+// is truncated beyond it, and the compressed body is capped too, so a small
+// gzip bomb can't make ingest inflate gigabytes. On error it returns the
+// status to answer with.
+func readIngestBody(r *http.Request) ([]byte, bool, int, error) {
+	var reader io.Reader = r.Body
+	compressed := &io.LimitedReader{R: r.Body, N: maxIngestBodyBytes + 1}
+	gzipped := false
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(compressed)
+		if err != nil {
+			return nil, false, http.StatusBadRequest, errors.New("Invalid gzip body")
+		}
+		defer zr.Close()
+		reader, gzipped = zr, true
+	default:
+		return nil, false, http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported Content-Encoding %q; send plain or gzip NDJSON", encoding)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxIngestBodyBytes+1))
+	if err != nil {
+		// A gzip stream cut off by the compressed cap is truncated, not broken
+		if !gzipped || compressed.N > 0 {
+			return nil, false, http.StatusBadRequest, errors.New("Failed to read body")
+		}
+		return body, true, 0, nil
+	}
+	if len(body) > maxIngestBodyBytes {
+		return body[:maxIngestBodyBytes], true, 0, nil
+	}
+	return body, false, 0, nil
 }
 
 // Ingest receives tracking events
 func (h *Handlers) Ingest(w http.ResponseWriter, r *http.Request) {
-	// Respect DNT (Do Not Track) and GPC (Global Privacy Control) headers
-	if h.cfg.RespectDNT {
-		if r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1" {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+	start := time.Now()
+
+	// Honor Do Not Track and Global Privacy Control when respect_dnt is on;
+	// the tracker also flags each event, as beacons may omit the headers
+	respectDNT := h.respectDNT.Load()
+	dntHeader := r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+
+	// Server-side senders may identify themselves with an API key, so their
+	// volume shows per key
+	apiKeyID, ok := h.ingestAPIKey(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Invalid API key")
+		return
 	}
 
 	// Parse events (NDJSON format - one event per line)
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB limit
+	body, truncated, status, err := readIngestBody(r)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Failed to read body")
+		writeError(w, status, err.Error())
 		return
 	}
+	obs := ingestObservation{bytes: len(body), truncated: truncated}
 
 	// Get Origin/Referer for domain validation
 	origin := r.Header.Get("Origin")
@@ -130,10 +318,7 @@ func (h *Handlers) Ingest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get client info for enrichment
-	clientIP := enrichment.ExtractClientIP(r.RemoteAddr, map[string]string{
-		"X-Forwarded-For": r.Header.Get("X-Forwarded-For"),
-		"X-Real-IP":       r.Header.Get("X-Real-IP"),
-	})
+	clientIP := requestClientIP(r)
 	userAgent := r.Header.Get("User-Agent")
 
 	// Collect headers for bot detection
@@ -143,46 +328,106 @@ func (h *Handlers) Ingest(w http.ResponseWriter, r *http.Request) {
 		"Accept":          r.Header.Get("Accept"),
 	}
 
+	// Client hints take precedence over the (frozen) User-Agent string
+	for _, name := range enrichment.ClientHintHeaders {
+		if v := r.Header.Get(name); v != "" {
+			headers[name] = v
+		}
+	}
+
 	// Enrich with geo, device, bot detection
 	enriched := h.enricher.EnrichWithHeaders(clientIP, userAgent, "", headers)
 
 	// Generate IP hash for tracking (privacy-preserving)
 	ipHash := hashIP(clientIP)
 
+	// Watch for oversized, slow and mostly-rejected requests per client
+	defer func() {
+		obs.duration = time.Since(start)
+		h.ingestMonitor.observe(ipHash, obs)
+	}()
+
+	// Drop traffic the operator excluded (own visits, office IPs)
+	if h.isExcludedRequest(r, ipHash) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	// Generate server-side session ID
 	sessionID := h.idGen.GenerateSessionID(clientIP, userAgent)
 
 	// Parse each line as a separate event
-	var events []*database.Event
+	var events, preConsent []*database.Event
 	var perfs []*database.Performance
 	var errs []*database.Error
-
+	anonID := generateID()
+	quotas := h.newQuotaBatch()
+
+	// One site flooding ingest is limited however many IPs it comes from.
+	// Each site in the request counts once, and only after its site_id and
+	// origin check out, so made-up site_ids can't use up a real site's limit.
+	siteLimits := make(map[string]limitResult)
+	var siteLimited *limitResult
+
+	// The scanner's buffer fits the whole (capped) body, so a long line
+	// can't stop it and lose the lines after; lines over the
+	// max_event_line_bytes setting are dropped one by one instead
+	obs.maxLine = h.maxEventLineBytes()
 	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
+		obs.events++
 
-		var raw map[string]interface{}
-		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		if len(line) > obs.maxLine {
+			obs.rejected++
+			obs.tooLong++
+			obs.longestLine = max(obs.longestLine, len(line))
+			continue
+		}
+
+		raw, repaired, err := parseIngestLine(line)
+		if err != nil {
+			obs.rejected++
+			obs.malformed++
+			if obs.malformedErr == "" {
+				obs.malformedErr = err.Error()
+			}
+			continue
+		}
+		if repaired {
+			obs.repaired++
+		}
+		if getBoolFromFloat(raw, "exclude") {
 			continue
 		}
 
 		// Validate site_id and domain match
 		siteID, _ := raw["site_id"].(string)
+		if obs.siteID == "" {
+			obs.siteID = siteID
+		}
+		consentMode := consentModeOff
+		var registeredDomain string
+		var quota *int64
 		if siteID == "" {
 			// No site_id provided - reject unless we have no domains registered (backwards compat)
 			var domainCount int
 			h.db.Conn().QueryRow("SELECT COUNT(*) FROM domains").Scan(&domainCount)
 			if domainCount > 0 {
+				h.ingestDiag.record(sightBadSiteID, requestHost, requestHost, "")
+				obs.rejected++
 				continue // Skip events without site_id when domains are configured
 			}
 		} else {
 			// Validate site_id exists and matches the request origin
-			var registeredDomain string
-			err := h.db.Conn().QueryRow("SELECT domain FROM domains WHERE site_id = ? AND is_active = 1", siteID).Scan(&registeredDomain)
+			err := h.db.Conn().QueryRow("SELECT domain, consent_mode, event_quota FROM domains WHERE site_id = ? AND is_active = 1", siteID).Scan(&registeredDomain, &consentMode, &quota)
 			if err != nil {
+				h.ingestDiag.record(sightBadSiteID, requestHost, requestHost, siteID)
+				obs.rejected++
 				continue // Invalid or inactive site_id
 			}
 
@@ -191,20 +436,61 @@ func (h *Handlers) Ingest(w http.ResponseWriter, r *http.Request) {
 			if requestHost != "" && requestHost != registeredDomain {
 				// Check if it's localhost/127.0.0.1 (development mode)
 				if !strings.HasPrefix(requestHost, "localhost") && !strings.HasPrefix(requestHost, "127.0.0.1") {
+					h.ingestDiag.record(sightOriginMismatch, siteID, requestHost, siteID)
+					obs.rejected++
 					continue // Origin doesn't match registered domain
 				}
 			}
 		}
 
+		if respectDNT && (dntHeader || getBoolFromFloat(raw, "dnt")) {
+			// Dropped, but counted so operators can see how much DNT hides
+			h.ingestDiag.record(sightDNT, siteID, requestHost, siteID)
+			continue
+		}
+		if siteID != "" {
+			h.ingestDiag.record(sightAccepted, siteID, requestHost, siteID)
+		}
+
 		eventType, _ := raw["type"].(string)
 
+		// Without consent, only the domain's consent mode decides what's kept
+		consented := consentMode == consentModeOff || getBoolFromFloat(raw, "consent")
+		if !consented && (consentMode == consentModeRequire || eventType == "performance" || eventType == "error") {
+			continue
+		}
+
+		// The per-site limit only counts events that would be kept, so
+		// visitors opting out don't use up the site's allowance
+		if siteID != "" && h.siteLimit != nil {
+			res, seen := siteLimits[siteID]
+			if !seen {
+				res = h.siteLimit.take(siteID)
+				siteLimits[siteID] = res
+			}
+			if !res.allowed {
+				siteLimited = &res
+				obs.rejected++
+				continue
+			}
+		}
+
+		// Over its monthly quota, the domain's events are dropped
+		if registeredDomain != "" && !quotas.allow(registeredDomain, quota) {
+			continue
+		}
+
 		switch eventType {
 		case "performance":
 			if !h.licenseManager.HasFeature(licensing.FeaturePerformance) {
 				continue
 			}
+			if !keepSample(h.cfg.PerformanceSampleRate) {
+				continue
+			}
 			perf := h.parsePerformance(raw, sessionID, enriched)
 			if perf != nil {
+				perf.SampleRate = h.cfg.PerformanceSampleRate
 				perfs = append(perfs, perf)
 			}
 
@@ -212,28 +498,78 @@ func (h *Handlers) Ingest(w http.ResponseWriter, r *http.Request) {
 			if !h.licenseManager.HasFeature(licensing.FeatureErrorTracking) {
 				continue
 			}
+			if !keepSample(h.cfg.ErrorSampleRate) {
+				continue
+			}
 			errEvent := h.parseError(raw, sessionID, enriched)
 			if errEvent != nil {
+				errEvent.SampleRate = h.cfg.ErrorSampleRate
 				errs = append(errs, errEvent)
 			}
 
 		default:
 			event := h.parseEvent(raw, sessionID, enriched, userAgent, ipHash)
-			if event != nil {
+			if event == nil {
+				continue
+			}
+			switch {
+			case consented:
+				events = append(events, event)
+			case consentMode == consentModeAnonymous:
+				anonymizeEvent(event, anonID)
 				events = append(events, event)
+			case event.EventType == "pageview":
+				preConsent = append(preConsent, event)
 			}
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		// Not expected with the buffer fitting the body, but the lines
+		// after this point were lost
+		log.Printf("[ingest] Reading body failed after %d lines: site_id=%q ip_hash=%s error=%v",
+			obs.events, obs.siteID, shortHash(ipHash), err)
+	}
 
-	// Batch insert
-	if err := h.db.InsertBatch(events, perfs, errs); err != nil {
+	// Batch insert, collapsing error loops so one broken page can't flood
+	// the errors table
+	errs, err = h.db.InsertBatchThrottled(events, perfs, errs, h.cfg.ErrorMaxPerSession)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, "Failed to save events")
 		return
 	}
 
+	if err := h.countPreConsentPageviews(preConsent); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to save events")
+		return
+	}
+	quotas.save()
+	if apiKeyID != "" {
+		h.apiKeyUsage.record(apiKeyID, countIngestedByDomain(events, perfs, errs), time.Now())
+	}
+
+	// Mirror to the domains' forwarding endpoints, in the background
+	h.forwarder.Enqueue(events)
+
+	h.throughput.record(events, perfs, errs)
+
 	// Notify SSE clients
 	h.notifyClients(events, perfs, errs)
 
+	// Let integrations see lines lost to malformed JSON, or only saved by
+	// repairing it
+	if obs.malformed > 0 {
+		w.Header().Set("X-Etiquetta-Malformed", strconv.Itoa(obs.malformed))
+	}
+	if obs.repaired > 0 {
+		w.Header().Set("X-Etiquetta-Repaired", strconv.Itoa(obs.repaired))
+	}
+	if siteLimited != nil {
+		writeRateLimited(w, *siteLimited)
+		return
+	}
+	if quotas.respond(w) {
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -299,15 +635,21 @@ func (h *Handlers) parseEvent(raw map[string]interface{}, sessionID string, enri
 		geoLon = &enriched.GeoLongitude
 	}
 
+	// Trackers that report the page's HTTP status flag error pages this way
+	eventType := getStringOr(raw, "event_type", "pageview")
+	if eventType == "pageview" && getFloatOr(raw, "status", 0) == 404 {
+		eventType = eventTypeNotFound
+	}
+
 	event := &database.Event{
 		ID:           generateID(),
 		Timestamp:    time.Now(),
-		EventType:    getStringOr(raw, "event_type", "pageview"),
+		EventType:    eventType,
 		SessionID:    sessionID,
 		VisitorHash:  visitorHash,
 		Domain:       parsedURL.Host,
-		URL:          urlStr,
-		Path:         parsedURL.Path,
+		URL:          scrubURL(urlStr),
+		Path:         scrubURL(parsedURL.Path),
 		GeoCountry:   &enriched.GeoCountry,
 		GeoCity:      &enriched.GeoCity,
 		GeoRegion:    &enriched.GeoRegion,
@@ -325,6 +667,12 @@ func (h *Handlers) parseEvent(raw map[string]interface{}, sessionID string, enri
 		DatacenterIP: enriched.DatacenterIP,
 		IPHash:       &ipHash,
 	}
+	if raw := bot.ClientSignalsToJSON(clientSignals); raw != "" {
+		event.BotClientSignals = &raw
+	}
+	if enriched.ASNOrg != "" {
+		event.ASNOrg = &enriched.ASNOrg
+	}
 
 	// Extract behavioral flags from client
 	event.HasScroll = getBoolFromFloat(raw, "has_scroll")
@@ -355,8 +703,9 @@ func (h *Handlers) parseEvent(raw map[string]interface{}, sessionID string, enri
 		event.EventName = &name
 	}
 	if ref, ok := raw["referrer_url"].(string); ok && ref != "" {
-		event.ReferrerURL = &ref
 		refType := enrichment.ClassifyReferrer(ref)
+		ref = scrubURL(ref)
+		event.ReferrerURL = &ref
 		event.ReferrerType = &refType
 	}
 	if utm, ok := raw["utm_source"].(string); ok {
@@ -375,10 +724,32 @@ func (h *Handlers) parseEvent(raw map[string]interface{}, sessionID string, enri
 		propsJSON, _ := json.Marshal(propsMap)
 		event.Props = propsJSON
 	}
+	event.Props = scrubPropsTarget(event.Props)
+	// Props from enrichment plugins override the tracker's
+	if len(enriched.Props) > 0 {
+		event.Props = mergeProps(event.Props, enriched.Props)
+	}
 
 	return event
 }
 
+// mergeProps sets extra on a props object, replacing props that aren't an
+// object
+func mergeProps(props json.RawMessage, extra map[string]interface{}) json.RawMessage {
+	merged := make(map[string]interface{}, len(extra))
+	if len(props) > 0 {
+		json.Unmarshal(props, &merged)
+	}
+	if merged == nil {
+		merged = make(map[string]interface{}, len(extra))
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	out, _ := json.Marshal(merged)
+	return out
+}
+
 func (h *Handlers) parsePerformance(raw map[string]interface{}, sessionID string, enriched *enrichment.EnrichmentResult) *database.Performance {
 	urlStr, _ := raw["url"].(string)
 	parsedURL, _ := url.Parse(urlStr)
@@ -389,8 +760,8 @@ func (h *Handlers) parsePerformance(raw map[string]interface{}, sessionID string
 		SessionID:   sessionID,
 		VisitorHash: getStringOr(raw, "visitor_hash", ""),
 		Domain:      parsedURL.Host,
-		URL:         urlStr,
-		Path:        parsedURL.Path,
+		URL:         scrubURL(urlStr),
+		Path:        scrubURL(parsedURL.Path),
 		DeviceType:  &enriched.DeviceType,
 		GeoCountry:  &enriched.GeoCountry,
 	}
@@ -417,6 +788,26 @@ func (h *Handlers) parsePerformance(raw map[string]interface{}, sessionID string
 		perf.ConnectionType = &v
 	}
 
+	// Extended metrics, sent with track_extended_performance
+	if v, ok := raw["tti"].(float64); ok {
+		perf.TTI = &v
+	}
+	if v, ok := raw["tbt"].(float64); ok {
+		perf.TBT = &v
+	}
+	if v, ok := raw["long_tasks"].(float64); ok {
+		n := int64(v)
+		perf.LongTasks = &n
+	}
+	if v, ok := raw["resource_count"].(float64); ok {
+		n := int64(v)
+		perf.ResourceCount = &n
+	}
+	if v, ok := raw["resource_bytes"].(float64); ok {
+		n := int64(v)
+		perf.ResourceBytes = &n
+	}
+
 	return perf
 }
 
@@ -424,9 +815,10 @@ func (h *Handlers) parseError(raw map[string]interface{}, sessionID string, enri
 	urlStr, _ := raw["url"].(string)
 	parsedURL, _ := url.Parse(urlStr)
 
-	errorType := getStringOr(raw, "error_type", "javascript")
-	errorMessage := getStringOr(raw, "message", "Unknown error")
-	scriptURL := getStringOr(raw, "script_url", "")
+	errorType := normalizeErrorType(getStringOr(raw, "error_type", ""))
+	// The tracker sends error_message/error_stack; message/stack are accepted for older clients
+	errorMessage := scrubURL(getStringOr(raw, "error_message", getStringOr(raw, "message", "Unknown error")))
+	scriptURL := scrubURL(getStringOr(raw, "script_url", ""))
 	lineNumber := int(getFloatOr(raw, "line_number", 0))
 
 	errEvent := &database.Error{
@@ -435,8 +827,8 @@ func (h *Handlers) parseError(raw map[string]interface{}, sessionID string, enri
 		SessionID:    sessionID,
 		VisitorHash:  getStringOr(raw, "visitor_hash", ""),
 		Domain:       parsedURL.Host,
-		URL:          urlStr,
-		Path:         parsedURL.Path,
+		URL:          scrubURL(urlStr),
+		Path:         scrubURL(parsedURL.Path),
 		ErrorType:    errorType,
 		ErrorMessage: errorMessage,
 		ErrorHash:    enrichment.HashError(errorType, errorMessage, scriptURL, lineNumber),
@@ -444,7 +836,12 @@ func (h *Handlers) parseError(raw map[string]interface{}, sessionID string, enri
 		GeoCountry:   &enriched.GeoCountry,
 	}
 
-	if v, ok := raw["stack"].(string); ok {
+	// Messages and stack traces quote URLs too
+	if v, ok := raw["error_stack"].(string); ok && v != "" {
+		v = scrubURL(v)
+		errEvent.ErrorStack = &v
+	} else if v, ok := raw["stack"].(string); ok && v != "" {
+		v = scrubURL(v)
 		errEvent.ErrorStack = &v
 	}
 	if scriptURL != "" {
@@ -461,6 +858,26 @@ func (h *Handlers) parseError(raw map[string]interface{}, sessionID string, enri
 	return errEvent
 }
 
+// Error types stored in errors.error_type
+const (
+	errorTypeJavaScript         = "javascript"
+	errorTypeResource           = "resource"
+	errorTypeUnhandledRejection = "unhandled_rejection"
+)
+
+// normalizeErrorType maps client-reported error types onto the known set,
+// defaulting to javascript
+func normalizeErrorType(t string) string {
+	switch strings.ToLower(strings.TrimSpace(t)) {
+	case "resource", "resource_error", "network":
+		return errorTypeResource
+	case "unhandled_rejection", "unhandledrejection", "unhandled_promise", "promise":
+		return errorTypeUnhandledRejection
+	default:
+		return errorTypeJavaScript
+	}
+}
+
 // License handlers
 func (h *Handlers) GetLicense(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, h.licenseManager.GetInfo())
@@ -511,6 +928,37 @@ func (h *Handlers) GetSettings(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, settings)
 }
 
+// adminSettingKeys are the settings only admins may change through
+// UpdateSettings, which any signed-in user can call
+var adminSettingKeys = map[string]bool{
+	alerting.TrafficEnabledKey: true,
+	alerting.TrafficEmailsKey:  true,
+	alerting.TrafficWebhookKey: true,
+
+	database.RetentionEventsKey:      true,
+	database.RetentionPerformanceKey: true,
+	database.RetentionErrorsKey:      true,
+
+	excludedIPHashesKey: true,
+	respectDNTKey:       true,
+	urlScrubRulesKey:    true,
+	publicURLKey:        true,
+
+	adminAllowedCIDRsKey:     true,
+	adminAllowedCountriesKey: true,
+	trustedProxiesKey:        true,
+
+	securityHeadersKey: true,
+	hstsMaxAgeKey:      true,
+	referrerPolicyKey:  true,
+	dashboardCSPKey:    true,
+
+	reportFrequencyKey:  true,
+	reportRecipientsKey: true,
+	reportHourKey:       true,
+	reportLastPeriodKey: true,
+}
+
 func (h *Handlers) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	var settings map[string]string
 	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
@@ -518,6 +966,146 @@ func (h *Handlers) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if claims := auth.GetUserFromContext(r.Context()); claims == nil || claims.Role != "admin" {
+		for key := range settings {
+			if adminSettingKeys[key] {
+				writeError(w, http.StatusForbidden, key+" can only be changed by an admin")
+				return
+			}
+		}
+	}
+
+	if origins, ok := settings["allowed_origins"]; ok {
+		if _, err := config.NewOriginMatcher(config.ParseAllowedOrigins(origins)); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if raw, ok := settings[publicURLKey]; ok {
+		normalized, err := normalizePublicURL(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		settings[publicURLKey] = normalized
+	}
+	_, cidrsSet := settings[adminAllowedCIDRsKey]
+	_, countriesSet := settings[adminAllowedCountriesKey]
+	_, proxiesSet := settings[trustedProxiesKey]
+	if cidrsSet || countriesSet || proxiesSet {
+		svc := newSettingsService(h)
+		cidrs, ok := settings[adminAllowedCIDRsKey]
+		if !ok {
+			cidrs = svc.GetWithDefault(adminAllowedCIDRsKey, "")
+		}
+		countries, ok := settings[adminAllowedCountriesKey]
+		if !ok {
+			countries = svc.GetWithDefault(adminAllowedCountriesKey, "")
+		}
+		list, err := parseAdminAllowlist(cidrs, countries)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		rawProxies, ok := settings[trustedProxiesKey]
+		if !ok || rawProxies == "" {
+			rawProxies = svc.GetWithDefault(trustedProxiesKey, defaultTrustedProxies)
+		}
+		nets, err := parseTrustedProxies(rawProxies)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if list.enabled() {
+			// Resolve the admin's own IP as the new proxy list would
+			var proxies trustedProxies
+			proxies.nets.Store(&nets)
+			peer := peerIP(r)
+			if allowed, _ := list.allowsClient(proxies.clientIP(peer, r.Header), peer, h.enricher); !allowed {
+				writeError(w, http.StatusBadRequest, "This allowlist would block your own access; include your current network or country")
+				return
+			}
+		}
+	}
+	if raw, ok := settings[exportMaxRowsKey]; ok && raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "export_max_rows must be a positive whole number")
+			return
+		}
+	}
+	for _, key := range []string{database.RetentionEventsKey, database.RetentionPerformanceKey, database.RetentionErrorsKey} {
+		raw, ok := settings[key]
+		if !ok || raw == "" {
+			continue
+		}
+		maxDays := h.licenseManager.GetLimit("max_retention_days")
+		if maxDays == -1 {
+			maxDays = database.UnlimitedRetentionDays
+		}
+		if days, err := strconv.Atoi(raw); err != nil || days < 1 || days > maxDays {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a whole number of days from 1 to %d on this license", key, maxDays))
+			return
+		}
+	}
+	for _, key := range sampleRateKeys {
+		if raw, ok := settings[key]; ok && raw != "" && !validSampleRate(raw) {
+			writeError(w, http.StatusBadRequest, key+" must be a number above 0 and at most 1, e.g. 0.1 for 10%")
+			return
+		}
+	}
+	if raw, ok := settings[defaultRangeDaysKey]; ok && raw != "" {
+		limit := h.rangeDaysLimit()
+		if days, err := strconv.Atoi(raw); err != nil || days < 1 || days > limit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("default_range_days must be a whole number of days from 1 to %d", limit))
+			return
+		}
+	}
+	if raw, ok := settings[urlScrubRulesKey]; ok && raw != "" {
+		var rules []enrichment.URLScrubRule
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			writeError(w, http.StatusBadRequest, "url_scrub_rules must be a JSON array of {name, pattern}")
+			return
+		}
+		if err := enrichment.CompileURLScrubRules(rules); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if raw, ok := settings[hstsMaxAgeKey]; ok && raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "hsts_max_age_seconds must be a whole number of seconds (0 disables HSTS)")
+			return
+		}
+	}
+	if raw, ok := settings[referrerPolicyKey]; ok && raw != "" && !validReferrerPolicies[raw] {
+		writeError(w, http.StatusBadRequest, "referrer_policy must be a valid Referrer-Policy value, e.g. strict-origin-when-cross-origin")
+		return
+	}
+	if raw, ok := settings[dashboardCSPKey]; ok {
+		if err := validateCSP(raw); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if raw, ok := settings[suspiciousPolicyKey]; ok && raw != "" {
+		if _, valid := adfraud.ParseSuspiciousPolicy(raw); !valid {
+			writeError(w, http.StatusBadRequest, "suspicious_policy must be one of human, bot, separate")
+			return
+		}
+	}
+	if err := validateReportSettings(settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := alerting.ValidateTrafficSettings(settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.validateTrackingPaths(settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	tx, _ := h.db.Conn().Begin()
 	changedKeys := make([]string, 0, len(settings))
 	for key, value := range settings {
@@ -527,6 +1115,25 @@ func (h *Handlers) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	tx.Commit()
 
+	if _, ok := settings[urlScrubRulesKey]; ok {
+		h.loadURLScrubRules()
+	}
+	if _, ok := settings[excludedIPHashesKey]; ok {
+		h.loadExcludedIPHashes()
+	}
+	if _, ok := settings[respectDNTKey]; ok {
+		h.loadRespectDNT()
+	}
+	if proxiesSet {
+		h.loadTrustedProxies()
+	}
+	for _, key := range securityHeaderKeys {
+		if _, ok := settings[key]; ok {
+			h.loadSecurityHeaders()
+			break
+		}
+	}
+
 	h.logAudit(r, "update", "settings", "", "Updated keys: "+strings.Join(changedKeys, ", "))
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -566,6 +1173,30 @@ func (h *Handlers) GetDatabaseInfo(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetDatabaseStats returns row counts, sizes and time ranges per table and per domain
+func (h *Handlers) GetDatabaseStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.db.GetStorageStats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// CheckDatabase runs SQLite's integrity and foreign key checks (admin only).
+// Corruption answers 200 with ok false and a recovery suggestion, like any
+// other finding; 500 means the check itself couldn't run.
+func (h *Handlers) CheckDatabase(w http.ResponseWriter, r *http.Request) {
+	report, err := h.db.CheckIntegrity(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
 // ExplorerQuery executes a read-only SQL query (admin only)
 func (h *Handlers) ExplorerQuery(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -602,7 +1233,10 @@ func (h *Handlers) ExplorerSchema(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, schema)
 }
 
-// SSE for real-time events
+// SSE for real-time events. An optional domain query param limits
+// notifications to that domain. Messages carry IDs; a client reconnecting
+// with Last-Event-ID (header, or last_event_id query param for clients that
+// reconnect manually) first receives the buffered notifications it missed.
 func (h *Handlers) EventStream(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -614,25 +1248,18 @@ func (h *Handlers) EventStream(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 
-	// Create client channel
-	client := make(chan []byte, 100)
-
-	h.sseMu.Lock()
-	if h.sseClients == nil {
-		h.sseClients = make(map[chan []byte]bool)
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
 	}
-	h.sseClients[client] = true
-	h.sseMu.Unlock()
+	client, missed := h.subscribe(r.URL.Query().Get("domain"), lastEventID)
+	defer h.unsubscribe(client)
 
-	defer func() {
-		h.sseMu.Lock()
-		delete(h.sseClients, client)
-		h.sseMu.Unlock()
-		close(client)
-	}()
-
-	// Send initial connection message
+	// Send initial connection message, then anything missed while away
 	fmt.Fprintf(w, "data: {\"type\":\"connected\"}\n\n")
+	for _, msg := range missed {
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.id, msg.data)
+	}
 	flusher.Flush()
 
 	// Listen for events with keepalive to prevent WriteTimeout
@@ -642,7 +1269,7 @@ func (h *Handlers) EventStream(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case msg := <-client:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", msg.id, msg.data)
 			flusher.Flush()
 		case <-keepalive.C:
 			fmt.Fprintf(w, ": keepalive\n\n")
@@ -653,15 +1280,115 @@ func (h *Handlers) EventStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// subscribe registers a realtime client. domain limits notifications to a
+// single domain; empty receives everything. If lastEventID is a valid ID,
+// the buffered notifications after it are returned to be sent first.
+// Registration and the buffer read happen under one lock, so nothing is
+// missed or delivered twice.
+func (h *Handlers) subscribe(domain, lastEventID string) (chan realtimeMessage, []realtimeMessage) {
+	client := make(chan realtimeMessage, 100)
+
+	h.sseMu.Lock()
+	defer h.sseMu.Unlock()
+
+	if h.sseClients == nil {
+		h.sseClients = make(map[chan realtimeMessage]string)
+	}
+	h.sseClients[client] = domain
+
+	var missed []realtimeMessage
+	if lastID, ok := parseLastEventID(lastEventID); ok && h.replay != nil && lastID <= h.realtimeSeq {
+		missed = h.replay.since(lastID, domain)
+	}
+	return client, missed
+}
+
+func (h *Handlers) unsubscribe(client chan realtimeMessage) {
+	h.sseMu.Lock()
+	delete(h.sseClients, client)
+	h.sseMu.Unlock()
+	close(client)
+}
+
 func (h *Handlers) notifyClients(events []*database.Event, perfs []*database.Performance, errs []*database.Error) {
-	h.sseMu.RLock()
-	defer h.sseMu.RUnlock()
+	h.sseMu.Lock()
+	defer h.sseMu.Unlock()
 
-	if len(h.sseClients) == 0 {
+	if len(h.sseClients) == 0 && h.replay == nil {
 		return
 	}
 
-	// Build notification
+	// Notifications are built once per domain filter. The replay buffer
+	// keeps the unfiltered one and one per domain in the batch.
+	payloads := make(map[string][]byte)
+	build := func(domain string) []byte {
+		data, ok := payloads[domain]
+		if !ok {
+			data = buildNotification(domain, events, perfs, errs)
+			payloads[domain] = data
+		}
+		return data
+	}
+
+	h.realtimeSeq++
+	id := h.realtimeSeq
+
+	if h.replay != nil {
+		build("")
+		for _, e := range events {
+			build(e.Domain)
+		}
+		for _, p := range perfs {
+			build(p.Domain)
+		}
+		for _, e := range errs {
+			build(e.Domain)
+		}
+		h.replay.add(replayEntry{id: id, payloads: payloads})
+	}
+
+	for client, domain := range h.sseClients {
+		data := build(domain)
+		if data == nil {
+			continue
+		}
+		select {
+		case client <- realtimeMessage{id: id, data: data}:
+		default:
+			// Client buffer full, skip
+		}
+	}
+}
+
+// buildNotification summarizes an ingested batch for realtime clients. With a
+// domain filter only that domain's items are counted, and nil is returned
+// when none match.
+func buildNotification(domain string, events []*database.Event, perfs []*database.Performance, errs []*database.Error) []byte {
+	if domain != "" {
+		var matchedEvents []*database.Event
+		for _, e := range events {
+			if e.Domain == domain {
+				matchedEvents = append(matchedEvents, e)
+			}
+		}
+		var matchedPerfs []*database.Performance
+		for _, p := range perfs {
+			if p.Domain == domain {
+				matchedPerfs = append(matchedPerfs, p)
+			}
+		}
+		var matchedErrs []*database.Error
+		for _, e := range errs {
+			if e.Domain == domain {
+				matchedErrs = append(matchedErrs, e)
+			}
+		}
+		if len(matchedEvents) == 0 && len(matchedPerfs) == 0 && len(matchedErrs) == 0 {
+			return nil
+		}
+		events, perfs, errs = matchedEvents, matchedPerfs, matchedErrs
+	}
+
 	notification := map[string]interface{}{
 		"type":        "batch",
 		"events":      len(events),
@@ -681,12 +1408,5 @@ func (h *Handlers) notifyClients(events []*database.Event, perfs []*database.Per
 	}
 
 	data, _ := json.Marshal(notification)
-
-	for client := range h.sseClients {
-		select {
-		case client <- data:
-		default:
-			// Client buffer full, skip
-		}
-	}
+	return data
 }
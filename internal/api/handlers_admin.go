@@ -1,15 +1,19 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
 )
 
 // ListUsers returns all users
@@ -106,16 +110,39 @@ func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
 }
 
+// notLastAdmin is a WHERE condition that excludes the only remaining admin.
+// It is part of the DELETE/UPDATE itself rather than a separate check, so two
+// concurrent requests can't each remove one of the last two admins.
+const notLastAdmin = "(role != 'admin' OR (SELECT COUNT(*) FROM users WHERE role = 'admin') > 1)"
+
+// userExists reports whether a user with the given ID exists
+func (h *Handlers) userExists(id string) bool {
+	var existingID string
+	return h.db.Conn().QueryRow("SELECT id FROM users WHERE id = ?", id).Scan(&existingID) == nil
+}
+
 // DeleteUser removes a user
 func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	_, err := h.db.Conn().Exec("DELETE FROM users WHERE id = ?", id)
+	// Never allow the last admin to be removed, or nobody could manage the instance
+	result, err := h.db.Conn().Exec("DELETE FROM users WHERE id = ? AND "+notLastAdmin, id)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		if h.userExists(id) {
+			writeError(w, http.StatusConflict, "Cannot delete the last admin account")
+			return
+		}
+		writeError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	h.db.Conn().Exec("DELETE FROM api_keys WHERE user_id = ?", id)
+
 	h.logAudit(r, "delete", "user", id, "User deleted")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -136,9 +163,7 @@ func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if user exists
-	var existingID string
-	err := h.db.Conn().QueryRow("SELECT id FROM users WHERE id = ?", id).Scan(&existingID)
-	if err != nil {
+	if !h.userExists(id) {
 		writeError(w, http.StatusNotFound, "User not found")
 		return
 	}
@@ -151,6 +176,11 @@ func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 	now := time.Now().UnixMilli()
 
+	// Prevent demoting the last admin; the condition only applies to demotions
+	demotion := "(? != 'viewer' OR " + notLastAdmin + ")"
+	var result sql.Result
+	var err error
+
 	// If password is provided, validate and hash it
 	if input.Password != "" {
 		if len(input.Password) < 8 {
@@ -164,18 +194,18 @@ func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = h.db.Conn().Exec(
-			"UPDATE users SET name = COALESCE(NULLIF(?, ''), name), role = COALESCE(NULLIF(?, ''), role), password_hash = ?, updated_at = ? WHERE id = ?",
-			input.Name, input.Role, passwordHash, now, id,
+		result, err = h.db.Conn().Exec(
+			"UPDATE users SET name = COALESCE(NULLIF(?, ''), name), role = COALESCE(NULLIF(?, ''), role), password_hash = ?, updated_at = ? WHERE id = ? AND "+demotion,
+			input.Name, input.Role, passwordHash, now, id, input.Role,
 		)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 	} else {
-		_, err = h.db.Conn().Exec(
-			"UPDATE users SET name = COALESCE(NULLIF(?, ''), name), role = COALESCE(NULLIF(?, ''), role), updated_at = ? WHERE id = ?",
-			input.Name, input.Role, now, id,
+		result, err = h.db.Conn().Exec(
+			"UPDATE users SET name = COALESCE(NULLIF(?, ''), name), role = COALESCE(NULLIF(?, ''), role), updated_at = ? WHERE id = ? AND "+demotion,
+			input.Name, input.Role, now, id, input.Role,
 		)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
@@ -183,17 +213,111 @@ func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusConflict, "Cannot demote the last admin account")
+		return
+	}
+
 	h.logAudit(r, "update", "user", id, fmt.Sprintf("Updated user (name: %s, role: %s)", input.Name, input.Role))
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ListDomains returns all registered domains
+// domainColumns are the domains columns a domainList record is read from
+const domainColumns = "id, name, domain, site_id, timezone, custom_dimensions, path_patterns, consent_mode, forward_url, forward_fields, event_quota, retention_days, created_by, created_at, is_active"
+
+// domainList holds what domain records add to their columns: effective
+// retention and sampling, quota usage and stored event counts
+type domainList struct {
+	maxDays     int
+	retention   database.RetentionPolicy
+	sampleRates map[string]float64
+	period      string
+	usage       map[string]int64
+	activity    map[string]domainActivity
+}
+
+// newDomainList loads what domain records need besides their columns. Call
+// it before querying the domains, as the connection is held while their rows
+// are open.
+func (h *Handlers) newDomainList() (*domainList, error) {
+	activity, err := h.domainActivity.load(h.db.Conn())
+	if err != nil {
+		return nil, err
+	}
+	// Sampling is instance-wide, and so is retention unless a domain
+	// overrides it
+	maxDays := h.licenseManager.GetLimit("max_retention_days")
+	return &domainList{
+		maxDays:   maxDays,
+		retention: database.NewRetentionPolicy(maxDays, newSettingsService(h).GetInt),
+		sampleRates: map[string]float64{
+			"events":      1,
+			"performance": h.cfg.PerformanceSampleRate,
+			"errors":      h.cfg.ErrorSampleRate,
+		},
+		// Event quotas are per UTC month
+		period:   usagePeriod(time.Now()),
+		usage:    h.domainUsage(),
+		activity: activity,
+	}, nil
+}
+
+// record scans a domain's domainColumns into its API representation
+func (l *domainList) record(scan func(dest ...interface{}) error) (map[string]interface{}, error) {
+	var id, name, domain, consentMode string
+	var siteID, timezone, dimensions, patterns, forwardURL, fields, createdBy *string
+	var quota *int64
+	var retentionDays *int
+	var createdAt int64
+	var isActive int
+
+	if err := scan(&id, &name, &domain, &siteID, &timezone, &dimensions, &patterns, &consentMode, &forwardURL, &fields, &quota, &retentionDays, &createdBy, &createdAt, &isActive); err != nil {
+		return nil, err
+	}
+	stats := l.activity[domain]
+	retention := l.retention
+	if retentionDays != nil {
+		retention = database.DomainRetentionPolicy(*retentionDays, l.maxDays)
+	}
+	quotaUsage := map[string]interface{}{
+		"period":   l.period,
+		"events":   l.usage[domain],
+		"exceeded": quota != nil && l.usage[domain] >= *quota,
+	}
+	return map[string]interface{}{
+		"id":                 id,
+		"name":               name,
+		"domain":             domain,
+		"site_id":            siteID,
+		"timezone":           timezone,
+		"custom_dimensions":  parseCustomDimensions(dimensions),
+		"path_patterns":      parsePathPatterns(patterns),
+		"consent_mode":       consentMode,
+		"forward_url":        forwardURL,
+		"forward_fields":     forwardFields(fields),
+		"event_quota":        quota,
+		"quota_usage":        quotaUsage,
+		"created_by":         createdBy,
+		"created_at":         createdAt,
+		"is_active":          isActive == 1,
+		"retention_days":     retention,
+		"retention_override": retentionDays,
+		"sample_rates":       l.sampleRates,
+		"event_count":        stats.events,
+		"last_event_at":      stats.lastEventAt,
+	}, nil
+}
+
+// ListDomains returns all registered domains with their effective retention
+// and sampling, stored event count and last event time (cached for a minute)
 func (h *Handlers) ListDomains(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.Conn().Query(`
-		SELECT id, name, domain, site_id, created_by, created_at, is_active
-		FROM domains
-		ORDER BY created_at DESC
-	`)
+	list, err := h.newDomainList()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rows, err := h.db.Conn().Query("SELECT " + domainColumns + " FROM domains ORDER BY created_at DESC")
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -202,33 +326,74 @@ func (h *Handlers) ListDomains(w http.ResponseWriter, r *http.Request) {
 
 	domains := make([]map[string]interface{}, 0)
 	for rows.Next() {
-		var id, name, domain string
-		var siteID, createdBy *string
-		var createdAt int64
-		var isActive int
-
-		rows.Scan(&id, &name, &domain, &siteID, &createdBy, &createdAt, &isActive)
-		domains = append(domains, map[string]interface{}{
-			"id":         id,
-			"name":       name,
-			"domain":     domain,
-			"site_id":    siteID,
-			"created_by": createdBy,
-			"created_at": createdAt,
-			"is_active":  isActive == 1,
-		})
+		domain, err := list.record(rows.Scan)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		domains = append(domains, domain)
 	}
 
 	writeJSON(w, http.StatusOK, domains)
 }
 
+// domainActivityTTL is how long per-domain event counts are reused. Counting
+// scans the events table, so the domain list doesn't redo it on every load.
+const domainActivityTTL = time.Minute
+
+type domainActivity struct {
+	events      int64
+	lastEventAt *int64
+}
+
+// domainActivityCache holds stored event counts and the last event time per
+// domain, as recorded on events
+type domainActivityCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	byDomain map[string]domainActivity
+}
+
+func (c *domainActivityCache) load(db *sql.DB) (map[string]domainActivity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.byDomain != nil && time.Since(c.loadedAt) < domainActivityTTL {
+		return c.byDomain, nil
+	}
+
+	rows, err := db.Query("SELECT domain, COUNT(*), MAX(timestamp) FROM events GROUP BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byDomain := make(map[string]domainActivity)
+	for rows.Next() {
+		var domain string
+		var a domainActivity
+		if err := rows.Scan(&domain, &a.events, &a.lastEventAt); err != nil {
+			return nil, err
+		}
+		byDomain[domain] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.byDomain, c.loadedAt = byDomain, time.Now()
+	return byDomain, nil
+}
+
 // CreateDomain adds a new domain
 func (h *Handlers) CreateDomain(w http.ResponseWriter, r *http.Request) {
 	claims := auth.GetUserFromContext(r.Context())
 
 	var input struct {
-		Name   string `json:"name"`
-		Domain string `json:"domain"`
+		Name          string `json:"name"`
+		Domain        string `json:"domain"`
+		Timezone      string `json:"timezone"`
+		RetentionDays int    `json:"retention_days"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -241,6 +406,21 @@ func (h *Handlers) CreateDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var timezone *string
+	if input.Timezone != "" {
+		if !validTimezone(input.Timezone) {
+			writeError(w, http.StatusBadRequest, "Unknown timezone (use an IANA name such as Europe/Lisbon)")
+			return
+		}
+		timezone = &input.Timezone
+	}
+
+	retentionDays, err := normalizeRetentionDays(input.RetentionDays, h.licenseManager.GetLimit("max_retention_days"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Check domain limit based on license tier
 	var domainCount int
 	h.db.Conn().QueryRow("SELECT COUNT(*) FROM domains").Scan(&domainCount)
@@ -275,9 +455,9 @@ func (h *Handlers) CreateDomain(w http.ResponseWriter, r *http.Request) {
 		createdBy = &claims.UserID
 	}
 
-	_, err := h.db.Conn().Exec(
-		"INSERT INTO domains (id, name, domain, site_id, created_by, created_at, is_active) VALUES (?, ?, ?, ?, ?, ?, 1)",
-		id, input.Name, domain, siteID, createdBy, now,
+	_, err = h.db.Conn().Exec(
+		"INSERT INTO domains (id, name, domain, site_id, timezone, retention_days, created_by, created_at, is_active) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)",
+		id, input.Name, domain, siteID, timezone, retentionDays, createdBy, now,
 	)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint") {
@@ -290,15 +470,213 @@ func (h *Handlers) CreateDomain(w http.ResponseWriter, r *http.Request) {
 
 	h.logAudit(r, "create", "domain", id, fmt.Sprintf("Created domain %s (%s)", input.Name, domain))
 	writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":         id,
-		"name":       input.Name,
-		"domain":     domain,
-		"site_id":    siteID,
-		"created_at": now,
-		"is_active":  true,
+		"id":                 id,
+		"name":               input.Name,
+		"domain":             domain,
+		"site_id":            siteID,
+		"timezone":           timezone,
+		"retention_override": retentionDays,
+		"created_at":         now,
+		"is_active":          true,
 	})
 }
 
+// normalizeRetentionDays validates a domain's retention override against the
+// license limit maxDays (-1 for unlimited); 0 removes it
+func normalizeRetentionDays(days, maxDays int) (*int, error) {
+	if maxDays == -1 {
+		maxDays = database.UnlimitedRetentionDays
+	}
+	switch {
+	case days < 0:
+		return nil, fmt.Errorf("retention_days must be 0 (instance retention) or more")
+	case days == 0:
+		return nil, nil
+	case days > maxDays:
+		return nil, fmt.Errorf("retention_days can be at most %d on this license", maxDays)
+	}
+	return &days, nil
+}
+
+// UpdateDomain changes a domain's display name, whether it's active, report
+// timezone and/or custom dimensions, and returns the updated domain. An
+// empty timezone reverts to the instance default; an empty custom_dimensions
+// object removes them all. An inactive domain keeps its data, but ingest
+// rejects its events until it's activated again.
+func (h *Handlers) UpdateDomain(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var input struct {
+		Name             *string            `json:"name"`
+		IsActive         *bool              `json:"is_active"`
+		Timezone         *string            `json:"timezone"`
+		CustomDimensions *map[string]string `json:"custom_dimensions"`
+		PathPatterns     *[]string          `json:"path_patterns"`
+		ConsentMode      *string            `json:"consent_mode"`
+		ForwardURL       *string            `json:"forward_url"`
+		ForwardFields    *[]string          `json:"forward_fields"`
+		EventQuota       *int64             `json:"event_quota"`
+		RetentionDays    *int               `json:"retention_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var sets, changed []string
+	var args []interface{}
+	if input.Name != nil {
+		if *input.Name == "" {
+			writeError(w, http.StatusBadRequest, "Name cannot be empty")
+			return
+		}
+		sets = append(sets, "name = ?")
+		args = append(args, *input.Name)
+		changed = append(changed, "name: "+*input.Name)
+	}
+	if input.IsActive != nil {
+		active := 0
+		if *input.IsActive {
+			active = 1
+		}
+		sets = append(sets, "is_active = ?")
+		args = append(args, active)
+		changed = append(changed, fmt.Sprintf("is_active: %t", *input.IsActive))
+	}
+	if input.Timezone != nil {
+		if *input.Timezone != "" && !validTimezone(*input.Timezone) {
+			writeError(w, http.StatusBadRequest, "Unknown timezone (use an IANA name such as Europe/Lisbon)")
+			return
+		}
+		sets = append(sets, "timezone = NULLIF(?, '')")
+		args = append(args, *input.Timezone)
+		changed = append(changed, "timezone: "+*input.Timezone)
+	}
+	if input.CustomDimensions != nil {
+		dims, err := normalizeCustomDimensions(*input.CustomDimensions)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var value *string
+		if len(dims) > 0 {
+			raw, _ := json.Marshal(dims)
+			s := string(raw)
+			value = &s
+		}
+		sets = append(sets, "custom_dimensions = ?")
+		args = append(args, value)
+		changed = append(changed, fmt.Sprintf("custom_dimensions: %d", len(dims)))
+	}
+	if input.PathPatterns != nil {
+		patterns, err := normalizePathPatterns(*input.PathPatterns)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var value *string
+		if len(patterns) > 0 {
+			raw, _ := json.Marshal(patterns)
+			s := string(raw)
+			value = &s
+		}
+		sets = append(sets, "path_patterns = ?")
+		args = append(args, value)
+		changed = append(changed, fmt.Sprintf("path_patterns: %d", len(patterns)))
+	}
+	if input.ConsentMode != nil {
+		if !validConsentMode(*input.ConsentMode) {
+			writeError(w, http.StatusBadRequest, "consent_mode must be off, require, anonymous or aggregate")
+			return
+		}
+		sets = append(sets, "consent_mode = ?")
+		args = append(args, *input.ConsentMode)
+		changed = append(changed, "consent_mode: "+*input.ConsentMode)
+	}
+	if input.ForwardURL != nil {
+		forwardURL, err := normalizeForwardURL(strings.TrimSpace(*input.ForwardURL))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets = append(sets, "forward_url = ?")
+		args = append(args, forwardURL)
+		if forwardURL == nil {
+			changed = append(changed, "forwarding: off")
+		} else {
+			changed = append(changed, "forward_url: "+*forwardURL)
+		}
+	}
+	if input.ForwardFields != nil {
+		if err := forwarding.ValidateFields(*input.ForwardFields); err != nil {
+			writeError(w, http.StatusBadRequest, "forward_fields: "+err.Error())
+			return
+		}
+		var value *string
+		if len(*input.ForwardFields) > 0 {
+			raw, _ := json.Marshal(*input.ForwardFields)
+			s := string(raw)
+			value = &s
+		}
+		sets = append(sets, "forward_fields = ?")
+		args = append(args, value)
+		changed = append(changed, fmt.Sprintf("forward_fields: %d", len(*input.ForwardFields)))
+	}
+	if input.EventQuota != nil {
+		quota, err := normalizeEventQuota(*input.EventQuota)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets = append(sets, "event_quota = ?")
+		args = append(args, quota)
+		changed = append(changed, fmt.Sprintf("event_quota: %d", *input.EventQuota))
+	}
+	if input.RetentionDays != nil {
+		days, err := normalizeRetentionDays(*input.RetentionDays, h.licenseManager.GetLimit("max_retention_days"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets = append(sets, "retention_days = ?")
+		args = append(args, days)
+		changed = append(changed, fmt.Sprintf("retention_days: %d", *input.RetentionDays))
+	}
+	if len(sets) == 0 {
+		writeError(w, http.StatusBadRequest, "Nothing to update")
+		return
+	}
+
+	result, err := h.db.Conn().Exec("UPDATE domains SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, id)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusNotFound, "Domain not found")
+		return
+	}
+
+	// Forwarding only covers active domains
+	if input.ForwardURL != nil || input.ForwardFields != nil || input.IsActive != nil {
+		h.forwarder.Reload()
+	}
+
+	h.logAudit(r, "update", "domain", id, fmt.Sprintf("Updated domain (%s)", strings.Join(changed, ", ")))
+
+	list, err := h.newDomainList()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	domain, err := list.record(h.db.Conn().QueryRow("SELECT "+domainColumns+" FROM domains WHERE id = ?", id).Scan)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, domain)
+}
+
 // DeleteDomain removes a domain
 func (h *Handlers) DeleteDomain(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -315,6 +693,8 @@ func (h *Handlers) DeleteDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.forwarder.Reload()
+
 	h.logAudit(r, "delete", "domain", id, "Domain deleted")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -330,19 +710,19 @@ func (h *Handlers) GetDomainSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the host from the request or use localhost for local dev
-	host := r.Host
-	scheme := "https"
-	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
-		scheme = "http"
-	}
+	// The public_url setting wins; otherwise use the host and scheme the
+	// admin reached us on
+	baseURL := h.publicBaseURL(r)
 
 	snippet := fmt.Sprintf(`<!-- Etiquetta Analytics -->
-<script defer data-site="%s" src="%s://%s/s.js"></script>`, siteID, scheme, host)
+<script defer data-site="%s" src="%s%s"></script>`, siteID, baseURL, h.scriptPath)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"domain":  domain,
 		"site_id": siteID,
 		"snippet": snippet,
+		// Pinned to the current script build; suits proxies and CDNs that
+		// cache aggressively, but must be updated after upgrades
+		"versioned_script_url": baseURL + h.versionedScriptURL(),
 	})
 }
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/config"
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// loginViewer adds a viewer and returns their session cookie
+func loginViewer(t *testing.T, router http.Handler, db *database.DB) *http.Cookie {
+	t.Helper()
+	hash, err := auth.HashPassword("password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UnixMilli()
+	_, err = db.Conn().Exec(
+		"INSERT INTO users (id, email, password_hash, name, role, created_at, updated_at) VALUES (?, ?, ?, ?, 'viewer', ?, ?)",
+		"viewer1", "viewer@example.com", hash, "Viewer", now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"email":"viewer@example.com","password":"password123"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "etiquetta_session" {
+			return c
+		}
+	}
+	t.Fatalf("login: status %d, no session cookie: %s", w.Code, w.Body)
+	return nil
+}
+
+// updateDomain sends PUT /api/domains/d1 with session
+func updateDomain(router http.Handler, session *http.Cookie, body string) int {
+	r := httptest.NewRequest("PUT", "/api/domains/d1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestUpdateDomainRequiresAdmin(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+	viewer := loginViewer(t, router, db)
+
+	if code := updateDomain(router, viewer, `{"timezone":"Europe/Lisbon"}`); code != http.StatusForbidden {
+		t.Errorf("viewer update: status %d, want %d", code, http.StatusForbidden)
+	}
+	var tz *string
+	db.Conn().QueryRow("SELECT timezone FROM domains WHERE id = 'd1'").Scan(&tz)
+	if tz != nil {
+		t.Errorf("timezone = %q after a viewer's update, want unset", *tz)
+	}
+
+	if code := updateDomain(router, admin, `{"timezone":"Europe/Lisbon"}`); code != http.StatusOK {
+		t.Errorf("admin update: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestViewerCannotShortenRetention(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+	viewer := loginViewer(t, router, db)
+
+	if code := updateDomain(router, viewer, `{"retention_days":1}`); code != http.StatusForbidden {
+		t.Errorf("viewer retention update: status %d, want %d", code, http.StatusForbidden)
+	}
+	var days sql.NullInt64
+	db.Conn().QueryRow("SELECT retention_days FROM domains WHERE id = 'd1'").Scan(&days)
+	if days.Valid {
+		t.Errorf("retention_days = %d after a viewer's update, want unset", days.Int64)
+	}
+
+	if code := updateDomain(router, admin, `{"retention_days":5}`); code != http.StatusOK {
+		t.Fatalf("admin retention update: status %d, want %d", code, http.StatusOK)
+	}
+	db.Conn().QueryRow("SELECT retention_days FROM domains WHERE id = 'd1'").Scan(&days)
+	if !days.Valid || days.Int64 != 5 {
+		t.Errorf("retention_days = %d (set %v) after the admin's update, want 5", days.Int64, days.Valid)
+	}
+}
+
+func TestDomainForwardingRequiresAdmin(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+	viewer := loginViewer(t, router, db)
+
+	r := httptest.NewRequest("GET", "/api/domains/d1/forwarding", nil)
+	r.AddCookie(viewer)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("viewer forwarding settings: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	for _, target := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/", "http://10.1.2.3/hook"} {
+		if code := updateDomain(router, admin, `{"forward_url":"`+target+`"}`); code != http.StatusBadRequest {
+			t.Errorf("forward_url %s: status %d, want %d", target, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// userRequest sends method to /users/{id} on a router with only the user
+// management handlers, which the full router puts behind a license check
+func userRequest(h *Handlers, method, id, body string) int {
+	router := chi.NewRouter()
+	router.Put("/users/{id}", h.UpdateUser)
+	router.Delete("/users/{id}", h.DeleteUser)
+
+	r := httptest.NewRequest(method, "/users/"+id, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestLastAdminIsKept(t *testing.T) {
+	h := newTestHandlers(t)
+	now := time.Now().UnixMilli()
+	for _, id := range []string{"admin1", "admin2"} {
+		if _, err := h.db.Conn().Exec(
+			"INSERT INTO users (id, email, password_hash, name, role, created_at, updated_at) VALUES (?, ?, 'x', ?, 'admin', ?, ?)",
+			id, id+"@example.com", id, now, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting both admins at once must leave one behind
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, id := range []string{"admin1", "admin2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- userRequest(h, "DELETE", id, "")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	conflicts := 0
+	for code := range codes {
+		if code == http.StatusConflict {
+			conflicts++
+		}
+	}
+	var lastID string
+	var admins int
+	h.db.Conn().QueryRow("SELECT COUNT(*), MAX(id) FROM users WHERE role = 'admin'").Scan(&admins, &lastID)
+	if admins != 1 || conflicts != 1 {
+		t.Fatalf("%d admins left and %d conflicts after deleting both, want 1 and 1", admins, conflicts)
+	}
+
+	if code := userRequest(h, "PUT", lastID, `{"role":"viewer"}`); code != http.StatusConflict {
+		t.Errorf("demoting the last admin: status %d, want %d", code, http.StatusConflict)
+	}
+	if code := userRequest(h, "PUT", lastID, `{"name":"Still admin"}`); code != http.StatusNoContent {
+		t.Errorf("renaming the last admin: status %d, want %d", code, http.StatusNoContent)
+	}
+	if code := userRequest(h, "DELETE", "missing", ""); code != http.StatusNotFound {
+		t.Errorf("deleting an unknown user: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// storedSettings returns every stored setting by key
+func storedSettings(t *testing.T, db *database.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Conn().Query("SELECT key, value FROM settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			t.Fatal(err)
+		}
+		settings[key] = value
+	}
+	return settings
+}
+
+func TestViewerCannotChangeAdminSettings(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+	viewer := loginViewer(t, router, db)
+
+	before := storedSettings(t, db)
+	for _, body := range []string{
+		`{"traffic_alerts_enabled":"false"}`,
+		`{"traffic_alert_emails":"someone@example.net"}`,
+		`{"traffic_alert_webhook":"https://203.0.113.10/hook"}`,
+		`{"retention_days_events":"1"}`,
+		`{"retention_days_performance":"1"}`,
+		`{"retention_days_errors":"1"}`,
+		`{"excluded_ip_hashes":"abc123"}`,
+		`{"respect_dnt":"false"}`,
+		`{"url_scrub_rules":"[]"}`,
+		`{"admin_allowed_cidrs":""}`,
+		`{"admin_allowed_countries":"PT"}`,
+		`{"trusted_proxies":"0.0.0.0/0"}`,
+		`{"security_headers_enabled":"false"}`,
+		`{"content_security_policy":"off"}`,
+		`{"hsts_max_age_seconds":"0"}`,
+		`{"referrer_policy":"unsafe-url"}`,
+		`{"report_recipients":"someone@example.net"}`,
+		`{"report_frequency":"daily"}`,
+		`{"report_hour":"3"}`,
+	} {
+		if w := putSettings(router, viewer, body); w.Code != http.StatusForbidden {
+			t.Errorf("viewer PUT %s: status %d, want %d", body, w.Code, http.StatusForbidden)
+		}
+	}
+	if after := storedSettings(t, db); !reflect.DeepEqual(after, before) {
+		t.Errorf("settings changed by a viewer:\nbefore %v\nafter  %v", before, after)
+	}
+
+	if w := putSettings(router, viewer, `{"theme":"dark"}`); w.Code != http.StatusNoContent {
+		t.Errorf("viewer PUT of an ordinary setting: status %d: %s", w.Code, w.Body)
+	}
+	if w := putSettings(router, admin, `{"traffic_alert_emails":"ops@example.com"}`); w.Code != http.StatusNoContent {
+		t.Errorf("admin PUT traffic_alert_emails: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestTrafficAlertWebhookMustBePublic(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+
+	for _, target := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/", "ftp://203.0.113.10/"} {
+		if w := putSettings(router, admin, `{"traffic_alert_webhook":"`+target+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("traffic_alert_webhook %s: status %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := putSettings(router, admin, `{"traffic_alert_emails":"not-an-address"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("traffic_alert_emails without @: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRetentionSettingsRange(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+
+	// The community license keeps at most 7 days
+	for _, days := range []string{"0", "-3", "8", "forever"} {
+		if w := putSettings(router, admin, `{"retention_days_events":"`+days+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("retention_days_events %q: status %d, want %d", days, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := putSettings(router, admin, `{"retention_days_errors":"3"}`); w.Code != http.StatusNoContent {
+		t.Errorf("retention_days_errors 3: status %d: %s", w.Code, w.Body)
+	}
+}
+
+// public_url is the base of password reset links, so a viewer who could set
+// it would receive the admin's reset token
+func TestViewerCannotSetPublicURL(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	admin := setupAdmin(t, router)
+	viewer := loginViewer(t, router, db)
+
+	if w := putSettings(router, viewer, `{"public_url":"https://attacker.example.net"}`); w.Code != http.StatusForbidden {
+		t.Errorf("viewer PUT public_url: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if got := storedSettings(t, db)["public_url"]; got != "" {
+		t.Errorf("public_url = %q after a viewer's update, want unset", got)
+	}
+
+	if w := putSettings(router, admin, `{"public_url":"https://analytics.example.com"}`); w.Code != http.StatusNoContent {
+		t.Errorf("admin PUT public_url: status %d: %s", w.Code, w.Body)
+	}
+}
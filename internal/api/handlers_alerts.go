@@ -0,0 +1,187 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/alerting"
+	"github.com/caioricciuti/etiquetta/internal/auth"
+)
+
+// alertRuleInput is the editable part of an alert rule
+type alertRuleInput struct {
+	Name            string   `json:"name"`
+	Domain          string   `json:"domain"`
+	Metric          string   `json:"metric"`
+	Operator        string   `json:"operator"`
+	Threshold       float64  `json:"threshold"`
+	WindowMinutes   int      `json:"window_minutes"`
+	CooldownMinutes *int     `json:"cooldown_minutes"`
+	NotifyEmails    []string `json:"notify_emails"`
+	WebhookURL      string   `json:"webhook_url"`
+	Enabled         *bool    `json:"enabled"`
+}
+
+func (in *alertRuleInput) apply(rule *alerting.Rule) {
+	rule.Name = in.Name
+	rule.Domain = in.Domain
+	rule.Metric = in.Metric
+	rule.Operator = in.Operator
+	rule.Threshold = in.Threshold
+	rule.WindowMinutes = in.WindowMinutes
+	rule.NotifyEmails = in.NotifyEmails
+	rule.WebhookURL = in.WebhookURL
+	if in.CooldownMinutes != nil {
+		rule.CooldownMinutes = *in.CooldownMinutes
+	}
+	if in.Enabled != nil {
+		rule.Enabled = *in.Enabled
+	}
+}
+
+// ListAlertRules returns all alert rules with their current state
+func (h *Handlers) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := alerting.ListRules(h.db.Conn())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// CreateAlertRule adds a new alert rule
+func (h *Handlers) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var input alertRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	rule := &alerting.Rule{ID: generateID(), CooldownMinutes: 60, Enabled: true}
+	input.apply(rule)
+	if err := rule.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if claims := auth.GetUserFromContext(r.Context()); claims != nil {
+		rule.CreatedBy = &claims.UserID
+	}
+
+	if err := alerting.CreateRule(h.db.Conn(), rule); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logAudit(r, "create", "alert_rule", rule.ID, fmt.Sprintf("Created alert %q (%s)", rule.Name, rule.Describe()))
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// UpdateAlertRule replaces an alert rule's configuration
+func (h *Handlers) UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	rule, err := alerting.GetRule(h.db.Conn(), id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Alert rule not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var input alertRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	input.apply(rule)
+	if err := rule.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := alerting.UpdateRule(h.db.Conn(), rule); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rule.State = alerting.StateOK
+
+	h.logAudit(r, "update", "alert_rule", id, fmt.Sprintf("Updated alert %q (%s)", rule.Name, rule.Describe()))
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// DeleteAlertRule removes an alert rule and its history
+func (h *Handlers) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	deleted, err := alerting.DeleteRule(h.db.Conn(), id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "Alert rule not found")
+		return
+	}
+
+	h.logAudit(r, "delete", "alert_rule", id, "Deleted alert rule")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// GetAlertHistory returns recent fired/resolved transitions.
+// Optional query params: rule_id, limit (default 100, max 1000).
+func (h *Handlers) GetAlertHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = min(l, 1000)
+	}
+
+	entries, err := alerting.ListHistory(h.db.Conn(), r.URL.Query().Get("rule_id"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// GetTrafficStatus returns the latest traffic-drop check per domain, so the
+// dashboard can flag sites whose tracking snippet appears to be broken
+func (h *Handlers) GetTrafficStatus(w http.ResponseWriter, r *http.Request) {
+	statuses, err := alerting.ListTrafficStatus(h.db.Conn())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// GetStatsBotRate compares the bot rate of the last window (window minutes,
+// default 60, at most a day) with its baseline over the previous week, and
+// flags a spike, so bot attacks can be spotted while they happen
+func (h *Handlers) GetStatsBotRate(w http.ResponseWriter, r *http.Request) {
+	window := alerting.DefaultBotRateWindow
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 5 || time.Duration(n)*time.Minute > alerting.MaxBotRateWindow {
+			writeError(w, http.StatusBadRequest, "window must be between 5 and 1440 minutes")
+			return
+		}
+		window = time.Duration(n) * time.Minute
+	}
+
+	rate, err := alerting.CheckBotRate(h.db.Conn(), getDomainParam(r), time.Now(), window)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rate)
+}
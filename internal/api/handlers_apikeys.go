@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+)
+
+// maxAPIKeyNameLength caps an API key's name
+const maxAPIKeyNameLength = 100
+
+// apiKeyUseInterval is how often a key's last_used_at is updated at most,
+// so scripts hammering the API don't write on every request
+const apiKeyUseInterval = time.Minute
+
+// apiKey is an API key as listed; the key itself is only returned once, at
+// creation
+type apiKey struct {
+	ID         string `json:"id"`
+	UserID     string `json:"user_id"`
+	UserEmail  string `json:"user_email"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"` // the key's first characters, to recognize it
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt *int64 `json:"last_used_at"`
+	Key        string `json:"key,omitempty"`
+}
+
+// lookupAPIKey resolves an API key to its user's claims. The user's current
+// role applies, and deleting the user disables their keys.
+func (h *Handlers) lookupAPIKey(key string) (*auth.Claims, error) {
+	var id string
+	var lastUsed *int64
+	claims := &auth.Claims{}
+	err := h.db.Conn().QueryRow(`
+		SELECT k.id, k.last_used_at, u.id, u.email, u.role
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = ?
+	`, auth.HashAPIKey(key)).Scan(&id, &lastUsed, &claims.UserID, &claims.Email, &claims.Role)
+	if err != nil {
+		return nil, auth.ErrInvalidToken
+	}
+	claims.APIKeyID = id
+
+	now := time.Now()
+	stale := lastUsed == nil || now.Sub(time.UnixMilli(*lastUsed)) >= apiKeyUseInterval
+	if stale && h.db.MigrationFailure() == nil {
+		h.db.Conn().Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now.UnixMilli(), id)
+	}
+	return claims, nil
+}
+
+// ListAPIKeys returns the current user's API keys, or every user's for
+// admins
+func (h *Handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+
+	query := `
+		SELECT k.id, k.user_id, COALESCE(u.email, ''), k.name, k.key_prefix, k.created_at, k.last_used_at
+		FROM api_keys k
+		LEFT JOIN users u ON u.id = k.user_id
+	`
+	var args []interface{}
+	if claims.Role != "admin" {
+		query += " WHERE k.user_id = ?"
+		args = append(args, claims.UserID)
+	}
+	rows, err := h.db.Conn().Query(query+" ORDER BY k.created_at DESC", args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	keys := make([]apiKey, 0)
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.UserEmail, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// CreateAPIKey creates an API key for the current user, acting with their
+// role. The response is the only time the key is shown. Requires a session:
+// a leaked key can't be used to mint more keys that outlive its revocation.
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+	if claims.APIKeyID != "" {
+		writeError(w, http.StatusForbidden, "API keys can't create API keys; sign in to create one")
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > maxAPIKeyNameLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("name is required, at most %d characters", maxAPIKeyNameLength))
+		return
+	}
+
+	secret := auth.GenerateAPIKey()
+	key := apiKey{
+		ID:        generateID(),
+		UserID:    claims.UserID,
+		UserEmail: claims.Email,
+		Name:      input.Name,
+		Prefix:    secret[:len(auth.APIKeyPrefix)+8],
+		CreatedAt: time.Now().UnixMilli(),
+		Key:       secret,
+	}
+	_, err := h.db.Conn().Exec(
+		"INSERT INTO api_keys (id, user_id, name, key_hash, key_prefix, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		key.ID, key.UserID, key.Name, auth.HashAPIKey(secret), key.Prefix, key.CreatedAt,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logAudit(r, "create", "api_key", key.ID, "Created API key "+key.Name)
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// RevokeAPIKey deletes one of the current user's API keys; admins can
+// revoke anyone's
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	query := "DELETE FROM api_keys WHERE id = ?"
+	args := []interface{}{id}
+	if claims.Role != "admin" {
+		query += " AND user_id = ?"
+		args = append(args, claims.UserID)
+	}
+	result, err := h.db.Conn().Exec(query, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		writeError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	h.db.Conn().Exec("DELETE FROM api_key_usage WHERE key_id = ?", id)
+
+	h.logAudit(r, "revoke", "api_key", id, "API key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/config"
+)
+
+// setupAdmin creates the first admin through the setup endpoint and returns
+// their session cookie
+func setupAdmin(t *testing.T, router http.Handler) *http.Cookie {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/auth/setup", strings.NewReader(`{"email":"admin@example.com","password":"password123"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "etiquetta_session" {
+			return c
+		}
+	}
+	t.Fatalf("setup: status %d, no session cookie: %s", w.Code, w.Body)
+	return nil
+}
+
+// createAPIKey creates an API key with the given credentials
+func createAPIKey(router http.Handler, authorize func(*http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"name":"ci"}`))
+	r.Header.Set("Content-Type", "application/json")
+	authorize(r)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestCreateAPIKeyRequiresSession(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+	session := setupAdmin(t, router)
+
+	w := createAPIKey(router, func(r *http.Request) { r.AddCookie(session) })
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create with session: status %d: %s", w.Code, w.Body)
+	}
+	var created apiKey
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, auth.APIKeyPrefix) || !strings.HasPrefix(created.Key, created.Prefix) {
+		t.Errorf("key %q (prefix %q) doesn't start with %q", created.Key, created.Prefix, auth.APIKeyPrefix)
+	}
+
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+created.Key) }
+
+	r := httptest.NewRequest("GET", "/api/keys", nil)
+	bearer(r)
+	list := httptest.NewRecorder()
+	router.ServeHTTP(list, r)
+	if list.Code != http.StatusOK {
+		t.Fatalf("list with API key: status %d: %s", list.Code, list.Body)
+	}
+
+	if w := createAPIKey(router, bearer); w.Code != http.StatusForbidden {
+		t.Errorf("create with API key: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAPIKeyUsage(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	session := setupAdmin(t, router)
+	withSession := func(r *http.Request) { r.AddCookie(session) }
+
+	var used, unused apiKey
+	for _, k := range []*apiKey{&used, &unused} {
+		w := createAPIKey(router, withSession)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: status %d: %s", w.Code, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A server-side sender: no Origin, identified by its key
+	ingest := func(key string, n int) int {
+		var ndjson strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&ndjson, `{"type":"pageview","site_id":%q,"url":"https://%s/api-%d"}`+"\n", testSiteID, testDomain, i)
+		}
+		r := ingestRequest([]byte(ndjson.String()), "")
+		r.Header.Del("Origin")
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, n := range []int{2, 3} {
+		if code := ingest(used.Key, n); code != http.StatusNoContent {
+			t.Fatalf("ingest with key: status %d", code)
+		}
+	}
+	if code := ingest(auth.APIKeyPrefix+"unknown", 1); code != http.StatusUnauthorized {
+		t.Errorf("ingest with unknown key: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	r := httptest.NewRequest("GET", "/api/domains/d1/key/usage", nil)
+	withSession(r)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("usage: status %d: %s", w.Code, w.Body)
+	}
+	var report struct {
+		Domain string              `json:"domain"`
+		Keys   []apiKeyUsageReport `json:"keys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := make(map[string]apiKeyUsageReport)
+	for _, k := range report.Keys {
+		usage[k.ID] = k
+	}
+	if got := usage[used.ID]; got.Events != 5 || got.LastEventAt == nil || got.LastUsedAt == nil {
+		t.Errorf("used key = %+v, want 5 events with last use", got)
+	}
+	if got, ok := usage[unused.ID]; !ok || got.Events != 0 || got.LastEventAt != nil {
+		t.Errorf("unused key = %+v (listed %v), want listed with no events", got, ok)
+	}
+	// The counts came from memory: reading them doesn't flush
+	var rows int
+	db.Conn().QueryRow("SELECT COUNT(*) FROM api_key_usage").Scan(&rows)
+	if rows != 0 {
+		t.Errorf("api_key_usage has %d row(s) after a report, want 0 until the next flush", rows)
+	}
+}
+
+func TestAPIKeyUsageFlush(t *testing.T) {
+	h := newTestHandlers(t)
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var u apiKeyUsage
+	u.record("k1", map[string]int64{"a.com": 2, "b.com": 1}, day)
+	u.record("k1", map[string]int64{"a.com": 3}, day.Add(time.Hour))
+	u.flush(h.db)
+	u.record("k1", map[string]int64{"a.com": 1}, day.Add(2*time.Hour))
+	u.record("k1", map[string]int64{"a.com": 4}, day.Add(24*time.Hour))
+	u.flush(h.db)
+
+	rows, err := h.db.Conn().Query("SELECT domain, day, events, last_used_at FROM api_key_usage WHERE key_id = 'k1' ORDER BY domain, day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var domain, d string
+		var events, lastUsed int64
+		if err := rows.Scan(&domain, &d, &events, &lastUsed); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %s %d %s", domain, d, events, time.UnixMilli(lastUsed).UTC().Format("15:04")))
+	}
+	want := []string{"a.com 2026-03-01 6 14:00", "a.com 2026-03-02 4 12:00", "b.com 2026-03-01 1 12:00"}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/bot"
+)
+
+// botRescoreState tracks the most recent bot rescore run
+type botRescoreState struct {
+	Running    bool   `json:"running"`
+	Since      int64  `json:"since,omitempty"`
+	Processed  int64  `json:"processed"`
+	Changed    int64  `json:"changed"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  int64  `json:"started_at,omitempty"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+// StartBotRescore recomputes bot scores for stored events in the background
+// using the current weights. Poll GetBotRescoreStatus for progress.
+func (h *Handlers) StartBotRescore(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Since     string `json:"since"`
+		BatchSize int    `json:"batch_size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	since, err := bot.ParseSince(input.Since)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "since must be YYYY-MM-DD or RFC3339")
+		return
+	}
+
+	h.botRescoreMu.Lock()
+	if h.botRescore.Running {
+		h.botRescoreMu.Unlock()
+		writeError(w, http.StatusConflict, "A rescore is already in progress")
+		return
+	}
+	h.botRescore = botRescoreState{Running: true, Since: since.UnixMilli(), StartedAt: time.Now().UnixMilli()}
+	state := h.botRescore
+	h.botRescoreMu.Unlock()
+
+	go func() {
+		log.Printf("[bot] Rescoring events since %s", since.Format(time.RFC3339))
+		result, err := bot.RescoreEvents(h.db.Conn(), since, input.BatchSize, func(p bot.RescoreProgress) {
+			h.botRescoreMu.Lock()
+			h.botRescore.Processed, h.botRescore.Changed = p.Processed, p.Changed
+			h.botRescoreMu.Unlock()
+			log.Printf("[bot] Rescore progress: %d processed, %d changed", p.Processed, p.Changed)
+		})
+
+		h.botRescoreMu.Lock()
+		h.botRescore.Running = false
+		h.botRescore.Processed, h.botRescore.Changed = result.Processed, result.Changed
+		h.botRescore.FinishedAt = time.Now().UnixMilli()
+		if err != nil {
+			h.botRescore.Error = err.Error()
+			log.Printf("[bot] Rescore failed after %d event(s): %v", result.Processed, err)
+		} else {
+			log.Printf("[bot] Rescore complete: %d processed, %d changed", result.Processed, result.Changed)
+		}
+		h.botRescoreMu.Unlock()
+	}()
+
+	h.logAudit(r, "rescore", "bot", "", fmt.Sprintf("Started bot rescore since %s", since.Format("2006-01-02")))
+	writeJSON(w, http.StatusAccepted, state)
+}
+
+// GetBotRescoreStatus reports progress of the current or last rescore run
+func (h *Handlers) GetBotRescoreStatus(w http.ResponseWriter, r *http.Request) {
+	h.botRescoreMu.Lock()
+	state := h.botRescore
+	h.botRescoreMu.Unlock()
+
+	writeJSON(w, http.StatusOK, state)
+}
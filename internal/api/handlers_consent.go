@@ -362,6 +362,10 @@ func (h *Handlers) GetConsentAnalytics(w http.ResponseWriter, r *http.Request) {
 	domainID := chi.URLParam(r, "domainId")
 	startMs, endMs := getDateRangeParams(r, 30)
 
+	var domainName string
+	h.db.Conn().QueryRow("SELECT domain FROM domains WHERE id = ?", domainID).Scan(&domainName)
+	dayMod := h.dayModifier(domainName, endMs)
+
 	// Action counts (single query)
 	var shows, acceptAll, rejectAll, custom int
 	rows, err := h.db.Conn().Query(`
@@ -402,7 +406,7 @@ func (h *Handlers) GetConsentAnalytics(w http.ResponseWriter, r *http.Request) {
 	// Timeseries — daily breakdown
 	var timeseries []map[string]interface{}
 	tsRows, err := h.db.Conn().Query(`
-		SELECT date(timestamp / 1000, 'unixepoch') as day,
+		SELECT date(timestamp / 1000, 'unixepoch', ?) as day,
 			SUM(CASE WHEN action = 'show' THEN 1 ELSE 0 END) as shows,
 			SUM(CASE WHEN action = 'accept_all' THEN 1 ELSE 0 END) as accept_all,
 			SUM(CASE WHEN action = 'reject_all' THEN 1 ELSE 0 END) as reject_all,
@@ -411,7 +415,7 @@ func (h *Handlers) GetConsentAnalytics(w http.ResponseWriter, r *http.Request) {
 		WHERE domain_id = ? AND timestamp >= ? AND timestamp <= ?
 		GROUP BY day
 		ORDER BY day ASC
-	`, domainID, startMs, endMs)
+	`, dayMod, domainID, startMs, endMs)
 	if err == nil {
 		defer tsRows.Close()
 		for tsRows.Next() {
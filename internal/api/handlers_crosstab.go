@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// crosstabDimensions maps the dimensions that can be crossed to their SQL
+// expressions, labelled as in their own reports
+var crosstabDimensions = map[string]string{
+	"country":       "COALESCE(geo_country, 'Unknown')",
+	"device":        "COALESCE(NULLIF(device_type, ''), 'Unknown')",
+	"browser":       "COALESCE(browser_name, 'Unknown')",
+	"os":            "COALESCE(NULLIF(os_name, ''), 'Unknown')",
+	"referrer_type": "COALESCE(NULLIF(referrer_type, ''), 'direct')",
+}
+
+// Values per dimension in a cross-tab; the rest are grouped as "Other"
+const (
+	defaultCrosstabLimit = 10
+	maxCrosstabLimit     = 25
+	crosstabOther        = "Other"
+)
+
+// GetStatsCrosstab counts visitors for every pair of values of two
+// dimensions, e.g. ?dimensions=country,device for mobile visitors from
+// Germany. Each dimension keeps its top limit values by visitors (default
+// 10, at most 25); the rest are grouped as "Other". Visitors are distinct
+// per cell, row and column, so cells don't add up to the totals.
+func (h *Handlers) GetStatsCrosstab(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	names := strings.Split(r.URL.Query().Get("dimensions"), ",")
+	if len(names) != 2 || names[0] == names[1] {
+		writeError(w, http.StatusBadRequest, "dimensions must be two different dimensions, e.g. country,device")
+		return
+	}
+	var exprs [2]string
+	for i, name := range names {
+		expr, ok := crosstabDimensions[name]
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown dimension %q; use country, device, browser, os or referrer_type", name))
+			return
+		}
+		exprs[i] = expr
+	}
+
+	limit := defaultCrosstabLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxCrosstabLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCrosstabLimit))
+			return
+		}
+		limit = n
+	}
+
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
+
+	// Collapse each dimension to its top values and "Other"
+	var keys [2]string
+	var keyArgs [2][]interface{}
+	truncated := false
+	for i, expr := range exprs {
+		top, more, err := h.topCrosstabValues(ctx, expr, where, args, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		keys[i] = expr
+		if more {
+			keys[i], keyArgs[i] = crosstabKey(expr, top)
+			truncated = true
+		}
+	}
+
+	cells, err := h.crosstabCounts(ctx, keys[0]+" AS a, "+keys[1]+" AS b", "a, b", where,
+		append(append(append([]interface{}{}, keyArgs[0]...), keyArgs[1]...), args...))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rowTotals, err := h.crosstabCounts(ctx, keys[0]+" AS a, '' AS b", "a", where, append(append([]interface{}{}, keyArgs[0]...), args...))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	colTotals, err := h.crosstabCounts(ctx, "'' AS a, "+keys[1]+" AS b", "b", where, append(append([]interface{}{}, keyArgs[1]...), args...))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var total int64
+	if err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(DISTINCT visitor_hash) FROM events WHERE "+where, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rows := orderedCrosstabValues(rowTotals, func(c crosstabCell) string { return c.a })
+	cols := orderedCrosstabValues(colTotals, func(c crosstabCell) string { return c.b })
+	rowIndex, colIndex := indexOf(rows), indexOf(cols)
+
+	visitors := make([][]int64, len(rows))
+	for i := range visitors {
+		visitors[i] = make([]int64, len(cols))
+	}
+	for _, c := range cells {
+		visitors[rowIndex[c.a]][colIndex[c.b]] = c.visitors
+	}
+	rowVisitors := make([]int64, len(rows))
+	for _, c := range rowTotals {
+		rowVisitors[rowIndex[c.a]] = c.visitors
+	}
+	colVisitors := make([]int64, len(cols))
+	for _, c := range colTotals {
+		colVisitors[colIndex[c.b]] = c.visitors
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dimensions":    names,
+		"rows":          rows,
+		"columns":       cols,
+		"visitors":      visitors,
+		"row_totals":    rowVisitors,
+		"column_totals": colVisitors,
+		"total":         total,
+		"truncated":     truncated,
+	})
+}
+
+// topCrosstabValues returns a dimension's limit values with the most
+// visitors, and whether it has more
+func (h *Handlers) topCrosstabValues(ctx context.Context, expr, where string, args []interface{}, limit int) ([]string, bool, error) {
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT `+expr+` AS v
+		FROM events
+		WHERE `+where+`
+		GROUP BY v
+		ORDER BY COUNT(DISTINCT visitor_hash) DESC, v
+		LIMIT ?
+	`, append(append([]interface{}{}, args...), limit+1)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	values := make([]string, 0, limit+1)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, false, err
+		}
+		values = append(values, v)
+	}
+	if len(values) > limit {
+		return values[:limit], true, rows.Err()
+	}
+	return values, false, rows.Err()
+}
+
+// crosstabKey groups a dimension's values outside top, which isn't
+// empty, as "Other"
+func crosstabKey(expr string, top []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(top)+1)
+	for _, v := range top {
+		args = append(args, v)
+	}
+	args = append(args, crosstabOther)
+	return "CASE WHEN " + expr + " IN (?" + strings.Repeat(", ?", len(top)-1) + ") THEN " + expr + " ELSE ? END", args
+}
+
+// crosstabCell is the visitors of one pair of values
+type crosstabCell struct {
+	a, b     string
+	visitors int64
+}
+
+// crosstabCounts counts distinct visitors grouped by the selected a and b
+// keys, busiest first
+func (h *Handlers) crosstabCounts(ctx context.Context, selectKeys, groupBy, where string, args []interface{}) ([]crosstabCell, error) {
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT `+selectKeys+`, COUNT(DISTINCT visitor_hash) AS visitors
+		FROM events
+		WHERE `+where+`
+		GROUP BY `+groupBy+`
+		ORDER BY visitors DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cells := make([]crosstabCell, 0)
+	for rows.Next() {
+		var c crosstabCell
+		if err := rows.Scan(&c.a, &c.b, &c.visitors); err != nil {
+			return nil, err
+		}
+		cells = append(cells, c)
+	}
+	return cells, rows.Err()
+}
+
+// orderedCrosstabValues lists a dimension's values busiest first, with
+// "Other" last
+func orderedCrosstabValues(totals []crosstabCell, value func(crosstabCell) string) []string {
+	values := make([]string, 0, len(totals))
+	other := false
+	for _, c := range totals {
+		if v := value(c); v == crosstabOther {
+			other = true
+		} else {
+			values = append(values, v)
+		}
+	}
+	if other {
+		values = append(values, crosstabOther)
+	}
+	return values
+}
+
+func indexOf(values []string) map[string]int {
+	index := make(map[string]int, len(values))
+	for i, v := range values {
+		index[v] = i
+	}
+	return index
+}
@@ -3,11 +3,14 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"net"
+	"html"
+	"log"
 	"net/http"
-	"net/smtp"
 	"strconv"
+	"time"
 
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/email"
 	"github.com/caioricciuti/etiquetta/internal/settings"
 )
 
@@ -39,7 +42,7 @@ func (h *Handlers) GetEmailSettings(w http.ResponseWriter, r *http.Request) {
 
 	provider := svc.GetWithDefault("email_provider", "disabled")
 	fromAddress, _ := svc.Get("email_from_address")
-	baseURL, _ := svc.Get("email_base_url")
+	baseURL := svc.PublicURL()
 	smtpHost, _ := svc.Get("smtp_host")
 	smtpPort := svc.GetInt("smtp_port", 587)
 	smtpUser, _ := svc.Get("smtp_username")
@@ -80,10 +83,22 @@ func (h *Handlers) UpdateEmailSettings(w http.ResponseWriter, r *http.Request) {
 
 	svc := newSettingsService(h)
 
+	// The base URL field edits the instance-wide public_url, which replaces
+	// the email-only email_base_url setting
+	if raw, ok := input["email_base_url"].(string); ok {
+		normalized, err := normalizePublicURL(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		input["email_base_url"] = normalized
+		svc.Delete("email_base_url")
+	}
+
 	keyMap := map[string]string{
 		"email_provider":     "email_provider",
 		"email_from_address": "email_from_address",
-		"email_base_url":     "email_base_url",
+		"email_base_url":     publicURLKey,
 		"smtp_host":          "smtp_host",
 		"smtp_port":          "smtp_port",
 		"smtp_username":      "smtp_username",
@@ -121,13 +136,19 @@ func (h *Handlers) UpdateEmailSettings(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// TestEmailSettings tests the email configuration by attempting a connection
+// TestEmailSettings sends a test message to the current user's address
+// with the saved settings. Failures answer 200 with success false and the
+// provider's error, which names the server or status but never the
+// credentials.
 func (h *Handlers) TestEmailSettings(w http.ResponseWriter, r *http.Request) {
-	svc := newSettingsService(h)
-
-	provider := svc.GetWithDefault("email_provider", "disabled")
+	claims := auth.GetUserFromContext(r.Context())
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
 
-	if provider == "disabled" {
+	sender := email.NewFromSettings(newSettingsService(h))
+	if !sender.Enabled() {
 		writeJSON(w, http.StatusOK, map[string]interface{}{
 			"success": false,
 			"message": "Email provider is disabled",
@@ -135,55 +156,27 @@ func (h *Handlers) TestEmailSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if provider == "smtp" {
-		host, _ := svc.Get("smtp_host")
-		port := svc.GetInt("smtp_port", 587)
-
-		if host == "" {
-			writeJSON(w, http.StatusOK, map[string]interface{}{
-				"success": false,
-				"message": "SMTP host is not configured",
-			})
-			return
-		}
-
-		addr := net.JoinHostPort(host, strconv.Itoa(port))
-		client, err := smtp.Dial(addr)
-		if err != nil {
-			writeJSON(w, http.StatusOK, map[string]interface{}{
-				"success": false,
-				"message": fmt.Sprintf("Failed to connect to SMTP server: %s", err.Error()),
-			})
-			return
-		}
-		client.Close()
-
-		writeJSON(w, http.StatusOK, map[string]interface{}{
-			"success": true,
-			"message": fmt.Sprintf("Successfully connected to %s", addr),
-		})
-		return
+	sentAt := time.Now().UTC().Format("2006-01-02 15:04:05 UTC")
+	msg := &email.Message{
+		To:      claims.Email,
+		Subject: "Etiquetta test email",
+		Body: fmt.Sprintf("This is a test email from Etiquetta, sent with the %s provider at %s.\n\n"+
+			"If you can read it, invitations, alerts and password resets can reach you.\n", sender.Provider, sentAt),
+		HTML: fmt.Sprintf("<p>This is a test email from Etiquetta, sent with the <strong>%s</strong> provider at %s.</p>"+
+			"<p>If you can read it, invitations, alerts and password resets can reach you.</p>",
+			html.EscapeString(sender.Provider), sentAt),
 	}
-
-	if provider == "resend" {
-		apiKey, _ := svc.Get("resend_api_key")
-		if apiKey == "" {
-			writeJSON(w, http.StatusOK, map[string]interface{}{
-				"success": false,
-				"message": "Resend API key is not configured",
-			})
-			return
-		}
-
+	if err := sender.SendMessage(msg); err != nil {
+		log.Printf("[email] Test email to %s via %s failed: %v", claims.Email, sender.Provider, err)
 		writeJSON(w, http.StatusOK, map[string]interface{}{
-			"success": true,
-			"message": "Resend API key is configured",
+			"success": false,
+			"message": fmt.Sprintf("Failed to send a test email to %s: %s", claims.Email, err.Error()),
 		})
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"success": false,
-		"message": fmt.Sprintf("Unknown email provider: %s", provider),
+		"success": true,
+		"message": fmt.Sprintf("Test email sent to %s via %s", claims.Email, sender.Provider),
 	})
 }
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+// RehashErrors recomputes error_hash for stored errors so errors recorded
+// before the hash fix group correctly. It runs synchronously; use
+// `etiquetta errors rehash` for very large tables.
+func (h *Handlers) RehashErrors(w http.ResponseWriter, r *http.Request) {
+	result, err := enrichment.RehashErrors(h.db.Conn(), 0, nil)
+	if err != nil {
+		log.Printf("[errors] Rehash failed after %d error(s): %v", result.Processed, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logAudit(r, "rehash", "errors", "", fmt.Sprintf("Rehashed %d error(s), %d changed", result.Processed, result.Changed))
+	writeJSON(w, http.StatusOK, result)
+}
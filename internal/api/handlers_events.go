@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// eventColumns are returned by ListEvents by default
+var eventColumns = []string{
+	"id", "timestamp", "event_type", "event_name", "domain", "url", "path", "page_title",
+	"referrer_url", "referrer_type", "utm_source", "utm_medium", "utm_campaign",
+	"geo_country", "geo_city", "geo_region", "browser_name", "os_name", "device_type",
+	"is_bot", "bot_score", "bot_category", "bot_signals", "props",
+}
+
+// eventIdentifierColumns link events to a visitor and are only returned on request
+var eventIdentifierColumns = []string{"session_id", "visitor_hash", "ip_hash"}
+
+// ListEvents returns individual events matching the standard stats filters,
+// newest first (admin). Query params: page, per_page (default 50, capped at 500),
+// event_type, include_identifiers=true to add session/visitor/IP hashes.
+// Bots are excluded unless bot_filter says otherwise, as in the reports.
+func (h *Handlers) ListEvents(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 50
+	} else if perPage > 500 {
+		perPage = 500
+	}
+
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
+	if eventType := r.URL.Query().Get("event_type"); eventType != "" {
+		where += " AND event_type = ?"
+		args = append(args, eventType)
+	}
+
+	cols := eventColumns
+	if r.URL.Query().Get("include_identifiers") == "true" {
+		cols = append(append([]string{}, eventColumns...), eventIdentifierColumns...)
+	}
+
+	ctx := r.Context()
+	var total int64
+	if err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM events WHERE "+where, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rows, err := h.db.Conn().QueryContext(ctx,
+		"SELECT "+strings.Join(cols, ", ")+" FROM events WHERE "+where+" ORDER BY timestamp DESC LIMIT ? OFFSET ?",
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	events := make([]map[string]interface{}, 0, perPage)
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		valuePtrs := make([]interface{}, len(cols))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			continue
+		}
+
+		event := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				event[col] = string(b)
+			} else {
+				event[col] = values[i]
+			}
+		}
+		events = append(events, event)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"events":   events,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+const (
+	// excludeCookieName marks a browser whose visits should not be recorded
+	excludeCookieName = "etiquetta_exclude"
+
+	// excludeTokenCookieName holds the confirmation token of the exclude
+	// page. It is SameSite=Strict, so a form posted from another site can't
+	// present it.
+	excludeTokenCookieName = "etiquetta_exclude_token"
+
+	// excludedIPHashesKey is the settings key holding IP hashes (as stored in
+	// events.ip_hash) whose traffic is dropped, separated by commas or whitespace
+	excludedIPHashesKey = "excluded_ip_hashes"
+)
+
+// ExcludePage shows whether the calling browser is excluded, its IP hash
+// (to add to excluded_ip_hashes) and a form to change it (public). The form
+// carries a confirmation token so other sites can't toggle the cookie.
+func (h *Handlers) ExcludePage(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(excludeCookieName)
+	h.renderExcludePage(w, r, http.StatusOK, err == nil && c.Value == "1", "")
+}
+
+// ExcludeVisits sets or clears the opt-out cookie for the calling browser
+// (public). Takes the form posted by ExcludePage: token, and enabled=false to
+// clear the cookie.
+//
+// Ingest drops requests carrying the cookie, which sendBeacon and the
+// tracker's fetch fallback send along. The cookie is HttpOnly, so the tracker
+// keeps sending and can't tell; its own localStorage opt-out is separate.
+func (h *Handlers) ExcludeVisits(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid form")
+		return
+	}
+	token, err := r.Cookie(excludeTokenCookieName)
+	if err != nil || token.Value == "" ||
+		subtle.ConstantTimeCompare([]byte(token.Value), []byte(r.PostForm.Get("token"))) != 1 {
+		h.renderExcludePage(w, r, http.StatusForbidden, false, "The page expired or the request came from another site. Try again.")
+		return
+	}
+	enabled := r.PostForm.Get("enabled") != "false"
+
+	// Cross-site ingest requests only carry the cookie with SameSite=None,
+	// which browsers accept only over HTTPS
+	secure := isHTTPSRequest(r)
+	cookie := &http.Cookie{
+		Name:     excludeCookieName,
+		Value:    "1",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   2 * 365 * 24 * 60 * 60,
+	}
+	if secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	if !enabled {
+		cookie.Value = ""
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+
+	status := "Visits from this browser are no longer recorded."
+	if !enabled {
+		status = "Visits from this browser are recorded again."
+	}
+	h.renderExcludePage(w, r, http.StatusOK, enabled, status)
+}
+
+// renderExcludePage writes the exclude page with a fresh confirmation token
+func (h *Handlers) renderExcludePage(w http.ResponseWriter, r *http.Request, code int, excluded bool, message string) {
+	token := generateID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     excludeTokenCookieName,
+		Value:    token,
+		Path:     "/api/exclude",
+		HttpOnly: true,
+		Secure:   isHTTPSRequest(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   15 * 60,
+	})
+
+	state := "Visits from this browser are recorded."
+	action, enabled := "Exclude my visits", "true"
+	if excluded {
+		state = "Visits from this browser are excluded."
+		action, enabled = "Record my visits again", "false"
+	}
+	if message != "" {
+		state = message
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `<!DOCTYPE html><html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">`+
+		`<title>Exclude my visits - Etiquetta</title>`+
+		`<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;line-height:1.5}code{word-break:break-all}</style></head><body>`+
+		`<h1>Exclude my visits</h1><p>%s</p>`+
+		`<form method="post" action="/api/exclude"><input type="hidden" name="token" value="%s"><input type="hidden" name="enabled" value="%s">`+
+		`<button type="submit">%s</button></form>`+
+		`<p>Your IP hash, to exclude your whole network in the <code>excluded_ip_hashes</code> setting: <code>%s</code></p>`+
+		`</body></html>`,
+		html.EscapeString(state), token, enabled, action, hashIP(requestClientIP(r)))
+}
+
+// loadExcludedIPHashes caches the excluded_ip_hashes setting for ingest
+func (h *Handlers) loadExcludedIPHashes() {
+	hashes := make(map[string]bool)
+	for _, hash := range strings.FieldsFunc(newSettingsService(h).GetWithDefault(excludedIPHashesKey, ""), isListSeparator) {
+		hashes[strings.ToLower(hash)] = true
+	}
+	h.excludedIPHashes.Store(&hashes)
+}
+
+// isExcludedRequest reports whether an ingest request comes from a browser or
+// IP the operator asked to ignore
+func (h *Handlers) isExcludedRequest(r *http.Request, ipHash string) bool {
+	if c, err := r.Cookie(excludeCookieName); err == nil && c.Value == "1" {
+		return true
+	}
+	if r.URL.Query().Get("exclude") == "1" {
+		return true
+	}
+	if hashes := h.excludedIPHashes.Load(); hashes != nil {
+		return (*hashes)[strings.ToLower(ipHash)]
+	}
+	return false
+}
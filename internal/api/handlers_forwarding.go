@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
+)
+
+// normalizeForwardURL validates a domain's forwarding URL, which must not
+// reach loopback, link-local or private addresses. An empty value turns
+// forwarding off.
+func normalizeForwardURL(raw string) (*string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if err := forwarding.ValidateURL(raw); err != nil {
+		return nil, fmt.Errorf("forward_url: %w", err)
+	}
+	return &raw, nil
+}
+
+// forwardFields decodes a domain's stored forwarding fields, falling back to
+// the defaults
+func forwardFields(raw *string) []string {
+	if raw != nil {
+		var fields []string
+		if json.Unmarshal([]byte(*raw), &fields) == nil && len(fields) > 0 {
+			return fields
+		}
+	}
+	return forwarding.DefaultFields
+}
+
+// GetDomainForwarding returns a domain's event forwarding settings and its
+// counters since the server started
+func (h *Handlers) GetDomainForwarding(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var domain string
+	var forwardURL, fields *string
+	err := h.db.Conn().QueryRow("SELECT domain, forward_url, forward_fields FROM domains WHERE id = ?", id).
+		Scan(&domain, &forwardURL, &fields)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Domain not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain":           domain,
+		"forward_url":      forwardURL,
+		"forward_fields":   forwardFields(fields),
+		"available_fields": forwarding.Fields(),
+		"status":           h.forwarder.Status(domain),
+	})
+}
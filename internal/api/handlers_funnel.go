@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Funnels have between two and maxFunnelSteps steps
+const (
+	minFunnelSteps = 2
+	maxFunnelSteps = 10
+)
+
+// funnelStep is a page (a path starting with "/") or a custom event name
+type funnelStep struct {
+	Step string `json:"step"`
+	Type string `json:"type"` // "page" or "event"
+}
+
+// condition matches the step's events
+func (s funnelStep) condition() (string, []interface{}) {
+	if s.Type == "page" {
+		return "event_type = 'pageview' AND path = ?", []interface{}{s.Step}
+	}
+	return "event_name = ?", []interface{}{s.Step}
+}
+
+// parseFunnelSteps reads the steps from repeated step params
+// (?step=/pricing&step=signup_started) or one JSON array
+// (?step=["/pricing","signup_started"])
+func parseFunnelSteps(r *http.Request) ([]funnelStep, error) {
+	raw := r.URL.Query()["step"]
+	if len(raw) == 1 && strings.HasPrefix(strings.TrimSpace(raw[0]), "[") {
+		raw = nil
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("step")), &raw); err != nil {
+			return nil, fmt.Errorf("step must be a JSON array of strings: %v", err)
+		}
+	}
+	if len(raw) < minFunnelSteps || len(raw) > maxFunnelSteps {
+		return nil, fmt.Errorf("a funnel needs between %d and %d steps", minFunnelSteps, maxFunnelSteps)
+	}
+
+	steps := make([]funnelStep, 0, len(raw))
+	for _, s := range raw {
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+			return nil, fmt.Errorf("steps can't be empty")
+		case strings.HasPrefix(s, "/"):
+			steps = append(steps, funnelStep{Step: s, Type: "page"})
+		default:
+			steps = append(steps, funnelStep{Step: s, Type: "event"})
+		}
+	}
+	return steps, nil
+}
+
+// GetStatsFunnel counts the sessions that went through an ordered sequence
+// of pages and custom events, e.g.
+// ?step=/pricing&step=signup_started&step=signup_completed. A session
+// reaches a step with its first matching event after the time it reached
+// the previous one; other events in between don't matter. The usual stats
+// filters apply to every step.
+func (h *Handlers) GetStatsFunnel(w http.ResponseWriter, r *http.Request) {
+	steps, err := parseFunnelSteps(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// One CTE per step holding each session's time of reaching it
+	f := h.parseStatsFilter(r)
+	var ctes, counts []string
+	var args []interface{}
+	for i, step := range steps {
+		condition, conditionArgs := step.condition()
+		base := "timestamp >= ? AND timestamp <= ? AND " + condition
+		if i > 0 {
+			base += fmt.Sprintf(" AND timestamp > (SELECT t FROM s%d WHERE s%d.session_id = events.session_id)", i-1, i-1)
+		}
+		where, whereArgs := f.where(base, append([]interface{}{f.startMs, f.endMs}, conditionArgs...)...)
+		ctes = append(ctes, fmt.Sprintf("s%d AS (SELECT session_id, MIN(timestamp) AS t FROM events WHERE %s GROUP BY session_id)", i, where))
+		counts = append(counts, fmt.Sprintf("(SELECT COUNT(*) FROM s%d)", i))
+		args = append(args, whereArgs...)
+	}
+
+	sessions := make([]int64, len(steps))
+	dest := make([]interface{}, len(steps))
+	for i := range sessions {
+		dest[i] = &sessions[i]
+	}
+	query := "WITH " + strings.Join(ctes, ",\n") + "\nSELECT " + strings.Join(counts, ", ")
+	if err := h.db.Conn().QueryRowContext(r.Context(), query, args...).Scan(dest...); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]map[string]interface{}, len(steps))
+	for i, step := range steps {
+		// conversion is from the first step, drop_off from the previous one
+		var dropOff float64
+		if i > 0 && sessions[i-1] > 0 {
+			dropOff = math.Round((100-funnelPercent(sessions[i], sessions[i-1]))*10) / 10
+		}
+		result[i] = map[string]interface{}{
+			"step":       step.Step,
+			"type":       step.Type,
+			"sessions":   sessions[i],
+			"conversion": funnelPercent(sessions[i], sessions[0]),
+			"drop_off":   dropOff,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"steps":      result,
+		"entered":    sessions[0],
+		"completed":  sessions[len(sessions)-1],
+		"conversion": funnelPercent(sessions[len(sessions)-1], sessions[0]),
+	})
+}
+
+// funnelPercent is part as a percentage of whole with one decimal, or 0
+// for an empty whole
+func funnelPercent(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*1000) / 10
+}
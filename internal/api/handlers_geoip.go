@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -28,6 +29,17 @@ type GeoIPStatus struct {
 	Configured   bool   `json:"configured"`
 }
 
+// geoipDownloadState tracks the most recent GeoIP download
+type geoipDownloadState struct {
+	Running    bool   `json:"running"`
+	Phase      string `json:"phase,omitempty"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  int64  `json:"started_at,omitempty"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
 // GetGeoIPSettings returns the current GeoIP settings (with masked credentials)
 func (h *Handlers) GetGeoIPSettings(w http.ResponseWriter, r *http.Request) {
 	settingsSvc := settings.New(h.db.Conn())
@@ -157,9 +169,51 @@ func (h *Handlers) DownloadGeoIPDatabase(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	h.geoipDownloadMu.Lock()
+	if h.geoipDownload.Running {
+		h.geoipDownloadMu.Unlock()
+		writeError(w, http.StatusConflict, "A GeoIP download is already in progress")
+		return
+	}
+	h.geoipDownload = geoipDownloadState{Running: true, StartedAt: time.Now().UnixMilli()}
+	h.geoipDownloadMu.Unlock()
+
+	geoipPath := settingsSvc.GetWithDefault("geoip_path", h.cfg.DataDir+"/GeoLite2-City.mmdb")
 	downloader := geoip.NewDownloader(accountID, licenseKey, h.cfg.DataDir)
+	downloader.Path = geoipPath
+
+	// Mirror progress into the shared state for GetGeoIPDownloadProgress
+	progress := make(chan geoip.Progress, 16)
+	done := make(chan struct{})
+	downloader.Progress = progress
+	go func() {
+		defer close(done)
+		for p := range progress {
+			h.geoipDownloadMu.Lock()
+			h.geoipDownload.Phase = p.Phase
+			h.geoipDownload.Downloaded = p.Downloaded
+			h.geoipDownload.Total = p.Total
+			h.geoipDownloadMu.Unlock()
+		}
+	}()
+
+	err := downloader.Download()
+	close(progress)
+	<-done
 
-	if err := downloader.Download(); err != nil {
+	h.geoipDownloadMu.Lock()
+	h.geoipDownload.Running = false
+	h.geoipDownload.FinishedAt = time.Now().UnixMilli()
+	if err != nil {
+		h.geoipDownload.Error = err.Error()
+	}
+	h.geoipDownloadMu.Unlock()
+
+	if errors.Is(err, geoip.ErrDownloadInProgress) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -173,7 +227,7 @@ func (h *Handlers) DownloadGeoIPDatabase(w http.ResponseWriter, r *http.Request)
 
 	// Reload enricher with new database
 	if h.enricher != nil {
-		h.enricher.ReloadGeoIP(h.cfg.DataDir + "/GeoLite2-City.mmdb")
+		h.enricher.ReloadGeoIP(geoipPath)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -182,6 +236,15 @@ func (h *Handlers) DownloadGeoIPDatabase(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// GetGeoIPDownloadProgress returns the state of the current or last GeoIP download
+func (h *Handlers) GetGeoIPDownloadProgress(w http.ResponseWriter, r *http.Request) {
+	h.geoipDownloadMu.Lock()
+	state := h.geoipDownload
+	h.geoipDownloadMu.Unlock()
+
+	writeJSON(w, http.StatusOK, state)
+}
+
 // maskValue masks a sensitive value for display
 func maskValue(value string) string {
 	if len(value) <= 4 {
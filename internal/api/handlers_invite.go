@@ -0,0 +1,282 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/email"
+)
+
+// inviteExpiry is how long an invitation link stays valid
+const inviteExpiry = 7 * 24 * time.Hour
+
+// hashInviteToken hashes an invite token for storage (only the hash is persisted)
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// InviteUser creates a pending invitation and emails the invitee a link to set their password
+func (h *Handlers) InviteUser(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+
+	var input struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+		Role  string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Email == "" || !strings.Contains(input.Email, "@") {
+		writeError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	if input.Role != "admin" && input.Role != "viewer" {
+		input.Role = "viewer"
+	}
+
+	// Pending invites count towards the user limit
+	now := time.Now().UnixMilli()
+	var userCount, inviteCount int
+	h.db.Conn().QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
+	h.db.Conn().QueryRow("SELECT COUNT(*) FROM user_invites WHERE accepted_at IS NULL AND expires_at > ?", now).Scan(&inviteCount)
+	maxUsers := h.licenseManager.GetLimit("max_users")
+	if maxUsers != -1 && userCount+inviteCount >= maxUsers {
+		writeError(w, http.StatusPaymentRequired, "User limit reached")
+		return
+	}
+
+	var existingID string
+	if err := h.db.Conn().QueryRow("SELECT id FROM users WHERE email = ?", input.Email).Scan(&existingID); err == nil {
+		writeError(w, http.StatusConflict, "Email already exists")
+		return
+	}
+
+	// Re-inviting the same email replaces any earlier pending invite
+	h.db.Conn().Exec("DELETE FROM user_invites WHERE email = ? AND accepted_at IS NULL", input.Email)
+
+	token := generateID() + generateID()
+	id := generateID()
+	expiresAt := time.Now().Add(inviteExpiry).UnixMilli()
+
+	var invitedBy *string
+	if claims != nil {
+		invitedBy = &claims.UserID
+	}
+
+	_, err := h.db.Conn().Exec(
+		"INSERT INTO user_invites (id, email, name, role, token_hash, invited_by, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, input.Email, input.Name, input.Role, hashInviteToken(token), invitedBy, expiresAt, now,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	inviteURL := h.publicBaseURL(r) + "/accept-invite?token=" + token
+
+	// Send the invitation email if a provider is configured. The link is also
+	// returned so admins can share it manually when email is disabled.
+	emailSent := false
+	sender := email.NewFromSettings(newSettingsService(h))
+	if sender.Enabled() {
+		msg := &email.Message{
+			To:      input.Email,
+			Subject: "You've been invited to Etiquetta",
+			Body: fmt.Sprintf("You have been invited to join Etiquetta as %s.\n\n"+
+				"Set your password and activate your account here:\n%s\n\n"+
+				"This link expires on %s.\n",
+				input.Role, inviteURL, time.UnixMilli(expiresAt).UTC().Format("2006-01-02 15:04 UTC")),
+		}
+		if err := sender.SendMessage(msg); err != nil {
+			log.Printf("[invite] Failed to send invite to %s: %v", input.Email, err)
+		} else {
+			emailSent = true
+		}
+	}
+
+	h.logAudit(r, "invite", "user", id, fmt.Sprintf("Invited %s (role: %s)", input.Email, input.Role))
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         id,
+		"email":      input.Email,
+		"role":       input.Role,
+		"expires_at": expiresAt,
+		"invite_url": inviteURL,
+		"email_sent": emailSent,
+	})
+}
+
+// ListInvites returns pending (not yet accepted) invitations
+func (h *Handlers) ListInvites(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.Conn().Query(`
+		SELECT id, email, COALESCE(name, ''), role, expires_at, created_at
+		FROM user_invites
+		WHERE accepted_at IS NULL
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UnixMilli()
+	invites := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, inviteEmail, name, role string
+		var expiresAt, createdAt int64
+		rows.Scan(&id, &inviteEmail, &name, &role, &expiresAt, &createdAt)
+		invites = append(invites, map[string]interface{}{
+			"id":         id,
+			"email":      inviteEmail,
+			"name":       name,
+			"role":       role,
+			"expires_at": expiresAt,
+			"created_at": createdAt,
+			"expired":    expiresAt <= now,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, invites)
+}
+
+// RevokeInvite deletes a pending invitation
+func (h *Handlers) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := h.db.Conn().Exec("DELETE FROM user_invites WHERE id = ? AND accepted_at IS NULL", id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		writeError(w, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	h.logAudit(r, "revoke", "invite", id, "Invite revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptInvite lets an invitee set their password and activates the account
+func (h *Handlers) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+		Name     string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if input.Token == "" {
+		writeError(w, http.StatusBadRequest, "Invite token is required")
+		return
+	}
+
+	if len(input.Password) < 8 {
+		writeError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	var inviteID, inviteEmail, name, role string
+	var expiresAt int64
+	err := h.db.Conn().QueryRow(
+		"SELECT id, email, COALESCE(name, ''), role, expires_at FROM user_invites WHERE token_hash = ? AND accepted_at IS NULL",
+		hashInviteToken(input.Token),
+	).Scan(&inviteID, &inviteEmail, &name, &role, &expiresAt)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Invalid or already used invite")
+		return
+	}
+
+	if time.Now().UnixMilli() > expiresAt {
+		writeError(w, http.StatusGone, "Invite has expired")
+		return
+	}
+
+	if input.Name != "" {
+		name = input.Name
+	}
+
+	passwordHash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	userID := auth.GenerateID()
+	now := time.Now().UnixMilli()
+
+	tx, err := h.db.Conn().Begin()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"INSERT INTO users (id, email, password_hash, name, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, inviteEmail, passwordHash, name, role, now, now,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			writeError(w, http.StatusConflict, "Email already exists")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE user_invites SET accepted_at = ? WHERE id = ?", now, inviteID); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to accept invite")
+		return
+	}
+
+	// Log the new user in
+	user := &auth.User{
+		ID:    userID,
+		Email: inviteEmail,
+		Role:  role,
+	}
+	token, err := h.auth.GenerateToken(user)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	h.auth.SetAuthCookie(w, token)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":    userID,
+			"email": inviteEmail,
+			"name":  name,
+			"role":  role,
+		},
+	})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+)
+
+// maxNetworks is how many networks the networks report lists
+const maxNetworks = 50
+
+// GetStatsNetworks breaks traffic down by the visitors' network, the
+// organization of the autonomous system their IP belongs to, busiest
+// first. Hosting providers stand out by their bot_events and datacenter
+// flag; bot_filter=all includes the bot traffic the report otherwise leaves
+// out, as usual. Networks are only recorded with an ASN database loaded, so
+// events stored without one aren't counted.
+func (h *Handlers) GetStatsNetworks(w http.ResponseWriter, r *http.Request) {
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND asn_org IS NOT NULL AND asn_org != ''", f.startMs, f.endMs)
+
+	rows, err := h.db.Conn().QueryContext(r.Context(), `
+		SELECT
+			asn_org,
+			COUNT(*) AS events,
+			COUNT(DISTINCT visitor_hash) AS visitors,
+			SUM(CASE WHEN event_type = 'pageview' THEN 1 ELSE 0 END) AS pageviews,
+			SUM(CASE WHEN is_bot = 1 THEN 1 ELSE 0 END) AS bot_events,
+			MAX(datacenter_ip) AS datacenter
+		FROM events
+		WHERE `+where+`
+		GROUP BY asn_org
+		ORDER BY events DESC
+		LIMIT ?
+	`, append(args, maxNetworks)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var network string
+		var events, visitors, pageviews, botEvents int64
+		var datacenter bool
+		if err := rows.Scan(&network, &events, &visitors, &pageviews, &botEvents, &datacenter); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		result = append(result, map[string]interface{}{
+			"network":    network,
+			"events":     events,
+			"visitors":   visitors,
+			"pageviews":  pageviews,
+			"bot_events": botEvents,
+			"datacenter": datacenter, // any of its events came from a known datacenter range
+		})
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/email"
+)
+
+// ForgotPassword emails a password reset link to a user. It answers 204
+// whether or not the email belongs to a user, and sends in the background
+// so the response time doesn't tell either. The link is built only from the
+// public_url setting, never from the request's Host, which anyone can set to
+// get a link to their own site mailed out. Without an email provider the
+// link is written to the server log for an admin to pass on.
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Email == "" {
+		writeError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	var user auth.User
+	err := h.db.Conn().QueryRow(
+		"SELECT id, email, password_hash FROM users WHERE email = ?",
+		input.Email,
+	).Scan(&user.ID, &user.Email, &user.PasswordHash)
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	settingsSvc := newSettingsService(h)
+	baseURL := settingsSvc.PublicURL()
+	if baseURL == "" {
+		log.Printf("[password-reset] Reset requested for %s, but no link can be built: set public_url", user.Email)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	token, err := h.auth.GenerateResetToken(&user)
+	if err != nil {
+		log.Printf("[password-reset] Failed to create a reset token for %s: %v", user.Email, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	link := baseURL + "/reset-password?token=" + token
+	minutes := int(auth.ResetTokenDuration.Minutes())
+
+	sender := email.NewFromSettings(settingsSvc)
+	if !sender.Enabled() {
+		log.Printf("[password-reset] Reset link for %s (expires in %d minutes): %s", user.Email, minutes, link)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	msg := &email.Message{
+		To:      user.Email,
+		Subject: "Reset your Etiquetta password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Etiquetta account.\n\n"+
+			"Choose a new password here:\n%s\n\n"+
+			"This link expires in %d minutes and works once. If you didn't ask for it, ignore this email; your password stays the same.\n",
+			link, minutes),
+	}
+	go func() {
+		if err := sender.SendMessage(msg); err != nil {
+			log.Printf("[password-reset] Failed to send reset link to %s: %v", user.Email, err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if input.Token == "" {
+		writeError(w, http.StatusBadRequest, "Reset token is required")
+		return
+	}
+
+	if len(input.Password) < 8 {
+		writeError(w, http.StatusBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	var currentHash string
+	claims, err := h.auth.ValidateResetToken(input.Token, func(userID string) (string, error) {
+		err := h.db.Conn().QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&currentHash)
+		return currentHash, err
+	})
+	if errors.Is(err, auth.ErrTokenExpired) {
+		writeError(w, http.StatusGone, "Reset link has expired")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid or already used reset link")
+		return
+	}
+
+	newHash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	// Only if the password is still the one the token was issued against, so
+	// the same link used twice at once changes it once
+	result, err := h.db.Conn().Exec(
+		"UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ? AND password_hash = ?",
+		newHash, time.Now().UnixMilli(), claims.UserID, currentHash,
+	)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid or already used reset link")
+		return
+	}
+
+	log.Printf("[password-reset] Password of %s reset by email link", claims.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
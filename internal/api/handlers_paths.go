@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Settings keys for the public tracker script and ingest paths. Custom paths
+// help where ad-blocker filter lists target the defaults. They are read when
+// the router is built, so changes apply after a restart.
+const (
+	trackerScriptPathKey = "tracker_script_path"
+	ingestPathKey        = "ingest_path"
+
+	defaultTrackerScriptPath = "/s.js"
+	defaultIngestPath        = "/i"
+)
+
+// trackingPathPattern restricts custom paths to a simple URL path
+var trackingPathPattern = regexp.MustCompile(`^/[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+// reservedPathPrefixes are owned by other routes, including the dashboard's
+// own pages, and can't be reused
+var reservedPathPrefixes = []string{
+	"/api", "/health", "/s/", "/tm/", "/c.js", "/consent/", "/share/", "/assets/",
+	"/login/", "/reset-password/", "/accept-invite/", "/settings/", "/bots/",
+	"/fraud/", "/explorer/", "/privacy/", "/tag-manager/", "/index.html",
+}
+
+// validTrackingPath reports whether p can be used as a custom tracking path
+func validTrackingPath(p string) bool {
+	if !trackingPathPattern.MatchString(p) || p == defaultTrackerScriptPath || p == defaultIngestPath {
+		return false
+	}
+	for _, prefix := range reservedPathPrefixes {
+		if p == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(p, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTrackingPaths checks the tracking paths in a settings update,
+// together with the stored one when only one of them changes. Empty values
+// restore the defaults.
+func (h *Handlers) validateTrackingPaths(updates map[string]string) error {
+	settings := newSettingsService(h)
+	paths := make(map[string]string, 2)
+	for _, key := range []string{trackerScriptPathKey, ingestPathKey} {
+		p, ok := updates[key]
+		if !ok {
+			p = settings.GetWithDefault(key, "")
+		}
+		p = strings.TrimSpace(p)
+		if ok && p != "" && !validTrackingPath(p) {
+			return fmt.Errorf("%s must be a path such as /stats/t.js, not a default or reserved path", key)
+		}
+		paths[key] = p
+	}
+	if p := paths[trackerScriptPathKey]; p != "" && p == paths[ingestPathKey] {
+		return fmt.Errorf("%s and %s must differ", trackerScriptPathKey, ingestPathKey)
+	}
+	return nil
+}
+
+// loadTrackingPaths resolves the configured script and ingest paths,
+// falling back to the defaults for unset or invalid values
+func (h *Handlers) loadTrackingPaths() {
+	settings := newSettingsService(h)
+	h.scriptPath = defaultTrackerScriptPath
+	h.ingestPath = defaultIngestPath
+
+	if p := strings.TrimSpace(settings.GetWithDefault(trackerScriptPathKey, "")); p != "" {
+		if validTrackingPath(p) {
+			h.scriptPath = p
+		} else {
+			log.Printf("[tracking] Ignoring invalid %s setting %q", trackerScriptPathKey, p)
+		}
+	}
+	if p := strings.TrimSpace(settings.GetWithDefault(ingestPathKey, "")); p != "" {
+		if validTrackingPath(p) && p != h.scriptPath {
+			h.ingestPath = p
+		} else {
+			log.Printf("[tracking] Ignoring invalid %s setting %q", ingestPathKey, p)
+		}
+	}
+}
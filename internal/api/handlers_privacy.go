@@ -79,8 +79,9 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 	var sessionTimeout string
 	h.db.Conn().QueryRow("SELECT value FROM settings WHERE key = 'session_timeout_minutes'").Scan(&sessionTimeout)
 
-	// Get data retention config from license
+	// Get data retention config from license, narrowed per table by settings
 	retentionDays := h.licenseManager.GetLimit("max_retention_days")
+	retention := database.NewRetentionPolicy(retentionDays, newSettingsService(h).GetInt)
 
 	// Count total records across tables
 	var eventCount, perfCount, errorCount, consentCount, sessionCount int64
@@ -173,8 +174,9 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 			Description: "Automated data cleanup enforces retention limits",
 			Status:   "pass",
 			Detail:   func() string {
-				if retentionDays > 0 {
-					return fmt.Sprintf("Data automatically deleted after %d days (runs every 24h)", retentionDays)
+				if retentionDays > 0 || retention.Events < database.UnlimitedRetentionDays {
+					return fmt.Sprintf("Data automatically deleted after %d days (performance: %d, errors: %d; runs every 24h)",
+						retention.Events, retention.Performance, retention.Errors)
 				}
 				return "Unlimited retention (Community tier). Consider setting a retention policy."
 			}(),
@@ -213,7 +215,7 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 			Name:        "Do Not Track / Global Privacy Control",
 			Description: "Respects browser DNT and Sec-GPC signals",
 			Status:      "pass",
-			Detail:      "DNT and Sec-GPC headers are enforced server-side and client-side. Visitors with these signals are not tracked.",
+			Detail:      fmt.Sprintf("Events from browsers sending DNT or Sec-GPC are dropped on ingest and never stored (%d since the server started).", h.ingestDiag.total(sightDNT)),
 		})
 	} else {
 		checks = append(checks, auditCheck{
@@ -222,7 +224,7 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 			Name:        "Do Not Track",
 			Description: "Respects browser Do Not Track signal",
 			Status:      "info",
-			Detail:      "DNT is not enforced. Since Etiquetta is cookie-free and collects no PII, DNT compliance is optional but recommended.",
+			Detail:      "DNT is not enforced: respect_dnt is off, so visitors with DNT or GPC are tracked. Since Etiquetta is cookie-free and collects no PII, DNT compliance is optional but recommended.",
 		})
 	}
 
@@ -283,8 +285,9 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 		{"field": "referrer_url", "purpose": "Traffic source analysis", "pii": "no", "note": "Where visitors come from"},
 		{"field": "utm_*", "purpose": "Campaign tracking", "pii": "no", "note": "UTM parameters from URL"},
 		{"field": "geo_country / city / region", "purpose": "Geographic analytics", "pii": "no", "note": "Derived from IP via GeoIP lookup, IP not stored"},
+		{"field": "asn_org", "purpose": "Network analytics", "pii": "no", "note": "Organization of the visitor's network, from the optional GeoIP ASN database, IP not stored"},
 		{"field": "browser_name / os_name / device_type", "purpose": "Technology analytics", "pii": "no", "note": "Parsed from User-Agent header"},
-		{"field": "bot_score / bot_signals", "purpose": "Bot detection", "pii": "no", "note": "Automated traffic filtering"},
+		{"field": "bot_score / bot_signals / bot_client_signals", "purpose": "Bot detection", "pii": "no", "note": "Automated traffic filtering; raw browser checks such as webdriver and screen size"},
 		{"field": "ip_hash", "purpose": "Bot/fraud analysis", "pii": "no", "note": "SHA-256 hash of IP, not reversible"},
 	}
 
@@ -301,6 +304,7 @@ func (h *Handlers) GetPrivacyAudit(w http.ResponseWriter, r *http.Request) {
 			"visitor_sessions": sessionCount,
 		},
 		"data_retention_days": retentionDays,
+		"data_retention":      retention,
 		"generated_at":        time.Now().UTC().Format(time.RFC3339),
 	})
 }
@@ -1,22 +1,53 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/caioricciuti/etiquetta/internal/adfraud"
+	"github.com/caioricciuti/etiquetta/internal/database"
 )
 
-// GetStatsVitals returns web vitals (Pro feature)
+// vitalsMetrics are the web vitals columns of the performance table
+var vitalsMetrics = []string{"lcp", "cls", "fcp", "ttfb", "inp"}
+
+// extendedVitalsMetrics are the performance columns trackers fill with
+// track_extended_performance on; null for other page loads
+var extendedVitalsMetrics = []string{"tti", "tbt", "long_tasks", "resource_count", "resource_bytes"}
+
+// vitalsPercentiles are the percentiles GetStatsVitals computes; Google
+// assesses Core Web Vitals at p75
+var vitalsPercentiles = map[int]bool{50: true, 75: true, 90: true, 95: true}
+
+// GetStatsVitals returns web vitals (Pro feature). With ?percentile=75
+// (or 50, 90, 95) each metric's percentile is returned alongside its
+// average, e.g. lcp_p75, with the number of measurements it's taken from.
+// Extended metrics (tti, tbt, ...) are included, with their number of
+// measurements, only when some page load in range reported them.
 func (h *Handlers) GetStatsVitals(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
+
+	percentile := 0
+	if raw := r.URL.Query().Get("percentile"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || !vitalsPercentiles[n] {
+			writeError(w, http.StatusBadRequest, "percentile must be 50, 75, 90 or 95")
+			return
+		}
+		percentile = n
+	}
 
 	where := "timestamp >= ? AND timestamp <= ?"
 	args := []interface{}{f.startMs, f.endMs}
@@ -25,8 +56,11 @@ func (h *Handlers) GetStatsVitals(w http.ResponseWriter, r *http.Request) {
 		args = append(args, f.domain)
 	}
 
-	var lcp, cls, fcp, ttfb, inp float64
+	// Averages are unaffected by uniform sampling; the count is scaled back
+	// up by each row's sample rate to estimate the real number of page loads
+	var lcp, cls, fcp, ttfb, inp, estimated float64
 	var samples int64
+	var sampled bool
 	h.db.Conn().QueryRowContext(ctx, `
 		SELECT
 			COALESCE(AVG(lcp), 0),
@@ -34,25 +68,208 @@ func (h *Handlers) GetStatsVitals(w http.ResponseWriter, r *http.Request) {
 			COALESCE(AVG(fcp), 0),
 			COALESCE(AVG(ttfb), 0),
 			COALESCE(AVG(inp), 0),
-			COUNT(*)
+			COUNT(*),
+			COALESCE(SUM(`+database.SampleWeightSQL+`), 0),
+			COALESCE(`+database.SampledSQL+`, 0)
 		FROM performance
 		WHERE `+where,
-		args...).Scan(&lcp, &cls, &fcp, &ttfb, &inp, &samples)
+		args...).Scan(&lcp, &cls, &fcp, &ttfb, &inp, &samples, &estimated, &sampled)
+
+	result := map[string]interface{}{
+		"lcp":             lcp,
+		"cls":             cls,
+		"fcp":             fcp,
+		"ttfb":            ttfb,
+		"inp":             inp,
+		"samples":         samples,
+		"estimated_total": estimateCount(estimated),
+		"estimated":       sampled,
+		"sample_rate":     h.cfg.PerformanceSampleRate,
+	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"lcp":     lcp,
-		"cls":     cls,
-		"fcp":     fcp,
-		"ttfb":    ttfb,
-		"inp":     inp,
-		"samples": samples,
-	})
+	// Percentiles cover the extended metrics that were reported
+	metrics := append([]string{}, vitalsMetrics...)
+	selects := make([]string, 0, 2*len(extendedVitalsMetrics))
+	for _, metric := range extendedVitalsMetrics {
+		selects = append(selects, "AVG("+metric+")", "COUNT("+metric+")")
+	}
+	averages := make([]sql.NullFloat64, len(extendedVitalsMetrics))
+	counts := make([]int64, len(extendedVitalsMetrics))
+	dest := make([]interface{}, 0, len(selects))
+	for i := range extendedVitalsMetrics {
+		dest = append(dest, &averages[i], &counts[i])
+	}
+	if err := h.db.Conn().QueryRowContext(ctx,
+		"SELECT "+strings.Join(selects, ", ")+" FROM performance WHERE "+where, args...).Scan(dest...); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i, metric := range extendedVitalsMetrics {
+		if counts[i] > 0 {
+			result[metric] = averages[i].Float64
+			result[metric+"_samples"] = counts[i]
+			metrics = append(metrics, metric)
+		}
+	}
+
+	// Like averages, percentiles are unaffected by uniform sampling. Each
+	// metric has its own count, as not every page load reports every metric
+	// (INP needs an interaction).
+	if percentile > 0 {
+		result["percentile"] = percentile
+		for _, metric := range metrics {
+			value, count, err := h.vitalsPercentile(ctx, metric, percentile, where, args)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			result[fmt.Sprintf("%s_p%d", metric, percentile)] = value
+			result[metric+"_samples"] = count
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// unknownConnection is the connection type of page loads whose browser
+// didn't report one
+const unknownConnection = "Unknown"
+
+// GetStatsVitalsByConnection returns the web vitals per connection type
+// (Pro feature): the effective type browsers report (slow-2g, 2g, 3g, 4g),
+// or "Unknown" for those that don't. Each has the averages and counts of
+// GetStatsVitals, and the same ?percentile= percentiles.
+func (h *Handlers) GetStatsVitalsByConnection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+
+	percentile := 0
+	if raw := r.URL.Query().Get("percentile"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || !vitalsPercentiles[n] {
+			writeError(w, http.StatusBadRequest, "percentile must be 50, 75, 90 or 95")
+			return
+		}
+		percentile = n
+	}
+
+	where := "timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{f.startMs, f.endMs}
+	if f.domain != "" {
+		where += " AND domain = ?"
+		args = append(args, f.domain)
+	}
+
+	connection := "COALESCE(NULLIF(connection_type, ''), '" + unknownConnection + "')"
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT
+			`+connection+` AS connection,
+			COALESCE(AVG(lcp), 0),
+			COALESCE(AVG(cls), 0),
+			COALESCE(AVG(fcp), 0),
+			COALESCE(AVG(ttfb), 0),
+			COALESCE(AVG(inp), 0),
+			COUNT(*),
+			COALESCE(SUM(`+database.SampleWeightSQL+`), 0),
+			COALESCE(`+database.SampledSQL+`, 0)
+		FROM performance
+		WHERE `+where+`
+		GROUP BY connection
+		ORDER BY COUNT(*) DESC
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Read every group before the percentile queries, as the connection is
+	// held while the rows are open
+	connections := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var name string
+		var lcp, cls, fcp, ttfb, inp, estimated float64
+		var samples int64
+		var sampled bool
+		if err := rows.Scan(&name, &lcp, &cls, &fcp, &ttfb, &inp, &samples, &estimated, &sampled); err != nil {
+			rows.Close()
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		connections = append(connections, map[string]interface{}{
+			"connection":      name,
+			"lcp":             lcp,
+			"cls":             cls,
+			"fcp":             fcp,
+			"ttfb":            ttfb,
+			"inp":             inp,
+			"samples":         samples,
+			"estimated_total": estimateCount(estimated),
+			"estimated":       sampled,
+		})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := map[string]interface{}{
+		"connections": connections,
+		"sample_rate": h.cfg.PerformanceSampleRate,
+	}
+	if percentile > 0 {
+		result["percentile"] = percentile
+		for _, c := range connections {
+			groupWhere := where + " AND " + connection + " = ?"
+			groupArgs := append(append([]interface{}{}, args...), c["connection"])
+			for _, metric := range vitalsMetrics {
+				value, count, err := h.vitalsPercentile(ctx, metric, percentile, groupWhere, groupArgs)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				c[fmt.Sprintf("%s_p%d", metric, percentile)] = value
+				c[metric+"_samples"] = count
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// vitalsPercentile returns a metric's nearest-rank percentile over the
+// matching page loads (nil when none measured it) and how many did
+func (h *Handlers) vitalsPercentile(ctx context.Context, metric string, percentile int, where string, args []interface{}) (*float64, int64, error) {
+	var count int64
+	if err := h.db.Conn().QueryRowContext(ctx,
+		"SELECT COUNT("+metric+") FROM performance WHERE "+where, args...).Scan(&count); err != nil {
+		return nil, 0, err
+	}
+	if count == 0 {
+		return nil, 0, nil
+	}
+
+	// The value at rank ceil(count * percentile / 100) in ascending order
+	rank := (count*int64(percentile) + 99) / 100
+	var value float64
+	err := h.db.Conn().QueryRowContext(ctx, `
+		SELECT `+metric+`
+		FROM performance
+		WHERE `+where+` AND `+metric+` IS NOT NULL
+		ORDER BY `+metric+`
+		LIMIT 1 OFFSET ?
+	`, append(append([]interface{}{}, args...), rank-1)...).Scan(&value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &value, count, nil
 }
 
 // GetStatsErrors returns error summary (Pro feature)
 func (h *Handlers) GetStatsErrors(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 
 	where := "timestamp >= ? AND timestamp <= ?"
 	args := []interface{}{f.startMs, f.endMs}
@@ -60,9 +277,19 @@ func (h *Handlers) GetStatsErrors(w http.ResponseWriter, r *http.Request) {
 		where += " AND domain = ?"
 		args = append(args, f.domain)
 	}
+	if errType := r.URL.Query().Get("type"); errType != "" {
+		where += " AND error_type = ?"
+		args = append(args, normalizeErrorType(errType))
+	}
 
+	// Occurrences are scaled up by each row's sample rate; affected sessions
+	// are as measured
 	rows, err := h.db.Conn().QueryContext(ctx, `
-		SELECT error_hash, error_type, error_message, COUNT(*) as occurrences, COUNT(DISTINCT session_id) as affected_sessions
+		SELECT error_hash, error_type, error_message,
+			SUM(occurrences * `+database.SampleWeightSQL+`) as occurrences,
+			SUM(occurrences) as sampled_occurrences,
+			`+database.SampledSQL+` as sampled,
+			COUNT(DISTINCT session_id) as affected_sessions
 		FROM errors
 		WHERE `+where+`
 		GROUP BY error_hash, error_type, error_message
@@ -78,45 +305,198 @@ func (h *Handlers) GetStatsErrors(w http.ResponseWriter, r *http.Request) {
 	result := make([]map[string]interface{}, 0)
 	for rows.Next() {
 		var hash, errType, message string
-		var occurrences, affected int64
-		rows.Scan(&hash, &errType, &message, &occurrences, &affected)
+		var occurrences float64
+		var sampledOccurrences, affected int64
+		var sampled bool
+		rows.Scan(&hash, &errType, &message, &occurrences, &sampledOccurrences, &sampled, &affected)
 		result = append(result, map[string]interface{}{
-			"error_hash":        hash,
-			"error_type":        errType,
-			"error_message":     message,
-			"occurrences":       occurrences,
-			"affected_sessions": affected,
+			"error_hash":          hash,
+			"error_type":          errType,
+			"error_message":       message,
+			"occurrences":         estimateCount(occurrences),
+			"sampled_occurrences": sampledOccurrences,
+			"estimated":           sampled,
+			"affected_sessions":   affected,
 		})
 	}
 
 	writeJSON(w, http.StatusOK, result)
 }
 
-// ExportEvents exports events as JSON (Pro feature)
+// GetStatsErrorTypes returns error counts broken down by error_type (Pro feature)
+func (h *Handlers) GetStatsErrorTypes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+
+	where := "timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{f.startMs, f.endMs}
+	if f.domain != "" {
+		where += " AND domain = ?"
+		args = append(args, f.domain)
+	}
+
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT error_type,
+			SUM(occurrences * `+database.SampleWeightSQL+`) as occurrences,
+			SUM(occurrences) as sampled_occurrences,
+			`+database.SampledSQL+` as sampled,
+			COUNT(DISTINCT error_hash) as unique_errors,
+			COUNT(DISTINCT session_id) as affected_sessions
+		FROM errors
+		WHERE `+where+`
+		GROUP BY error_type
+		ORDER BY occurrences DESC
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var errType string
+		var occurrences float64
+		var sampledOccurrences, unique, affected int64
+		var sampled bool
+		rows.Scan(&errType, &occurrences, &sampledOccurrences, &sampled, &unique, &affected)
+		result = append(result, map[string]interface{}{
+			"error_type":          errType,
+			"occurrences":         estimateCount(occurrences),
+			"sampled_occurrences": sampledOccurrences,
+			"estimated":           sampled,
+			"unique_errors":       unique,
+			"affected_sessions":   affected,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// exportMaxRowsKey caps the events in one export page; defaultExportMaxRows
+// applies when it is unset
+const (
+	exportMaxRowsKey     = "export_max_rows"
+	defaultExportMaxRows = 100000
+)
+
+// exportCursor marks the last event of an export page. Pages are ordered by
+// (timestamp, id) descending, so events stored during an export sort before
+// the cursor and don't shift later pages.
+type exportCursor struct {
+	timestamp int64
+	id        string
+}
+
+func (c exportCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.timestamp, 10) + ":" + c.id))
+}
+
+func parseExportCursor(s string) (exportCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return exportCursor{}, false
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return exportCursor{}, false
+	}
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return exportCursor{}, false
+	}
+	return exportCursor{timestamp: timestamp, id: id}, true
+}
+
+// ExportEvents exports events as JSON or CSV (Pro feature), newest first, in
+// pages of limit events (default and max the export_max_rows setting).
+// X-Total-Count holds the events matching from/to/cursor; when more remain
+// than fit, X-Export-Truncated and X-Export-Warning are set and
+// X-Next-Cursor holds the cursor param for the next page.
 func (h *Handlers) ExportEvents(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
 	// Get date range from query params
 	from := r.URL.Query().Get("from")
 	to := r.URL.Query().Get("to")
 
-	query := "SELECT * FROM events"
+	where := "1=1"
 	var args []interface{}
-
-	if from != "" || to != "" {
-		query += " WHERE 1=1"
-		if from != "" {
-			fromTime, _ := time.Parse(time.RFC3339, from)
-			query += " AND timestamp >= ?"
-			args = append(args, fromTime.UnixMilli())
+	if from != "" {
+		fromTime, _ := time.Parse(time.RFC3339, from)
+		where += " AND timestamp >= ?"
+		args = append(args, fromTime.UnixMilli())
+	}
+	if to != "" {
+		toTime, _ := time.Parse(time.RFC3339, to)
+		where += " AND timestamp <= ?"
+		args = append(args, toTime.UnixMilli())
+	}
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, ok := parseExportCursor(c)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "Invalid cursor")
+			return
 		}
-		if to != "" {
-			toTime, _ := time.Parse(time.RFC3339, to)
-			query += " AND timestamp <= ?"
-			args = append(args, toTime.UnixMilli())
+		where += " AND (timestamp, id) < (?, ?)"
+		args = append(args, cursor.timestamp, cursor.id)
+	}
+
+	maxRows := newSettingsService(h).GetInt(exportMaxRowsKey, defaultExportMaxRows)
+	if maxRows < 1 {
+		maxRows = defaultExportMaxRows
+	}
+	limit := maxRows
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxRows {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxRows))
+			return
 		}
+		limit = n
 	}
 
-	query += " ORDER BY timestamp DESC LIMIT 100000"
+	var total int64
+	if err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM events WHERE "+where, args...).Scan(&total); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	// Find the page's last event first, so the next cursor can be sent
+	// before the body. The page is then read down to that event rather than
+	// by count, so rows stored meanwhile can't push an event out of it.
+	var last []exportCursor
+	boundRows, err := h.db.Conn().QueryContext(ctx,
+		"SELECT timestamp, id FROM events WHERE "+where+" ORDER BY timestamp DESC, id DESC LIMIT 2 OFFSET ?",
+		append(args, limit-1)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for boundRows.Next() {
+		var c exportCursor
+		boundRows.Scan(&c.timestamp, &c.id)
+		last = append(last, c)
+	}
+	boundRows.Close()
+
+	query := "SELECT * FROM events WHERE " + where
+	if len(last) > 0 {
+		query += " AND (timestamp, id) >= (?, ?)"
+		args = append(args, last[0].timestamp, last[0].id)
+	}
+	if len(last) > 1 {
+		w.Header().Set("X-Export-Truncated", "true")
+		w.Header().Set("X-Next-Cursor", last[0].String())
+		w.Header().Set("X-Export-Warning", fmt.Sprintf("Truncated at %d of %d events; request the next page with cursor=<X-Next-Cursor>", limit, total))
+	}
+	query += " ORDER BY timestamp DESC, id DESC"
 
 	rows, err := h.db.Conn().QueryContext(ctx, query, args...)
 	if err != nil {
@@ -126,7 +506,6 @@ func (h *Handlers) ExportEvents(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	cols, _ := rows.Columns()
-	format := r.URL.Query().Get("format")
 
 	if format == "csv" {
 		w.Header().Set("Content-Type", "text/csv")
@@ -145,15 +524,11 @@ func (h *Handlers) ExportEvents(w http.ResponseWriter, r *http.Request) {
 			}
 			rows.Scan(valuePtrs...)
 
+			// The writer quotes cells with commas, quotes or line breaks,
+			// so JSON props stay in one cell
 			record := make([]string, len(cols))
 			for i, v := range values {
-				if v == nil {
-					record[i] = ""
-				} else if b, ok := v.([]byte); ok {
-					record[i] = string(b)
-				} else {
-					record[i] = fmt.Sprintf("%v", v)
-				}
+				record[i] = csvCell(cols[i], v)
 			}
 			cw.Write(record)
 		}
@@ -192,13 +567,59 @@ func (h *Handlers) ExportEvents(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("]"))
 }
 
-// GetFraudSummary returns fraud detection summary
+// csvTimeFormat is RFC3339 with the milliseconds events are stored with
+const csvTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// csvCell renders an exported event column for CSV. The timestamp column is
+// written as an RFC3339 UTC time rather than epoch milliseconds, for
+// spreadsheets and BI tools.
+func csvCell(col string, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case int64:
+		if col == "timestamp" {
+			return time.UnixMilli(v).UTC().Format(csvTimeFormat)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// getSuspiciousParam reads the suspicious query param (bot, human or
+// separate), falling back to the suspicious_policy setting. It writes a 400
+// and returns false when the param is invalid.
+func (h *Handlers) getSuspiciousParam(w http.ResponseWriter, r *http.Request) (adfraud.SuspiciousPolicy, bool) {
+	v := r.URL.Query().Get("suspicious")
+	if v == "" {
+		return h.suspiciousPolicy(), true
+	}
+	p, ok := adfraud.ParseSuspiciousPolicy(v)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "suspicious must be one of bot, human, separate")
+	}
+	return p, ok
+}
+
+// GetFraudSummary returns fraud detection summary. Unlike the reports,
+// bot_filter defaults to all traffic. Suspicious clicks count as fraud only
+// under the bot policy (suspicious param or suspicious_policy setting).
 func (h *Handlers) GetFraudSummary(w http.ResponseWriter, r *http.Request) {
-	days := getDaysParam(r, 7)
+	days := h.getReportDays(r)
 	domain := getDomainParam(r)
 
+	suspicious, ok := h.getSuspiciousParam(w, r)
+	if !ok {
+		return
+	}
+	opts := adfraud.FraudOptions{
+		Where:      getBotFilterCondition(getBotFilterParam(r), suspicious),
+		Suspicious: suspicious,
+	}
+
 	detector := adfraud.NewDetector(h.db.Conn())
-	summary, err := detector.GetFraudSummary(domain, days)
+	summary, err := detector.GetFraudSummary(r.Context(), domain, days, opts)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -207,19 +628,50 @@ func (h *Handlers) GetFraudSummary(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, summary)
 }
 
-// GetSourceQuality returns traffic quality per source
+// GetSourceQuality returns traffic quality per source. Sources with fewer
+// sessions than min_visits (query param, else the source_quality_min_visits
+// setting, default 10) are left out and counted in excluded_sources.
+// Score weights come from the source_quality_weight_* settings. bot_filter
+// defaults to all traffic; suspicious sessions are split per the suspicious
+// param or suspicious_policy setting.
 func (h *Handlers) GetSourceQuality(w http.ResponseWriter, r *http.Request) {
-	days := getDaysParam(r, 7)
+	days := h.getReportDays(r)
 	domain := getDomainParam(r)
 
+	suspicious, ok := h.getSuspiciousParam(w, r)
+	if !ok {
+		return
+	}
+
+	settings := newSettingsService(h)
+	opts := adfraud.QualityOptions{
+		Where:      getBotFilterCondition(getBotFilterParam(r), suspicious),
+		Suspicious: suspicious,
+		MinVisits:  settings.GetInt("source_quality_min_visits", adfraud.DefaultMinVisits),
+		Weights: adfraud.QualityWeights{
+			BotRate:              settings.GetFloat("source_quality_weight_bot_rate", adfraud.DefaultQualityWeights.BotRate),
+			BotScore:             settings.GetFloat("source_quality_weight_bot_score", adfraud.DefaultQualityWeights.BotScore),
+			EngagementBonus:      settings.GetFloat("source_quality_weight_engagement_bonus", adfraud.DefaultQualityWeights.EngagementBonus),
+			LowEngagementPenalty: settings.GetFloat("source_quality_weight_low_engagement_penalty", adfraud.DefaultQualityWeights.LowEngagementPenalty),
+		},
+	}
+	if v := r.URL.Query().Get("min_visits"); v != "" {
+		minVisits, err := strconv.Atoi(v)
+		if err != nil || minVisits < 1 {
+			writeError(w, http.StatusBadRequest, "min_visits must be a positive integer")
+			return
+		}
+		opts.MinVisits = minVisits
+	}
+
 	detector := adfraud.NewDetector(h.db.Conn())
-	sources, err := detector.GetSourceQuality(domain, days)
+	report, err := detector.GetSourceQuality(r.Context(), domain, days, opts)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, sources)
+	writeJSON(w, http.StatusOK, report)
 }
 
 // ListCampaigns returns all campaigns
@@ -234,7 +686,8 @@ func (h *Handlers) ListCampaigns(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, campaigns)
 }
 
-// CreateCampaign creates a new campaign
+// CreateCampaign creates a new campaign. Campaigns whose UTM values can
+// match the same traffic are listed in overlaps; they don't block creation.
 func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name        string  `json:"name"`
@@ -246,6 +699,7 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 		Budget      float64 `json:"budget"`
 		StartDate   *int64  `json:"start_date,omitempty"`
 		EndDate     *int64  `json:"end_date,omitempty"`
+		Currency    string  `json:"currency"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -257,6 +711,11 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "Campaign name is required")
 		return
 	}
+	currency, err := adfraud.NormalizeCurrency(input.Currency)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	campaign := &adfraud.Campaign{
 		ID:          generateID(),
@@ -269,15 +728,119 @@ func (h *Handlers) CreateCampaign(w http.ResponseWriter, r *http.Request) {
 		Budget:      input.Budget,
 		StartDate:   input.StartDate,
 		EndDate:     input.EndDate,
+		Currency:    currency,
 	}
 
 	analyzer := adfraud.NewSpendAnalyzer(h.db.Conn())
+	existing, err := analyzer.ListCampaigns()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if err := analyzer.CreateCampaign(campaign); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, campaign)
+	writeJSON(w, http.StatusCreated, struct {
+		*adfraud.Campaign
+		Overlaps []adfraud.CampaignOverlap `json:"overlaps"`
+	}{campaign, adfraud.FindCampaignOverlaps(campaign, existing)})
+}
+
+// GetCampaignOverlaps lists pairs of campaigns whose UTM values can match the
+// same traffic, which their reports would both count
+func (h *Handlers) GetCampaignOverlaps(w http.ResponseWriter, r *http.Request) {
+	analyzer := adfraud.NewSpendAnalyzer(h.db.Conn())
+	overlaps, err := analyzer.CampaignOverlaps()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, overlaps)
+}
+
+// campaignImportResult reports what happened to one CSV row
+type campaignImportResult struct {
+	Line   int    `json:"line"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"` // created, duplicate, skipped or error
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Campaigns a created row can share traffic with (not blocking)
+	Overlaps []adfraud.CampaignOverlap `json:"overlaps,omitempty"`
+}
+
+// ImportCampaigns bulk-creates campaigns from an ad platform CSV export sent
+// as the request body. Columns are matched by common header names; query
+// params named after a field (name, utm_source, utm_medium, utm_campaign,
+// cpc, cpm, budget, start_date, end_date) map it to a header explicitly.
+// Rows whose UTM combination (or name, without UTMs) matches an existing or
+// earlier campaign are reported as duplicates, and created rows list the
+// campaigns they overlap.
+func (h *Handlers) ImportCampaigns(w http.ResponseWriter, r *http.Request) {
+	mapping := make(map[string]string)
+	for field, values := range r.URL.Query() {
+		mapping[field] = values[0]
+	}
+
+	rows, err := adfraud.ParseCampaignCSV(io.LimitReader(r.Body, 5<<20), mapping)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	analyzer := adfraud.NewSpendAnalyzer(h.db.Conn())
+	existing, err := analyzer.ListCampaigns()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		seen[c.UTMKey()] = true
+	}
+
+	results := make([]campaignImportResult, 0, len(rows))
+	counts := make(map[string]int)
+	for _, row := range rows {
+		result := campaignImportResult{Line: row.Line, Name: row.Campaign.Name}
+		campaign := row.Campaign
+		switch {
+		case row.Err != nil:
+			result.Status, result.Error = "error", row.Err.Error()
+		case row.Skip != "":
+			result.Status, result.Error = "skipped", row.Skip
+		case seen[campaign.UTMKey()]:
+			result.Status, result.Error = "duplicate", "a campaign with the same UTM values already exists"
+		default:
+			campaign.ID = generateID()
+			if err := analyzer.CreateCampaign(&campaign); err != nil {
+				result.Status, result.Error = "error", err.Error()
+				break
+			}
+			seen[campaign.UTMKey()] = true
+			result.Status, result.ID = "created", campaign.ID
+			if overlaps := adfraud.FindCampaignOverlaps(&campaign, existing); len(overlaps) > 0 {
+				result.Overlaps = overlaps
+				counts["overlapping"]++
+			}
+			existing = append(existing, campaign)
+		}
+		counts[result.Status]++
+		results = append(results, result)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"created":     counts["created"],
+		"duplicates":  counts["duplicate"],
+		"skipped":     counts["skipped"],
+		"errors":      counts["error"],
+		"overlapping": counts["overlapping"],
+		"rows":        results,
+	})
 }
 
 // GetCampaignReport returns fraud report for a campaign
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+)
+
+// reconcileBreakdown describes how a report counts visitors per row, so the
+// rows' sum can be compared with the distinct count it is drawn from
+type reconcileBreakdown struct {
+	report    string
+	dimension string
+	group     string // SQL grouping expression, as in the report's query
+	pageviews bool   // the report only counts pageview events
+	note      string
+}
+
+var reconcileBreakdowns = []reconcileBreakdown{
+	{"timeseries", "day", "date(timestamp / 1000, 'unixepoch', ?)", true,
+		"A visitor who comes back on another day is counted once for each day."},
+	{"pages", "path", "path", true,
+		"A visitor who views several pages is counted once for each page."},
+	{"geo", "country", "geo_country", false,
+		"A visitor whose IP geolocates to several countries (travel, VPNs, mobile networks) is counted once for each."},
+	{"devices", "device type", "COALESCE(NULLIF(device_type, ''), 'Unknown')", false,
+		"A visitor is identified per device and browser, so this sum rarely exceeds the total; any excess comes from device type changing, e.g. after a user-agent parser update."},
+	{"browsers", "browser", "browser_name", false,
+		"A visitor is identified per device and browser, so this sum rarely exceeds the total; any excess comes from the browser name changing between events."},
+}
+
+// GetStatsReconcile explains why visitor numbers differ between reports. It
+// returns the distinct visitor counts the reports are drawn from and, per
+// breakdown, the sum of its per-row visitor counts over all rows (reports
+// only show the top rows) and how far that sum exceeds the distinct count.
+func (h *Handlers) GetStatsReconcile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+	dayMod := h.dayModifier(f.domain, f.endMs)
+
+	allWhere, allArgs := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
+	pvWhere, pvArgs := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
+
+	var uniqueVisitors, pageviewVisitors int64
+	if err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(DISTINCT visitor_hash) FROM events WHERE "+allWhere, allArgs...).Scan(&uniqueVisitors); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(DISTINCT visitor_hash) FROM events WHERE "+pvWhere, pvArgs...).Scan(&pageviewVisitors); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	breakdowns := make([]map[string]interface{}, 0, len(reconcileBreakdowns))
+	for _, b := range reconcileBreakdowns {
+		where, args, base, baseName := allWhere, allArgs, uniqueVisitors, "unique_visitors"
+		if b.pageviews {
+			where, args, base, baseName = pvWhere, pvArgs, pageviewVisitors, "pageview_visitors"
+		}
+		args = append([]interface{}{}, args...)
+		if b.report == "timeseries" {
+			args = append(args, dayMod)
+		}
+
+		var sum, rows, largest int64
+		err := h.db.Conn().QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(visitors), 0), COUNT(*), COALESCE(MAX(visitors), 0)
+			FROM (
+				SELECT COUNT(DISTINCT visitor_hash) as visitors
+				FROM events
+				WHERE `+where+`
+				GROUP BY `+b.group+`
+			)
+		`, args...).Scan(&sum, &rows, &largest)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		breakdowns = append(breakdowns, map[string]interface{}{
+			"report":      b.report,
+			"dimension":   b.dimension,
+			"compare_to":  baseName,
+			"distinct":    base,
+			"sum_of_rows": sum,
+			"excess":      sum - base,
+			"rows":        rows,
+			"largest_row": largest,
+			"explanation": b.note,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"unique_visitors":   uniqueVisitors,
+		"pageview_visitors": pageviewVisitors,
+		"breakdowns":        breakdowns,
+		"notes": []string{
+			"unique_visitors is the authoritative count: distinct visitors with any event in the range, as shown in the overview.",
+			"pageview_visitors counts only visitors with a pageview; the timeseries, pages, referrers and campaigns reports are drawn from it.",
+			"Each report row counts distinct visitors within that row, so a visitor who appears in several rows is counted in each. Summing rows therefore overstates visitors by the excess shown per breakdown; no row can exceed the distinct count.",
+			"Reports list only their top rows, so the rows on screen may add up to less than sum_of_rows.",
+		},
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Weeks of cohorts in a retention report
+const (
+	defaultRetentionWeeks = 8
+	maxRetentionWeeks     = 26
+)
+
+const weekMs = int64(7 * 24 * time.Hour / time.Millisecond)
+
+// retentionCohort is the visitors first seen in one week and how many of
+// them came back in each week since
+type retentionCohort struct {
+	Week      string    `json:"week"`  // date of the week's Monday
+	Start     int64     `json:"start"` // the Monday's start, Unix ms
+	Visitors  int64     `json:"visitors"`
+	Returned  []int64   `json:"returned"`  // visitors seen in week 0, 1, ... after the first
+	Retention []float64 `json:"retention"` // Returned as a percentage of Visitors
+}
+
+// GetStatsRetention groups visitors into weekly cohorts by the week of
+// their first event and reports the share of each cohort seen again in
+// every following week. The cohorts are the ?weeks= weeks (default 8, at
+// most 26) up to the week of the range's end; weeks start on Monday in the
+// report timezone. A visitor's first event is looked for in all stored
+// history, so visitors from before the first cohort aren't counted as new.
+// The usual filters apply to every event, so e.g. a country filter follows
+// visitors while they visit from that country. Weeks are seven days long
+// from the first Monday, so across a DST change their edges can be off by
+// an hour.
+func (h *Handlers) GetStatsRetention(w http.ResponseWriter, r *http.Request) {
+	weeks := defaultRetentionWeeks
+	if raw := r.URL.Query().Get("weeks"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRetentionWeeks {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("weeks must be between 1 and %d", maxRetentionWeeks))
+			return
+		}
+		weeks = n
+	}
+
+	f := h.parseStatsFilter(r)
+	endMs := min(f.endMs, time.Now().UnixMilli())
+	loc := h.reportLocation(f.domain)
+	end := time.UnixMilli(endMs).In(loc)
+	monday := time.Date(end.Year(), end.Month(), end.Day()-(int(end.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	first := monday.AddDate(0, 0, -7*(weeks-1))
+	startMs := first.UnixMilli()
+
+	firstWhere, firstArgs := f.where("timestamp <= ?", endMs)
+	activeWhere, activeArgs := f.where("timestamp >= ? AND timestamp <= ?", startMs, endMs)
+	args := append(append(append([]interface{}{startMs, weekMs}, firstArgs...), startMs, startMs, weekMs), activeArgs...)
+
+	rows, err := h.db.Conn().QueryContext(r.Context(), `
+		WITH firsts AS (
+			SELECT visitor_hash, (MIN(timestamp) - ?) / ? AS cohort
+			FROM events
+			WHERE `+firstWhere+`
+			GROUP BY visitor_hash
+			HAVING MIN(timestamp) >= ?
+		),
+		active AS (
+			SELECT DISTINCT visitor_hash, (timestamp - ?) / ? AS week
+			FROM events
+			WHERE `+activeWhere+`
+		)
+		SELECT firsts.cohort, active.week - firsts.cohort AS offset, COUNT(*)
+		FROM firsts
+		JOIN active ON active.visitor_hash = firsts.visitor_hash
+		GROUP BY firsts.cohort, offset
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	cohorts := make([]retentionCohort, weeks)
+	for i := range cohorts {
+		start := first.AddDate(0, 0, 7*i)
+		cohorts[i] = retentionCohort{
+			Week:     start.Format("2006-01-02"),
+			Start:    start.UnixMilli(),
+			Returned: make([]int64, weeks-i),
+		}
+	}
+	for rows.Next() {
+		var cohort, offset int
+		var visitors int64
+		if err := rows.Scan(&cohort, &offset, &visitors); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if cohort < 0 || cohort >= weeks || offset < 0 || offset >= weeks-cohort {
+			continue
+		}
+		cohorts[cohort].Returned[offset] = visitors
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i := range cohorts {
+		c := &cohorts[i]
+		c.Visitors = c.Returned[0]
+		c.Retention = make([]float64, len(c.Returned))
+		for j, n := range c.Returned {
+			if c.Visitors > 0 {
+				c.Retention[j] = math.Round(float64(n)/float64(c.Visitors)*1000) / 10
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"weeks":    weeks,
+		"timezone": loc.String(),
+		"cohorts":  cohorts,
+	})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// searchEventName is the custom event the tracker reports site searches as,
+// e.g. etiquetta.track("search", {query: "pricing", results: 3})
+const searchEventName = "search"
+
+// searchTermsLimit caps the terms listed per report
+const searchTermsLimit = 50
+
+// searchQueryKeys are the props keys read as the search term, in order
+var searchQueryKeys = []string{"query", "q", "term"}
+
+// normalizeSearchTerm lowercases a term and collapses its whitespace, so
+// "  Pricing  Plans" and "pricing plans" count as one
+func normalizeSearchTerm(term string) string {
+	return strings.ToLower(strings.Join(strings.Fields(term), " "))
+}
+
+type searchTermStats struct {
+	term        string
+	searches    int64
+	visitors    map[string]bool
+	zeroResults int64
+	resultsSum  int64
+	resultsN    int64
+}
+
+// GetStatsSearches aggregates site search events: the top terms and those
+// that returned nothing. Zero-result counts only include searches that
+// reported a results count.
+func (h *Handlers) GetStatsSearches(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'custom' AND event_name = ? AND json_valid(props)",
+		f.startMs, f.endMs, searchEventName)
+
+	terms := make([]string, len(searchQueryKeys))
+	for i, key := range searchQueryKeys {
+		terms[i] = fmt.Sprintf("json_extract(props, '$.%s')", key)
+	}
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT CAST(COALESCE(`+strings.Join(terms, ", ")+`) AS TEXT) as term,
+			CAST(json_extract(props, '$.results') AS INTEGER) as results,
+			visitor_hash
+		FROM events
+		WHERE `+where, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	byTerm := make(map[string]*searchTermStats)
+	var searches, zeroResults int64
+	for rows.Next() {
+		var raw *string
+		var results *int64
+		var visitor string
+		if rows.Scan(&raw, &results, &visitor) != nil || raw == nil {
+			continue
+		}
+		term := normalizeSearchTerm(*raw)
+		if term == "" {
+			continue
+		}
+		s, ok := byTerm[term]
+		if !ok {
+			s = &searchTermStats{term: term, visitors: make(map[string]bool)}
+			byTerm[term] = s
+		}
+		searches++
+		s.searches++
+		s.visitors[visitor] = true
+		if results != nil {
+			s.resultsSum += *results
+			s.resultsN++
+			if *results == 0 {
+				s.zeroResults++
+				zeroResults++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	all := make([]*searchTermStats, 0, len(byTerm))
+	for _, s := range byTerm {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].searches != all[j].searches {
+			return all[i].searches > all[j].searches
+		}
+		return all[i].term < all[j].term
+	})
+
+	top := make([]map[string]interface{}, 0, searchTermsLimit)
+	zero := make([]map[string]interface{}, 0)
+	for _, s := range all {
+		if len(top) < searchTermsLimit {
+			var avgResults interface{}
+			if s.resultsN > 0 {
+				avgResults = float64(s.resultsSum) / float64(s.resultsN)
+			}
+			top = append(top, map[string]interface{}{
+				"term":         s.term,
+				"searches":     s.searches,
+				"visitors":     len(s.visitors),
+				"zero_results": s.zeroResults,
+				"avg_results":  avgResults,
+			})
+		}
+		if s.zeroResults > 0 {
+			zero = append(zero, map[string]interface{}{
+				"term":         s.term,
+				"zero_results": s.zeroResults,
+				"visitors":     len(s.visitors),
+			})
+		}
+	}
+	// Most frequent failures first
+	sort.SliceStable(zero, func(i, j int) bool {
+		return zero[i]["zero_results"].(int64) > zero[j]["zero_results"].(int64)
+	})
+	if len(zero) > searchTermsLimit {
+		zero = zero[:searchTermsLimit]
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"searches":             searches,
+		"unique_terms":         len(byTerm),
+		"zero_result_searches": zeroResults,
+		"terms":                top,
+		"zero_result_terms":    zero,
+	})
+}
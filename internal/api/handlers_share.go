@@ -0,0 +1,300 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+)
+
+// shareDefaultDays is the rolling period used when a share has no fixed range
+const shareDefaultDays = 30
+
+// sharePasswordHeader carries the password for protected share links
+const sharePasswordHeader = "X-Share-Password"
+
+// publicShare is a read-only dashboard link for a single domain
+type publicShare struct {
+	ID           string  `json:"id"`
+	Token        string  `json:"token"`
+	URL          string  `json:"url"`
+	Name         string  `json:"name"`
+	Domain       string  `json:"domain"`
+	StartAt      *int64  `json:"start_at,omitempty"`
+	EndAt        *int64  `json:"end_at,omitempty"`
+	PeriodDays   *int    `json:"period_days,omitempty"`
+	HasPassword  bool    `json:"has_password"`
+	ExpiresAt    *int64  `json:"expires_at,omitempty"`
+	ViewCount    int64   `json:"view_count"`
+	LastViewedAt *int64  `json:"last_viewed_at,omitempty"`
+	CreatedBy    *string `json:"created_by,omitempty"`
+	CreatedAt    int64   `json:"created_at"`
+
+	passwordHash string
+}
+
+// dateRange resolves the share's reporting period
+func (s *publicShare) dateRange() (startMs, endMs int64) {
+	if s.StartAt != nil && s.EndAt != nil {
+		return *s.StartAt, *s.EndAt
+	}
+	days := shareDefaultDays
+	if s.PeriodDays != nil && *s.PeriodDays > 0 {
+		days = *s.PeriodDays
+	}
+	now := time.Now()
+	return now.Add(-time.Duration(days) * 24 * time.Hour).UnixMilli(), now.UnixMilli()
+}
+
+func (s *publicShare) expired() bool {
+	return s.ExpiresAt != nil && time.Now().UnixMilli() > *s.ExpiresAt
+}
+
+const shareColumns = `s.id, s.token, s.name, s.domain, s.start_at, s.end_at, s.period_days,
+	COALESCE(s.password_hash, ''), s.expires_at, s.view_count, s.last_viewed_at, s.created_by, s.created_at`
+
+func scanShare(scanner interface{ Scan(...interface{}) error }) (*publicShare, error) {
+	var s publicShare
+	err := scanner.Scan(&s.ID, &s.Token, &s.Name, &s.Domain, &s.StartAt, &s.EndAt, &s.PeriodDays,
+		&s.passwordHash, &s.ExpiresAt, &s.ViewCount, &s.LastViewedAt, &s.CreatedBy, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.HasPassword = s.passwordHash != ""
+	return &s, nil
+}
+
+// ListShares returns all public share links
+func (h *Handlers) ListShares(w http.ResponseWriter, r *http.Request) {
+	baseURL := h.publicBaseURL(r)
+
+	rows, err := h.db.Conn().Query("SELECT " + shareColumns + " FROM public_shares s ORDER BY s.created_at DESC")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	shares := make([]*publicShare, 0)
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			continue
+		}
+		s.URL = baseURL + "/share/" + s.Token
+		shares = append(shares, s)
+	}
+
+	writeJSON(w, http.StatusOK, shares)
+}
+
+// CreateShare creates a public link to a domain's aggregated stats.
+// Either start/end (RFC3339) fix the range, or period_days gives a rolling window.
+func (h *Handlers) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string `json:"name"`
+		Domain        string `json:"domain"`
+		Start         string `json:"start"`
+		End           string `json:"end"`
+		PeriodDays    int    `json:"period_days"`
+		Password      string `json:"password"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	input.Domain = strings.TrimSpace(input.Domain)
+	var exists int
+	if err := h.db.Conn().QueryRow("SELECT 1 FROM domains WHERE domain = ? AND is_active = 1", input.Domain).Scan(&exists); err != nil {
+		writeError(w, http.StatusBadRequest, "Unknown domain")
+		return
+	}
+
+	now := time.Now()
+	share := &publicShare{
+		ID:        generateID(),
+		Token:     generateID() + generateID(),
+		Name:      strings.TrimSpace(input.Name),
+		Domain:    input.Domain,
+		CreatedAt: now.UnixMilli(),
+	}
+	if share.Name == "" {
+		share.Name = input.Domain
+	}
+
+	switch {
+	case input.Start != "" || input.End != "":
+		start, errS := time.Parse(time.RFC3339, input.Start)
+		end, errE := time.Parse(time.RFC3339, input.End)
+		if errS != nil || errE != nil || !end.After(start) {
+			writeError(w, http.StatusBadRequest, "start and end must be RFC3339 timestamps with end after start")
+			return
+		}
+		startMs, endMs := start.UTC().UnixMilli(), end.UTC().UnixMilli()
+		share.StartAt, share.EndAt = &startMs, &endMs
+	case input.PeriodDays < 0 || input.PeriodDays > 365:
+		writeError(w, http.StatusBadRequest, "period_days must be between 1 and 365")
+		return
+	default:
+		days := input.PeriodDays
+		if days == 0 {
+			days = shareDefaultDays
+		}
+		share.PeriodDays = &days
+	}
+
+	if input.ExpiresInDays < 0 {
+		writeError(w, http.StatusBadRequest, "expires_in_days cannot be negative")
+		return
+	}
+	if input.ExpiresInDays > 0 {
+		expiresAt := now.Add(time.Duration(input.ExpiresInDays) * 24 * time.Hour).UnixMilli()
+		share.ExpiresAt = &expiresAt
+	}
+
+	var passwordHash *string
+	if input.Password != "" {
+		hash, err := auth.HashPassword(input.Password)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		passwordHash = &hash
+		share.HasPassword = true
+	}
+
+	if claims := auth.GetUserFromContext(r.Context()); claims != nil {
+		share.CreatedBy = &claims.UserID
+	}
+
+	_, err := h.db.Conn().Exec(`
+		INSERT INTO public_shares (id, token, name, domain, start_at, end_at, period_days, password_hash, expires_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, share.ID, share.Token, share.Name, share.Domain, share.StartAt, share.EndAt, share.PeriodDays,
+		passwordHash, share.ExpiresAt, share.CreatedBy, share.CreatedAt)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	share.URL = h.publicBaseURL(r) + "/share/" + share.Token
+
+	h.logAudit(r, "create", "public_share", share.ID, fmt.Sprintf("Shared %s (password: %t)", share.Domain, share.HasPassword))
+	writeJSON(w, http.StatusCreated, share)
+}
+
+// DeleteShare revokes a public share link
+func (h *Handlers) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := h.db.Conn().Exec("DELETE FROM public_shares WHERE id = ?", id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		writeError(w, http.StatusNotFound, "Share not found")
+		return
+	}
+
+	h.logAudit(r, "delete", "public_share", id, "Revoked public share link")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupShare finds a live share by token. Expired links and links to
+// deleted or inactive domains are treated as missing.
+func (h *Handlers) lookupShare(token string) (*publicShare, error) {
+	share, err := scanShare(h.db.Conn().QueryRow(`
+		SELECT `+shareColumns+`
+		FROM public_shares s
+		JOIN domains d ON d.domain = s.domain AND d.is_active = 1
+		WHERE s.token = ?
+	`, token))
+	if err != nil {
+		return nil, err
+	}
+	if share.expired() {
+		return nil, sql.ErrNoRows
+	}
+	return share, nil
+}
+
+// GetPublicShare returns what a share link covers, so the viewer knows
+// whether to prompt for a password (public)
+func (h *Handlers) GetPublicShare(w http.ResponseWriter, r *http.Request) {
+	share, err := h.lookupShare(chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Share link not found or expired")
+		return
+	}
+
+	startMs, endMs := share.dateRange()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":              share.Name,
+		"domain":            share.Domain,
+		"start":             startMs,
+		"end":               endMs,
+		"requires_password": share.HasPassword,
+	})
+}
+
+// GetPublicShareReport serves the aggregated report for a share link (public).
+// Only aggregate stats for the share's domain and period are returned; query
+// parameters cannot widen the scope.
+func (h *Handlers) GetPublicShareReport(w http.ResponseWriter, r *http.Request) {
+	share, err := h.lookupShare(chi.URLParam(r, "token"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Share link not found or expired")
+		return
+	}
+
+	if share.HasPassword && !auth.VerifyPassword(r.Header.Get(sharePasswordHeader), share.passwordHash) {
+		writeError(w, http.StatusUnauthorized, "Password required")
+		return
+	}
+
+	ctx := r.Context()
+	f := statsFilter{domain: share.Domain, suspicious: h.suspiciousPolicy()}
+	f.startMs, f.endMs = share.dateRange()
+
+	report := map[string]interface{}{
+		"name":     share.Name,
+		"domain":   share.Domain,
+		"start":    f.startMs,
+		"end":      f.endMs,
+		"overview": h.queryOverviewStats(ctx, f),
+	}
+
+	sections := []struct {
+		key   string
+		query func() ([]map[string]interface{}, error)
+	}{
+		{"timeseries", func() ([]map[string]interface{}, error) { return h.queryTimeseries(ctx, f) }},
+		{"pages", func() ([]map[string]interface{}, error) { return h.queryTopPages(ctx, f) }},
+		{"referrers", func() ([]map[string]interface{}, error) { return h.queryReferrers(ctx, f) }},
+		{"countries", func() ([]map[string]interface{}, error) { return h.queryGeo(ctx, f) }},
+		{"devices", func() ([]map[string]interface{}, error) { return h.queryDevices(ctx, f) }},
+		{"browsers", func() ([]map[string]interface{}, error) { return h.queryBrowsers(ctx, f) }},
+	}
+	for _, s := range sections {
+		result, err := s.query()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		report[s.key] = result
+	}
+
+	h.db.Conn().Exec("UPDATE public_shares SET view_count = view_count + 1, last_viewed_at = ? WHERE id = ?",
+		time.Now().UnixMilli(), share.ID)
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -4,8 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/adfraud"
+	"github.com/caioricciuti/etiquetta/internal/bot"
 )
 
 // statsFilter holds all filter parameters for stat queries
@@ -19,12 +26,23 @@ type statsFilter struct {
 	page      string
 	referrer  string
 	botFilter string // "all", "humans", "good_bots", "bad_bots", "suspicious", or "" (default = exclude bots)
+
+	// suspicious places the suspicious category for botFilter; empty means
+	// adfraud.DefaultSuspiciousPolicy
+	suspicious adfraud.SuspiciousPolicy
+
+	dimensions []dimensionCondition // custom dimension filters (dim.<Name>=<value>)
+
+	// eventName keeps only visitors, or sessions when eventScope is
+	// "session", with an event of that name in the range
+	eventName  string
+	eventScope string
 }
 
 // parseStatsFilter extracts filter params from request
-func parseStatsFilter(r *http.Request) statsFilter {
-	f := statsFilter{}
-	f.startMs, f.endMs = getDateRangeParams(r, 7)
+func (h *Handlers) parseStatsFilter(r *http.Request) statsFilter {
+	f := statsFilter{suspicious: h.suspiciousPolicy()}
+	f.startMs, f.endMs = h.getReportDateRange(r)
 	f.domain = r.URL.Query().Get("domain")
 	f.country = r.URL.Query().Get("country")
 	f.browser = r.URL.Query().Get("browser")
@@ -32,6 +50,9 @@ func parseStatsFilter(r *http.Request) statsFilter {
 	f.page = r.URL.Query().Get("page")
 	f.referrer = r.URL.Query().Get("referrer")
 	f.botFilter = r.URL.Query().Get("bot_filter")
+	f.dimensions = h.parseDimensionFilters(r, f.domain)
+	f.eventName = r.URL.Query().Get("event_name")
+	f.eventScope = r.URL.Query().Get("event_scope")
 	return f
 }
 
@@ -42,7 +63,11 @@ func (f statsFilter) where(base string, baseArgs ...interface{}) (string, []inte
 	args := append([]interface{}{}, baseArgs...)
 
 	// Bot filtering — replaces hardcoded "is_bot = 0" in all callers
-	where += " AND " + getBotFilterCondition(f.botFilter)
+	suspicious := f.suspicious
+	if suspicious == "" {
+		suspicious = adfraud.DefaultSuspiciousPolicy
+	}
+	where += " AND " + getBotFilterCondition(f.botFilter, suspicious)
 
 	if f.domain != "" {
 		where += " AND domain = ?"
@@ -56,7 +81,9 @@ func (f statsFilter) where(base string, baseArgs ...interface{}) (string, []inte
 		where += " AND browser_name = ?"
 		args = append(args, f.browser)
 	}
-	if f.device != "" {
+	if f.device == "Unknown" {
+		where += " AND (device_type IS NULL OR device_type IN ('', 'Unknown'))"
+	} else if f.device != "" {
 		where += " AND device_type = ?"
 		args = append(args, f.device)
 	}
@@ -68,15 +95,34 @@ func (f statsFilter) where(base string, baseArgs ...interface{}) (string, []inte
 		where += " AND referrer_url LIKE ?"
 		args = append(args, "%"+f.referrer+"%")
 	}
+	for _, d := range f.dimensions {
+		where += " AND " + d.sql
+		args = append(args, d.args...)
+	}
+	if f.eventName != "" {
+		col := "visitor_hash"
+		if f.eventScope == "session" {
+			col = "session_id"
+		}
+		where += " AND " + col + " IN (SELECT " + col + " FROM events WHERE event_name = ? AND timestamp >= ? AND timestamp <= ?"
+		args = append(args, f.eventName, f.startMs, f.endMs)
+		if f.domain != "" {
+			where += " AND domain = ?"
+			args = append(args, f.domain)
+		}
+		where += ")"
+	}
 	return where, args
 }
 
-// prevPeriod returns a filter shifted back by the same duration
+// prevPeriod returns a filter for the period of the same length just before
+// f. Both ends are inclusive, so it ends 1ms before f starts and an event on
+// the boundary counts in one period only.
 func (f statsFilter) prevPeriod() statsFilter {
-	duration := f.endMs - f.startMs
+	length := f.endMs - f.startMs + 1
 	prev := f
-	prev.startMs = f.startMs - duration
-	prev.endMs = f.startMs
+	prev.startMs = f.startMs - length
+	prev.endMs = f.startMs - 1
 	return prev
 }
 
@@ -93,6 +139,30 @@ func (h *Handlers) queryOverviewStats(ctx context.Context, f statsFilter) map[st
 	w2, a2 := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
 	h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM events WHERE "+w2, a2...).Scan(&pageviews)
 
+	bounceRate = h.queryBounceRate(ctx, f, "")
+	avgDuration = h.queryAvgEngagement(ctx, f)
+
+	return map[string]interface{}{
+		"total_events":        totalEvents,
+		"unique_visitors":     uniqueVisitors,
+		"sessions":            sessions,
+		"pageviews":           pageviews,
+		"bounce_rate":         bounceRate,
+		"avg_session_seconds": avgDuration,
+	}
+}
+
+// queryBounceRate returns the percentage of sessions matching f with a
+// single pageview. With page set, only sessions that viewed that page count.
+func (h *Handlers) queryBounceRate(ctx context.Context, f statsFilter, page string) float64 {
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
+	having := ""
+	if page != "" {
+		having = "HAVING SUM(path = ?) > 0"
+		args = append(args, page)
+	}
+
+	var bounceRate float64
 	h.db.Conn().QueryRowContext(ctx, `
 		SELECT COALESCE(
 			CAST(SUM(CASE WHEN pv_count = 1 THEN 1 ELSE 0 END) AS FLOAT) / NULLIF(COUNT(*), 0) * 100,
@@ -100,34 +170,34 @@ func (h *Handlers) queryOverviewStats(ctx context.Context, f statsFilter) map[st
 		) FROM (
 			SELECT session_id, COUNT(*) as pv_count
 			FROM events
-			WHERE `+w2+`
+			WHERE `+where+`
 			GROUP BY session_id
+			`+having+`
 		)
-	`, a2...).Scan(&bounceRate)
+	`, args...).Scan(&bounceRate)
+	return bounceRate
+}
 
-	w3, a3 := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'engagement'", f.startMs, f.endMs)
+// queryAvgEngagement returns the average visible time per engagement event
+// (one per page view) matching f, in seconds
+func (h *Handlers) queryAvgEngagement(ctx context.Context, f statsFilter) float64 {
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'engagement'", f.startMs, f.endMs)
+
+	var avgSeconds float64
 	h.db.Conn().QueryRowContext(ctx, `
 		SELECT COALESCE(AVG(
 			CAST(json_extract(props, '$.visible_time_ms') AS INTEGER)
 		), 0) / 1000.0
 		FROM events
-		WHERE `+w3,
-		a3...).Scan(&avgDuration)
-
-	return map[string]interface{}{
-		"total_events":        totalEvents,
-		"unique_visitors":     uniqueVisitors,
-		"sessions":            sessions,
-		"pageviews":           pageviews,
-		"bounce_rate":         bounceRate,
-		"avg_session_seconds": avgDuration,
-	}
+		WHERE `+where,
+		args...).Scan(&avgSeconds)
+	return avgSeconds
 }
 
 // GetStatsOverview returns main dashboard stats with period comparison
 func (h *Handlers) GetStatsOverview(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 	live := time.Now().Add(-5 * time.Minute).UnixMilli()
 
 	// Current period stats
@@ -149,28 +219,28 @@ func (h *Handlers) GetStatsOverview(w http.ResponseWriter, r *http.Request) {
 	result["prev_bounce_rate"] = prev["bounce_rate"]
 	result["prev_avg_session_seconds"] = prev["avg_session_seconds"]
 
+	// live_visitors changes whatever the range, so always revalidate
+	w.Header().Set("Cache-Control", "private, no-cache")
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsTimeseries returns traffic over time
-func (h *Handlers) GetStatsTimeseries(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	f := parseStatsFilter(r)
+// queryTimeseries returns traffic over time
+func (h *Handlers) queryTimeseries(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
+	dayMod := h.dayModifier(f.domain, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
 		SELECT
-			date(timestamp / 1000, 'unixepoch') as period,
+			date(timestamp / 1000, 'unixepoch', ?) as period,
 			COUNT(*) as pageviews,
 			COUNT(DISTINCT visitor_hash) as visitors
 		FROM events
 		WHERE `+where+`
 		GROUP BY period
 		ORDER BY period
-	`, args...)
+	`, append([]interface{}{dayMod}, args...)...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -186,64 +256,191 @@ func (h *Handlers) GetStatsTimeseries(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	return result, nil
+}
+
+// GetStatsTimeseries returns traffic over time
+func (h *Handlers) GetStatsTimeseries(w http.ResponseWriter, r *http.Request) {
+	result, err := h.queryTimeseries(r.Context(), h.parseStatsFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsPages returns top pages
-func (h *Handlers) GetStatsPages(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	f := parseStatsFilter(r)
-	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
+// routePageviewName is the event_name the tracker gives pageviews from a
+// single-page app's route changes; the initial load of a page is "pv".
+// Pageviews stored before the distinction are all counted as initial loads.
+const routePageviewName = "route"
+
+// Pageview navigations GetStatsPages can count: all (default), only
+// initial loads or only route changes, or all split into both
+const (
+	navigationAll     = "all"
+	navigationInitial = "initial"
+	navigationRoute   = "route"
+	navigationSplit   = "split"
+)
+
+// queryTopPages returns top pages, grouped by the domains' path patterns
+func (h *Handlers) queryTopPages(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
+	return h.queryTopPagesByNavigation(ctx, f, navigationAll, true)
+}
+
+// queryTopPagesByNavigation returns top pages counting the given
+// navigations; split adds each page's initial_views and route_views.
+// grouped collapses paths matching a path pattern into the pattern.
+func (h *Handlers) queryTopPagesByNavigation(ctx context.Context, f statsFilter, navigation string, grouped bool) ([]map[string]interface{}, error) {
+	pageExpr, pageArgs := "path", []interface{}(nil)
+	if grouped {
+		pageExpr, pageArgs = h.pageGroupExpr(ctx, f.domain)
+	}
+	base := "timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'"
+	switch navigation {
+	case navigationInitial:
+		base += " AND COALESCE(event_name, '') != '" + routePageviewName + "'"
+	case navigationRoute:
+		base += " AND event_name = '" + routePageviewName + "'"
+	}
+	where, args := f.where(base, f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
-		SELECT path, COUNT(*) as views, COUNT(DISTINCT visitor_hash) as visitors
+		SELECT `+pageExpr+` as page, COUNT(*) as views, COUNT(DISTINCT visitor_hash) as visitors,
+			COALESCE(SUM(CASE WHEN event_name = '`+routePageviewName+`' THEN 1 ELSE 0 END), 0) as route_views
 		FROM events
 		WHERE `+where+`
-		GROUP BY path
+		GROUP BY page
 		ORDER BY views DESC
 		LIMIT 10
-	`, args...)
+	`, append(pageArgs, args...)...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	result := make([]map[string]interface{}, 0)
 	for rows.Next() {
 		var path string
-		var views, visitors int64
-		rows.Scan(&path, &views, &visitors)
-		result = append(result, map[string]interface{}{
+		var views, visitors, routeViews int64
+		rows.Scan(&path, &views, &visitors, &routeViews)
+		page := map[string]interface{}{
 			"path":     path,
 			"views":    views,
 			"visitors": visitors,
-		})
+		}
+		if navigation == navigationSplit {
+			page["initial_views"] = views - routeViews
+			page["route_views"] = routeViews
+		}
+		result = append(result, page)
 	}
 
+	return result, nil
+}
+
+// GetStatsPages returns top pages. ?navigation=initial or route counts only
+// initial page loads or single-page-app route changes, and split counts
+// both with each page's views broken down. Paths matching a domain's path
+// patterns are grouped under the pattern unless ?group_paths=false.
+func (h *Handlers) GetStatsPages(w http.ResponseWriter, r *http.Request) {
+	navigation := r.URL.Query().Get("navigation")
+	switch navigation {
+	case "":
+		navigation = navigationAll
+	case navigationAll, navigationInitial, navigationRoute, navigationSplit:
+	default:
+		writeError(w, http.StatusBadRequest, "navigation must be all, initial, route or split")
+		return
+	}
+	grouped := r.URL.Query().Get("group_paths") != "false"
+	result, err := h.queryTopPagesByNavigation(r.Context(), h.parseStatsFilter(r), navigation, grouped)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsReferrers returns traffic sources with actual domains
-func (h *Handlers) GetStatsReferrers(w http.ResponseWriter, r *http.Request) {
+// GetStatsPage returns one page's metrics next to the average page's for the
+// same period and filters. A page's bounce rate is the share of sessions that
+// viewed it with no other pageview; the site's is over all sessions.
+func (h *Handlers) GetStatsPage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	site := h.parseStatsFilter(r)
+	if site.page == "" {
+		writeError(w, http.StatusBadRequest, "page is required")
+		return
+	}
+	page := site
+	site.page = ""
+
+	var views, visitors int64
+	where, args := page.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", page.startMs, page.endMs)
+	err := h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(*), COUNT(DISTINCT visitor_hash) FROM events WHERE "+where, args...).Scan(&views, &visitors)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var avgViews, avgVisitors float64
+	var pages int64
+	where, args = site.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", site.startMs, site.endMs)
+	err = h.db.Conn().QueryRowContext(ctx, `
+		SELECT COALESCE(AVG(views), 0), COALESCE(AVG(visitors), 0), COUNT(*)
+		FROM (
+			SELECT COUNT(*) as views, COUNT(DISTINCT visitor_hash) as visitors
+			FROM events
+			WHERE `+where+`
+			GROUP BY path
+		)
+	`, args...).Scan(&avgViews, &avgVisitors, &pages)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page": page.page,
+		"metrics": map[string]interface{}{
+			"views":            views,
+			"visitors":         visitors,
+			"bounce_rate":      h.queryBounceRate(ctx, site, page.page),
+			"avg_time_seconds": h.queryAvgEngagement(ctx, page),
+		},
+		"site_average": map[string]interface{}{
+			"views":            avgViews,
+			"visitors":         avgVisitors,
+			"bounce_rate":      h.queryBounceRate(ctx, site, ""),
+			"avg_time_seconds": h.queryAvgEngagement(ctx, site),
+		},
+		"pages": pages,
+	})
+}
+
+// referrerSourceExpr is a referrer's host without www., as the referrers
+// report groups them
+const referrerSourceExpr = `
+	CASE
+		WHEN referrer_url IS NULL OR referrer_url = '' THEN 'Direct / None'
+		ELSE REPLACE(
+			SUBSTR(referrer_url,
+				INSTR(referrer_url, '://') + 3,
+				CASE
+					WHEN INSTR(SUBSTR(referrer_url, INSTR(referrer_url, '://') + 3), '/') > 0
+					THEN INSTR(SUBSTR(referrer_url, INSTR(referrer_url, '://') + 3), '/') - 1
+					ELSE LENGTH(referrer_url)
+				END
+			), 'www.', '')
+	END`
+
+// queryReferrers returns traffic sources with actual domains
+func (h *Handlers) queryReferrers(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
 		SELECT
-			CASE
-				WHEN referrer_url IS NULL OR referrer_url = '' THEN 'Direct / None'
-				ELSE REPLACE(
-					SUBSTR(referrer_url,
-						INSTR(referrer_url, '://') + 3,
-						CASE
-							WHEN INSTR(SUBSTR(referrer_url, INSTR(referrer_url, '://') + 3), '/') > 0
-							THEN INSTR(SUBSTR(referrer_url, INSTR(referrer_url, '://') + 3), '/') - 1
-							ELSE LENGTH(referrer_url)
-						END
-					), 'www.', '')
-			END as source,
+			`+referrerSourceExpr+` as source,
 			COALESCE(NULLIF(referrer_type, ''), 'direct') as referrer_type,
 			COUNT(*) as visits,
 			COUNT(DISTINCT visitor_hash) as visitors
@@ -254,8 +451,7 @@ func (h *Handlers) GetStatsReferrers(w http.ResponseWriter, r *http.Request) {
 		LIMIT 20
 	`, args...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -272,13 +468,21 @@ func (h *Handlers) GetStatsReferrers(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	return result, nil
+}
+
+// GetStatsReferrers returns traffic sources with actual domains
+func (h *Handlers) GetStatsReferrers(w http.ResponseWriter, r *http.Request) {
+	result, err := h.queryReferrers(r.Context(), h.parseStatsFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsGeo returns geographic distribution
-func (h *Handlers) GetStatsGeo(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	f := parseStatsFilter(r)
+// queryGeo returns geographic distribution
+func (h *Handlers) queryGeo(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
 	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -290,8 +494,7 @@ func (h *Handlers) GetStatsGeo(w http.ResponseWriter, r *http.Request) {
 		LIMIT 20
 	`, args...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -306,13 +509,23 @@ func (h *Handlers) GetStatsGeo(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	return result, nil
+}
+
+// GetStatsGeo returns geographic distribution
+func (h *Handlers) GetStatsGeo(w http.ResponseWriter, r *http.Request) {
+	result, err := h.queryGeo(r.Context(), h.parseStatsFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
 // GetStatsMapData returns geographic data with coordinates for map visualization
 func (h *Handlers) GetStatsMapData(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND geo_latitude IS NOT NULL AND geo_latitude != 0", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -354,22 +567,19 @@ func (h *Handlers) GetStatsMapData(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsDevices returns device breakdown
-func (h *Handlers) GetStatsDevices(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	f := parseStatsFilter(r)
+// queryDevices returns device breakdown
+func (h *Handlers) queryDevices(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
 	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
-		SELECT COALESCE(device_type, 'Unknown') as device, COUNT(DISTINCT visitor_hash) as visitors
+		SELECT COALESCE(NULLIF(device_type, ''), 'Unknown') as device, COUNT(DISTINCT visitor_hash) as visitors
 		FROM events
 		WHERE `+where+`
-		GROUP BY device_type
+		GROUP BY device
 		ORDER BY visitors DESC
 	`, args...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -384,13 +594,21 @@ func (h *Handlers) GetStatsDevices(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	return result, nil
+}
+
+// GetStatsDevices returns device breakdown
+func (h *Handlers) GetStatsDevices(w http.ResponseWriter, r *http.Request) {
+	result, err := h.queryDevices(r.Context(), h.parseStatsFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
-// GetStatsBrowsers returns browser breakdown
-func (h *Handlers) GetStatsBrowsers(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	f := parseStatsFilter(r)
+// queryBrowsers returns browser breakdown
+func (h *Handlers) queryBrowsers(ctx context.Context, f statsFilter) ([]map[string]interface{}, error) {
 	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -402,8 +620,7 @@ func (h *Handlers) GetStatsBrowsers(w http.ResponseWriter, r *http.Request) {
 		LIMIT 10
 	`, args...)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -418,13 +635,23 @@ func (h *Handlers) GetStatsBrowsers(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	return result, nil
+}
+
+// GetStatsBrowsers returns browser breakdown
+func (h *Handlers) GetStatsBrowsers(w http.ResponseWriter, r *http.Request) {
+	result, err := h.queryBrowsers(r.Context(), h.parseStatsFilter(r))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, result)
 }
 
 // GetStatsCampaigns returns UTM campaign breakdown
 func (h *Handlers) GetStatsCampaigns(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'pageview'", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -466,7 +693,7 @@ func (h *Handlers) GetStatsCampaigns(w http.ResponseWriter, r *http.Request) {
 // GetStatsCustomEvents returns custom event breakdown
 func (h *Handlers) GetStatsCustomEvents(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'custom'", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -508,7 +735,7 @@ func (h *Handlers) GetStatsCustomEvents(w http.ResponseWriter, r *http.Request)
 // GetStatsOutbound returns outbound link clicks
 func (h *Handlers) GetStatsOutbound(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	f := parseStatsFilter(r)
+	f := h.parseStatsFilter(r)
 	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'click' AND event_name = 'outbound'", f.startMs, f.endMs)
 
 	rows, err := h.db.Conn().QueryContext(ctx, `
@@ -547,15 +774,215 @@ func (h *Handlers) GetStatsOutbound(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, result)
 }
 
+// GetStatsDownloads returns tracked file downloads per URL, or per file
+// extension with group=extension
+func (h *Handlers) GetStatsDownloads(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = 'click' AND event_name = 'download'", f.startMs, f.endMs)
+
+	byExtension := r.URL.Query().Get("group") == "extension"
+	groupCol := "json_extract(props, '$.target')"
+	if byExtension {
+		groupCol = "json_extract(props, '$.extension')"
+	}
+
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT
+			`+groupCol+` as grp,
+			MAX(json_extract(props, '$.extension')) as extension,
+			COUNT(*) as downloads,
+			COUNT(DISTINCT visitor_hash) as visitors
+		FROM events
+		WHERE `+where+`
+		GROUP BY grp
+		ORDER BY downloads DESC
+		LIMIT 20
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var group, extension *string
+		var downloads, visitors int64
+		rows.Scan(&group, &extension, &downloads, &visitors)
+		ext := ""
+		if extension != nil {
+			ext = *extension
+		}
+		item := map[string]interface{}{
+			"extension":       ext,
+			"downloads":       downloads,
+			"unique_visitors": visitors,
+		}
+		if !byExtension {
+			target := "(unknown)"
+			if group != nil {
+				target = *group
+			}
+			item["url"] = target
+		}
+		result = append(result, item)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// eventTypeNotFound marks hits on pages that returned 404
+const eventTypeNotFound = "not_found"
+
+// GetStatsNotFound returns the most hit 404 paths with the referrers that led
+// to them. Referrers on the same domain are flagged internal: those are the
+// site's own broken links.
+func (h *Handlers) GetStatsNotFound(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+	where, args := f.where("timestamp >= ? AND timestamp <= ? AND event_type = ?", f.startMs, f.endMs, eventTypeNotFound)
+
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT domain, path, COUNT(*) as hits, COUNT(DISTINCT visitor_hash) as visitors, MAX(timestamp) as last_seen
+		FROM events
+		WHERE `+where+`
+		GROUP BY domain, path
+		ORDER BY hits DESC
+		LIMIT 20
+	`, args...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type notFoundPath struct {
+		domain, path           string
+		hits, visitors, lastTs int64
+	}
+	var paths []notFoundPath
+	for rows.Next() {
+		var p notFoundPath
+		if err := rows.Scan(&p.domain, &p.path, &p.hits, &p.visitors, &p.lastTs); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	rows.Close()
+
+	result := make([]map[string]interface{}, 0, len(paths))
+	for _, p := range paths {
+		refWhere, refArgs := f.where("timestamp >= ? AND timestamp <= ? AND event_type = ? AND domain = ? AND path = ?",
+			f.startMs, f.endMs, eventTypeNotFound, p.domain, p.path)
+		refRows, err := h.db.Conn().QueryContext(ctx, `
+			SELECT COALESCE(referrer_url, ''), COUNT(*) as hits
+			FROM events
+			WHERE `+refWhere+`
+			GROUP BY referrer_url
+			ORDER BY hits DESC
+			LIMIT 5
+		`, refArgs...)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		referrers := make([]map[string]interface{}, 0)
+		for refRows.Next() {
+			var ref string
+			var hits int64
+			refRows.Scan(&ref, &hits)
+			internal := false
+			if u, err := url.Parse(ref); err == nil && ref != "" {
+				internal = u.Host == p.domain
+			}
+			if ref == "" {
+				ref = "(direct)"
+			}
+			referrers = append(referrers, map[string]interface{}{
+				"referrer": ref,
+				"hits":     hits,
+				"internal": internal,
+			})
+		}
+		refRows.Close()
+
+		result = append(result, map[string]interface{}{
+			"domain":          p.domain,
+			"path":            p.path,
+			"hits":            p.hits,
+			"unique_visitors": p.visitors,
+			"last_seen":       p.lastTs,
+			"referrers":       referrers,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Limits for the top bots list of the bots report
+const (
+	defaultTopBotsLimit     = 50
+	maxTopBotsLimit         = 500
+	maxBotUserAgentExamples = 5
+)
+
+// topBotsOptions are the query parameters shaping the top bots list
+type topBotsOptions struct {
+	limit    int
+	category string // only this bot category, or "" for all non-human
+	full     bool   // full signal objects and example user agents
+}
+
+func parseTopBotsOptions(r *http.Request) (topBotsOptions, error) {
+	q := r.URL.Query()
+	opts := topBotsOptions{limit: defaultTopBotsLimit, full: q.Get("detail") == "full"}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("limit must be a positive number")
+		}
+		opts.limit = min(n, maxTopBotsLimit)
+	}
+	switch category := q.Get("category"); category {
+	case "", bot.CategorySuspicious, bot.CategoryBadBot, bot.CategoryGoodBot:
+		opts.category = category
+	default:
+		return opts, fmt.Errorf("category must be one of %s, %s or %s",
+			bot.CategorySuspicious, bot.CategoryBadBot, bot.CategoryGoodBot)
+	}
+	return opts, nil
+}
+
+// parseBotSignals decodes an event's bot_signals JSON into the signal names,
+// or into the full signals (name, weight and value) when full is set
+func parseBotSignals(raw string, full bool) interface{} {
+	signals := make([]bot.Signal, 0)
+	if json.Unmarshal([]byte(raw), &signals) != nil {
+		signals = signals[:0]
+	}
+	if full {
+		return signals
+	}
+	names := make([]string, 0, len(signals))
+	for _, s := range signals {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
 // GetStatsBots returns bot traffic breakdown (intentionally shows ALL traffic including bots)
 func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	startMs, endMs := getDateRangeParams(r, 7)
+	startMs, endMs := h.getReportDateRange(r)
 	domain := getDomainParam(r)
+	opts, err := parseTopBotsOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Category distribution
 	var categoryRows *sql.Rows
-	var err error
 	if domain != "" {
 		categoryRows, err = h.db.Conn().QueryContext(ctx, `
 			SELECT bot_category, COUNT(*) as count, COUNT(DISTINCT visitor_hash) as visitors
@@ -652,11 +1079,12 @@ func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 	scoreRows.Close()
 
 	// Bot traffic over time
+	dayMod := h.dayModifier(domain, endMs)
 	var timeRows *sql.Rows
 	if domain != "" {
 		timeRows, err = h.db.Conn().QueryContext(ctx, `
 			SELECT
-				date(timestamp / 1000, 'unixepoch') as period,
+				date(timestamp / 1000, 'unixepoch', ?) as period,
 				SUM(CASE WHEN bot_category = 'human' THEN 1 ELSE 0 END) as humans,
 				SUM(CASE WHEN bot_category = 'suspicious' THEN 1 ELSE 0 END) as suspicious,
 				SUM(CASE WHEN bot_category = 'bad_bot' THEN 1 ELSE 0 END) as bad_bots,
@@ -665,11 +1093,11 @@ func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 			WHERE timestamp >= ? AND timestamp <= ? AND domain = ?
 			GROUP BY period
 			ORDER BY period
-		`, startMs, endMs, domain)
+		`, dayMod, startMs, endMs, domain)
 	} else {
 		timeRows, err = h.db.Conn().QueryContext(ctx, `
 			SELECT
-				date(timestamp / 1000, 'unixepoch') as period,
+				date(timestamp / 1000, 'unixepoch', ?) as period,
 				SUM(CASE WHEN bot_category = 'human' THEN 1 ELSE 0 END) as humans,
 				SUM(CASE WHEN bot_category = 'suspicious' THEN 1 ELSE 0 END) as suspicious,
 				SUM(CASE WHEN bot_category = 'bad_bot' THEN 1 ELSE 0 END) as bad_bots,
@@ -678,7 +1106,7 @@ func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 			WHERE timestamp >= ? AND timestamp <= ?
 			GROUP BY period
 			ORDER BY period
-		`, startMs, endMs)
+		`, dayMod, startMs, endMs)
 	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
@@ -701,42 +1129,34 @@ func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 	timeRows.Close()
 
 	// Top bots detail list
-	var botRows *sql.Rows
+	where := "timestamp >= ? AND timestamp <= ? AND bot_category != 'human'"
+	args := []interface{}{startMs, endMs}
 	if domain != "" {
-		botRows, err = h.db.Conn().QueryContext(ctx, `
-			SELECT
-				COALESCE(browser_name, 'Unknown') as browser_name,
-				bot_category,
-				bot_score,
-				bot_signals,
-				COUNT(*) as hits,
-				COUNT(DISTINCT visitor_hash) as visitors,
-				COUNT(DISTINCT session_id) as sessions,
-				MAX(timestamp) as last_seen
-			FROM events
-			WHERE timestamp >= ? AND timestamp <= ? AND domain = ? AND bot_category != 'human'
-			GROUP BY browser_name, bot_category, bot_score
-			ORDER BY hits DESC
-			LIMIT 50
-		`, startMs, endMs, domain)
-	} else {
-		botRows, err = h.db.Conn().QueryContext(ctx, `
-			SELECT
-				COALESCE(browser_name, 'Unknown') as browser_name,
-				bot_category,
-				bot_score,
-				bot_signals,
-				COUNT(*) as hits,
-				COUNT(DISTINCT visitor_hash) as visitors,
-				COUNT(DISTINCT session_id) as sessions,
-				MAX(timestamp) as last_seen
-			FROM events
-			WHERE timestamp >= ? AND timestamp <= ? AND bot_category != 'human'
-			GROUP BY browser_name, bot_category, bot_score
-			ORDER BY hits DESC
-			LIMIT 50
-		`, startMs, endMs)
+		where += " AND domain = ?"
+		args = append(args, domain)
+	}
+	if opts.category != "" {
+		where += " AND bot_category = ?"
+		args = append(args, opts.category)
 	}
+	args = append(args, opts.limit)
+	botRows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT
+			COALESCE(browser_name, 'Unknown') as browser_name,
+			bot_category,
+			bot_score,
+			bot_signals,
+			COUNT(*) as hits,
+			COUNT(DISTINCT visitor_hash) as visitors,
+			COUNT(DISTINCT session_id) as sessions,
+			MAX(timestamp) as last_seen,
+			GROUP_CONCAT(DISTINCT COALESCE(browser_name, 'Unknown') || ' / ' || COALESCE(NULLIF(os_name, ''), 'Unknown') || ' / ' || COALESCE(NULLIF(device_type, ''), 'unknown')) as user_agents
+		FROM events
+		WHERE `+where+`
+		GROUP BY browser_name, bot_category, bot_score
+		ORDER BY hits DESC
+		LIMIT ?
+	`, args...)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -747,30 +1167,30 @@ func (h *Handlers) GetStatsBots(w http.ResponseWriter, r *http.Request) {
 		var browserName, botCat, botSigs string
 		var score int
 		var hits, visitors, sessions, lastSeen int64
-		botRows.Scan(&browserName, &botCat, &score, &botSigs, &hits, &visitors, &sessions, &lastSeen)
+		var userAgents *string
+		botRows.Scan(&browserName, &botCat, &score, &botSigs, &hits, &visitors, &sessions, &lastSeen, &userAgents)
 
-		// Extract signal names from JSON
-		var rawSignals []struct {
-			Name  string `json:"name"`
-			Value string `json:"value,omitempty"`
-		}
-		signalNames := make([]string, 0)
-		if json.Unmarshal([]byte(botSigs), &rawSignals) == nil {
-			for _, s := range rawSignals {
-				signalNames = append(signalNames, s.Name)
-			}
-		}
-
-		topBots = append(topBots, map[string]interface{}{
+		row := map[string]interface{}{
 			"browser_name": browserName,
 			"category":     botCat,
 			"score":        score,
-			"signals":      signalNames,
+			"signals":      parseBotSignals(botSigs, opts.full),
 			"hits":         hits,
 			"visitors":     visitors,
 			"sessions":     sessions,
 			"last_seen":    lastSeen,
-		})
+		}
+		if opts.full {
+			examples := make([]string, 0)
+			if userAgents != nil {
+				examples = strings.Split(*userAgents, ",")
+				if len(examples) > maxBotUserAgentExamples {
+					examples = examples[:maxBotUserAgentExamples]
+				}
+			}
+			row["user_agents"] = examples
+		}
+		topBots = append(topBots, row)
 	}
 	botRows.Close()
 
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+func TestOverviewPeriodBoundaries(t *testing.T) {
+	h := newTestHandlers(t)
+
+	// A one-day range as the dashboard sends it: start of day to its last
+	// millisecond
+	start := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24*time.Hour - time.Millisecond)
+	prevStart := start.Add(-24 * time.Hour)
+
+	// Each instant gets a distinct power of two of pageviews, so the totals
+	// show exactly which ones a period counted
+	instants := []struct {
+		at    time.Time
+		count int
+	}{
+		{prevStart.Add(-time.Millisecond), 1},
+		{prevStart, 2},
+		{start.Add(-time.Millisecond), 4},
+		{start, 8},
+		{end, 16},
+		{end.Add(time.Millisecond), 32},
+	}
+	var events []*database.Event
+	for _, instant := range instants {
+		for i := 0; i < instant.count; i++ {
+			id := fmt.Sprintf("%d-%d", instant.count, i)
+			events = append(events, &database.Event{
+				ID:          id,
+				Timestamp:   instant.at,
+				EventType:   "pageview",
+				SessionID:   id,
+				VisitorHash: id,
+				Domain:      "example.com",
+				URL:         "https://example.com/",
+				Path:        "/",
+			})
+		}
+	}
+	if err := h.db.InsertBatch(events, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	q := url.Values{
+		"domain": {"example.com"},
+		"start":  {start.Format(time.RFC3339Nano)},
+		"end":    {end.Format(time.RFC3339Nano)},
+	}
+	w := httptest.NewRecorder()
+	h.GetStatsOverview(w, httptest.NewRequest("GET", "/api/stats/overview?"+q.Encode(), nil))
+
+	var got struct {
+		Pageviews     int64 `json:"pageviews"`
+		PrevPageviews int64 `json:"prev_pageviews"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Pageviews != 8+16 {
+		t.Errorf("pageviews = %d, want %d (start and end of the range)", got.Pageviews, 8+16)
+	}
+	if got.PrevPageviews != 2+4 {
+		t.Errorf("prev_pageviews = %d, want %d (start and end of the previous day)", got.PrevPageviews, 2+4)
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caioricciuti/etiquetta/internal/config"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func ingestRequest(body []byte, encoding string) *http.Request {
+	r := httptest.NewRequest("POST", defaultIngestPath, bytes.NewReader(body))
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("Content-Type", "text/plain")
+	r.Header.Set("Origin", "https://"+testDomain)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36")
+	if encoding != "" {
+		r.Header.Set("Content-Encoding", encoding)
+	}
+	return r
+}
+
+func TestIngestGzipBatch(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+
+	var ndjson strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&ndjson, `{"type":"pageview","site_id":%q,"url":"https://%s/page-%d","visitor_hash":""}`+"\n", testSiteID, testDomain, i)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, ingestRequest(gzipped(t, []byte(ndjson.String())), "gzip"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", w.Code, w.Body)
+	}
+
+	var stored int
+	if err := db.Conn().QueryRow("SELECT COUNT(*) FROM events WHERE domain = ? AND path LIKE '/page-%'", testDomain).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 3 {
+		t.Errorf("stored %d events, want 3", stored)
+	}
+}
+
+func TestIngestUnsupportedEncoding(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, ingestRequest([]byte("not brotli"), "br"))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415", w.Code)
+	}
+}
+
+func TestReadIngestBodyGzipBomb(t *testing.T) {
+	// 64 MiB of newlines compress to well under the compressed cap
+	bomb := gzipped(t, bytes.Repeat([]byte("\n"), 64<<20))
+	if len(bomb) > maxIngestBodyBytes {
+		t.Fatalf("bomb is %d bytes compressed, want under the cap", len(bomb))
+	}
+
+	body, truncated, _, err := readIngestBody(ingestRequest(bomb, "gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(body) != maxIngestBodyBytes {
+		t.Errorf("read %d bytes (truncated %v), want %d truncated", len(body), truncated, maxIngestBodyBytes)
+	}
+}
+
+func TestReadIngestBodyCompressedCap(t *testing.T) {
+	// Random-looking lines barely compress, so the compressed cap is hit first
+	var lines bytes.Buffer
+	for i := 0; lines.Len() < 3*maxIngestBodyBytes; i++ {
+		fmt.Fprintf(&lines, "%x\n", uint64(i)*0x9E3779B97F4A7C15)
+	}
+	compressed := gzipped(t, lines.Bytes())
+	if len(compressed) <= maxIngestBodyBytes {
+		t.Fatalf("body is %d bytes compressed, want over the cap", len(compressed))
+	}
+
+	body, truncated, _, err := readIngestBody(ingestRequest(compressed, "gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated || len(body) == 0 || len(body) > maxIngestBodyBytes {
+		t.Errorf("read %d bytes (truncated %v), want a truncated body up to %d", len(body), truncated, maxIngestBodyBytes)
+	}
+}
+
+func TestIngestRespectDNT(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{})
+	session := setupAdmin(t, router)
+
+	ingest := func(path string) {
+		t.Helper()
+		body := fmt.Sprintf(`{"type":"pageview","site_id":%q,"url":"https://%s%s"}`, testSiteID, testDomain, path)
+		r := ingestRequest([]byte(body), "")
+		r.Header.Set("DNT", "1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("ingest: status %d: %s", w.Code, w.Body)
+		}
+	}
+	stored := func(path string) int {
+		var n int
+		db.Conn().QueryRow("SELECT COUNT(*) FROM events WHERE path = ?", path).Scan(&n)
+		return n
+	}
+
+	ingest("/dnt-on")
+	if n := stored("/dnt-on"); n != 0 {
+		t.Errorf("stored %d event(s) with DNT while respect_dnt is on, want 0", n)
+	}
+
+	// The cached setting follows updates
+	if w := putSettings(router, session, `{"respect_dnt":"false"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("update settings: status %d: %s", w.Code, w.Body)
+	}
+
+	ingest("/dnt-off")
+	if n := stored("/dnt-off"); n != 1 {
+		t.Errorf("stored %d event(s) with DNT while respect_dnt is off, want 1", n)
+	}
+}
+
+// putSettings sends PUT /api/settings with session
+func putSettings(router http.Handler, session *http.Cookie, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", "/api/settings", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestUpdateSettingsValidatesSampleRates(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+	session := setupAdmin(t, router)
+
+	for _, rate := range []string{"-1", "0", "1.5", "half", "NaN"} {
+		if w := putSettings(router, session, `{"error_sample_rate":"`+rate+`"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("error_sample_rate %q: status %d, want %d", rate, w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := putSettings(router, session, `{"performance_sample_rate":"0.25"}`); w.Code != http.StatusNoContent {
+		t.Errorf("performance_sample_rate 0.25: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestUpdateSettingsValidatesTrackingPaths(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{})
+	session := setupAdmin(t, router)
+
+	for _, body := range []string{
+		`{"tracker_script_path":"no-slash.js"}`,
+		`{"ingest_path":"/i"}`,
+		`{"ingest_path":"/api/collect"}`,
+		`{"tracker_script_path":"/login"}`,
+		`{"ingest_path":"/share/collect"}`,
+		`{"tracker_script_path":"/t.js","ingest_path":"/t.js"}`,
+	} {
+		if w := putSettings(router, session, body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	if w := putSettings(router, session, `{"tracker_script_path":"/js/app.js"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("valid tracker_script_path: status %d: %s", w.Code, w.Body)
+	}
+	// Checked against the stored script path
+	if w := putSettings(router, session, `{"ingest_path":"/js/app.js"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("ingest_path equal to the stored script path: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// trendDimension is a breakdown whose rows are compared between periods
+type trendDimension struct {
+	name      string
+	key       string // SQL grouping expression, as in the dimension's report
+	pageviews bool   // the report only counts pageview events
+	condition string // extra WHERE condition, if any
+}
+
+var trendDimensions = []trendDimension{
+	{name: "pages", key: "path", pageviews: true},
+	{name: "referrers", key: referrerSourceExpr, pageviews: true,
+		condition: "referrer_url IS NOT NULL AND referrer_url != ''"},
+	{name: "countries", key: "COALESCE(geo_country, 'Unknown')"},
+	{name: "campaigns", key: "COALESCE(utm_source, '(none)') || ' / ' || COALESCE(utm_medium, '(none)') || ' / ' || COALESCE(utm_campaign, '(none)')", pageviews: true,
+		condition: "(utm_source IS NOT NULL OR utm_campaign IS NOT NULL)"},
+}
+
+// Defaults for the trends query parameters
+const (
+	defaultTrendsLimit       = 5
+	maxTrendsLimit           = 20
+	defaultTrendsMinVisitors = 10
+)
+
+// trendRow is a dimension value's visitors in both periods
+type trendRow struct {
+	Key       string   `json:"key"`
+	Current   int64    `json:"current"`
+	Previous  int64    `json:"previous"`
+	Change    int64    `json:"change"`
+	ChangePct *float64 `json:"change_pct"` // nil when the value is new
+}
+
+// queryTrendVisitors counts visitors per dimension value over f's range
+func (h *Handlers) queryTrendVisitors(ctx context.Context, f statsFilter, d trendDimension) (map[string]int64, error) {
+	base := "timestamp >= ? AND timestamp <= ?"
+	if d.pageviews {
+		base += " AND event_type = 'pageview'"
+	}
+	if d.condition != "" {
+		base += " AND " + d.condition
+	}
+	where, args := f.where(base, f.startMs, f.endMs)
+
+	rows, err := h.db.Conn().QueryContext(ctx, `
+		SELECT `+d.key+` as k, COUNT(DISTINCT visitor_hash)
+		FROM events
+		WHERE `+where+`
+		GROUP BY k
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var key *string
+		var visitors int64
+		if rows.Scan(&key, &visitors) == nil && key != nil {
+			counts[*key] = visitors
+		}
+	}
+	return counts, rows.Err()
+}
+
+// GetStatsTrends lists the pages, referrers, countries and campaigns whose
+// visitors changed most against the previous period of the same length.
+// Percentage movers only consider values with at least min_visitors in one
+// of the periods, so tiny values don't dominate them.
+func (h *Handlers) GetStatsTrends(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	f := h.parseStatsFilter(r)
+	pf := f.prevPeriod()
+
+	limit := defaultTrendsLimit
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxTrendsLimit)
+	}
+	minVisitors := int64(defaultTrendsMinVisitors)
+	if n, err := strconv.Atoi(r.URL.Query().Get("min_visitors")); err == nil && n >= 0 {
+		minVisitors = int64(n)
+	}
+
+	trends := make(map[string]interface{}, len(trendDimensions))
+	for _, d := range trendDimensions {
+		current, err := h.queryTrendVisitors(ctx, f, d)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		previous, err := h.queryTrendVisitors(ctx, pf, d)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		rows := make([]trendRow, 0, len(current))
+		for key, cur := range current {
+			rows = append(rows, newTrendRow(key, cur, previous[key]))
+		}
+		for key, prev := range previous {
+			if _, ok := current[key]; !ok {
+				rows = append(rows, newTrendRow(key, 0, prev))
+			}
+		}
+		trends[d.name] = rankTrends(rows, limit, minVisitors)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"start":      f.startMs,
+		"end":        f.endMs,
+		"prev_start": pf.startMs,
+		"prev_end":   pf.endMs,
+		"trends":     trends,
+	})
+}
+
+func newTrendRow(key string, current, previous int64) trendRow {
+	row := trendRow{Key: key, Current: current, Previous: previous, Change: current - previous}
+	if previous > 0 {
+		pct := math.Round(float64(row.Change)/float64(previous)*1000) / 10
+		row.ChangePct = &pct
+	}
+	return row
+}
+
+// rankTrends picks the top movers of one dimension, by absolute and by
+// percentage change in each direction
+func rankTrends(rows []trendRow, limit int, minVisitors int64) map[string][]trendRow {
+	pick := func(keep func(trendRow) bool, less func(a, b trendRow) bool) []trendRow {
+		out := make([]trendRow, 0, limit)
+		for _, row := range rows {
+			if keep(row) {
+				out = append(out, row)
+			}
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if less(out[i], out[j]) != less(out[j], out[i]) {
+				return less(out[i], out[j])
+			}
+			return out[i].Key < out[j].Key
+		})
+		if len(out) > limit {
+			out = out[:limit]
+		}
+		return out
+	}
+	pctEligible := func(row trendRow) bool {
+		return row.ChangePct != nil && max(row.Current, row.Previous) >= minVisitors
+	}
+
+	return map[string][]trendRow{
+		"gainers": pick(func(r trendRow) bool { return r.Change > 0 },
+			func(a, b trendRow) bool { return a.Change > b.Change }),
+		"losers": pick(func(r trendRow) bool { return r.Change < 0 },
+			func(a, b trendRow) bool { return a.Change < b.Change }),
+		"pct_gainers": pick(func(r trendRow) bool { return r.Change > 0 && pctEligible(r) },
+			func(a, b trendRow) bool { return *a.ChangePct > *b.ChangePct }),
+		"pct_losers": pick(func(r trendRow) bool { return r.Change < 0 && pctEligible(r) },
+			func(a, b trendRow) bool { return *a.ChangePct < *b.ChangePct }),
+	}
+}
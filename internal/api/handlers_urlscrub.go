@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+// urlScrubRulesKey is the settings key holding the JSON-encoded URL scrub
+// rules. While unset the built-in defaults apply; [] turns scrubbing off.
+const urlScrubRulesKey = "url_scrub_rules"
+
+// urlScrubRules returns the stored rules, or the defaults when none are set
+func (h *Handlers) urlScrubRules() ([]enrichment.URLScrubRule, error) {
+	raw := newSettingsService(h).GetWithDefault(urlScrubRulesKey, "")
+	if raw == "" {
+		return enrichment.DefaultURLScrubRules, nil
+	}
+	rules := make([]enrichment.URLScrubRule, 0)
+	err := json.Unmarshal([]byte(raw), &rules)
+	return rules, err
+}
+
+// scrubURL applies the active scrub rules to a URL, or to any text quoting
+// URLs, before it is stored. URLs can carry emails and tokens.
+func scrubURL(s string) string {
+	scrubbed, _ := enrichment.ScrubURL(s)
+	return scrubbed
+}
+
+// scrubPropsTarget scrubs the link target that outbound and download clicks
+// keep in their props
+func scrubPropsTarget(props json.RawMessage) json.RawMessage {
+	if len(props) == 0 {
+		return props
+	}
+	var decoded map[string]interface{}
+	if json.Unmarshal(props, &decoded) != nil {
+		return props
+	}
+	target, ok := decoded["target"].(string)
+	if !ok {
+		return props
+	}
+	scrubbed := scrubURL(target)
+	if scrubbed == target {
+		return props
+	}
+	decoded["target"] = scrubbed
+	out, err := json.Marshal(decoded)
+	if err != nil {
+		return props
+	}
+	return out
+}
+
+// loadURLScrubRules applies the stored URL scrub rules to ingestion
+func (h *Handlers) loadURLScrubRules() {
+	rules, err := h.urlScrubRules()
+	if err != nil {
+		log.Printf("[urlscrub] Ignoring malformed %s setting: %v", urlScrubRulesKey, err)
+		return
+	}
+	if err := enrichment.SetURLScrubRules(rules); err != nil {
+		log.Printf("[urlscrub] Ignoring invalid scrub rules: %v", err)
+	}
+}
+
+// GetURLScrubRules returns the active URL scrub rules and the defaults
+func (h *Handlers) GetURLScrubRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.urlScrubRules()
+	if err != nil {
+		rules = enrichment.DefaultURLScrubRules
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rules":    rules,
+		"defaults": enrichment.DefaultURLScrubRules,
+	})
+}
+
+// UpdateURLScrubRules replaces the URL scrub rules. Rules apply in order,
+// each to the output of the one before; they only affect new events.
+func (h *Handlers) UpdateURLScrubRules(w http.ResponseWriter, r *http.Request) {
+	var rules []enrichment.URLScrubRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if rules == nil {
+		rules = []enrichment.URLScrubRule{}
+	}
+
+	if err := enrichment.CompileURLScrubRules(rules); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	encoded, _ := json.Marshal(rules)
+	if err := newSettingsService(h).Set(urlScrubRulesKey, string(encoded)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	enrichment.SetURLScrubRules(rules)
+
+	h.logAudit(r, "update", "settings", urlScrubRulesKey, fmt.Sprintf("Updated URL scrub rules (%d rules)", len(rules)))
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// TestURLScrub shows how a URL would be stored, with the active rules or
+// with rules given in the request (to try them before saving)
+func (h *Handlers) TestURLScrub(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL   string                     `json:"url"`
+		Rules *[]enrichment.URLScrubRule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	scrub := func(s string) (string, []string, error) {
+		if input.Rules == nil {
+			out, matched := enrichment.ScrubURL(s)
+			return out, matched, nil
+		}
+		return enrichment.ScrubURLWith(s, *input.Rules)
+	}
+
+	scrubbedURL, matched, err := scrub(input.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var path string
+	if parsed, err := url.Parse(input.URL); err == nil {
+		path, _, _ = scrub(parsed.Path)
+	}
+	if matched == nil {
+		matched = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":           scrubbedURL,
+		"path":          path,
+		"matched_rules": matched,
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/identification"
+)
+
+func TestIngestScrubsStoredURLs(t *testing.T) {
+	h := newTestHandlers(t)
+	h.idGen = identification.New("secret", 30)
+	enriched := &enrichment.EnrichmentResult{}
+
+	const page = "https://example.com/account?token=s3cret&tab=1"
+	leaked := func(field, value string) {
+		t.Helper()
+		if strings.Contains(value, "s3cret") || strings.Contains(value, "jane@example.com") {
+			t.Errorf("%s stored unscrubbed: %s", field, value)
+		}
+	}
+
+	event := h.parseEvent(map[string]interface{}{
+		"url":          page,
+		"referrer_url": "https://mail.example.org/read?email=jane@example.com",
+		"event_type":   "click",
+		"event_name":   "outbound",
+		"props":        `{"target":"https://partner.example.net/in?key=s3cret"}`,
+	}, "session", enriched, "Mozilla/5.0", "iphash")
+	leaked("event url", event.URL)
+	leaked("event referrer", *event.ReferrerURL)
+	leaked("event props", string(event.Props))
+	if *event.ReferrerType == "" {
+		t.Error("referrer type not classified")
+	}
+	var props map[string]string
+	if err := json.Unmarshal(event.Props, &props); err != nil || props["target"] != "https://partner.example.net/in?key="+enrichment.URLRedacted {
+		t.Errorf("props = %s, want the target with the key redacted", event.Props)
+	}
+
+	perf := h.parsePerformance(map[string]interface{}{"url": page}, "session", enriched)
+	leaked("performance url", perf.URL)
+
+	errEvent := h.parseError(map[string]interface{}{
+		"url":           page,
+		"error_message": "Failed to load https://cdn.example.com/app.js?sig=s3cret",
+		"error_stack":   "at render (https://example.com/account?token=s3cret:10:5)",
+		"script_url":    "https://cdn.example.com/app.js?sig=s3cret",
+	}, "session", enriched)
+	leaked("error url", errEvent.URL)
+	leaked("error message", errEvent.ErrorMessage)
+	leaked("error stack", *errEvent.ErrorStack)
+	leaked("error script url", *errEvent.ScriptURL)
+}
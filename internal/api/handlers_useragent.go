@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+// uaOverridesKey is the settings key holding the JSON-encoded override rules
+const uaOverridesKey = "ua_overrides"
+
+// loadUAOverrides applies the stored user-agent override rules to the parser
+func (h *Handlers) loadUAOverrides() {
+	raw := newSettingsService(h).GetWithDefault(uaOverridesKey, "[]")
+
+	var rules []enrichment.UAOverride
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		log.Printf("[useragent] Ignoring malformed %s setting: %v", uaOverridesKey, err)
+		return
+	}
+	if err := enrichment.SetUAOverrides(rules); err != nil {
+		log.Printf("[useragent] Ignoring invalid override rules: %v", err)
+	}
+}
+
+// GetUAOverrides returns the configured user-agent override rules
+func (h *Handlers) GetUAOverrides(w http.ResponseWriter, r *http.Request) {
+	raw := newSettingsService(h).GetWithDefault(uaOverridesKey, "[]")
+
+	rules := make([]enrichment.UAOverride, 0)
+	json.Unmarshal([]byte(raw), &rules)
+
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// UpdateUAOverrides replaces the user-agent override rules.
+// Rules are evaluated in order; the first matching pattern wins.
+func (h *Handlers) UpdateUAOverrides(w http.ResponseWriter, r *http.Request) {
+	var rules []enrichment.UAOverride
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if rules == nil {
+		rules = []enrichment.UAOverride{}
+	}
+
+	if err := enrichment.CompileUAOverrides(rules); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	encoded, _ := json.Marshal(rules)
+	if err := newSettingsService(h).Set(uaOverridesKey, string(encoded)); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	enrichment.SetUAOverrides(rules)
+
+	h.logAudit(r, "update", "settings", uaOverridesKey, fmt.Sprintf("Updated user-agent overrides (%d rules)", len(rules)))
+	writeJSON(w, http.StatusOK, rules)
+}
+
+// TestUAOverride parses a user agent with the current rules and reports which rule matched
+func (h *Handlers) TestUAOverride(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserAgent string `json:"user_agent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	result := enrichment.ParseUserAgent(input.UserAgent)
+	index, rule := enrichment.MatchUAOverride(input.UserAgent)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"browser":         result.BrowserName,
+		"browser_version": result.BrowserVersion,
+		"os":              result.OSName,
+		"device":          result.DeviceType,
+		"is_bot":          result.IsBot,
+		"matched_rule":    rule,
+		"matched_index":   index,
+	})
+}
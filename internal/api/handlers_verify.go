@@ -0,0 +1,184 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// verifyWindow is how recent an event must be to count as "receiving"
+const verifyWindow = 5 * time.Minute
+
+// maxIngestDiagnostics caps each diagnostics map; rejections from hosts
+// nobody is verifying would otherwise accumulate
+const maxIngestDiagnostics = 1000
+
+// ingestSighting is the latest time something was seen at ingest
+type ingestSighting struct {
+	origin string
+	siteID string
+	at     time.Time
+	count  int64
+}
+
+// Kinds of ingest sightings, each keyed as noted
+const (
+	sightAccepted       = iota // accepted, by site_id
+	sightOriginMismatch        // origin didn't match the site's domain, by site_id
+	sightBadSiteID             // missing, unknown or inactive site_id, by origin host
+	sightDNT                   // dropped for Do Not Track / GPC, by site_id
+	numSightingKinds
+)
+
+// ingestDiagnostics remembers recent accepted and rejected tracking
+// requests, which are otherwise dropped silently, so installations can be
+// verified. The zero value is ready to use.
+type ingestDiagnostics struct {
+	mu        sync.Mutex
+	sightings [numSightingKinds]map[string]ingestSighting
+}
+
+func (d *ingestDiagnostics) record(kind int, key, origin, siteID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m := d.sightings[kind]
+	if m == nil {
+		m = make(map[string]ingestSighting)
+		d.sightings[kind] = m
+	}
+	now := time.Now()
+	s, ok := m[key]
+	if !ok && len(m) >= maxIngestDiagnostics {
+		for k, old := range m {
+			if now.Sub(old.at) > time.Hour {
+				delete(m, k)
+			}
+		}
+		if len(m) >= maxIngestDiagnostics {
+			return
+		}
+	}
+	m[key] = ingestSighting{origin: origin, siteID: siteID, at: now, count: s.count + 1}
+}
+
+// total sums the counts of one kind of sighting
+func (d *ingestDiagnostics) total(kind int) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n int64
+	for _, s := range d.sightings[kind] {
+		n += s.count
+	}
+	return n
+}
+
+func (d *ingestDiagnostics) lookup(kind int, key string) (ingestSighting, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.sightings[kind][key]
+	return s, ok
+}
+
+// VerifyDomain reports whether a domain's tracking snippet is working:
+// receiving, no_events, origin_mismatch, wrong_site_id or inactive. Recent
+// rejections are kept in memory, so they reset on restart.
+func (h *Handlers) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var domain, siteID string
+	var isActive bool
+	err := h.db.Conn().QueryRow("SELECT domain, site_id, is_active FROM domains WHERE id = ?", id).Scan(&domain, &siteID, &isActive)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Domain not found")
+		return
+	}
+
+	now := time.Now()
+	var lastEventAt *int64
+	h.db.Conn().QueryRow("SELECT MAX(timestamp) FROM events WHERE domain = ?", domain).Scan(&lastEventAt)
+
+	var lastOrigin string
+	if s, ok := h.ingestDiag.lookup(sightAccepted, siteID); ok {
+		lastOrigin = s.origin
+		if at := s.at.UnixMilli(); lastEventAt == nil || at > *lastEventAt {
+			lastEventAt = &at
+		}
+	}
+
+	issues := make([]map[string]interface{}, 0)
+	addIssue := func(kind string, s ingestSighting, message string) {
+		issues = append(issues, map[string]interface{}{
+			"type":      kind,
+			"origin":    s.origin,
+			"site_id":   s.siteID,
+			"count":     s.count,
+			"last_seen": s.at.UnixMilli(),
+			"message":   message,
+		})
+	}
+
+	if s, ok := h.ingestDiag.lookup(sightOriginMismatch, siteID); ok {
+		addIssue("origin_mismatch", s, fmt.Sprintf(
+			"Events with this site_id were sent from %s, which does not match %s. Install the snippet on %s or add %s as its own domain.",
+			s.origin, domain, domain, s.origin))
+	}
+	// The snippet is often installed on the www variant too
+	hosts := []string{domain, "www." + domain}
+	if bare := strings.TrimPrefix(domain, "www."); bare != domain {
+		hosts[1] = bare
+	}
+	for _, host := range hosts {
+		s, ok := h.ingestDiag.lookup(sightBadSiteID, host)
+		if !ok {
+			continue
+		}
+		message := fmt.Sprintf("Events from %s used site_id %q, which is not an active site. Copy the snippet again from Settings > Domains.", s.origin, s.siteID)
+		if s.siteID == "" {
+			message = fmt.Sprintf("Events from %s had no site_id. Make sure the script tag has data-site=%q.", s.origin, siteID)
+		}
+		addIssue("wrong_site_id", s, message)
+		break
+	}
+	// Informational, so listed after the issues that explain a status
+	if s, ok := h.ingestDiag.lookup(sightDNT, siteID); ok {
+		addIssue("dnt", s, fmt.Sprintf(
+			"%d event(s) from visitors with Do Not Track or Global Privacy Control were dropped because respect_dnt is on.",
+			s.count))
+	}
+
+	recent := func(kind string) bool {
+		for _, issue := range issues {
+			if issue["type"] == kind && now.Sub(time.UnixMilli(issue["last_seen"].(int64))) <= verifyWindow {
+				return true
+			}
+		}
+		return false
+	}
+
+	status := "no_events"
+	switch {
+	case !isActive:
+		status = "inactive"
+	case lastEventAt != nil && now.Sub(time.UnixMilli(*lastEventAt)) <= verifyWindow:
+		status = "receiving"
+	case recent("origin_mismatch"):
+		status = "origin_mismatch"
+	case recent("wrong_site_id"):
+		status = "wrong_site_id"
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain":         domain,
+		"site_id":        siteID,
+		"status":         status,
+		"last_event_at":  lastEventAt,
+		"last_origin":    lastOrigin,
+		"window_minutes": int(verifyWindow.Minutes()),
+		"issues":         issues,
+	})
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// widgetCacheTTL is how long a widget value is served from memory and how
+// long browsers/CDNs may cache it
+const widgetCacheTTL = 5 * time.Minute
+
+// widgetMetrics are the single values a widget can show, with default labels
+var widgetMetrics = map[string]string{
+	"visitors":    "visitors",
+	"pageviews":   "pageviews",
+	"sessions":    "sessions",
+	"bounce_rate": "bounce rate",
+}
+
+type widgetEntry struct {
+	value     float64
+	expiresAt time.Time
+}
+
+// widgetCache holds computed widget values keyed by token and metric.
+// The zero value is ready to use.
+type widgetCache struct {
+	mu      sync.Mutex
+	entries map[string]widgetEntry
+}
+
+func (c *widgetCache) get(key string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		return 0, false
+	}
+	return e.value, true
+}
+
+func (c *widgetCache) set(key string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]widgetEntry)
+	}
+	now := time.Now()
+	// Drop stale entries so revoked or unused tokens don't accumulate
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = widgetEntry{value: value, expiresAt: now.Add(widgetCacheTTL)}
+}
+
+// GetShareWidget serves a single aggregated metric for a share link as JSON or
+// an SVG badge (public). Query params: metric (visitors, pageviews, sessions,
+// bounce_rate), format (json or svg), label (badge text).
+// Password-protected shares cannot be embedded.
+func (h *Handlers) GetShareWidget(w http.ResponseWriter, r *http.Request) {
+	// Widgets are meant to be embedded on any site
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	token := chi.URLParam(r, "token")
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "visitors"
+	}
+	label, ok := widgetMetrics[metric]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "metric must be one of visitors, pageviews, sessions, bounce_rate")
+		return
+	}
+	if l := r.URL.Query().Get("label"); l != "" && len(l) <= 40 {
+		label = l
+	}
+
+	share, err := h.lookupShare(token)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Share link not found or expired")
+		return
+	}
+	if share.HasPassword {
+		writeError(w, http.StatusForbidden, "Password-protected shares cannot be embedded")
+		return
+	}
+
+	cacheKey := token + ":" + metric
+	value, ok := h.widgets.get(cacheKey)
+	if !ok {
+		f := statsFilter{domain: share.Domain, suspicious: h.suspiciousPolicy()}
+		f.startMs, f.endMs = share.dateRange()
+		overview := h.queryOverviewStats(r.Context(), f)
+
+		// A missing or differently typed value shows as 0 rather than
+		// failing the request
+		switch metric {
+		case "visitors":
+			n, _ := overview["unique_visitors"].(int64)
+			value = float64(n)
+		case "pageviews":
+			n, _ := overview["pageviews"].(int64)
+			value = float64(n)
+		case "sessions":
+			n, _ := overview["sessions"].(int64)
+			value = float64(n)
+		case "bounce_rate":
+			value, _ = overview["bounce_rate"].(float64)
+		}
+		h.widgets.set(cacheKey, value)
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(widgetCacheTTL.Seconds())))
+
+	if r.URL.Query().Get("format") == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(renderBadge(label, formatWidgetValue(metric, value))))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain": share.Domain,
+		"metric": metric,
+		"value":  value,
+		"label":  label,
+	})
+}
+
+// formatWidgetValue renders a metric compactly, e.g. 12.3K or 41.2%
+func formatWidgetValue(metric string, value float64) string {
+	if metric == "bounce_rate" {
+		return strconv.FormatFloat(value, 'f', 1, 64) + "%"
+	}
+	switch {
+	case value >= 1_000_000:
+		return strconv.FormatFloat(value/1_000_000, 'f', 1, 64) + "M"
+	case value >= 1000:
+		return strconv.FormatFloat(value/1000, 'f', 1, 64) + "K"
+	}
+	return strconv.FormatFloat(value, 'f', 0, 64)
+}
+
+// renderBadge draws a flat two-part badge in the style of shields.io.
+// Widths are estimated at ~7px per character of 11px Verdana.
+func renderBadge(label, value string) string {
+	labelWidth := 7*len(label) + 12
+	valueWidth := 7*len(value) + 12
+	total := labelWidth + valueWidth
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<rect width="%[2]d" height="20" fill="#555"/>`+
+		`<rect x="%[2]d" width="%[3]d" height="20" fill="#4c1"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[6]d" y="14">%[4]s</text>`+
+		`<text x="%[7]d" y="14">%[5]s</text>`+
+		`</g></svg>`,
+		total, labelWidth, valueWidth, label, value, labelWidth/2, labelWidth+valueWidth/2)
+}
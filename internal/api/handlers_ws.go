@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	// wsPingInterval is how often idle WebSocket clients are pinged
+	wsPingInterval = 30 * time.Second
+	// wsWriteTimeout bounds each message write and pong wait
+	wsWriteTimeout = 10 * time.Second
+)
+
+// EventSocket is a WebSocket alternative to EventStream for networks whose
+// proxies buffer or drop SSE. It receives the same notifications, honours the
+// same domain query param, and pings the client to detect dead connections.
+func (h *Handlers) EventSocket(w http.ResponseWriter, r *http.Request) {
+	// The connection outlives the server's read/write timeouts once upgraded
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// Origin must match the host, so other sites can't ride the session cookie
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	client, _ := h.subscribe(r.URL.Query().Get("domain"), "")
+	defer h.unsubscribe(client)
+
+	// Clients only listen; CloseRead handles control frames and cancels ctx
+	// when the peer goes away
+	ctx := conn.CloseRead(r.Context())
+
+	if err := wsWrite(ctx, conn, []byte(`{"type":"connected"}`)); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-client:
+			if err := wsWrite(ctx, conn, msg.data); err != nil {
+				return
+			}
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func wsWrite(ctx context.Context, conn *websocket.Conn, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return conn.Write(ctx, websocket.MessageText, msg)
+}
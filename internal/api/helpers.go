@@ -4,9 +4,13 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
+	"math"
+	mrand "math/rand/v2"
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/adfraud"
 )
 
 func generateID() string {
@@ -15,6 +19,27 @@ func generateID() string {
 	return hex.EncodeToString(b)
 }
 
+// keepSample decides whether to keep a beacon given a sampling rate in [0, 1]
+func keepSample(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return mrand.Float64() < rate
+}
+
+// sampleRateKeys are the performance and error sampling settings. They're
+// read at startup, so changes apply after a restart.
+var sampleRateKeys = []string{"performance_sample_rate", "error_sample_rate"}
+
+// validSampleRate reports whether raw is a sampling rate above 0 and at most 1
+func validSampleRate(raw string) bool {
+	rate, err := strconv.ParseFloat(raw, 64)
+	return err == nil && rate > 0 && rate <= 1
+}
+
 func getStringOr(m map[string]interface{}, key, def string) string {
 	if v, ok := m[key].(string); ok {
 		return v
@@ -44,12 +69,17 @@ func hashIP(ip string) string {
 	return hex.EncodeToString(h[:8])
 }
 
-// getBotFilterCondition returns SQL condition for bot filtering
-func getBotFilterCondition(filter string) string {
+// getBotFilterCondition returns SQL condition for bot filtering. The
+// suspicious policy decides whether suspicious traffic is in "humans",
+// "bots" and the default view.
+func getBotFilterCondition(filter string, suspicious adfraud.SuspiciousPolicy) string {
 	switch filter {
 	case "all":
 		return "1=1"
 	case "humans":
+		if suspicious == adfraud.SuspiciousAsHuman {
+			return "bot_category IN ('human', 'suspicious')"
+		}
 		return "bot_category = 'human'"
 	case "good_bots":
 		return "bot_category = 'good_bot'"
@@ -58,22 +88,88 @@ func getBotFilterCondition(filter string) string {
 	case "suspicious":
 		return "bot_category = 'suspicious'"
 	case "bots":
+		if suspicious == adfraud.SuspiciousAsBot {
+			return "(is_bot = 1 OR bot_category = 'suspicious')"
+		}
 		return "is_bot = 1"
 	default:
 		// Default: exclude bots (maintain backward compatibility)
-		return "is_bot = 0"
+		if suspicious == adfraud.SuspiciousAsHuman {
+			return "is_bot = 0"
+		}
+		return "is_bot = 0 AND bot_category IS NOT 'suspicious'"
+	}
+}
+
+// suspiciousPolicyKey decides how the suspicious bot category is counted
+const suspiciousPolicyKey = "suspicious_policy"
+
+// suspiciousPolicy returns the suspicious_policy setting, or the default
+// when it is unset or invalid
+func (h *Handlers) suspiciousPolicy() adfraud.SuspiciousPolicy {
+	if p, ok := adfraud.ParseSuspiciousPolicy(newSettingsService(h).GetWithDefault(suspiciousPolicyKey, "")); ok {
+		return p
 	}
+	return adfraud.DefaultSuspiciousPolicy
+}
+
+// getBotFilterParam reads bot_filter for endpoints that analyze bot traffic
+// itself, where the default is all traffic rather than humans only
+func getBotFilterParam(r *http.Request) string {
+	if f := r.URL.Query().Get("bot_filter"); f != "" {
+		return f
+	}
+	return "all"
 }
 
 func getDaysParam(r *http.Request, defaultVal int) int {
 	if d := r.URL.Query().Get("days"); d != "" {
-		if days, err := strconv.Atoi(d); err == nil && days > 0 && days <= 365 {
+		if days, err := strconv.Atoi(d); err == nil && days > 0 && days <= maxRangeDays {
 			return days
 		}
 	}
 	return defaultVal
 }
 
+// maxRangeDays is the longest window the days param selects
+const maxRangeDays = 365
+
+// defaultRangeDaysKey sets the days reports cover when a request gives
+// neither start/end nor days
+const defaultRangeDaysKey = "default_range_days"
+
+// rangeDaysLimit is the largest default_range_days allowed: maxRangeDays, or
+// the license's retention if shorter, since older events are purged
+func (h *Handlers) rangeDaysLimit() int {
+	if retention := h.licenseManager.GetLimit("max_retention_days"); retention > 0 && retention < maxRangeDays {
+		return retention
+	}
+	return maxRangeDays
+}
+
+// defaultRangeDays returns the default_range_days setting, or 7 when unset
+// or invalid, capped at rangeDaysLimit in case the license changed since
+func (h *Handlers) defaultRangeDays() int {
+	days := newSettingsService(h).GetInt(defaultRangeDaysKey, 7)
+	if days < 1 {
+		days = 7
+	}
+	if limit := h.rangeDaysLimit(); days > limit {
+		days = limit
+	}
+	return days
+}
+
+// getReportDays is getDaysParam with the instance default range
+func (h *Handlers) getReportDays(r *http.Request) int {
+	return getDaysParam(r, h.defaultRangeDays())
+}
+
+// getReportDateRange is getDateRangeParams with the instance default range
+func (h *Handlers) getReportDateRange(r *http.Request) (startMs, endMs int64) {
+	return getDateRangeParams(r, h.defaultRangeDays())
+}
+
 func getDomainParam(r *http.Request) string {
 	return r.URL.Query().Get("domain")
 }
@@ -99,3 +195,9 @@ func getDateRangeParams(r *http.Request, defaultDays int) (startMs, endMs int64)
 	now := time.Now()
 	return now.Add(-time.Duration(days) * 24 * time.Hour).UnixMilli(), now.UnixMilli()
 }
+
+// estimateCount rounds a count scaled up from sampled rows (see
+// database.SampleWeightSQL) to a whole estimate
+func estimateCount(scaled float64) int64 {
+	return int64(math.Round(scaled))
+}
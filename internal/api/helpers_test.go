@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/config"
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
+	"github.com/caioricciuti/etiquetta/internal/licensing"
+)
+
+// Domain registered by newTestRouter
+const (
+	testDomain = "example.com"
+	testSiteID = "site_test"
+)
+
+// newTestDB returns a migrated database in a temporary directory
+func newTestDB(t *testing.T) (*database.DB, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "etiquetta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return db, dir
+}
+
+// newTestHandlers returns handlers backed by a migrated database in a
+// temporary directory
+func newTestHandlers(t *testing.T) *Handlers {
+	t.Helper()
+	db, dir := newTestDB(t)
+	return &Handlers{
+		db:             db,
+		cfg:            &config.Config{DataDir: dir},
+		licenseManager: licensing.NewManager(filepath.Join(dir, "license.json")),
+	}
+}
+
+// newTestRouter returns the full router over a migrated database with
+// testDomain registered as testSiteID. cfg may set limits; the data
+// directory and secret are filled in.
+func newTestRouter(t *testing.T, cfg config.Config) (http.Handler, *database.DB) {
+	t.Helper()
+	db, dir := newTestDB(t)
+	_, err := db.Conn().Exec(
+		"INSERT INTO domains (id, name, domain, site_id, created_at, is_active) VALUES (?, ?, ?, ?, ?, 1)",
+		"d1", "Example", testDomain, testSiteID, time.Now().UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.DataDir = dir
+	cfg.SecretKey = "test-secret"
+	cfg.SessionTimeoutMinutes = 30
+	if cfg.AllowedOrigins == nil {
+		cfg.AllowedOrigins = []string{"*"}
+	}
+	ui := fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}
+	router := NewRouter(db, enrichment.New(""), forwarding.New(db.Conn()), licensing.NewManager(filepath.Join(dir, "license.json")), &cfg, ui)
+	return router, db
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/caioricciuti/etiquetta/internal/config"
+)
+
+// utf8BOM is a byte order mark, which some clients write before the body
+const utf8BOM = "\ufeff"
+
+// parseIngestLine decodes one NDJSON event. A line that isn't valid JSON is
+// retried without the slips hand-written or concatenated events tend to
+// have: a byte order mark, commas before a closing } or ], and a comma
+// ending the line. repaired reports whether that was needed; err is the
+// original error when it doesn't help.
+func parseIngestLine(line string) (raw map[string]interface{}, repaired bool, err error) {
+	if err = json.Unmarshal([]byte(line), &raw); err == nil {
+		return raw, false, nil
+	}
+
+	fixed := strings.TrimSpace(strings.TrimPrefix(line, utf8BOM))
+	fixed = stripTrailingCommas(strings.TrimSuffix(fixed, ","))
+	if fixed == line {
+		return nil, false, err
+	}
+	raw = nil
+	if json.Unmarshal([]byte(fixed), &raw) != nil {
+		return nil, false, err
+	}
+	return raw, true, nil
+}
+
+// stripTrailingCommas removes commas followed only by whitespace before a
+// closing } or ], outside strings
+func stripTrailingCommas(s string) string {
+	var b strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ',':
+			rest := strings.TrimLeft(s[i+1:], " \t")
+			if strings.HasPrefix(rest, "}") || strings.HasPrefix(rest, "]") {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// maxEventLineBytes is the longest event line ingest accepts
+func (h *Handlers) maxEventLineBytes() int {
+	if h.cfg.MaxEventLineBytes <= 0 {
+		return config.DefaultMaxEventLineBytes
+	}
+	return h.cfg.MaxEventLineBytes
+}
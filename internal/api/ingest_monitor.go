@@ -0,0 +1,278 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ingest limits and the thresholds at which a request or client is logged
+const (
+	maxIngestBodyBytes   = 1 << 20                    // larger bodies are truncated
+	largeIngestBodyBytes = maxIngestBodyBytes / 4 * 3 // "near the limit"
+	slowIngestThreshold  = 500 * time.Millisecond
+
+	// A client is logged once at least half of its last events were
+	// rejected, counting from rejectRateMinEvents events
+	highRejectRate      = 0.5
+	rejectRateMinEvents = 20
+
+	// ingestLogEvery is how often the same client is logged for the same
+	// problem, so a broken integration doesn't flood the log
+	ingestLogEvery = 10 * time.Minute
+
+	// maxIngestClients caps the clients tracked; idle ones are forgotten
+	maxIngestClients = 1000
+	ingestClientIdle = time.Hour
+)
+
+// ingestObservation is what one ingest request looked like
+type ingestObservation struct {
+	siteID    string // the first site_id in the body
+	bytes     int
+	truncated bool
+	events    int // lines in the body
+	rejected  int // lines dropped as malformed, or for their site_id or origin
+	duration  time.Duration
+
+	malformed    int    // lines that weren't JSON, also counted in rejected
+	malformedErr string // why the first of them failed
+	repaired     int    // lines parsed after fixing a trailing comma or BOM
+	tooLong      int    // lines over max_event_line_bytes, also counted in rejected
+	longestLine  int    // bytes of the longest of them
+	maxLine      int    // the limit they were over
+}
+
+// ingestClient counts one client's requests. Clients are keyed by site_id
+// and IP hash, never by the raw IP.
+type ingestClient struct {
+	SiteID      string `json:"site_id"`
+	IPHash      string `json:"ip_hash"`
+	Requests    int64  `json:"requests"`
+	Events      int64  `json:"events"`
+	Rejected    int64  `json:"rejected"`
+	Malformed   int64  `json:"malformed"`
+	Repaired    int64  `json:"repaired"`
+	TooLong     int64  `json:"too_long"`
+	LargeBodies int64  `json:"large_bodies"`
+	Truncated   int64  `json:"truncated"`
+	Slow        int64  `json:"slow"`
+	LastSeen    int64  `json:"last_seen"`
+
+	// Events and rejections since the reject rate was last checked
+	windowEvents   int
+	windowRejected int
+
+	seen       time.Time
+	lastLogged [numIngestProblems]time.Time
+}
+
+// Problems logged per client
+const (
+	problemLarge = iota
+	problemSlow
+	problemRejects
+	problemMalformed
+	problemTooLong
+	numIngestProblems
+)
+
+// ingestTotals are the ingest counters since the server started
+type ingestTotals struct {
+	Requests    int64 `json:"requests"`
+	Events      int64 `json:"events"`
+	Rejected    int64 `json:"rejected"`
+	Malformed   int64 `json:"malformed"`
+	Repaired    int64 `json:"repaired"`
+	TooLong     int64 `json:"too_long"`
+	LargeBodies int64 `json:"large_bodies"`
+	Truncated   int64 `json:"truncated"`
+	Slow        int64 `json:"slow"`
+}
+
+// ingestMonitor counts oversized, slow, mostly-rejected and malformed ingest
+// requests per client and logs them. The zero value is ready to use.
+type ingestMonitor struct {
+	mu      sync.Mutex
+	since   time.Time
+	totals  ingestTotals
+	clients map[string]*ingestClient
+}
+
+// observe records a finished ingest request
+func (m *ingestMonitor) observe(ipHash string, o ingestObservation) {
+	large := o.bytes >= largeIngestBodyBytes
+	slow := o.duration >= slowIngestThreshold
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.clients == nil {
+		m.clients = make(map[string]*ingestClient)
+		m.since = now
+	}
+	m.totals.Requests++
+	m.totals.Events += int64(o.events)
+	m.totals.Rejected += int64(o.rejected)
+	m.totals.Malformed += int64(o.malformed)
+	m.totals.Repaired += int64(o.repaired)
+	m.totals.TooLong += int64(o.tooLong)
+	if large {
+		m.totals.LargeBodies++
+	}
+	if o.truncated {
+		m.totals.Truncated++
+	}
+	if slow {
+		m.totals.Slow++
+	}
+
+	c := m.client(o.siteID, ipHash, now)
+	if c == nil {
+		return
+	}
+	c.Requests++
+	c.Events += int64(o.events)
+	c.Rejected += int64(o.rejected)
+	c.Malformed += int64(o.malformed)
+	c.Repaired += int64(o.repaired)
+	c.TooLong += int64(o.tooLong)
+	c.windowEvents += o.events
+	c.windowRejected += o.rejected
+	c.seen = now
+	c.LastSeen = now.UnixMilli()
+
+	if large {
+		c.LargeBodies++
+		if o.truncated {
+			c.Truncated++
+		}
+		if c.shouldLog(problemLarge, now) {
+			log.Printf("[ingest] Large body: site_id=%q ip_hash=%s bytes=%d limit=%d truncated=%t events=%d",
+				c.SiteID, shortHash(c.IPHash), o.bytes, maxIngestBodyBytes, o.truncated, o.events)
+		}
+	}
+	if slow {
+		c.Slow++
+		if c.shouldLog(problemSlow, now) {
+			log.Printf("[ingest] Slow request: site_id=%q ip_hash=%s duration=%s bytes=%d events=%d",
+				c.SiteID, shortHash(c.IPHash), o.duration.Round(time.Millisecond), o.bytes, o.events)
+		}
+	}
+	if o.malformed > 0 && c.shouldLog(problemMalformed, now) {
+		log.Printf("[ingest] Malformed lines: site_id=%q ip_hash=%s malformed=%d/%d error=%q",
+			c.SiteID, shortHash(c.IPHash), o.malformed, o.events, o.malformedErr)
+	}
+	if o.tooLong > 0 && c.shouldLog(problemTooLong, now) {
+		log.Printf("[ingest] Lines too long: site_id=%q ip_hash=%s dropped=%d/%d longest=%d limit=%d",
+			c.SiteID, shortHash(c.IPHash), o.tooLong, o.events, o.longestLine, o.maxLine)
+	}
+	if c.windowEvents >= rejectRateMinEvents {
+		rate := float64(c.windowRejected) / float64(c.windowEvents)
+		if rate >= highRejectRate && c.shouldLog(problemRejects, now) {
+			log.Printf("[ingest] High reject rate: site_id=%q ip_hash=%s rejected=%d/%d (%.0f%%)",
+				c.SiteID, shortHash(c.IPHash), c.windowRejected, c.windowEvents, rate*100)
+		}
+		c.windowEvents, c.windowRejected = 0, 0
+	}
+}
+
+// client returns the client's counters, making room for new clients by
+// forgetting idle ones; nil when every tracked client is still active
+func (m *ingestMonitor) client(siteID, ipHash string, now time.Time) *ingestClient {
+	key := siteID + "|" + ipHash
+	if c, ok := m.clients[key]; ok {
+		return c
+	}
+	if len(m.clients) >= maxIngestClients {
+		for k, c := range m.clients {
+			if now.Sub(c.seen) > ingestClientIdle {
+				delete(m.clients, k)
+			}
+		}
+		if len(m.clients) >= maxIngestClients {
+			return nil
+		}
+	}
+	c := &ingestClient{SiteID: siteID, IPHash: ipHash}
+	m.clients[key] = c
+	return c
+}
+
+// shouldLog reports whether a problem is due to be logged again
+func (c *ingestClient) shouldLog(problem int, now time.Time) bool {
+	if now.Sub(c.lastLogged[problem]) < ingestLogEvery {
+		return false
+	}
+	c.lastLogged[problem] = now
+	return true
+}
+
+// snapshot returns the totals and the clients with any problem, worst
+// first
+func (m *ingestMonitor) snapshot(limit int) (ingestTotals, time.Time, []ingestClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients := make([]ingestClient, 0)
+	for _, c := range m.clients {
+		if c.Rejected > 0 || c.Repaired > 0 || c.LargeBodies > 0 || c.Slow > 0 {
+			clients = append(clients, *c)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := clients[i], clients[j]
+		if a.Rejected+a.LargeBodies+a.Slow != b.Rejected+b.LargeBodies+b.Slow {
+			return a.Rejected+a.LargeBodies+a.Slow > b.Rejected+b.LargeBodies+b.Slow
+		}
+		return a.LastSeen > b.LastSeen
+	})
+	if len(clients) > limit {
+		clients = clients[:limit]
+	}
+	return m.totals, m.since, clients
+}
+
+// shortHash shortens an IP hash for logs; it stays enough to tell clients
+// apart and to match GetIngestStats
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// GetIngestStats reports ingest counters since the server started and the
+// clients with oversized, slow or rejected requests, to find misbehaving
+// integrations. Counters are kept in memory, so they reset on restart.
+func (h *Handlers) GetIngestStats(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+		limit = min(n, maxIngestClients)
+	}
+
+	totals, since, clients := h.ingestMonitor.snapshot(limit)
+	var sinceMs *int64
+	if !since.IsZero() {
+		ms := since.UnixMilli()
+		sinceMs = &ms
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"since":  sinceMs,
+		"totals": totals,
+		"thresholds": map[string]interface{}{
+			"max_body_bytes":         maxIngestBodyBytes,
+			"max_line_bytes":         h.maxEventLineBytes(),
+			"large_body_bytes":       largeIngestBodyBytes,
+			"slow_ms":                slowIngestThreshold.Milliseconds(),
+			"high_reject_rate":       highRejectRate,
+			"reject_rate_min_events": rejectRateMinEvents,
+		},
+		"clients": clients,
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPathPatterns caps a domain's path patterns; each adds a condition to
+// every pages query
+const maxPathPatterns = 50
+
+// Path pattern segments: literal text, a :name placeholder matching one
+// segment, or a final * matching the rest of the path
+var (
+	pathPatternLiteral     = regexp.MustCompile(`^[A-Za-z0-9._~%@!$&'()+,;=-]+$`)
+	pathPatternPlaceholder = regexp.MustCompile(`^:[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// normalizePathPatterns validates a domain's path patterns, templates such
+// as /products/:id or /docs/* that the pages report groups matching paths
+// under. Patterns are trimmed and must be unique.
+func normalizePathPatterns(patterns []string) ([]string, error) {
+	if len(patterns) > maxPathPatterns {
+		return nil, fmt.Errorf("at most %d path patterns per domain", maxPathPatterns)
+	}
+	normalized := make([]string, 0, len(patterns))
+	seen := make(map[string]bool, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if !strings.HasPrefix(pattern, "/") || pattern == "/" {
+			return nil, fmt.Errorf("path pattern %q must start with / and have a segment", pattern)
+		}
+		segments := strings.Split(pattern[1:], "/")
+		wildcard := false
+		for i, segment := range segments {
+			switch {
+			case segment == "*" && i == len(segments)-1:
+				wildcard = true
+			case pathPatternPlaceholder.MatchString(segment), pathPatternLiteral.MatchString(segment):
+			default:
+				return nil, fmt.Errorf("path pattern %q: segment %q must be text, a :name placeholder or a final *", pattern, segment)
+			}
+		}
+		if !wildcard && !strings.Contains(pattern, "/:") {
+			return nil, fmt.Errorf("path pattern %q has no :name placeholder or final *", pattern)
+		}
+		if seen[pattern] {
+			return nil, fmt.Errorf("path pattern %q is listed twice", pattern)
+		}
+		seen[pattern] = true
+		normalized = append(normalized, pattern)
+	}
+	return normalized, nil
+}
+
+// parsePathPatterns reads the path_patterns column; invalid JSON reads as
+// no patterns
+func parsePathPatterns(raw *string) []string {
+	patterns := make([]string, 0)
+	if raw != nil {
+		json.Unmarshal([]byte(*raw), &patterns)
+	}
+	return patterns
+}
+
+// pathPatternCondition returns a SQL condition matching the paths a
+// pattern covers: a GLOB where each placeholder is a non-empty segment,
+// and the same number of segments unless the pattern ends in *
+func pathPatternCondition(pattern string) (string, []interface{}) {
+	segments := strings.Split(pattern[1:], "/")
+	glob := make([]string, len(segments))
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			glob[i] = "*"
+		case strings.HasPrefix(segment, ":"):
+			glob[i] = "[^/]*"
+		default:
+			glob[i] = segment
+		}
+	}
+	cond := "path GLOB ?"
+	if segments[len(segments)-1] != "*" {
+		cond += " AND LENGTH(path) - LENGTH(REPLACE(path, '/', '')) = " + strconv.Itoa(len(segments))
+	}
+	return cond, []interface{}{"/" + strings.Join(glob, "/")}
+}
+
+// pageGroupExpr returns a SQL expression grouping paths under the first of
+// their domain's path patterns they match, or the path itself, and its
+// args. With no domain selected, each domain uses its own patterns.
+func (h *Handlers) pageGroupExpr(ctx context.Context, domain string) (string, []interface{}) {
+	query := "SELECT domain, path_patterns FROM domains WHERE path_patterns IS NOT NULL"
+	var queryArgs []interface{}
+	if domain != "" {
+		query += " AND domain = ?"
+		queryArgs = append(queryArgs, domain)
+	}
+	rows, err := h.db.Conn().QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return "path", nil
+	}
+	defer rows.Close()
+
+	var cases []string
+	var args []interface{}
+	for rows.Next() {
+		var d string
+		var raw *string
+		if rows.Scan(&d, &raw) != nil {
+			continue
+		}
+		for _, pattern := range parsePathPatterns(raw) {
+			cond, condArgs := pathPatternCondition(pattern)
+			cases = append(cases, "WHEN domain = ? AND "+cond+" THEN ?")
+			args = append(append(append(args, d), condArgs...), pattern)
+		}
+	}
+	if len(cases) == 0 {
+		return "path", nil
+	}
+	return "CASE " + strings.Join(cases, " ") + " ELSE path END", args
+}
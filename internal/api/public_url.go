@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+// publicURLKey is where the public base URL is configured
+const publicURLKey = settings.PublicURLKey
+
+// normalizePublicURL validates a public_url value and trims any trailing
+// slash. An empty value clears the override.
+func normalizePublicURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("public_url must be an absolute http(s) URL, e.g. https://analytics.example.com")
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", errors.New("public_url cannot contain credentials, a query or a fragment")
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// requestBaseURL derives scheme://host from the request. The scheme comes
+// from TLS or X-Forwarded-Proto (set by a TLS-terminating proxy); without
+// either, localhost is assumed to be plain http and anything else https.
+func requestBaseURL(r *http.Request) string {
+	scheme := "https"
+	proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	switch {
+	case proto == "http" || proto == "https":
+		scheme = proto
+	case r.TLS != nil:
+		scheme = "https"
+	case strings.HasPrefix(r.Host, "localhost") || strings.HasPrefix(r.Host, "127.0.0.1"):
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
+
+// publicBaseURL is the base for every absolute URL handed out: the
+// public_url setting (see settings.PublicURL), or the base URL derived from
+// the request when it isn't set
+func (h *Handlers) publicBaseURL(r *http.Request) string {
+	if u := newSettingsService(h).PublicURL(); u != "" {
+		return u
+	}
+	return requestBaseURL(r)
+}
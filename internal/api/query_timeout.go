@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// statsQueryTimeoutKey caps how long a report request may use the database,
+// in seconds; 0 disables the cap
+const statsQueryTimeoutKey = "stats_query_timeout_seconds"
+
+// defaultStatsQueryTimeout matches the data explorer's limit
+const defaultStatsQueryTimeout = database.QueryTimeout
+
+func (h *Handlers) statsQueryTimeout() time.Duration {
+	seconds := newSettingsService(h).GetInt(statsQueryTimeoutKey, int(defaultStatsQueryTimeout/time.Second))
+	if seconds < 0 {
+		return defaultStatsQueryTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// queryTimeout bounds the request context of report endpoints so an
+// expensive query can't hold the single database connection and stall
+// ingest. Queries run with the request context are interrupted at the
+// deadline, and the response becomes a 504 whatever the handler wrote.
+func (h *Handlers) queryTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := h.statsQueryTimeout()
+		if timeout == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if tw.timedOut {
+			log.Printf("[stats] %s timed out after %s", r.URL.Path, timeout)
+		}
+	})
+}
+
+// timeoutWriter replaces the response with a 504 when the request context
+// has expired by the time the handler responds. Handlers often ignore
+// query errors, so what they wrote may be empty or partial.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	if tw.ctx.Err() == context.DeadlineExceeded {
+		tw.timedOut = true
+		writeError(tw.ResponseWriter, http.StatusGatewayTimeout, "Query timed out; try a shorter date range or fewer filters")
+		return
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
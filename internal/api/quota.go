@@ -0,0 +1,236 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// eventQuotaResponseKey is the setting deciding how ingest answers requests
+// whose events were dropped for a domain's quota: "accept" (default) answers
+// 204 like any other request, since beacons ignore the response anyway;
+// "reject" answers 429, for SDKs that back off on it. Either way the
+// response carries an X-Etiquetta-Quota: exceeded header.
+const eventQuotaResponseKey = "event_quota_response"
+
+const (
+	quotaResponseAccept = "accept"
+	quotaResponseReject = "reject"
+)
+
+// usagePeriod is the quota period containing t, a UTC month as YYYY-MM
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// nextUsagePeriod is when the quota period containing t ends
+func nextUsagePeriod(t time.Time) time.Time {
+	y, m, _ := t.UTC().Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// quotaLog remembers the domains logged as over quota per period, so each
+// is logged once a month. The zero value is ready to use.
+type quotaLog struct {
+	mu     sync.Mutex
+	logged map[string]string // domain -> period
+}
+
+// once reports whether domain hasn't been logged for period yet
+func (l *quotaLog) once(domain, period string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.logged == nil {
+		l.logged = make(map[string]string)
+	}
+	if l.logged[domain] == period {
+		return false
+	}
+	l.logged[domain] = period
+	return true
+}
+
+// quotaBatch applies domains' event quotas to one ingest request
+type quotaBatch struct {
+	h        *Handlers
+	period   string
+	used     map[string]int64 // usage so far, including this request
+	accepted map[string]int64 // events this request counts per domain
+	exceeded map[string]int64 // domains over quota, with their quota
+}
+
+func (h *Handlers) newQuotaBatch() *quotaBatch {
+	return &quotaBatch{
+		h:        h,
+		period:   usagePeriod(time.Now()),
+		used:     make(map[string]int64),
+		accepted: make(map[string]int64),
+		exceeded: make(map[string]int64),
+	}
+}
+
+// allow counts an event towards its domain's usage, or reports false when
+// the domain has used its quota; a nil quota is unlimited
+func (b *quotaBatch) allow(domain string, quota *int64) bool {
+	if quota == nil {
+		b.accepted[domain]++
+		return true
+	}
+	used, ok := b.used[domain]
+	if !ok {
+		b.h.db.Conn().QueryRow("SELECT events FROM domain_usage WHERE domain = ? AND period = ?", domain, b.period).Scan(&used)
+	}
+	if used >= *quota {
+		b.used[domain] = used
+		b.exceeded[domain] = *quota
+		return false
+	}
+	b.used[domain] = used + 1
+	b.accepted[domain]++
+	return true
+}
+
+// save adds the accepted events to the domains' usage and logs domains that
+// went over quota, once per period each. Events are already stored, so a
+// failure is only logged.
+func (b *quotaBatch) save() {
+	for domain, n := range b.accepted {
+		_, err := b.h.db.Conn().Exec(`
+			INSERT INTO domain_usage (domain, period, events)
+			VALUES (?, ?, ?)
+			ON CONFLICT (domain, period) DO UPDATE SET events = events + excluded.events
+		`, domain, b.period, n)
+		if err != nil {
+			log.Printf("[quota] Failed to record usage of %s: %v", domain, err)
+		}
+	}
+	for domain, quota := range b.exceeded {
+		if b.h.quotaLog.once(domain, b.period) {
+			log.Printf("[quota] %s used its quota of %d events for %s; dropping its events until %s",
+				domain, quota, b.period, nextUsagePeriod(time.Now()).Format("2006-01-02"))
+		}
+	}
+}
+
+// respond marks a request whose events were dropped for a quota, and
+// answers it with 429 when event_quota_response is "reject". It reports
+// whether it wrote the response.
+func (b *quotaBatch) respond(w http.ResponseWriter) bool {
+	if len(b.exceeded) == 0 {
+		return false
+	}
+	w.Header().Set("X-Etiquetta-Quota", "exceeded")
+	if newSettingsService(b.h).GetWithDefault(eventQuotaResponseKey, quotaResponseAccept) != quotaResponseReject {
+		return false
+	}
+	retry := time.Until(nextUsagePeriod(time.Now()))
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	writeError(w, http.StatusTooManyRequests, "Monthly event quota exceeded")
+	return true
+}
+
+// domainUsage returns each domain's events in the current quota period
+func (h *Handlers) domainUsage() map[string]int64 {
+	usage := make(map[string]int64)
+	rows, err := h.db.Conn().Query("SELECT domain, events FROM domain_usage WHERE period = ?", usagePeriod(time.Now()))
+	if err != nil {
+		return usage
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var domain string
+		var events int64
+		if rows.Scan(&domain, &events) == nil {
+			usage[domain] = events
+		}
+	}
+	return usage
+}
+
+// normalizeEventQuota validates a domain's monthly event quota; 0 removes it
+func normalizeEventQuota(quota int64) (*int64, error) {
+	switch {
+	case quota < 0:
+		return nil, fmt.Errorf("event_quota must be 0 (unlimited) or more")
+	case quota == 0:
+		return nil, nil
+	}
+	return &quota, nil
+}
+
+// domainUsageReport is a domain's events in a quota period
+type domainUsageReport struct {
+	Domain      string   `json:"domain"`
+	Name        string   `json:"name"`
+	Events      int64    `json:"events"`
+	Quota       *int64   `json:"quota"`
+	PercentUsed *float64 `json:"percent_used"` // of the quota, when there is one
+	Projected   int64    `json:"projected"`    // events by the period's end at the current pace
+	Exceeded    bool     `json:"exceeded"`
+}
+
+// GetUsage reports each domain's events in the current quota period (the UTC
+// month) against its quota, with a projection for the whole month made by
+// extending the pace so far. Domains with a quota are projected at most to
+// it, since ingest drops their events beyond it. Counts come from the usage
+// ingest keeps for quotas, so they include performance beacons and errors,
+// and aren't lowered by retention or bot filtering.
+func (h *Handlers) GetUsage(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	period := usagePeriod(now)
+	end := nextUsagePeriod(now)
+	start := end.AddDate(0, -1, 0)
+	elapsed := now.Sub(start)
+
+	rows, err := h.db.Conn().QueryContext(r.Context(), `
+		SELECT d.domain, d.name, d.event_quota, COALESCE(u.events, 0)
+		FROM domains d
+		LEFT JOIN domain_usage u ON u.domain = d.domain AND u.period = ?
+		ORDER BY d.domain
+	`, period)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	domains := make([]domainUsageReport, 0)
+	var total, projectedTotal int64
+	for rows.Next() {
+		var d domainUsageReport
+		if err := rows.Scan(&d.Domain, &d.Name, &d.Quota, &d.Events); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		d.Projected = int64(math.Round(float64(d.Events) * float64(end.Sub(start)) / float64(elapsed)))
+		if d.Quota != nil {
+			percent := math.Round(float64(d.Events)/float64(*d.Quota)*1000) / 10
+			d.PercentUsed = &percent
+			d.Projected = min(d.Projected, *d.Quota)
+			d.Exceeded = d.Events >= *d.Quota
+		}
+		total += d.Events
+		projectedTotal += d.Projected
+		domains = append(domains, d)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"period":       period,
+		"period_start": start.UnixMilli(),
+		"period_end":   end.UnixMilli(),
+		"elapsed":      math.Round(float64(elapsed)/float64(end.Sub(start))*1000) / 1000, // share of the period gone
+		"domains":      domains,
+		"total": map[string]int64{
+			"events":    total,
+			"projected": projectedTotal,
+		},
+	})
+}
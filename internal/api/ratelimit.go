@@ -1,11 +1,56 @@
 package api
 
 import (
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// limiter decides whether a client, keyed by IP or site_id, may make
+// another request in the current window
+type limiter interface {
+	take(key string) limitResult
+}
+
+// limitResult is the outcome of one request against a limit
+type limitResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	reset     time.Duration // until the current window ends
+}
+
+// newLimitResult builds the result of the count-th request in a window
+func newLimitResult(count, rate int, reset time.Duration) limitResult {
+	return limitResult{
+		allowed:   count <= rate,
+		limit:     rate,
+		remaining: max(rate-count, 0),
+		reset:     max(reset, 0),
+	}
+}
+
+// setHeaders reports the limit on a response, and when to retry once it is
+// exceeded
+func (res limitResult) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.remaining))
+	if !res.allowed {
+		retry := int((res.reset + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(max(retry, 1)))
+	}
+}
+
+// writeRateLimited answers a request over its limit
+func writeRateLimited(w http.ResponseWriter, res limitResult) {
+	res.setHeaders(w)
+	writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+}
+
+// rateLimiter is the in-memory fixed-window limiter. Counts are per process,
+// which is exact for a single node.
 type rateLimiter struct {
 	mu       sync.Mutex
 	visitors map[string]*visitor
@@ -28,18 +73,18 @@ func newRateLimiter(rate int, window time.Duration) *rateLimiter {
 	return rl
 }
 
-func (rl *rateLimiter) allow(ip string) bool {
+func (rl *rateLimiter) take(key string) limitResult {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
-	v, exists := rl.visitors[ip]
+	v, exists := rl.visitors[key]
 	if !exists || now.Sub(v.windowStart) > rl.window {
-		rl.visitors[ip] = &visitor{count: 1, windowStart: now}
-		return true
+		v = &visitor{windowStart: now}
+		rl.visitors[key] = v
 	}
 	v.count++
-	return v.count <= rl.rate
+	return newLimitResult(v.count, rl.rate, v.windowStart.Add(rl.window).Sub(now))
 }
 
 func (rl *rateLimiter) cleanup() {
@@ -58,14 +103,29 @@ func (rl *rateLimiter) cleanup() {
 
 // RateLimit returns middleware that limits requests per IP.
 func RateLimit(rate int, window time.Duration) func(http.Handler) http.Handler {
-	limiter := newRateLimiter(rate, window)
+	return limitMiddleware(newRateLimiter(rate, window))
+}
+
+func limitMiddleware(limiter limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !limiter.allow(r.RemoteAddr) {
-				writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			res := limiter.take(clientKey(r))
+			if !res.allowed {
+				writeRateLimited(w, res)
 				return
 			}
+			res.setHeaders(w)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// clientKey is the client's IP. RemoteAddr is the proxy-reported IP behind a
+// proxy, but carries the port on direct connections, which would give every
+// new connection its own window.
+func clientKey(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
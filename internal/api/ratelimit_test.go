@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/caioricciuti/etiquetta/internal/config"
+)
+
+func pageview(siteID string) []byte {
+	return []byte(fmt.Sprintf(`{"type":"pageview","site_id":%q,"url":"https://%s/"}`, siteID, testDomain))
+}
+
+func TestIngestRateLimitHeaders(t *testing.T) {
+	router, _ := newTestRouter(t, config.Config{IngestRateLimitPerMin: 3})
+
+	for i := 1; i <= 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, ingestRequest(pageview(testSiteID), ""))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, want 204", i, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: X-RateLimit-Limit = %q, want 3", i, got)
+		}
+		if got, want := w.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(3-i); got != want {
+			t.Errorf("request %d: X-RateLimit-Remaining = %q, want %s", i, got, want)
+		}
+		if got := w.Header().Get("Retry-After"); got != "" {
+			t.Errorf("request %d: Retry-After = %q before the limit", i, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, ingestRequest(pageview(testSiteID), ""))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("Retry-After = %q, want 1-60 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// Other clients have their own window
+	r := ingestRequest(pageview(testSiteID), "")
+	r.RemoteAddr = "198.51.100.1:5000"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("another client got %d, want 204", w.Code)
+	}
+}
+
+func TestIngestSiteRateLimit(t *testing.T) {
+	router, db := newTestRouter(t, config.Config{IngestRateLimitPerMin: 1000, IngestSiteRateLimitPerMin: 2})
+
+	send := func(i int, body []byte, origin string) *httptest.ResponseRecorder {
+		r := ingestRequest(body, "")
+		r.RemoteAddr = fmt.Sprintf("203.0.113.%d:5000", i%250+1)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// Unknown site_ids, other origins and dropped DNT visits don't count
+	// against the site
+	dnt := []byte(fmt.Sprintf(`{"type":"pageview","site_id":%q,"url":"https://%s/","dnt":1}`, testSiteID, testDomain))
+	for i := 0; i < 5; i++ {
+		if w := send(i, dnt, "https://"+testDomain); w.Code != http.StatusNoContent {
+			t.Fatalf("DNT visit: status = %d, want 204", w.Code)
+		}
+		if w := send(i, pageview("site_unknown"), "https://"+testDomain); w.Code != http.StatusNoContent {
+			t.Fatalf("unknown site_id: status = %d, want 204", w.Code)
+		}
+		if w := send(i, pageview(testSiteID), "https://attacker.example"); w.Code != http.StatusNoContent {
+			t.Fatalf("wrong origin: status = %d, want 204", w.Code)
+		}
+	}
+
+	for i := 1; i <= 2; i++ {
+		if w := send(100+i, pageview(testSiteID), "https://"+testDomain); w.Code != http.StatusNoContent {
+			t.Fatalf("request %d within the site limit: status = %d, want 204", i, w.Code)
+		}
+	}
+	w := send(200, pageview(testSiteID), "https://"+testDomain)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the site limit: status = %d, want 429", w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("429 headers: limit %q, retry after %q", w.Header().Get("X-RateLimit-Limit"), w.Header().Get("Retry-After"))
+	}
+
+	var stored int
+	if err := db.Conn().QueryRow("SELECT COUNT(*) FROM events").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Errorf("stored %d events, want 2", stored)
+	}
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// readOnlyAllowedPaths accept writes in read-only mode, so admins can still
+// sign in to see what's wrong
+var readOnlyAllowedPaths = map[string]bool{
+	"/api/auth/login":  true,
+	"/api/auth/logout": true,
+}
+
+// readOnlyGuard answers every write with 503 while the server runs
+// read-only after a failed migration: the schema is behind what the code
+// expects, so writes could fail halfway or store incomplete rows. Ingest is
+// refused too; trackers drop the events rather than retrying.
+func (h *Handlers) readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failure := h.db.MigrationFailure()
+		if failure == nil || readOnlyAllowedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf(
+			"Etiquetta is read-only because database migration %d (%s) failed; see the server log",
+			failure.Version, failure.Description))
+	})
+}
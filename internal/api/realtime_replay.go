@@ -0,0 +1,97 @@
+package api
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// Settings key for how many recent realtime notifications are kept so SSE
+// clients reconnecting with Last-Event-ID can catch up. 0 disables replay.
+// Read when the router is built, so changes apply after a restart.
+const (
+	realtimeReplaySizeKey     = "realtime_replay_size"
+	defaultRealtimeReplaySize = 256
+	maxRealtimeReplaySize     = 10000
+)
+
+// realtimeMessage is a notification queued for a realtime subscriber
+type realtimeMessage struct {
+	id   uint64
+	data []byte
+}
+
+// replayEntry is one broadcast, with its payload for each domain filter
+// that matched it ("" is the unfiltered payload)
+type replayEntry struct {
+	id       uint64
+	payloads map[string][]byte
+}
+
+// replayBuffer is a fixed-size ring of recent broadcasts. Payloads are small
+// summaries, so memory is bounded by the entry count.
+type replayBuffer struct {
+	entries []replayEntry
+	next    int
+	full    bool
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{entries: make([]replayEntry, size)}
+}
+
+func (b *replayBuffer) add(e replayEntry) {
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// since returns the buffered messages for domain with IDs after lastID,
+// oldest first
+func (b *replayBuffer) since(lastID uint64, domain string) []realtimeMessage {
+	var out []realtimeMessage
+	start, n := 0, b.next
+	if b.full {
+		start, n = b.next, len(b.entries)
+	}
+	for i := 0; i < n; i++ {
+		e := b.entries[(start+i)%len(b.entries)]
+		if e.id <= lastID {
+			continue
+		}
+		if data := e.payloads[domain]; data != nil {
+			out = append(out, realtimeMessage{id: e.id, data: data})
+		}
+	}
+	return out
+}
+
+// loadRealtimeReplay sizes the replay buffer from settings. Message IDs
+// start at the current Unix time in milliseconds so they keep increasing
+// across restarts.
+func (h *Handlers) loadRealtimeReplay() {
+	size := newSettingsService(h).GetInt(realtimeReplaySizeKey, defaultRealtimeReplaySize)
+	if size < 0 || size > maxRealtimeReplaySize {
+		log.Printf("[realtime] Ignoring invalid %s setting %d", realtimeReplaySizeKey, size)
+		size = defaultRealtimeReplaySize
+	}
+
+	h.sseMu.Lock()
+	defer h.sseMu.Unlock()
+	h.realtimeSeq = uint64(time.Now().UnixMilli())
+	h.replay = nil
+	if size > 0 {
+		h.replay = newReplayBuffer(size)
+	}
+}
+
+// parseLastEventID reads the ID a reconnecting client last saw
+func parseLastEventID(s string) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,382 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/auth"
+	"github.com/caioricciuti/etiquetta/internal/email"
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+// Scheduled email reports: report_frequency is off (default), daily or
+// weekly; report_recipients a comma-separated list of addresses, empty for
+// every admin; report_hour the hour of the day they go out, in the default
+// report timezone. report_last_period remembers the last period sent, so a
+// restart doesn't send it again.
+const (
+	reportFrequencyKey  = "report_frequency"
+	reportRecipientsKey = "report_recipients"
+	reportHourKey       = "report_hour"
+	reportLastPeriodKey = "report_last_period"
+)
+
+const (
+	reportOff    = "off"
+	reportDaily  = "daily"
+	reportWeekly = "weekly"
+)
+
+const defaultReportHour = 8
+
+// reportCheckInterval is how often the scheduler looks for a report due
+const reportCheckInterval = 10 * time.Minute
+
+// reportTopItems is how many pages and referrers a report lists per domain
+const reportTopItems = 5
+
+// validateReportSettings checks the report settings in a settings update
+func validateReportSettings(updates map[string]string) error {
+	if raw, ok := updates[reportFrequencyKey]; ok && raw != "" {
+		if raw != reportOff && raw != reportDaily && raw != reportWeekly {
+			return fmt.Errorf("%s must be off, daily or weekly", reportFrequencyKey)
+		}
+	}
+	if raw, ok := updates[reportHourKey]; ok && raw != "" {
+		if hour, err := strconv.Atoi(raw); err != nil || hour < 0 || hour > 23 {
+			return fmt.Errorf("%s must be an hour from 0 to 23", reportHourKey)
+		}
+	}
+	if raw, ok := updates[reportRecipientsKey]; ok {
+		for _, to := range parseReportRecipients(raw) {
+			if !strings.Contains(to, "@") || strings.ContainsAny(to, " \r\n") {
+				return fmt.Errorf("%s: %q is not an email address", reportRecipientsKey, to)
+			}
+		}
+	}
+	return nil
+}
+
+// parseReportRecipients splits a comma-separated list of addresses
+func parseReportRecipients(raw string) []string {
+	recipients := make([]string, 0)
+	for _, to := range strings.Split(raw, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			recipients = append(recipients, to)
+		}
+	}
+	return recipients
+}
+
+// reportRecipients returns the configured recipients, or every admin's
+// address when none are configured
+func (h *Handlers) reportRecipients(svc *settings.Service) []string {
+	if recipients := parseReportRecipients(svc.GetWithDefault(reportRecipientsKey, "")); len(recipients) > 0 {
+		return recipients
+	}
+	recipients := make([]string, 0)
+	rows, err := h.db.Conn().Query("SELECT email FROM users WHERE role = 'admin' ORDER BY email")
+	if err != nil {
+		return recipients
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var to string
+		if rows.Scan(&to) == nil {
+			recipients = append(recipients, to)
+		}
+	}
+	return recipients
+}
+
+// reportPeriod is the range of days a report covers
+type reportPeriod struct {
+	frequency string
+	start     time.Time
+	end       time.Time // exclusive
+}
+
+// key identifies the period in report_last_period
+func (p reportPeriod) key() string {
+	return p.frequency + ":" + p.start.Format("2006-01-02")
+}
+
+// label names the period in the report's subject and heading
+func (p reportPeriod) label() string {
+	if p.frequency == reportWeekly {
+		return p.start.Format("Jan 2") + " - " + p.end.AddDate(0, 0, -1).Format("Jan 2, 2006")
+	}
+	return p.start.Format("Monday, Jan 2, 2006")
+}
+
+// previous names the period before, for changes
+func (p reportPeriod) previous() string {
+	if p.frequency == reportWeekly {
+		return "previous week"
+	}
+	return "previous day"
+}
+
+// lastReportPeriod is the latest complete period as of now, in now's
+// location: yesterday for daily reports, and for weekly ones the Monday to
+// Sunday week before this one
+func lastReportPeriod(frequency string, now time.Time) reportPeriod {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if frequency == reportWeekly {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return reportPeriod{frequency: frequency, start: monday.AddDate(0, 0, -7), end: monday}
+	}
+	return reportPeriod{frequency: reportDaily, start: today.AddDate(0, 0, -1), end: today}
+}
+
+// domainReport is one domain's numbers in a report
+type domainReport struct {
+	domain       string
+	visitors     int64
+	prevVisitors int64
+	pageviews    int64
+	sessions     int64
+	bounceRate   float64
+	botRate      float64 // percentage of all events made by bots
+	pages        []map[string]interface{}
+	referrers    []map[string]interface{}
+}
+
+// buildReport gathers each active domain's numbers for the period with the
+// same queries as the dashboard's overview, pages and referrers reports
+func (h *Handlers) buildReport(ctx context.Context, period reportPeriod) ([]domainReport, error) {
+	rows, err := h.db.Conn().QueryContext(ctx, "SELECT domain FROM domains WHERE is_active = 1 ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	var domains []string
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reports := make([]domainReport, 0, len(domains))
+	for _, domain := range domains {
+		f := statsFilter{
+			startMs:    period.start.UnixMilli(),
+			endMs:      period.end.UnixMilli() - 1,
+			domain:     domain,
+			suspicious: h.suspiciousPolicy(),
+		}
+		overview := h.queryOverviewStats(ctx, f)
+		prev := h.queryOverviewStats(ctx, f.prevPeriod())
+		report := domainReport{
+			domain:       domain,
+			visitors:     overview["unique_visitors"].(int64),
+			prevVisitors: prev["unique_visitors"].(int64),
+			pageviews:    overview["pageviews"].(int64),
+			sessions:     overview["sessions"].(int64),
+			bounceRate:   overview["bounce_rate"].(float64),
+		}
+
+		all, bots := f, f
+		all.botFilter, bots.botFilter = "all", "bots"
+		if total := h.countEvents(ctx, all); total > 0 {
+			report.botRate = float64(h.countEvents(ctx, bots)) / float64(total) * 100
+		}
+
+		if report.pages, err = h.queryTopPages(ctx, f); err != nil {
+			return nil, err
+		}
+		if report.referrers, err = h.queryReferrers(ctx, f); err != nil {
+			return nil, err
+		}
+		report.pages = report.pages[:min(len(report.pages), reportTopItems)]
+		report.referrers = report.referrers[:min(len(report.referrers), reportTopItems)]
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// countEvents counts the events matching f in its range
+func (h *Handlers) countEvents(ctx context.Context, f statsFilter) int64 {
+	where, args := f.where("timestamp >= ? AND timestamp <= ?", f.startMs, f.endMs)
+	var n int64
+	h.db.Conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM events WHERE "+where, args...).Scan(&n)
+	return n
+}
+
+// reportChange describes cur against the previous period's prev, e.g.
+// "120 (+12% on the previous day)"
+func reportChange(cur, prev int64, previous string) string {
+	if prev == 0 {
+		return fmt.Sprintf("%d (none the %s)", cur, previous)
+	}
+	change := math.Round(float64(cur-prev) / float64(prev) * 100)
+	return fmt.Sprintf("%d (%+.0f%% on the %s)", cur, change, previous)
+}
+
+// reportMessage renders a report as a plain-text and an HTML email
+func reportMessage(period reportPeriod, loc *time.Location, reports []domainReport, baseURL string) *email.Message {
+	title := fmt.Sprintf("Etiquetta %s report: %s", period.frequency, period.label())
+
+	var text, body strings.Builder
+	fmt.Fprintf(&text, "%s (%s)\n", title, loc)
+	fmt.Fprintf(&body, "<h2>%s</h2><p>Times in %s.</p>", html.EscapeString(title), html.EscapeString(loc.String()))
+	if len(reports) == 0 {
+		text.WriteString("\nNo active domains.\n")
+		body.WriteString("<p>No active domains.</p>")
+	}
+
+	for _, r := range reports {
+		summary := [][2]string{
+			{"Visitors", reportChange(r.visitors, r.prevVisitors, period.previous())},
+			{"Pageviews", strconv.FormatInt(r.pageviews, 10)},
+			{"Sessions", strconv.FormatInt(r.sessions, 10)},
+			{"Bounce rate", fmt.Sprintf("%.1f%%", r.bounceRate)},
+			{"Bot traffic", fmt.Sprintf("%.1f%% of events", r.botRate)},
+		}
+		fmt.Fprintf(&text, "\n%s\n", r.domain)
+		fmt.Fprintf(&body, "<h3>%s</h3><table>", html.EscapeString(r.domain))
+		for _, row := range summary {
+			fmt.Fprintf(&text, "  %-12s %s\n", row[0], row[1])
+			fmt.Fprintf(&body, "<tr><td>%s</td><td>%s</td></tr>", row[0], html.EscapeString(row[1]))
+		}
+		body.WriteString("</table>")
+
+		lists := []struct {
+			title string
+			items []map[string]interface{}
+			name  string
+			count string
+			unit  string
+		}{
+			{"Top pages", r.pages, "path", "views", "views"},
+			{"Top referrers", r.referrers, "source", "visits", "visits"},
+		}
+		for _, list := range lists {
+			if len(list.items) == 0 {
+				continue
+			}
+			fmt.Fprintf(&text, "  %s\n", list.title)
+			fmt.Fprintf(&body, "<p><strong>%s</strong></p><ol>", list.title)
+			for _, item := range list.items {
+				name, count := fmt.Sprint(item[list.name]), fmt.Sprint(item[list.count])
+				fmt.Fprintf(&text, "    %s  %s %s\n", name, count, list.unit)
+				fmt.Fprintf(&body, "<li>%s: %s %s</li>", html.EscapeString(name), count, list.unit)
+			}
+			body.WriteString("</ol>")
+		}
+	}
+
+	if baseURL != "" {
+		fmt.Fprintf(&text, "\nOpen Etiquetta: %s/\n", baseURL)
+		fmt.Fprintf(&body, `<p><a href="%s/">Open Etiquetta</a></p>`, html.EscapeString(baseURL))
+	}
+	return &email.Message{Subject: title, Body: text.String(), HTML: body.String()}
+}
+
+// sendReport builds the report for period and emails it to each recipient
+func (h *Handlers) sendReport(ctx context.Context, svc *settings.Service, period reportPeriod, loc *time.Location, recipients []string) error {
+	sender := email.NewFromSettings(svc)
+	if !sender.Enabled() {
+		return email.ErrDisabled
+	}
+	reports, err := h.buildReport(ctx, period)
+	if err != nil {
+		return fmt.Errorf("building the report: %w", err)
+	}
+	msg := reportMessage(period, loc, reports, svc.PublicURL())
+	for _, to := range recipients {
+		msg.To = to
+		if err := sender.SendMessage(msg); err != nil {
+			return fmt.Errorf("sending to %s: %w", to, err)
+		}
+	}
+	return nil
+}
+
+// runReportScheduler sends the scheduled reports, checking every
+// reportCheckInterval whether one is due. Settings are re-read each time,
+// so changes apply without a restart.
+func (h *Handlers) runReportScheduler() {
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.sendScheduledReport(time.Now())
+	}
+}
+
+// sendScheduledReport sends the latest complete period's report once its
+// hour has come, unless it was already sent. A failed send isn't retried,
+// so a broken provider doesn't send duplicates to the recipients it reached.
+func (h *Handlers) sendScheduledReport(now time.Time) {
+	svc := newSettingsService(h)
+	frequency := svc.GetWithDefault(reportFrequencyKey, reportOff)
+	if frequency != reportDaily && frequency != reportWeekly {
+		return
+	}
+	loc := h.reportLocation("")
+	now = now.In(loc)
+	if now.Hour() < svc.GetInt(reportHourKey, defaultReportHour) {
+		return
+	}
+	period := lastReportPeriod(frequency, now)
+	if svc.GetWithDefault(reportLastPeriodKey, "") == period.key() {
+		return
+	}
+	if err := svc.Set(reportLastPeriodKey, period.key()); err != nil {
+		log.Printf("[reports] Failed to record the %s report as sent, skipping it: %v", frequency, err)
+		return
+	}
+
+	recipients := h.reportRecipients(svc)
+	if len(recipients) == 0 {
+		log.Printf("[reports] No recipients for the %s report", frequency)
+		return
+	}
+	if err := h.sendReport(context.Background(), svc, period, loc, recipients); err != nil {
+		log.Printf("[reports] Failed to send the %s report for %s: %v", frequency, period.label(), err)
+		return
+	}
+	log.Printf("[reports] Sent the %s report for %s to %d recipient(s)", frequency, period.label(), len(recipients))
+}
+
+// PreviewReport emails the report for the latest complete period (daily
+// when reports are off) to the current user, to check how it looks. It
+// doesn't count as the scheduled send.
+func (h *Handlers) PreviewReport(w http.ResponseWriter, r *http.Request) {
+	claims := auth.GetUserFromContext(r.Context())
+	if claims == nil {
+		writeError(w, http.StatusUnauthorized, "Not authenticated")
+		return
+	}
+
+	svc := newSettingsService(h)
+	frequency := svc.GetWithDefault(reportFrequencyKey, reportOff)
+	loc := h.reportLocation("")
+	period := lastReportPeriod(frequency, time.Now().In(loc))
+
+	if err := h.sendReport(r.Context(), svc, period, loc, []string{claims.Email}); err != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Failed to send the report: %s", err.Error()),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Sent the %s report for %s to %s", period.frequency, period.label(), claims.Email),
+	})
+}
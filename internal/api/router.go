@@ -4,7 +4,6 @@ import (
 	"embed"
 	"io/fs"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -16,6 +15,7 @@ import (
 	"github.com/caioricciuti/etiquetta/internal/config"
 	"github.com/caioricciuti/etiquetta/internal/database"
 	"github.com/caioricciuti/etiquetta/internal/enrichment"
+	"github.com/caioricciuti/etiquetta/internal/forwarding"
 	"github.com/caioricciuti/etiquetta/internal/identification"
 	"github.com/caioricciuti/etiquetta/internal/licensing"
 )
@@ -26,42 +26,45 @@ var trackerJS embed.FS
 //go:embed consent.js
 var consentJS embed.FS
 
-// NewRouter creates the HTTP router
-func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *licensing.Manager, cfg *config.Config, uiFS fs.FS) http.Handler {
+// NewRouter creates the HTTP router. Ingested events are handed to
+// forwarder, which the caller starts and stops.
+func NewRouter(db *database.DB, enricher *enrichment.Enricher, forwarder *forwarding.Forwarder, licenseManager *licensing.Manager, cfg *config.Config, uiFS fs.FS) http.Handler {
 	r := chi.NewRouter()
 
 	// Middleware
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
+
+	// Client IPs come from forwarding headers only when a trusted proxy sends
+	// them (trusted_proxies setting)
+	proxies := &trustedProxies{}
+	r.Use(proxies.realIP)
+
 	r.Use(middleware.Compress(5))
 
 	// CORS - allow credentials for auth cookies
 	// Use AllowOriginFunc instead of AllowedOrigins to reflect the actual
 	// Origin header. AllowedOrigins: ["*"] sends a literal "*" which browsers
 	// reject when credentials are included (sendBeacon, fetch with cookies).
+	// Patterns are validated at startup; invalid ones are skipped here.
+	originMatcher, _ := config.NewOriginMatcher(cfg.AllowedOrigins)
 	r.Use(cors.Handler(cors.Options{
 		AllowOriginFunc: func(r *http.Request, origin string) bool {
-			for _, o := range cfg.AllowedOrigins {
-				if o == "*" || o == origin {
-					return true
-				}
-			}
-			return false
+			return originMatcher.Allowed(origin)
 		},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Content-Type", "X-Requested-With", "Authorization"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Encoding", "X-Requested-With", "Authorization", "X-Share-Password"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count", "X-Next-Cursor", "X-Export-Truncated", "X-Export-Warning", "X-Etiquetta-Malformed", "X-Etiquetta-Repaired", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	// Create auth service
-	// secureCookie should only be true when running with HTTPS directly
-	// When behind a reverse proxy (nginx), the proxy handles HTTPS
-	// Check ETIQUETTA_SECURE_COOKIES env var, default to false for proxy setups
-	secureCookie := os.Getenv("ETIQUETTA_SECURE_COOKIES") == "true"
-	authService := auth.New(cfg.SecretKey, secureCookie)
+	// Create auth service (cookie attributes are resolved from settings/env in serve)
+	authService := auth.New(cfg.SecretKey, cfg.SessionDurationHours, auth.CookieOptions{
+		Secure:   cfg.CookieSecure,
+		SameSite: cfg.CookieSameSite,
+		Domain:   cfg.CookieDomain,
+	})
 	authMiddleware := auth.NewMiddleware(authService)
 
 	// Create identity generator
@@ -75,23 +78,79 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 		idGen:          idGen,
 		cfg:            cfg,
 		auth:           authService,
+		forwarder:      forwarder,
+		throughput:     throughputCounter{started: time.Now()},
+		proxies:        proxies,
 	}
 
+	// Scheduled email reports and API key usage are written to the
+	// database, so not while read-only
+	if db.MigrationFailure() == nil {
+		go h.runReportScheduler()
+		go h.runAPIKeyUsageFlush()
+	}
+
+	// Optional dashboard allowlist (admin_allowed_cidrs / admin_allowed_countries)
+	authMiddleware.SetAccessFilter(h.checkAdminAccess)
+
+	// API keys (Authorization: Bearer yaat_...) for scripted access
+	authMiddleware.SetAPIKeyLookup(h.lookupAPIKey)
+
+	// Apply operator-defined user-agent overrides and URL scrub rules before any ingestion
+	h.loadUAOverrides()
+	h.loadURLScrubRules()
+	h.loadTrackingPaths()
+	h.loadRealtimeReplay()
+	h.loadExcludedIPHashes()
+	h.loadRespectDNT()
+	h.loadTrustedProxies()
+
+	// Security headers, relaxed for the tracking endpoints
+	h.loadSecurityHeaders()
+	r.Use(h.securityHeaders)
+
+	// Only reads while serving after a failed migration
+	r.Use(h.readOnlyGuard)
+
 	// ========== Public endpoints ==========
 
 	// Tracker script - serve at /s.js (clean URL)
-	r.Get("/s.js", h.ServeTrackerScript)
+	r.Get(defaultTrackerScriptPath, h.ServeTrackerScript)
+	r.Get(versionedScriptPattern(defaultTrackerScriptPath), h.ServeTrackerScript) // Versioned, cacheable forever
 	r.Get("/s/tracker.js", h.ServeTrackerScript) // Legacy URL
 
-	// Ingest endpoint (rate limited: 100 req/min/IP)
-	r.With(RateLimit(100, time.Minute)).Post("/i", h.Ingest)
+	// Public rate limits, shared across instances when Redis is configured
+	limits := newRateLimits(cfg.RedisURL)
+
+	// Ingest endpoint (rate limited per IP by ingest_rate_limit_per_min, and
+	// per site_id by ingest_site_rate_limit_per_min when set)
+	ingestRate := cfg.IngestRateLimitPerMin
+	if ingestRate <= 0 {
+		ingestRate = config.DefaultIngestRateLimitPerMin
+	}
+	ingestLimit := limits.middleware("ingest", ingestRate, time.Minute)
+	if cfg.IngestSiteRateLimitPerMin > 0 {
+		h.siteLimit = limits.limiter("ingest-site", cfg.IngestSiteRateLimitPerMin, time.Minute)
+	}
+	r.With(ingestLimit).Post(defaultIngestPath, h.Ingest)
+
+	// Custom paths from settings; the defaults stay live for existing snippets
+	if h.scriptPath != defaultTrackerScriptPath {
+		r.Get(h.scriptPath, h.ServeTrackerScript)
+		if pattern := versionedScriptPattern(h.scriptPath); pattern != "" {
+			r.Get(pattern, h.ServeTrackerScript)
+		}
+	}
+	if h.ingestPath != defaultIngestPath {
+		r.With(ingestLimit).Post(h.ingestPath, h.Ingest)
+	}
 
 	// Consent banner script
 	r.Get("/c.js", h.ServeConsentScript)
 
 	// Consent public endpoints
 	r.Get("/consent/{siteId}/config", h.GetPublicConsentConfig)
-	r.With(RateLimit(60, time.Minute)).Post("/consent/{siteId}/record", h.RecordConsent)
+	r.With(limits.middleware("consent", 60, time.Minute)).Post("/consent/{siteId}/record", h.RecordConsent)
 
 	// Tag Manager container script
 	r.Get("/tm/{siteId}.js", h.ServeContainerScript)
@@ -102,15 +161,23 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 	// Version endpoint (public)
 	r.Get("/api/version", h.GetVersion)
 
+	// Opt-out cookie for excluding your own visits (public)
+	excludeLimit := limits.middleware("exclude", 30, time.Minute)
+	r.With(excludeLimit).Get("/api/exclude", h.ExcludePage)
+	r.With(excludeLimit).Post("/api/exclude", h.ExcludeVisits)
+
 	// ========== API routes ==========
 	r.Route("/api", func(r chi.Router) {
 
 		// Auth routes (public)
 		r.Route("/auth", func(r chi.Router) {
 			r.Get("/setup", h.CheckSetup)
-			r.Post("/setup", h.Setup)
-			r.Post("/login", h.Login)
+			r.With(authMiddleware.RestrictAccess).Post("/setup", h.Setup)
+			r.With(authMiddleware.RestrictAccess).Post("/login", h.Login)
 			r.Post("/logout", h.Logout)
+			r.With(authMiddleware.RestrictAccess, limits.middleware("accept-invite", 10, time.Minute)).Post("/accept-invite", h.AcceptInvite)
+			r.With(authMiddleware.RestrictAccess, limits.middleware("forgot-password", 5, time.Minute)).Post("/forgot-password", h.ForgotPassword)
+			r.With(authMiddleware.RestrictAccess, limits.middleware("reset-password", 10, time.Minute)).Post("/reset-password", h.ResetPassword)
 
 			// Protected auth routes
 			r.Group(func(r chi.Router) {
@@ -123,6 +190,11 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 		// License info (public - needed for UI to check features)
 		r.Get("/license", h.GetLicense)
 
+		// Public share links (no auth; scoped to the share's domain and period)
+		r.Get("/public/shares/{token}", h.GetPublicShare)
+		r.With(limits.middleware("share-report", 30, time.Minute)).Get("/public/shares/{token}/report", h.GetPublicShareReport)
+		r.With(limits.middleware("share-widget", 120, time.Minute)).Get("/public/shares/{token}/widget", h.GetShareWidget)
+
 		// Protected routes
 		r.Group(func(r chi.Router) {
 			r.Use(authMiddleware.RequireAuth)
@@ -142,6 +214,7 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 				r.Put("/settings/geoip", h.UpdateGeoIPSettings)
 				r.Get("/settings/geoip/status", h.GetGeoIPStatus)
 				r.Post("/settings/geoip/download", h.DownloadGeoIPDatabase)
+				r.Get("/settings/geoip/download/progress", h.GetGeoIPDownloadProgress)
 			})
 
 			// Email Settings (admin only)
@@ -150,45 +223,108 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 				r.Get("/settings/email", h.GetEmailSettings)
 				r.Put("/settings/email", h.UpdateEmailSettings)
 				r.Post("/settings/email/test", h.TestEmailSettings)
+				r.Post("/settings/email/report-preview", h.PreviewReport)
+			})
+
+			// User-agent override rules (admin only)
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Get("/settings/ua-overrides", h.GetUAOverrides)
+				r.Put("/settings/ua-overrides", h.UpdateUAOverrides)
+				r.Post("/settings/ua-overrides/test", h.TestUAOverride)
+			})
+
+			// URL scrub rules (admin only)
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Get("/settings/url-scrub", h.GetURLScrubRules)
+				r.Put("/settings/url-scrub", h.UpdateURLScrubRules)
+				r.Post("/settings/url-scrub/test", h.TestURLScrub)
 			})
 
 			// Database access
 			r.Get("/db", h.ServeDatabase)
 			r.Get("/db/info", h.GetDatabaseInfo)
+			r.Get("/db/stats", h.GetDatabaseStats)
+			r.With(authMiddleware.RequireAdmin).Get("/db/check", h.CheckDatabase)
+
+			// API keys for scripted access
+			r.Get("/keys", h.ListAPIKeys)
+			r.Post("/keys", h.CreateAPIKey)
+			r.Delete("/keys/{id}", h.RevokeAPIKey)
 
-			// Real-time events via SSE
+			// Ingest diagnostics (admin only)
+			r.With(authMiddleware.RequireAdmin).Get("/ingest/stats", h.GetIngestStats)
+
+			// Real-time events via SSE, or WebSocket where proxies break SSE
 			r.Get("/events/stream", h.EventStream)
+			r.Get("/events/ws", h.EventSocket)
+
+			// Traffic-drop detection
+			r.Get("/alerts/traffic", h.GetTrafficStatus)
 
-			// Stats endpoints
-			r.Get("/stats/overview", h.GetStatsOverview)
-			r.Get("/stats/timeseries", h.GetStatsTimeseries)
-			r.Get("/stats/pages", h.GetStatsPages)
-			r.Get("/stats/referrers", h.GetStatsReferrers)
-			r.Get("/stats/geo", h.GetStatsGeo)
-			r.Get("/stats/map", h.GetStatsMapData)
-			r.Get("/stats/devices", h.GetStatsDevices)
-			r.Get("/stats/browsers", h.GetStatsBrowsers)
-			r.Get("/stats/campaigns", h.GetStatsCampaigns)
-			r.Get("/stats/events", h.GetStatsCustomEvents)
-			r.Get("/stats/outbound", h.GetStatsOutbound)
-			r.Get("/stats/bots", h.GetStatsBots) // Bot traffic breakdown
+			// Stats endpoints, with a query time limit and cache headers
+			r.Group(func(r chi.Router) {
+				r.Use(statsCacheHeaders, h.queryTimeout)
+				r.Get("/stats/overview", h.GetStatsOverview)
+				r.Get("/stats/timeseries", h.GetStatsTimeseries)
+				r.Get("/stats/pages", h.GetStatsPages)
+				r.Get("/stats/page", h.GetStatsPage)
+				r.Get("/stats/referrers", h.GetStatsReferrers)
+				r.Get("/stats/geo", h.GetStatsGeo)
+				r.Get("/stats/map", h.GetStatsMapData)
+				r.Get("/stats/devices", h.GetStatsDevices)
+				r.Get("/stats/browsers", h.GetStatsBrowsers)
+				r.Get("/stats/campaigns", h.GetStatsCampaigns)
+				r.Get("/stats/events", h.GetStatsCustomEvents)
+				r.Get("/stats/outbound", h.GetStatsOutbound)
+				r.Get("/stats/downloads", h.GetStatsDownloads)
+				r.Get("/stats/not-found", h.GetStatsNotFound)
+				r.Get("/stats/bots", h.GetStatsBots) // Bot traffic breakdown
+				r.Get("/stats/bot-rate", h.GetStatsBotRate)
+				r.Get("/stats/reconcile", h.GetStatsReconcile)
+				r.Get("/stats/dimension", h.GetStatsDimension)
+				r.Get("/stats/pre-consent", h.GetStatsPreConsent)
+				r.Get("/stats/searches", h.GetStatsSearches)
+				r.Get("/stats/trends", h.GetStatsTrends)
+				r.Get("/stats/crosstab", h.GetStatsCrosstab)
+				r.Get("/stats/funnel", h.GetStatsFunnel)
+				r.Get("/stats/retention", h.GetStatsRetention)
+				r.Get("/stats/throughput", h.GetStatsThroughput)
+				r.Get("/stats/networks", h.GetStatsNetworks)
+			})
 
 			// Domain management
 			r.Get("/domains", h.ListDomains)
 			r.Post("/domains", h.CreateDomain)
 			r.Delete("/domains/{id}", h.DeleteDomain)
 			r.Get("/domains/{id}/snippet", h.GetDomainSnippet)
+			r.Get("/domains/{id}/verify", h.VerifyDomain)
+			r.Get("/domains/{id}/key/usage", h.GetAPIKeyUsage)
+
+			// Domain settings (admin only): they decide what ingest keeps,
+			// for how long, and where it's sent
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Put("/domains/{id}", h.UpdateDomain)
+				r.Get("/domains/{id}/forwarding", h.GetDomainForwarding)
+			})
+
+			// Event usage against quotas
+			r.Get("/usage", h.GetUsage)
 
 			// Pro features - Web Vitals
 			r.Group(func(r chi.Router) {
 				r.Use(licensing.RequireFeature(licenseManager, licensing.FeaturePerformance))
-				r.Get("/stats/vitals", h.GetStatsVitals)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/stats/vitals", h.GetStatsVitals)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/stats/vitals/by-connection", h.GetStatsVitalsByConnection)
 			})
 
 			// Pro features - Error tracking
 			r.Group(func(r chi.Router) {
 				r.Use(licensing.RequireFeature(licenseManager, licensing.FeatureErrorTracking))
-				r.Get("/stats/errors", h.GetStatsErrors)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/stats/errors", h.GetStatsErrors)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/stats/errors/types", h.GetStatsErrorTypes)
 			})
 
 			// Pro features - Export
@@ -200,10 +336,12 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 			// Pro features - Ad Fraud Detection
 			r.Group(func(r chi.Router) {
 				r.Use(licensing.RequireFeature(licenseManager, licensing.FeatureAdFraud))
-				r.Get("/stats/fraud", h.GetFraudSummary)
-				r.Get("/sources/quality", h.GetSourceQuality)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/stats/fraud", h.GetFraudSummary)
+				r.With(statsCacheHeaders, h.queryTimeout).Get("/sources/quality", h.GetSourceQuality)
 				r.Get("/campaigns", h.ListCampaigns)
 				r.Post("/campaigns", h.CreateCampaign)
+				r.Post("/campaigns/import", h.ImportCampaigns)
+				r.Get("/campaigns/overlaps", h.GetCampaignOverlaps)
 				r.Get("/campaigns/{id}/report", h.GetCampaignReport)
 				r.Delete("/campaigns/{id}", h.DeleteCampaign)
 			})
@@ -259,6 +397,9 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 				r.Post("/users", h.CreateUser)
 				r.Put("/users/{id}", h.UpdateUser)
 				r.Delete("/users/{id}", h.DeleteUser)
+				r.Post("/users/invite", h.InviteUser)
+				r.Get("/users/invites", h.ListInvites)
+				r.Delete("/users/invites/{id}", h.RevokeInvite)
 			})
 
 			// Admin only - Privacy / GDPR
@@ -271,6 +412,43 @@ func NewRouter(db *database.DB, enricher *enrichment.Enricher, licenseManager *l
 				r.Delete("/privacy/erasure/{visitorHash}", h.EraseVisitorData)
 			})
 
+			// Admin only - Alert rules
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Get("/alerts", h.ListAlertRules)
+				r.Post("/alerts", h.CreateAlertRule)
+				r.Get("/alerts/history", h.GetAlertHistory)
+				r.Put("/alerts/{id}", h.UpdateAlertRule)
+				r.Delete("/alerts/{id}", h.DeleteAlertRule)
+			})
+
+			// Admin only - Event inspector
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Get("/events", h.ListEvents)
+			})
+
+			// Admin only - Bot score maintenance
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Post("/bots/rescore", h.StartBotRescore)
+				r.Get("/bots/rescore", h.GetBotRescoreStatus)
+			})
+
+			// Admin only - Error hash maintenance
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Post("/errors/rehash", h.RehashErrors)
+			})
+
+			// Admin only - Public share links
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.RequireAdmin)
+				r.Get("/shares", h.ListShares)
+				r.Post("/shares", h.CreateShare)
+				r.Delete("/shares/{id}", h.DeleteShare)
+			})
+
 			// Admin only - Data Explorer
 			r.Group(func(r chi.Router) {
 				r.Use(authMiddleware.RequireAdmin)
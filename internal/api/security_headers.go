@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Settings keys for the security headers sent with every response. They are
+// loaded when the router is built and reloaded when settings are saved.
+const (
+	securityHeadersKey = "security_headers_enabled"
+	hstsMaxAgeKey      = "hsts_max_age_seconds"
+	referrerPolicyKey  = "referrer_policy"
+	dashboardCSPKey    = "content_security_policy"
+)
+
+// securityHeaderKeys are the settings that reload the security headers
+var securityHeaderKeys = []string{securityHeadersKey, hstsMaxAgeKey, referrerPolicyKey, dashboardCSPKey}
+
+// Security header defaults
+const (
+	defaultHSTSMaxAge     = 31536000 // one year
+	defaultReferrerPolicy = "strict-origin-when-cross-origin"
+
+	// defaultDashboardCSP allows the dashboard's own assets, inline styles
+	// (set by the UI components) and the visitor map's tiles
+	defaultDashboardCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data: blob: https://*.basemaps.cartocdn.com; font-src 'self' data:; " +
+		"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'self'"
+
+	// cspOff as content_security_policy sends no CSP
+	cspOff = "off"
+)
+
+// validReferrerPolicies are the Referrer-Policy values accepted in settings
+var validReferrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+// securityHeaderConfig is the resolved security header settings
+type securityHeaderConfig struct {
+	enabled        bool
+	hstsMaxAge     int
+	referrerPolicy string
+	csp            string // "" sends none
+}
+
+// validateCSP rejects policies that can't be sent as a single header value
+func validateCSP(csp string) error {
+	for _, c := range csp {
+		if c < 0x20 || c == 0x7f {
+			return errors.New("content_security_policy cannot contain line breaks or control characters")
+		}
+	}
+	return nil
+}
+
+// loadSecurityHeaders resolves the security header settings, falling back
+// to the defaults for invalid values
+func (h *Handlers) loadSecurityHeaders() {
+	svc := newSettingsService(h)
+	cfg := &securityHeaderConfig{
+		enabled:        svc.GetBool(securityHeadersKey, true),
+		hstsMaxAge:     svc.GetInt(hstsMaxAgeKey, defaultHSTSMaxAge),
+		referrerPolicy: svc.GetWithDefault(referrerPolicyKey, defaultReferrerPolicy),
+		csp:            strings.TrimSpace(svc.GetWithDefault(dashboardCSPKey, defaultDashboardCSP)),
+	}
+	if cfg.hstsMaxAge < 0 {
+		cfg.hstsMaxAge = defaultHSTSMaxAge
+	}
+	if !validReferrerPolicies[cfg.referrerPolicy] {
+		cfg.referrerPolicy = defaultReferrerPolicy
+	}
+	if cfg.csp == cspOff || validateCSP(cfg.csp) != nil {
+		cfg.csp = ""
+	}
+	h.securityConfig.Store(cfg)
+}
+
+// isTrackingRequest reports whether a path is served to tracked sites
+// rather than to the dashboard: tracker, consent and tag manager scripts,
+// their public endpoints and ingest
+func (h *Handlers) isTrackingRequest(path string) bool {
+	if path == h.ingestPath || path == defaultIngestPath {
+		return true
+	}
+	for _, script := range []string{h.scriptPath, defaultTrackerScriptPath} {
+		base := strings.TrimSuffix(script, ".js")
+		if path == script || (strings.HasPrefix(path, base+".") && strings.HasSuffix(path, ".js")) {
+			return true
+		}
+	}
+	for _, prefix := range []string{"/s/", "/tm/", "/consent/", "/c.js"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// securityHeaders sets the configured security headers. Tracking endpoints
+// are embedded in other sites, so they only get nosniff and HSTS; the
+// referrer policy and CSP apply to the dashboard and its API.
+func (h *Handlers) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.securityConfig.Load()
+		if cfg == nil || !cfg.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		// Only over https, directly or via a TLS-terminating proxy
+		if cfg.hstsMaxAge > 0 && isHTTPSRequest(r) {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(cfg.hstsMaxAge))
+		}
+		if !h.isTrackingRequest(r.URL.Path) {
+			header.Set("Referrer-Policy", cfg.referrerPolicy)
+			if cfg.csp != "" {
+				header.Set("Content-Security-Policy", cfg.csp)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isHTTPSRequest reports whether the client connected over https
+func isHTTPSRequest(r *http.Request) bool {
+	proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+	return r.TLS != nil || strings.EqualFold(proto, "https")
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// historicalStatsMaxAge is how long a browser may reuse a report whose range
+// has ended. Past data can still change (bot rescoring, erasure requests,
+// retention), so this is a day rather than forever.
+const historicalStatsMaxAge = 24 * time.Hour
+
+// statsCacheHeaders adds an ETag derived from the response body to report
+// responses and answers matching If-None-Match requests with 304. Reports
+// whose range ended more than a day ago, so they can't include today in any
+// timezone, may be cached for historicalStatsMaxAge; others must be
+// revalidated. Handlers that mix in live data set their own Cache-Control,
+// which is kept. Responses are private because they require a session.
+func statsCacheHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+
+		if bw.status != http.StatusOK {
+			w.WriteHeader(bw.status)
+			w.Write(bw.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(bw.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if w.Header().Get("Cache-Control") == "" {
+			_, endMs := getDateRangeParams(r, 7)
+			if time.UnixMilli(endMs).Before(time.Now().Add(-24 * time.Hour)) {
+				w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(historicalStatsMaxAge.Seconds())))
+			} else {
+				w.Header().Set("Cache-Control", "private, no-cache")
+			}
+		}
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(bw.body.Bytes())
+	})
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds a response so headers can be derived from the body
+// before anything is sent
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(b)
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// throughputSeconds is how far back ingest throughput is kept, one slot per
+// second; the longest reported window
+const throughputSeconds = 300
+
+// Types counted besides events, which count under their event_type
+const (
+	throughputPerformance = "performance"
+	throughputErrors      = "errors"
+)
+
+// throughputKey is what a count is kept by
+type throughputKey struct {
+	domain string
+	typ    string
+}
+
+// throughputSlot is one second's stored events
+type throughputSlot struct {
+	second int64 // Unix second the counts are for
+	counts map[throughputKey]int64
+}
+
+// throughputCounter counts stored events per second in a ring of
+// throughputSeconds slots, reused as time moves on
+type throughputCounter struct {
+	mu      sync.Mutex
+	started time.Time // windows reaching further back are shortened
+	slots   [throughputSeconds]throughputSlot
+}
+
+// record counts a stored ingest batch
+func (c *throughputCounter) record(events []*database.Event, perfs []*database.Performance, errs []*database.Error) {
+	if len(events)+len(perfs)+len(errs) == 0 {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := &c.slots[now.Unix()%throughputSeconds]
+	if slot.second != now.Unix() || slot.counts == nil {
+		slot.second, slot.counts = now.Unix(), make(map[throughputKey]int64)
+	}
+	for _, e := range events {
+		slot.counts[throughputKey{e.Domain, e.EventType}]++
+	}
+	for _, p := range perfs {
+		slot.counts[throughputKey{p.Domain, throughputPerformance}]++
+	}
+	for _, e := range errs {
+		slot.counts[throughputKey{e.Domain, throughputErrors}]++
+	}
+}
+
+// throughputWindow is the event rate over one window
+type throughputWindow struct {
+	Seconds   int                `json:"seconds"`
+	Covered   int                `json:"covered_seconds"` // shorter while the server has just started
+	Events    int64              `json:"events"`
+	PerSecond float64            `json:"per_second"`
+	ByType    map[string]float64 `json:"by_type"` // events per second per type
+}
+
+// snapshot returns the rate over each window ending now, for a domain or,
+// with an empty domain, all domains, plus the last minute's per-second
+// totals, oldest first
+func (c *throughputCounter) snapshot(domain string, now time.Time, windows ...int) ([]throughputWindow, []int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uptime := throughputSeconds
+	if !c.started.IsZero() {
+		uptime = int(now.Sub(c.started)/time.Second) + 1
+	}
+
+	// Counts for each of the last throughputSeconds seconds, newest first
+	type second struct {
+		total  int64
+		byType map[string]int64
+	}
+	seconds := make([]second, throughputSeconds)
+	for age := range seconds {
+		t := now.Unix() - int64(age)
+		slot := &c.slots[t%throughputSeconds]
+		if slot.second != t {
+			continue
+		}
+		seconds[age].byType = make(map[string]int64)
+		for key, n := range slot.counts {
+			if domain == "" || key.domain == domain {
+				seconds[age].total += n
+				seconds[age].byType[key.typ] += n
+			}
+		}
+	}
+
+	result := make([]throughputWindow, 0, len(windows))
+	for _, length := range windows {
+		w := throughputWindow{Seconds: length, Covered: min(length, uptime), ByType: make(map[string]float64)}
+		counts := make(map[string]int64)
+		for _, s := range seconds[:length] {
+			w.Events += s.total
+			for typ, n := range s.byType {
+				counts[typ] += n
+			}
+		}
+		w.PerSecond = perSecond(w.Events, w.Covered)
+		for typ, n := range counts {
+			w.ByType[typ] = perSecond(n, w.Covered)
+		}
+		result = append(result, w)
+	}
+
+	series := make([]int64, 60)
+	for age := range series {
+		series[len(series)-1-age] = seconds[age].total
+	}
+	return result, series
+}
+
+func perSecond(n int64, seconds int) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(seconds)*100) / 100
+}
+
+// GetStatsThroughput returns the events stored per second over the last
+// minute and five minutes, by type (pageview, custom, ..., performance and
+// errors), for a domain or all of them. Counts are kept in memory by this
+// server as ingest stores events, so they reset on restart and, behind a
+// load balancer, only cover this instance.
+func (h *Handlers) GetStatsThroughput(w http.ResponseWriter, r *http.Request) {
+	domain := r.URL.Query().Get("domain")
+	now := time.Now()
+	windows, series := h.throughput.snapshot(domain, now, 60, throughputSeconds)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domain":    domain,
+		"timestamp": now.UnixMilli(),
+		"last_1m":   windows[0],
+		"last_5m":   windows[1],
+		"series":    series, // events in each of the last 60 seconds, oldest first
+	})
+}
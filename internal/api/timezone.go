@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Embedded zone database so timezones resolve on minimal hosts/containers
+	_ "time/tzdata"
+)
+
+// defaultTimezoneKey is the settings key for the instance-wide report
+// timezone, used when no single domain with its own timezone is selected
+const defaultTimezoneKey = "default_timezone"
+
+// validTimezone reports whether tz is an IANA zone name such as Europe/Lisbon
+func validTimezone(tz string) bool {
+	if tz == "" || strings.EqualFold(tz, "local") {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+// reportLocation resolves the timezone for daily report buckets: the
+// domain's own timezone when one domain is selected and has it set,
+// otherwise the instance default, otherwise UTC
+func (h *Handlers) reportLocation(domain string) *time.Location {
+	var tz string
+	if domain != "" {
+		h.db.Conn().QueryRow("SELECT COALESCE(timezone, '') FROM domains WHERE domain = ?", domain).Scan(&tz)
+	}
+	if tz == "" {
+		tz = newSettingsService(h).GetWithDefault(defaultTimezoneKey, "UTC")
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// dayModifier returns the SQLite date() modifier that shifts UTC timestamps
+// into the report timezone, e.g. "+3600 seconds". The offset in effect at
+// atMs is used for the whole range, so days across a DST change can be off
+// by an hour at their edges.
+func (h *Handlers) dayModifier(domain string, atMs int64) string {
+	_, offset := time.UnixMilli(atMs).In(h.reportLocation(domain)).Zone()
+	return fmt.Sprintf("%+d seconds", offset)
+}
@@ -12,15 +12,20 @@
   const CONFIG = window.__ETIQUETTA_CONFIG__ || {};
 
   function getScript() {
+    // currentScript covers custom and versioned script paths
+    const current = document.currentScript;
+    if (current && current.src) {
+      return { baseUrl: new URL(current.src).origin, siteId: current.getAttribute('data-site'), spa: current.getAttribute('data-spa') };
+    }
     const scripts = document.querySelectorAll('script[src*="s.js"]');
     for (let i = 0; i < scripts.length; i++) {
       const src = scripts[i].src;
       if (src && src.includes('s.js')) {
         const url = new URL(src);
-        return { baseUrl: url.origin, siteId: scripts[i].getAttribute('data-site') };
+        return { baseUrl: url.origin, siteId: scripts[i].getAttribute('data-site'), spa: scripts[i].getAttribute('data-spa') };
       }
     }
-    return { baseUrl: location.origin, siteId: null };
+    return { baseUrl: location.origin, siteId: null, spa: null };
   }
 
   const SCRIPT = getScript();
@@ -28,9 +33,21 @@
   const INGEST_URL = BASE_URL + (CONFIG.endpoint || "/i");
   const SITE_ID = CONFIG.siteId || SCRIPT.siteId;
   const DOMAIN = location.hostname;
+  // Do Not Track / Global Privacy Control. Such browsers send nothing unless
+  // the site sets respectDNT: false; the server also drops flagged events
+  // while respect_dnt is on, in case a tracker doesn't check
+  const DNT = navigator.doNotTrack === "1" || window.doNotTrack === "1" || navigator.globalPrivacyControl === true;
   const DEBUG = CONFIG.debug || false;
   const TRACK_PERFORMANCE = CONFIG.trackPerformance !== false;
+  // TTI, long tasks and resource totals along with the vitals
+  const EXTENDED_PERFORMANCE = CONFIG.extendedPerformance === true;
   const TRACK_ERRORS = CONFIG.trackErrors !== false;
+  const TRACK_DOWNLOADS = CONFIG.trackDownloads !== false;
+  const DOWNLOAD_EXTENSIONS = new Set(CONFIG.downloadExtensions || []);
+  // SPA route changes (data-spa on the script tag): "history" (default)
+  // tracks pushState/replaceState/popstate, "hash" also hash changes, and
+  // "manual" none, for apps that call etiquetta.pageview() on each route
+  const SPA_MODE = ["history", "hash", "manual"].includes(SCRIPT.spa) ? SCRIPT.spa : "history";
 
   // Rate limiting
   let eventCount = 0;
@@ -168,7 +185,8 @@
     if (navigator.sendBeacon) {
       navigator.sendBeacon(INGEST_URL, new Blob([payload], { type: "text/plain" }));
     } else {
-      fetch(INGEST_URL, { method: "POST", body: payload, keepalive: true }).catch(() => {});
+      // Send cookies like sendBeacon does, so the server sees the opt-out cookie
+      fetch(INGEST_URL, { method: "POST", body: payload, keepalive: true, credentials: "include" }).catch(() => {});
     }
     log("Flushed", batch.length, "events");
   }
@@ -182,6 +200,14 @@
       visitor_hash: VISITOR_HASH,
       ...data
     };
+    if (DNT) {
+      event.dnt = 1;
+    }
+    // Consent state for domains that gate ingestion on it
+    const consent = window.__ETIQUETTA_CONSENT__;
+    if (consent && typeof consent.analytics === "boolean") {
+      event.consent = consent.analytics ? 1 : 0;
+    }
 
     if (withSignals || table === "events") {
       event.bot_signals = getBotSignals();
@@ -214,15 +240,43 @@
   // Pageview tracking
   let lastPage = null;
 
+  // Whether the initial document was served as a 404: an explicit
+  // <meta name="etiquetta:status" content="404">, else the navigation status
+  // where the browser exposes it
+  function initialNotFound() {
+    const meta = document.querySelector('meta[name="etiquetta:status"]');
+    if (meta) return meta.content === "404";
+    try {
+      const nav = performance.getEntriesByType("navigation")[0];
+      return !!nav && nav.responseStatus === 404;
+    } catch (e) {
+      return false;
+    }
+  }
+
+  let firstPage = true;
+
+  // A page's identity for deduplication: in-page anchors and frameworks
+  // re-setting the same URL aren't new pageviews, except in hash mode
+  function pageKey(url) {
+    return SPA_MODE === "hash" ? url : url.split("#")[0];
+  }
+
   function trackPageview(opts = {}) {
     const url = opts.url || location.href;
-    if (lastPage === url && !opts.force) return;
-    lastPage = url;
+    if (lastPage === pageKey(url) && !opts.force) return;
+    lastPage = pageKey(url);
+
+    // Error pages are recorded as not_found instead of pageviews. The first
+    // pageview is the initial load ("pv"); later ones are route changes.
+    const notFound = opts.notFound === true || (firstPage && initialNotFound());
+    const initial = firstPage;
+    firstPage = false;
 
     const u = new URL(url);
     send("events", {
-      event_type: "pageview",
-      event_name: "pv",
+      event_type: notFound ? "not_found" : "pageview",
+      event_name: initial ? "pv" : "route",
       url: url,
       path: u.pathname,
       referrer_url: document.referrer || null,
@@ -235,6 +289,7 @@
 
   // SPA navigation
   function setupSPA() {
+    if (SPA_MODE === "manual") return;
     const push = history.pushState;
     const replace = history.replaceState;
     let navTimer = null;
@@ -247,6 +302,7 @@
     history.pushState = function() { push.apply(this, arguments); onNav(); };
     history.replaceState = function() { replace.apply(this, arguments); onNav(); };
     window.addEventListener("popstate", onNav);
+    if (SPA_MODE === "hash") window.addEventListener("hashchange", onNav);
   }
 
   // Scroll tracking (milestones)
@@ -282,13 +338,30 @@
   }
 
   // Outbound link tracking
+  // File extension of a link target, lowercased ("" if none)
+  function linkExtension(u) {
+    const name = u.pathname.split("/").pop();
+    const dot = name.lastIndexOf(".");
+    return dot > 0 ? name.slice(dot + 1).toLowerCase() : "";
+  }
+
   function setupOutbound() {
     document.addEventListener("click", (e) => {
       const link = e.target.closest("a");
       if (link && link.href) {
         try {
           const u = new URL(link.href);
-          if (u.host !== location.host) {
+          const ext = linkExtension(u);
+          if (TRACK_DOWNLOADS && u.protocol.startsWith("http") && (link.hasAttribute("download") || DOWNLOAD_EXTENSIONS.has(ext))) {
+            // Downloads are reported on their own, whether internal or external
+            send("events", {
+              event_type: "click",
+              event_name: "download",
+              url: location.href,
+              path: location.pathname,
+              props: JSON.stringify({ target: link.href, extension: ext })
+            });
+          } else if (u.host !== location.host) {
             send("events", {
               event_type: "click",
               event_name: "outbound",
@@ -309,6 +382,23 @@
     if (!TRACK_ERRORS) return;
 
     window.addEventListener("error", (e) => {
+      // Resource load failures (img, script, link) fire on the element, not window
+      const el = e.target;
+      if (el && el !== window && el.tagName) {
+        const src = el.src || el.href || "";
+        const fp = hash("resource|" + el.tagName + "|" + src);
+        if (seenErrors.has(fp)) return;
+        seenErrors.add(fp);
+        send("error", {
+          error_type: "resource",
+          error_hash: fp,
+          url: location.href,
+          path: location.pathname,
+          error_message: ("Failed to load " + el.tagName.toLowerCase() + ": " + src).substring(0, 500),
+          script_url: src.substring(0, 500)
+        });
+        return;
+      }
       if (e.filename) {
         const fp = hash(e.message + "|" + e.filename + "|" + e.lineno + "|" + e.colno);
         if (seenErrors.has(fp)) return;
@@ -345,7 +435,7 @@
   }
 
   // Performance tracking (Core Web Vitals)
-  const PERF = { lcp: null, fcp: null, cls: null, inp: null, ttfb: null, pageLoad: null, sent: false };
+  const PERF = { lcp: null, fcp: null, cls: null, inp: null, ttfb: null, pageLoad: null, tti: null, tbt: null, longTasks: null, sent: false };
 
   function setupPerformance() {
     if (!TRACK_PERFORMANCE) return;
@@ -369,6 +459,8 @@
     if (nav) {
       PERF.pageLoad = Math.round(nav.loadEventEnd);
       PERF.ttfb = Math.round(nav.responseStart);
+      // Approximated by when the document became interactive
+      if (EXTENDED_PERFORMANCE) PERF.tti = Math.round(nav.domInteractive);
     }
   }
 
@@ -409,12 +501,30 @@
         PERF.inp = Math.round(maxInp);
       }).observe({ type: "event", buffered: true, durationThreshold: 16 });
     } catch (e) {}
+
+    if (EXTENDED_PERFORMANCE) {
+      // Long tasks block the main thread for over 50ms; their time past
+      // 50ms adds up to the total blocking time
+      try {
+        PERF.longTasks = 0;
+        PERF.tbt = 0;
+        new PerformanceObserver((list) => {
+          for (const e of list.getEntries()) {
+            PERF.longTasks++;
+            PERF.tbt += Math.max(0, Math.round(e.duration - 50));
+          }
+        }).observe({ type: "longtask", buffered: true });
+      } catch (e) {
+        PERF.longTasks = null;
+        PERF.tbt = null;
+      }
+    }
   }
 
   function sendPerf() {
     if (PERF.sent) return;
     PERF.sent = true;
-    send("performance", {
+    const data = {
       url: location.href,
       path: location.pathname,
       lcp: PERF.lcp,
@@ -424,7 +534,16 @@
       ttfb: PERF.ttfb,
       page_load_time: PERF.pageLoad,
       connection_type: navigator.connection?.effectiveType || null
-    });
+    };
+    if (EXTENDED_PERFORMANCE) {
+      const resources = performance.getEntriesByType("resource");
+      data.tti = PERF.tti;
+      data.tbt = PERF.tbt;
+      data.long_tasks = PERF.longTasks;
+      data.resource_count = resources.length;
+      data.resource_bytes = resources.reduce((sum, r) => sum + (r.transferSize || 0), 0);
+    }
+    send("performance", data);
     flush();
   }
 
@@ -498,12 +617,36 @@
     });
   }
 
+  // Exclude this browser's visits (stored per site in localStorage).
+  // Toggle with etiquetta.exclude(true/false) or ?etiquetta_exclude=1/0 on any page.
+  const EXCLUDE_KEY = "etiquetta_exclude";
+
+  function setExcluded(excluded) {
+    try {
+      if (excluded) localStorage.setItem(EXCLUDE_KEY, "1");
+      else localStorage.removeItem(EXCLUDE_KEY);
+    } catch (e) {}
+    log(excluded ? "Visits from this browser are excluded" : "Visit exclusion removed");
+  }
+
+  function isExcluded() {
+    try {
+      const param = new URLSearchParams(location.search).get(EXCLUDE_KEY);
+      if (param === "1" || param === "0") setExcluded(param === "1");
+      return localStorage.getItem(EXCLUDE_KEY) === "1";
+    } catch (e) {
+      return false;
+    }
+  }
+
   // Public API
   window.etiquetta = {
     track: track,
     pageview: trackPageview,
+    notFound: () => trackPageview({ notFound: true, force: true }),
     flush: flush,
-    getVisitorHash: () => VISITOR_HASH
+    getVisitorHash: () => VISITOR_HASH,
+    exclude: setExcluded
   };
 
   // Init
@@ -529,13 +672,17 @@
   }
 
   function init() {
+    if (isExcluded()) {
+      log("Visits from this browser are excluded, not tracking");
+      window.etiquetta = { track: function(){}, pageview: function(){}, flush: function(){}, getVisitorHash: function(){ return ""; }, exclude: setExcluded };
+      return;
+    }
+
     // Respect DNT / GPC if configured
-    if (CONFIG.respectDNT !== false) {
-      if (navigator.doNotTrack === "1" || navigator.globalPrivacyControl === true) {
-        log("DNT/GPC signal detected, not tracking");
-        window.etiquetta = { track: function(){}, pageview: function(){}, flush: function(){}, getVisitorHash: function(){ return ""; } };
-        return;
-      }
+    if (CONFIG.respectDNT !== false && DNT) {
+      log("DNT/GPC signal detected, not tracking");
+      window.etiquetta = { track: function(){}, pageview: function(){}, flush: function(){}, getVisitorHash: function(){ return ""; }, exclude: setExcluded };
+      return;
     }
 
     var consent = window.__ETIQUETTA_CONSENT__;
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -24,9 +27,25 @@ type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+
+	// Set on tokens for anything but a session, which ValidateToken rejects
+	Purpose string `json:"purpose,omitempty"`
+	// Fingerprint of the password hash a reset token was issued against
+	PasswordFingerprint string `json:"pwd,omitempty"`
+
+	// APIKeyID is set when the request authenticated with an API key rather
+	// than a session; never part of a token
+	APIKeyID string `json:"-"`
+
 	jwt.RegisteredClaims
 }
 
+// PurposePasswordReset marks password reset tokens
+const PurposePasswordReset = "password_reset"
+
+// ResetTokenDuration is how long a password reset link stays valid
+const ResetTokenDuration = time.Hour
+
 // User represents a user in the system
 type User struct {
 	ID           string `json:"id"`
@@ -38,20 +57,97 @@ type User struct {
 	UpdatedAt    int64  `json:"updated_at"`
 }
 
+// Token lifetime bounds
+const (
+	DefaultTokenDuration = 7 * 24 * time.Hour
+	MinTokenDuration     = time.Hour
+	MaxTokenDuration     = 90 * 24 * time.Hour
+)
+
+// CookieOptions controls the attributes of the session cookie
+type CookieOptions struct {
+	Secure   bool
+	SameSite string // "lax", "strict" or "none" (case-insensitive, empty = lax)
+	Domain   string // empty = host-only cookie
+}
+
+// Validate checks the cookie options for invalid values and combinations
+func (o CookieOptions) Validate() error {
+	if _, err := parseSameSite(o.SameSite); err != nil {
+		return err
+	}
+	if o.Domain != "" {
+		d := strings.TrimPrefix(o.Domain, ".")
+		if d == "" || strings.ContainsAny(d, "/:@ ") {
+			return fmt.Errorf("invalid cookie domain %q: expected a bare host name such as example.com", o.Domain)
+		}
+	}
+	return nil
+}
+
+// parseSameSite maps a config value to an http.SameSite mode
+func parseSameSite(v string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid cookie SameSite %q: must be lax, strict or none", v)
+	}
+}
+
 // Auth handles authentication operations
 type Auth struct {
 	jwtSecret     []byte
 	tokenDuration time.Duration
 	secureCookie  bool
+	sameSite      http.SameSite
+	cookieDomain  string
 }
 
-// New creates a new Auth instance
-func New(jwtSecret string, secureCookie bool) *Auth {
+// New creates a new Auth instance.
+// sessionHours sets the token lifetime; 0 uses the default and values outside
+// MinTokenDuration..MaxTokenDuration are clamped. Invalid cookie options fall
+// back to SameSite=Lax; call CookieOptions.Validate beforehand to reject them.
+func New(jwtSecret string, sessionHours int, cookie CookieOptions) *Auth {
+	sameSite, err := parseSameSite(cookie.SameSite)
+	if err != nil {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	// Browsers reject SameSite=None cookies that are not Secure
+	secure := cookie.Secure || sameSite == http.SameSiteNoneMode
+
 	return &Auth{
 		jwtSecret:     []byte(jwtSecret),
-		tokenDuration: 7 * 24 * time.Hour, // 7 days
-		secureCookie:  secureCookie,
+		tokenDuration: ClampTokenDuration(sessionHours),
+		secureCookie:  secure,
+		sameSite:      sameSite,
+		cookieDomain:  cookie.Domain,
+	}
+}
+
+// ClampTokenDuration converts a session length in hours to a valid token duration
+func ClampTokenDuration(hours int) time.Duration {
+	if hours <= 0 {
+		return DefaultTokenDuration
 	}
+	d := time.Duration(hours) * time.Hour
+	if d < MinTokenDuration {
+		return MinTokenDuration
+	}
+	if d > MaxTokenDuration {
+		return MaxTokenDuration
+	}
+	return d
+}
+
+// TokenDuration returns the configured token lifetime
+func (a *Auth) TokenDuration() time.Duration {
+	return a.tokenDuration
 }
 
 // HashPassword hashes a password using bcrypt
@@ -69,6 +165,20 @@ func VerifyPassword(password, hash string) bool {
 	return err == nil
 }
 
+// APIKeyPrefix starts every API key, telling them apart from session tokens
+const APIKeyPrefix = "yaat_"
+
+// GenerateAPIKey creates a random API key
+func GenerateAPIKey() string {
+	return APIKeyPrefix + GenerateID() + GenerateID()
+}
+
+// HashAPIKey hashes an API key for storage and lookup
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 // GenerateID generates a random ID
 func GenerateID() string {
 	b := make([]byte, 16)
@@ -94,8 +204,64 @@ func (a *Auth) GenerateToken(user *User) (string, error) {
 	return token.SignedString(a.jwtSecret)
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// ValidateToken validates a session token and returns the claims
 func (a *Auth) ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := a.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// GenerateResetToken creates a password reset token for a user. It's tied
+// to the user's current password hash, so it stops working once the
+// password changes, which makes it single use.
+func (a *Auth) GenerateResetToken(user *User) (string, error) {
+	claims := &Claims{
+		UserID:              user.ID,
+		Email:               user.Email,
+		Purpose:             PurposePasswordReset,
+		PasswordFingerprint: passwordFingerprint(user.PasswordHash),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ResetTokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "etiquetta",
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(a.jwtSecret)
+}
+
+// ValidateResetToken validates a password reset token against the user's
+// current password hash, looked up by user ID from the token
+func (a *Auth) ValidateResetToken(tokenString string, passwordHash func(userID string) (string, error)) (*Claims, error) {
+	claims, err := a.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposePasswordReset {
+		return nil, ErrInvalidToken
+	}
+	hash, err := passwordHash(claims.UserID)
+	if err != nil || passwordFingerprint(hash) != claims.PasswordFingerprint {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// passwordFingerprint identifies a password hash without revealing it
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseToken checks a token's signature and expiry and returns its claims
+func (a *Auth) parseToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, ErrInvalidToken
@@ -124,9 +290,10 @@ func (a *Auth) SetAuthCookie(w http.ResponseWriter, token string) {
 		Name:     "etiquetta_session",
 		Value:    token,
 		Path:     "/",
+		Domain:   a.cookieDomain,
 		HttpOnly: true,
 		Secure:   a.secureCookie,
-		SameSite: http.SameSiteLaxMode,
+		SameSite: a.sameSite,
 		MaxAge:   int(a.tokenDuration.Seconds()),
 	})
 }
@@ -137,9 +304,10 @@ func (a *Auth) ClearAuthCookie(w http.ResponseWriter) {
 		Name:     "etiquetta_session",
 		Value:    "",
 		Path:     "/",
+		Domain:   a.cookieDomain,
 		HttpOnly: true,
 		Secure:   a.secureCookie,
-		SameSite: http.SameSiteLaxMode,
+		SameSite: a.sameSite,
 		MaxAge:   -1,
 	})
 }
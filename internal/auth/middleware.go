@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
 type contextKey string
@@ -12,9 +13,19 @@ const (
 	UserContextKey contextKey = "user"
 )
 
+// AccessFilter reports whether a request's client may use the dashboard,
+// with the reason when it may not
+type AccessFilter func(r *http.Request) (allowed bool, reason string)
+
+// APIKeyLookup returns the claims of the user an API key belongs to, with
+// APIKeyID set to the key's ID
+type APIKeyLookup func(key string) (*Claims, error)
+
 // Middleware creates authentication middleware
 type Middleware struct {
-	auth *Auth
+	auth    *Auth
+	access  AccessFilter
+	apiKeys APIKeyLookup
 }
 
 // NewMiddleware creates a new auth middleware
@@ -22,16 +33,66 @@ func NewMiddleware(auth *Auth) *Middleware {
 	return &Middleware{auth: auth}
 }
 
+// SetAccessFilter makes RequireAuth and RestrictAccess answer 403 to
+// requests f denies, before checking credentials
+func (m *Middleware) SetAccessFilter(f AccessFilter) {
+	m.access = f
+}
+
+// checkAccess applies the access filter, writing a 403 when it denies r
+func (m *Middleware) checkAccess(w http.ResponseWriter, r *http.Request) bool {
+	if m.access == nil {
+		return true
+	}
+	if allowed, reason := m.access(r); !allowed {
+		writeError(w, http.StatusForbidden, reason)
+		return false
+	}
+	return true
+}
+
+// RestrictAccess applies the access filter to routes that don't require
+// authentication, such as login
+func (m *Middleware) RestrictAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.checkAccess(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetAPIKeyLookup enables API keys: bearer tokens starting with
+// APIKeyPrefix are resolved with lookup instead of validated as sessions
+func (m *Middleware) SetAPIKeyLookup(lookup APIKeyLookup) {
+	m.apiKeys = lookup
+}
+
+// validate returns the claims of a session token or API key
+func (m *Middleware) validate(token string) (*Claims, error) {
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		if m.apiKeys == nil {
+			return nil, ErrInvalidToken
+		}
+		return m.apiKeys(token)
+	}
+	return m.auth.ValidateToken(token)
+}
+
 // RequireAuth ensures the request has a valid authentication token
 func (m *Middleware) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.checkAccess(w, r) {
+			return
+		}
+
 		token := GetTokenFromRequest(r)
 		if token == "" {
 			writeError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
 
-		claims, err := m.auth.ValidateToken(token)
+		claims, err := m.validate(token)
 		if err != nil {
 			writeError(w, http.StatusUnauthorized, "invalid or expired token")
 			return
@@ -66,7 +127,7 @@ func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := GetTokenFromRequest(r)
 		if token != "" {
-			claims, err := m.auth.ValidateToken(token)
+			claims, err := m.validate(token)
 			if err == nil {
 				ctx := context.WithValue(r.Context(), UserContextKey, claims)
 				r = r.WithContext(ctx)
@@ -179,6 +179,8 @@ func (b *BatchAnalyzer) analyzePerfectTiming(since time.Time) int {
 
 // MaterializeSessions creates/updates the visitor_sessions table
 func (b *BatchAnalyzer) MaterializeSessions(since time.Time) error {
+	// The unary + on e2/e3.domain keeps SQLite from serving the entry/exit
+	// lookups from the domain index, which scans every event of the domain
 	query := `
 		INSERT OR REPLACE INTO visitor_sessions (
 			id, session_id, visitor_hash, domain,
@@ -195,8 +197,8 @@ func (b *BatchAnalyzer) MaterializeSessions(since time.Time) error {
 			MAX(timestamp) as end_time,
 			MAX(timestamp) - MIN(timestamp) as duration,
 			SUM(CASE WHEN event_type = 'pageview' THEN 1 ELSE 0 END) as pageviews,
-			(SELECT url FROM events e2 WHERE e2.session_id = e.session_id AND e2.domain = e.domain ORDER BY timestamp ASC LIMIT 1) as entry_url,
-			(SELECT url FROM events e3 WHERE e3.session_id = e.session_id AND e3.domain = e.domain ORDER BY timestamp DESC LIMIT 1) as exit_url,
+			(SELECT url FROM events e2 WHERE e2.session_id = e.session_id AND +e2.domain = e.domain ORDER BY timestamp ASC LIMIT 1) as entry_url,
+			(SELECT url FROM events e3 WHERE e3.session_id = e.session_id AND +e3.domain = e.domain ORDER BY timestamp DESC LIMIT 1) as exit_url,
 			CASE WHEN SUM(CASE WHEN event_type = 'pageview' THEN 1 ELSE 0 END) = 1 THEN 1 ELSE 0 END as is_bounce,
 			MAX(bot_score) as bot_score,
 			MAX(bot_category) as bot_category
@@ -1,10 +1,18 @@
 package bot
 
 import (
+	_ "embed"
 	"net"
+	"strings"
 	"sync"
 )
 
+// ipv6Ranges lists cloud providers' IPv6 ranges, one CIDR per line with #
+// comments, kept in a file so it's easy to update
+//
+//go:embed datacenter_ipv6.txt
+var ipv6Ranges string
+
 // DatacenterDetector detects if an IP belongs to a known cloud provider
 type DatacenterDetector struct {
 	mu     sync.RWMutex
@@ -295,6 +303,8 @@ func (d *DatacenterDetector) loadCIDRs() {
 		"213.133.96.0/19",
 		"213.239.192.0/18",
 	}
+	// IPv6 ranges come from the embedded list
+	ranges = append(ranges, parseRangeList(ipv6Ranges)...)
 
 	d.cidrs = make([]*net.IPNet, 0, len(ranges))
 	for _, cidr := range ranges {
@@ -306,7 +316,23 @@ func (d *DatacenterDetector) loadCIDRs() {
 	d.loaded = true
 }
 
-// IsDatacenterIP checks if the given IP belongs to a known datacenter
+// parseRangeList reads a list of CIDRs, one per line; blank lines and #
+// comments are skipped
+func parseRangeList(list string) []string {
+	var ranges []string
+	for _, line := range strings.Split(list, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			ranges = append(ranges, line)
+		}
+	}
+	return ranges
+}
+
+// IsDatacenterIP checks if the given IP, IPv4 or IPv6, belongs to a known
+// datacenter
 func (d *DatacenterDetector) IsDatacenterIP(ipStr string) bool {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsDatacenterIPv6(t *testing.T) {
+	d := NewDatacenterDetector()
+
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		// Cloud and hosting providers
+		{"aws us-east-1", "2600:1f18:4c12:9a00:3d1e:8b2f:1c4a:7e01", true},
+		{"aws eu-central-1", "2a05:d014:d8a:5400::12", true},
+		{"aws ap-southeast-1", "2406:da18:77c:6100::a", true},
+		{"google cloud", "2600:1900:4000:b1f2::1", true},
+		{"azure", "2603:1030:408:6::33", true},
+		{"digitalocean nyc", "2604:a880:800:10::1a7:d001", true},
+		{"digitalocean ams", "2a03:b0c0:3:d0::ec3:6001", true},
+		{"hetzner dedicated", "2a01:4f8:c17:b8f::2", true},
+		{"hetzner cloud", "2a01:4ff:f0:1e3a::1", true},
+		{"ovh", "2001:41d0:8:e8ad::1", true},
+		{"linode", "2600:3c03::f03c:91ff:fe24:b5a1", true},
+		{"vultr", "2001:19f0:5:6c2b:5400:4ff:fe3a:9d1", true},
+
+		// Residential and mobile networks, some next to cloud ranges
+		{"comcast", "2601:646:8a00:1f30:c5d1:24e2:9b1a:6f0e", false},
+		{"deutsche telekom", "2003:e6:2f1d:a600:8c4b:1ff:fe2a:31c7", false},
+		{"orange france", "2a01:cb08:8e4:a500:d1c8:5b3f:9f0e:27a4", false},
+		{"bt", "2a00:23c5:e2a1:6b01:4d9:c6ff:fe8a:1b2c", false},
+		{"t-mobile us", "2607:fb90:a3e1:2c4f:1d2e:3f4a:5b6c:7d8e", false},
+
+		// IPv4, including IPv4-mapped IPv6
+		{"aws ipv4", "52.94.76.10", true},
+		{"aws ipv4-mapped", "::ffff:52.94.76.10", true},
+		{"residential ipv4", "81.2.69.160", false},
+		{"invalid", "not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.IsDatacenterIP(tt.ip); got != tt.want {
+				t.Errorf("IsDatacenterIP(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPv6RangeListParses(t *testing.T) {
+	ranges := parseRangeList(ipv6Ranges)
+	if len(ranges) == 0 {
+		t.Fatal("no ranges in the embedded list")
+	}
+	for _, r := range ranges {
+		ip, _, err := net.ParseCIDR(r)
+		if err != nil {
+			t.Errorf("invalid range %q: %v", r, err)
+		} else if ip.To4() != nil {
+			t.Errorf("IPv4 range %q in the IPv6 list", r)
+		}
+	}
+}
+
+func TestParseRangeList(t *testing.T) {
+	list := "# header\n\n2600:1f00::/24  # AWS\n  2001:41d0::/32\n#2001:db8::/32\n"
+	got := parseRangeList(list)
+	want := []string{"2600:1f00::/24", "2001:41d0::/32"}
+	if len(got) != len(want) {
+		t.Fatalf("parseRangeList = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseRangeList[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
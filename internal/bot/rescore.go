@@ -0,0 +1,205 @@
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// signalWeights maps stored signal names to their current weight, so stored
+// events can be re-scored after the weights change. Signals not listed here
+// (e.g. behavioral signals added by the batch analyzer) keep their stored weight.
+var signalWeights = map[string]int{
+	"empty_ua":                WeightEmptyUA,
+	"automation_ua":           WeightAutomationUA,
+	"headless_browser":        WeightHeadlessBrowser,
+	"short_ua":                WeightShortUA,
+	"webdriver":               WeightWebdriver,
+	"phantom":                 WeightHeadlessBrowser,
+	"selenium":                WeightAutomationUA,
+	"headless":                WeightHeadlessBrowser,
+	"screen_anomaly":          WeightScreenAnomaly,
+	"no_plugins":              WeightNoPlugins,
+	"no_languages":            WeightNoLanguages,
+	"missing_accept_language": WeightMissingHeaders,
+}
+
+// Rescore re-derives a score from the signals stored on an event plus the
+// server-side data still available in the row. The datacenter and suspicious
+// path signals are recomputed from scratch, as are client-side signals when
+// the raw client data was stored; known good bots are left as is.
+func Rescore(stored []Signal, client *ClientSignals, isDatacenterIP bool, path string) *ScoringResult {
+	result := &ScoringResult{Category: CategoryHuman, Signals: make([]Signal, 0, len(stored))}
+
+	for _, s := range stored {
+		switch s.Name {
+		case "known_good_bot":
+			return &ScoringResult{Category: CategoryGoodBot, Signals: stored, IsBot: true}
+		case "datacenter_ip", "suspicious_path":
+			continue
+		}
+		if client != nil && clientSignalNames[s.Name] {
+			continue
+		}
+		if w, ok := signalWeights[s.Name]; ok {
+			s.Weight = w
+		}
+		result.Score += s.Weight
+		result.Signals = append(result.Signals, s)
+	}
+
+	for _, sig := range ScoreClientSignals(client) {
+		result.Score += sig.Weight
+		result.Signals = append(result.Signals, sig)
+	}
+	if isDatacenterIP {
+		result.Score += WeightDatacenterIP
+		result.Signals = append(result.Signals, Signal{Name: "datacenter_ip", Weight: WeightDatacenterIP})
+	}
+	if pathSignal := ScoreSuspiciousPath(path); pathSignal != nil {
+		result.Score += pathSignal.Weight
+		result.Signals = append(result.Signals, *pathSignal)
+	}
+
+	if result.Score > 100 {
+		result.Score = 100
+	}
+	result.Category = ScoreToCategory(result.Score)
+	result.IsBot = result.Score > SuspiciousMaxScore
+	return result
+}
+
+// RescoreProgress reports how far a rescore run has got
+type RescoreProgress struct {
+	Processed int64 `json:"processed"`
+	Changed   int64 `json:"changed"`
+}
+
+type rescoreRow struct {
+	rowid      int64
+	score      int
+	category   string
+	signals    string
+	client     string
+	datacenter bool
+	path       string
+}
+
+// RescoreEvents recomputes bot_score, bot_category, bot_signals and is_bot for
+// events since the given time, in batches of batchSize. Each batch is read
+// fully before it is written so the single connection is never held by an
+// open cursor. progress, if set, is called after every batch. Session
+// aggregates are re-materialized for the affected period afterwards.
+func RescoreEvents(db *sql.DB, since time.Time, batchSize int, progress func(RescoreProgress)) (RescoreProgress, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var p RescoreProgress
+	var lastRowID int64
+	for {
+		batch, err := loadRescoreBatch(db, since, lastRowID, batchSize)
+		if err != nil {
+			return p, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		changed, err := applyRescoreBatch(db, batch)
+		if err != nil {
+			return p, err
+		}
+
+		p.Processed += int64(len(batch))
+		p.Changed += changed
+		lastRowID = batch[len(batch)-1].rowid
+		if progress != nil {
+			progress(p)
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+
+	analyzer := NewBatchAnalyzer(db, 0)
+	if err := analyzer.MaterializeSessions(since); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func loadRescoreBatch(db *sql.DB, since time.Time, afterRowID int64, limit int) ([]rescoreRow, error) {
+	rows, err := db.Query(`
+		SELECT rowid, COALESCE(bot_score, 0), COALESCE(bot_category, ''), COALESCE(bot_signals, '[]'),
+			COALESCE(bot_client_signals, ''), COALESCE(datacenter_ip, 0), COALESCE(path, '')
+		FROM events
+		WHERE timestamp >= ? AND rowid > ?
+		ORDER BY rowid
+		LIMIT ?
+	`, since.UnixMilli(), afterRowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []rescoreRow
+	for rows.Next() {
+		var r rescoreRow
+		var datacenter int
+		if err := rows.Scan(&r.rowid, &r.score, &r.category, &r.signals, &r.client, &datacenter, &r.path); err != nil {
+			return nil, err
+		}
+		r.datacenter = datacenter == 1
+		batch = append(batch, r)
+	}
+	return batch, rows.Err()
+}
+
+func applyRescoreBatch(db *sql.DB, batch []rescoreRow) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE events SET bot_score = ?, bot_category = ?, bot_signals = ?, is_bot = ? WHERE rowid = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var changed int64
+	for _, r := range batch {
+		var stored []Signal
+		json.Unmarshal([]byte(r.signals), &stored)
+
+		result := Rescore(stored, ParseClientSignals(r.client), r.datacenter, r.path)
+		if result.Category == CategoryGoodBot {
+			continue
+		}
+		signals := SignalsToJSON(result.Signals)
+		if result.Score == r.score && result.Category == r.category && signals == r.signals {
+			continue
+		}
+
+		isBot := 0
+		if result.IsBot {
+			isBot = 1
+		}
+		if _, err := stmt.Exec(result.Score, result.Category, signals, isBot, r.rowid); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+
+	return changed, tx.Commit()
+}
+
+// ParseSince parses a rescore start date given as YYYY-MM-DD or RFC3339
+func ParseSince(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
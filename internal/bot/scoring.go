@@ -13,6 +13,22 @@ const (
 	CategoryGoodBot    = "good_bot"
 )
 
+// Score thresholds between categories: scores up to HumanMaxScore are human,
+// up to SuspiciousMaxScore suspicious, and anything higher a bad bot
+const (
+	HumanMaxScore      = 20
+	SuspiciousMaxScore = 50
+)
+
+// Thresholds describes the category boundaries for API consumers
+type Thresholds struct {
+	HumanMaxScore      int `json:"human_max_score"`
+	SuspiciousMaxScore int `json:"suspicious_max_score"`
+}
+
+// CategoryThresholds are the boundaries ScoreToCategory applies
+var CategoryThresholds = Thresholds{HumanMaxScore: HumanMaxScore, SuspiciousMaxScore: SuspiciousMaxScore}
+
 // Signal weights for bot scoring
 const (
 	WeightWebdriver       = 30 // navigator.webdriver detected
@@ -110,44 +126,9 @@ func CalculateScore(userAgent string, clientSignals *ClientSignals, isDatacenter
 	}
 
 	// Check client-side signals
-	if clientSignals != nil {
-		if clientSignals.Webdriver {
-			result.Score += WeightWebdriver
-			result.Signals = append(result.Signals, Signal{Name: "webdriver", Weight: WeightWebdriver})
-		}
-
-		if clientSignals.Phantom {
-			result.Score += WeightHeadlessBrowser
-			result.Signals = append(result.Signals, Signal{Name: "phantom", Weight: WeightHeadlessBrowser})
-		}
-
-		if clientSignals.Selenium {
-			result.Score += WeightAutomationUA
-			result.Signals = append(result.Signals, Signal{Name: "selenium", Weight: WeightAutomationUA})
-		}
-
-		if clientSignals.Headless {
-			result.Score += WeightHeadlessBrowser
-			result.Signals = append(result.Signals, Signal{Name: "headless", Weight: WeightHeadlessBrowser})
-		}
-
-		// Screen anomaly detection - only flag when both dimensions are zero (headless/bot)
-		if clientSignals.ScreenWidth == 0 && clientSignals.ScreenHeight == 0 {
-			result.Score += WeightScreenAnomaly
-			result.Signals = append(result.Signals, Signal{Name: "screen_anomaly", Weight: WeightScreenAnomaly})
-		}
-
-		// No plugins (common in headless browsers)
-		if clientSignals.Plugins == 0 {
-			result.Score += WeightNoPlugins
-			result.Signals = append(result.Signals, Signal{Name: "no_plugins", Weight: WeightNoPlugins})
-		}
-
-		// No languages
-		if clientSignals.Languages == 0 {
-			result.Score += WeightNoLanguages
-			result.Signals = append(result.Signals, Signal{Name: "no_languages", Weight: WeightNoLanguages})
-		}
+	for _, sig := range ScoreClientSignals(clientSignals) {
+		result.Score += sig.Weight
+		result.Signals = append(result.Signals, sig)
 	}
 
 	// Check for datacenter IP
@@ -171,17 +152,103 @@ func CalculateScore(userAgent string, clientSignals *ClientSignals, isDatacenter
 
 	// Determine category based on score
 	result.Category = ScoreToCategory(result.Score)
-	result.IsBot = result.Score > 50
+	result.IsBot = result.Score > SuspiciousMaxScore
 
 	return result
 }
 
+// clientSignalNames are the signals derived from ClientSignals
+var clientSignalNames = map[string]bool{
+	"webdriver":      true,
+	"phantom":        true,
+	"selenium":       true,
+	"headless":       true,
+	"screen_anomaly": true,
+	"no_plugins":     true,
+	"no_languages":   true,
+}
+
+// ScoreClientSignals returns the bot signals raised by client-side checks
+func ScoreClientSignals(clientSignals *ClientSignals) []Signal {
+	if clientSignals == nil {
+		return nil
+	}
+
+	var signals []Signal
+	if clientSignals.Webdriver {
+		signals = append(signals, Signal{Name: "webdriver", Weight: WeightWebdriver})
+	}
+
+	if clientSignals.Phantom {
+		signals = append(signals, Signal{Name: "phantom", Weight: WeightHeadlessBrowser})
+	}
+
+	if clientSignals.Selenium {
+		signals = append(signals, Signal{Name: "selenium", Weight: WeightAutomationUA})
+	}
+
+	if clientSignals.Headless {
+		signals = append(signals, Signal{Name: "headless", Weight: WeightHeadlessBrowser})
+	}
+
+	// Screen anomaly detection - only flag when both dimensions are zero (headless/bot)
+	if clientSignals.ScreenWidth == 0 && clientSignals.ScreenHeight == 0 {
+		signals = append(signals, Signal{Name: "screen_anomaly", Weight: WeightScreenAnomaly})
+	}
+
+	// No plugins (common in headless browsers)
+	if clientSignals.Plugins == 0 {
+		signals = append(signals, Signal{Name: "no_plugins", Weight: WeightNoPlugins})
+	}
+
+	// No languages
+	if clientSignals.Languages == 0 {
+		signals = append(signals, Signal{Name: "no_languages", Weight: WeightNoLanguages})
+	}
+
+	return signals
+}
+
+// maxClientSignalCount bounds the numeric client signals before storage so a
+// crafted payload can't inflate the stored JSON
+const maxClientSignalCount = 100000
+
+// ClientSignalsToJSON serializes raw client signals for storage alongside the
+// event, so scores can be recomputed later. Returns "" for nil.
+func ClientSignalsToJSON(clientSignals *ClientSignals) string {
+	if clientSignals == nil {
+		return ""
+	}
+	cs := *clientSignals
+	for _, v := range []*int{&cs.Plugins, &cs.Languages, &cs.ScreenWidth, &cs.ScreenHeight} {
+		*v = max(0, min(*v, maxClientSignalCount))
+	}
+	data, err := json.Marshal(cs)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ParseClientSignals decodes signals stored by ClientSignalsToJSON.
+// Returns nil when none were recorded.
+func ParseClientSignals(data string) *ClientSignals {
+	if data == "" {
+		return nil
+	}
+	var cs ClientSignals
+	if err := json.Unmarshal([]byte(data), &cs); err != nil {
+		return nil
+	}
+	return &cs
+}
+
 // ScoreToCategory converts a score to a category
 func ScoreToCategory(score int) string {
 	switch {
-	case score <= 20:
+	case score <= HumanMaxScore:
 		return CategoryHuman
-	case score <= 50:
+	case score <= SuspiciousMaxScore:
 		return CategorySuspicious
 	default:
 		return CategoryBadBot
@@ -5,22 +5,72 @@ import (
 	"os"
 )
 
+// DefaultDownloadExtensions are the file types the tracker reports as downloads
+var DefaultDownloadExtensions = []string{
+	"pdf", "zip", "gz", "tgz", "7z", "rar", "dmg", "exe", "msi", "pkg", "deb", "rpm", "apk", "iso",
+	"csv", "xls", "xlsx", "doc", "docx", "ppt", "pptx", "epub", "mp3", "mp4", "mov",
+}
+
+// DefaultMaxEventLineBytes is the default longest ingested event line, room
+// for large props and stack traces
+const DefaultMaxEventLineBytes = 256 << 10
+
+// DefaultIngestRateLimitPerMin is the default number of ingest requests one
+// IP may make per minute
+const DefaultIngestRateLimitPerMin = 100
+
 type Config struct {
 	ListenAddr string `json:"listen_addr"`
 	DataDir    string `json:"data_dir"`
 	GeoIPPath  string `json:"geoip_path"`
 
+	// Optional GeoLite2-ASN database, for the network of each event
+	GeoIPASNPath string `json:"geoip_asn_path"`
+
 	// Tracker settings
 	SessionTimeoutMinutes int  `json:"session_timeout_minutes"`
 	TrackPerformance      bool `json:"track_performance"`
 	TrackErrors           bool `json:"track_errors"`
 	RespectDNT            bool `json:"respect_dnt"`
 
+	// Also collect TTI, long tasks and resource totals with the vitals
+	TrackExtendedPerformance bool `json:"track_extended_performance"`
+
+	// Report clicks on links to these file extensions as downloads
+	TrackDownloads     bool     `json:"track_downloads"`
+	DownloadExtensions []string `json:"download_extensions"`
+
+	// Fraction (0-1] of performance/error beacons to keep
+	PerformanceSampleRate float64 `json:"performance_sample_rate"`
+	ErrorSampleRate       float64 `json:"error_sample_rate"`
+
+	// Max stored rows per error per session; repeats only bump a counter (0 = unlimited)
+	ErrorMaxPerSession int `json:"error_max_per_session"`
+
+	// Max bytes of one ingested event line; longer lines are dropped
+	MaxEventLineBytes int `json:"max_event_line_bytes"`
+
+	// Ingest requests allowed per minute per IP, and per site_id (0 = no
+	// per-site limit)
+	IngestRateLimitPerMin     int `json:"ingest_rate_limit_per_min"`
+	IngestSiteRateLimitPerMin int `json:"ingest_site_rate_limit_per_min"`
+
 	// CORS
 	AllowedOrigins []string `json:"allowed_origins"`
 
+	// Redis URL for sharing rate limits between instances (empty = in-memory)
+	RedisURL string `json:"redis_url"`
+
 	// Secret for session HMAC
 	SecretKey string `json:"secret_key"`
+
+	// Dashboard login session lifetime in hours
+	SessionDurationHours int `json:"session_duration_hours"`
+
+	// Session cookie attributes
+	CookieSecure   bool   `json:"cookie_secure"`
+	CookieSameSite string `json:"cookie_samesite"`
+	CookieDomain   string `json:"cookie_domain"`
 }
 
 func Load(path string) *Config {
@@ -28,12 +78,22 @@ func Load(path string) *Config {
 		ListenAddr:            ":3456",
 		DataDir:               "./data",
 		GeoIPPath:             "./data/GeoLite2-City.mmdb",
+		GeoIPASNPath:          "./data/GeoLite2-ASN.mmdb",
 		SessionTimeoutMinutes: 30,
 		TrackPerformance:      true,
 		TrackErrors:           true,
 		RespectDNT:            true,
+		TrackDownloads:        true,
+		DownloadExtensions:    DefaultDownloadExtensions,
+		PerformanceSampleRate: 1,
+		ErrorSampleRate:       1,
+		ErrorMaxPerSession:    5,
+		MaxEventLineBytes:     DefaultMaxEventLineBytes,
+		IngestRateLimitPerMin: DefaultIngestRateLimitPerMin,
 		AllowedOrigins:        []string{"*"},
 		SecretKey:             "change-me-in-production",
+		SessionDurationHours:  168,
+		CookieSameSite:        "lax",
 	}
 
 	if path == "" {
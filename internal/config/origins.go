@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OriginMatcher decides whether a request Origin is allowed by CORS.
+// Patterns are one of:
+//   - "*" to allow any origin
+//   - an exact origin such as https://example.com or http://localhost:3000
+//   - a subdomain wildcard such as https://*.example.com, or *.example.com
+//     for any scheme. The wildcard matches one or more labels, so
+//     *.example.com allows a.example.com and a.b.example.com but not
+//     example.com itself.
+//
+// Ports are part of the origin, so https://*.example.com does not allow
+// https://a.example.com:8443.
+type OriginMatcher struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []originWildcard
+}
+
+type originWildcard struct {
+	scheme string // "" matches any scheme
+	suffix string // e.g. ".example.com"
+}
+
+// ParseAllowedOrigins splits the comma-separated allowed_origins setting into
+// trimmed entries. A "*" entry allows everything, so it replaces the list.
+// An empty value yields no allowed origins.
+func ParseAllowedOrigins(raw string) []string {
+	origins := make([]string, 0)
+	for _, o := range strings.Split(raw, ",") {
+		o = strings.TrimSpace(o)
+		if o == "*" {
+			return []string{"*"}
+		}
+		if o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// NewOriginMatcher compiles allowed-origin patterns. Invalid patterns are
+// reported in the error and left out; the returned matcher still applies
+// the valid ones.
+func NewOriginMatcher(patterns []string) (*OriginMatcher, error) {
+	m := &OriginMatcher{exact: make(map[string]bool)}
+	var invalid []string
+
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p), "/"))
+		switch {
+		case p == "":
+			continue
+		case p == "*":
+			m.any = true
+		case strings.Contains(p, "*"):
+			w, ok := parseOriginWildcard(p)
+			if !ok {
+				invalid = append(invalid, p)
+				continue
+			}
+			m.wildcards = append(m.wildcards, w)
+		default:
+			u, err := url.Parse(p)
+			if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				invalid = append(invalid, p)
+				continue
+			}
+			m.exact[u.Scheme+"://"+u.Host] = true
+		}
+	}
+
+	if len(invalid) > 0 {
+		return m, fmt.Errorf("invalid allowed origin pattern(s): %s (use *, https://example.com or https://*.example.com)", strings.Join(invalid, ", "))
+	}
+	return m, nil
+}
+
+// parseOriginWildcard accepts [scheme://]*.host[:port] with the wildcard as
+// the leftmost label only
+func parseOriginWildcard(p string) (originWildcard, bool) {
+	var w originWildcard
+	if scheme, rest, ok := strings.Cut(p, "://"); ok {
+		if scheme == "" || strings.Contains(scheme, "*") {
+			return w, false
+		}
+		w.scheme, p = scheme, rest
+	}
+	if !strings.HasPrefix(p, "*.") {
+		return w, false
+	}
+	host := p[1:] // ".example.com"
+	if strings.ContainsAny(host, "*/?#@") || len(host) < 2 || strings.HasPrefix(host, "..") {
+		return w, false
+	}
+	w.suffix = host
+	return w, true
+}
+
+// Allowed reports whether origin (scheme://host[:port]) matches a pattern
+func (m *OriginMatcher) Allowed(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || host == "" {
+		return false
+	}
+	for _, w := range m.wildcards {
+		if w.scheme != "" && w.scheme != scheme {
+			continue
+		}
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
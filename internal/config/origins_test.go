@@ -0,0 +1,92 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"", []string{}},
+		{"https://a.com", []string{"https://a.com"}},
+		{"https://a.com,https://b.com", []string{"https://a.com", "https://b.com"}},
+		{" https://a.com ,  https://b.com:8443 ,", []string{"https://a.com", "https://b.com:8443"}},
+		{",, ,", []string{}},
+		{"*", []string{"*"}},
+		{"https://a.com, * ,https://b.com", []string{"*"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseAllowedOrigins(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseAllowedOrigins(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestOriginMatcherAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		allowed []string
+		denied  []string
+	}{
+		{
+			name:    "several origins",
+			raw:     "https://a.com, http://localhost:3000,https://b.org/",
+			allowed: []string{"https://a.com", "http://localhost:3000", "https://b.org", "HTTPS://A.COM"},
+			denied:  []string{"http://a.com", "https://a.com:8443", "http://localhost:3001", "https://c.com", ""},
+		},
+		{
+			name:    "any origin",
+			raw:     "https://a.com, *",
+			allowed: []string{"https://a.com", "https://anything.example", "http://localhost:1234"},
+		},
+		{
+			name:    "subdomain wildcards",
+			raw:     "https://*.example.com, *.test.org",
+			allowed: []string{"https://a.example.com", "https://a.b.example.com", "http://x.test.org", "https://x.test.org"},
+			denied:  []string{"https://example.com", "http://a.example.com", "https://a.example.com:8443", "https://test.org", "https://badexample.com"},
+		},
+		{
+			name:   "empty",
+			raw:    "",
+			denied: []string{"https://a.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewOriginMatcher(ParseAllowedOrigins(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, origin := range tt.allowed {
+				if !m.Allowed(origin) {
+					t.Errorf("%q denied, want allowed", origin)
+				}
+			}
+			for _, origin := range tt.denied {
+				if m.Allowed(origin) {
+					t.Errorf("%q allowed, want denied", origin)
+				}
+			}
+		})
+	}
+}
+
+func TestNewOriginMatcherInvalid(t *testing.T) {
+	m, err := NewOriginMatcher(ParseAllowedOrigins("https://a.com, a.com, https://a.*.com, https://b.com/path"))
+	if err == nil {
+		t.Fatal("invalid patterns accepted")
+	}
+	// The valid patterns still apply
+	if !m.Allowed("https://a.com") {
+		t.Error("valid origin denied alongside invalid ones")
+	}
+	if m.Allowed("https://b.com") {
+		t.Error("origin with a path was accepted")
+	}
+}
@@ -0,0 +1,22 @@
+package database
+
+import "time"
+
+// MaxClockSkew is how far past the server clock a row's timestamp may be
+// before it counts as future-dated. Ingest stamps rows with the server clock,
+// so such rows were written while that clock ran ahead; they fall outside
+// every date range until their time comes.
+const MaxClockSkew = 5 * time.Minute
+
+// countFutureRows counts tracking rows timestamped beyond MaxClockSkew,
+// e.g. written while the server clock was ahead
+func (db *DB) countFutureRows() int64 {
+	cutoff := time.Now().Add(MaxClockSkew).UnixMilli()
+	var total int64
+	for _, table := range domainTables {
+		var n int64
+		db.conn.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE timestamp > ?", cutoff).Scan(&n)
+		total += n
+	}
+	return total
+}
@@ -4,9 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -15,6 +18,12 @@ import (
 type DB struct {
 	conn *sql.DB
 	mu   sync.RWMutex
+
+	// Set when serving read-only after a failed migration
+	migrationFailure *MigrationError
+
+	// Highest migration version applied, as of the last Migrate
+	appliedVersion atomic.Int64
 }
 
 // Event represents a tracking event
@@ -39,6 +48,7 @@ type Event struct {
 	GeoRegion    *string         `json:"geo_region,omitempty"`
 	GeoLatitude  *float64        `json:"geo_latitude,omitempty"`
 	GeoLongitude *float64        `json:"geo_longitude,omitempty"`
+	ASNOrg       *string         `json:"asn_org,omitempty"`
 	BrowserName  *string         `json:"browser_name,omitempty"`
 	OSName       *string         `json:"os_name,omitempty"`
 	DeviceType   *string         `json:"device_type,omitempty"`
@@ -58,6 +68,9 @@ type Event struct {
 	PageDuration *int    `json:"page_duration,omitempty"`
 	DatacenterIP bool    `json:"datacenter_ip"`
 	IPHash       *string `json:"ip_hash,omitempty"`
+
+	// Raw client-side bot checks as JSON, nil when the tracker sent none
+	BotClientSignals *string `json:"bot_client_signals,omitempty"`
 }
 
 // Performance represents web vitals
@@ -75,9 +88,15 @@ type Performance struct {
 	TTFB           *float64  `json:"ttfb,omitempty"`
 	INP            *float64  `json:"inp,omitempty"`
 	PageLoadTime   *float64  `json:"page_load_time,omitempty"`
+	TTI            *float64  `json:"tti,omitempty"`            // time to interactive, ms
+	TBT            *float64  `json:"tbt,omitempty"`            // total blocking time, ms
+	LongTasks      *int64    `json:"long_tasks,omitempty"`     // tasks over 50 ms
+	ResourceCount  *int64    `json:"resource_count,omitempty"` // subresources loaded
+	ResourceBytes  *int64    `json:"resource_bytes,omitempty"` // their transfer size
 	DeviceType     *string   `json:"device_type,omitempty"`
 	ConnectionType *string   `json:"connection_type,omitempty"`
 	GeoCountry     *string   `json:"geo_country,omitempty"`
+	SampleRate     float64   `json:"sample_rate"`
 }
 
 // Error represents a JS error
@@ -98,8 +117,18 @@ type Error struct {
 	ColumnNumber *int      `json:"column_number,omitempty"`
 	BrowserName  *string   `json:"browser_name,omitempty"`
 	GeoCountry   *string   `json:"geo_country,omitempty"`
+	SampleRate   float64   `json:"sample_rate"`
+	Occurrences  int       `json:"occurrences"`
 }
 
+// SampleWeightSQL is a performance or error row's weight in estimated
+// totals: the inverse of the sample rate it was kept at, so summing it
+// scales sampled counts back up. Rows stored before sampling count once.
+const SampleWeightSQL = "(1.0 / COALESCE(NULLIF(sample_rate, 0), 1))"
+
+// SampledSQL is true for a group of rows when any of them was sampled
+const SampledSQL = "MIN(COALESCE(NULLIF(sample_rate, 0), 1)) < 1"
+
 func New(path string) (*DB, error) {
 	// Ensure data directory exists
 	dir := filepath.Dir(path)
@@ -179,8 +208,8 @@ func (db *DB) InsertEvent(e *Event) error {
 			browser_name, os_name, device_type, is_bot, props,
 			bot_score, bot_signals, bot_category,
 			has_scroll, has_mouse_move, has_click, has_touch,
-			click_x, click_y, page_duration, datacenter_ip, ip_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			click_x, click_y, page_duration, datacenter_ip, ip_hash, bot_client_signals, asn_org
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		e.ID, e.Timestamp.UnixMilli(), e.EventType, e.EventName, e.SessionID, e.VisitorHash,
 		e.Domain, e.URL, e.Path, e.PageTitle, e.ReferrerURL, e.ReferrerType,
@@ -189,7 +218,7 @@ func (db *DB) InsertEvent(e *Event) error {
 		e.BrowserName, e.OSName, e.DeviceType, e.IsBot, props,
 		e.BotScore, botSignals, botCategory,
 		e.HasScroll, e.HasMouseMove, e.HasClick, e.HasTouch,
-		e.ClickX, e.ClickY, e.PageDuration, e.DatacenterIP, e.IPHash,
+		e.ClickX, e.ClickY, e.PageDuration, e.DatacenterIP, e.IPHash, e.BotClientSignals, e.ASNOrg,
 	)
 	return err
 }
@@ -203,12 +232,14 @@ func (db *DB) InsertPerformance(p *Performance) error {
 		INSERT INTO performance (
 			id, timestamp, session_id, visitor_hash, domain, url, path,
 			lcp, cls, fcp, ttfb, inp, page_load_time,
-			device_type, connection_type, geo_country
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			device_type, connection_type, geo_country, sample_rate,
+			tti, tbt, long_tasks, resource_count, resource_bytes
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		p.ID, p.Timestamp.UnixMilli(), p.SessionID, p.VisitorHash, p.Domain, p.URL, p.Path,
 		p.LCP, p.CLS, p.FCP, p.TTFB, p.INP, p.PageLoadTime,
-		p.DeviceType, p.ConnectionType, p.GeoCountry,
+		p.DeviceType, p.ConnectionType, p.GeoCountry, sampleRateOrOne(p.SampleRate),
+		p.TTI, p.TBT, p.LongTasks, p.ResourceCount, p.ResourceBytes,
 	)
 	return err
 }
@@ -222,27 +253,57 @@ func (db *DB) InsertError(e *Error) error {
 		INSERT INTO errors (
 			id, timestamp, session_id, visitor_hash, domain, url, path,
 			error_type, error_message, error_stack, error_hash,
-			script_url, line_number, column_number, browser_name, geo_country
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			script_url, line_number, column_number, browser_name, geo_country, sample_rate,
+			occurrences
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		e.ID, e.Timestamp.UnixMilli(), e.SessionID, e.VisitorHash, e.Domain, e.URL, e.Path,
 		e.ErrorType, e.ErrorMessage, e.ErrorStack, e.ErrorHash,
-		e.ScriptURL, e.LineNumber, e.ColumnNumber, e.BrowserName, e.GeoCountry,
+		e.ScriptURL, e.LineNumber, e.ColumnNumber, e.BrowserName, e.GeoCountry, sampleRateOrOne(e.SampleRate),
+		max(e.Occurrences, 1),
 	)
 	return err
 }
 
 // InsertBatch inserts multiple events in a transaction
 func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error) error {
+	_, err := db.InsertBatchThrottled(events, perfs, errs, 0)
+	return err
+}
+
+// InsertBatchThrottled inserts a batch like InsertBatch, first capping the
+// rows of each (session, error_hash) pair at maxErrorsPerSession (see
+// throttleSessionErrors). The cap is checked and the occurrence counts bumped
+// in the same transaction as the insert, so concurrent batches can't both pass
+// it and a failed insert leaves no counts behind. If throttling itself fails
+// the errors are stored as they are. Returns the errors stored.
+func (db *DB) InsertBatchThrottled(events []*Event, perfs []*Performance, errs []*Error, maxErrorsPerSession int) ([]*Error, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	tx, err := db.conn.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
+	if maxErrorsPerSession > 0 && len(errs) > 0 {
+		if _, err := tx.Exec("SAVEPOINT throttle_errors"); err != nil {
+			return nil, err
+		}
+		if throttled, err := throttleSessionErrors(tx, errs, maxErrorsPerSession); err != nil {
+			log.Printf("[ingest] Throttling repeated errors failed, storing %d errors unthrottled: %v", len(errs), err)
+			if _, err := tx.Exec("ROLLBACK TO throttle_errors"); err != nil {
+				return nil, err
+			}
+		} else {
+			errs = throttled
+		}
+		if _, err := tx.Exec("RELEASE throttle_errors"); err != nil {
+			return nil, err
+		}
+	}
+
 	// Prepare statements
 	eventStmt, err := tx.Prepare(`
 		INSERT INTO events (
@@ -253,11 +314,11 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 			browser_name, os_name, device_type, is_bot, props,
 			bot_score, bot_signals, bot_category,
 			has_scroll, has_mouse_move, has_click, has_touch,
-			click_x, click_y, page_duration, datacenter_ip, ip_hash
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			click_x, click_y, page_duration, datacenter_ip, ip_hash, bot_client_signals, asn_org
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer eventStmt.Close()
 
@@ -265,11 +326,12 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 		INSERT INTO performance (
 			id, timestamp, session_id, visitor_hash, domain, url, path,
 			lcp, cls, fcp, ttfb, inp, page_load_time,
-			device_type, connection_type, geo_country
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			device_type, connection_type, geo_country, sample_rate,
+			tti, tbt, long_tasks, resource_count, resource_bytes
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer perfStmt.Close()
 
@@ -277,11 +339,12 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 		INSERT INTO errors (
 			id, timestamp, session_id, visitor_hash, domain, url, path,
 			error_type, error_message, error_stack, error_hash,
-			script_url, line_number, column_number, browser_name, geo_country
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			script_url, line_number, column_number, browser_name, geo_country, sample_rate,
+			occurrences
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer errStmt.Close()
 
@@ -307,10 +370,10 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 			e.BrowserName, e.OSName, e.DeviceType, e.IsBot, props,
 			e.BotScore, botSignals, botCategory,
 			e.HasScroll, e.HasMouseMove, e.HasClick, e.HasTouch,
-			e.ClickX, e.ClickY, e.PageDuration, e.DatacenterIP, e.IPHash,
+			e.ClickX, e.ClickY, e.PageDuration, e.DatacenterIP, e.IPHash, e.BotClientSignals, e.ASNOrg,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -319,10 +382,11 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 		_, err := perfStmt.Exec(
 			p.ID, p.Timestamp.UnixMilli(), p.SessionID, p.VisitorHash, p.Domain, p.URL, p.Path,
 			p.LCP, p.CLS, p.FCP, p.TTFB, p.INP, p.PageLoadTime,
-			p.DeviceType, p.ConnectionType, p.GeoCountry,
+			p.DeviceType, p.ConnectionType, p.GeoCountry, sampleRateOrOne(p.SampleRate),
+			p.TTI, p.TBT, p.LongTasks, p.ResourceCount, p.ResourceBytes,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -331,14 +395,78 @@ func (db *DB) InsertBatch(events []*Event, perfs []*Performance, errs []*Error)
 		_, err := errStmt.Exec(
 			e.ID, e.Timestamp.UnixMilli(), e.SessionID, e.VisitorHash, e.Domain, e.URL, e.Path,
 			e.ErrorType, e.ErrorMessage, e.ErrorStack, e.ErrorHash,
-			e.ScriptURL, e.LineNumber, e.ColumnNumber, e.BrowserName, e.GeoCountry,
+			e.ScriptURL, e.LineNumber, e.ColumnNumber, e.BrowserName, e.GeoCountry, sampleRateOrOne(e.SampleRate),
+			max(e.Occurrences, 1),
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// throttleSessionErrors caps how many rows each (session, error_hash) pair
+// gets. Errors past the cap are folded into the occurrences counter of the
+// newest kept row, either in this batch or already stored. Returns the errors
+// that should still be inserted; errs is only modified once nothing failed.
+func throttleSessionErrors(tx *sql.Tx, errs []*Error, maxPerSession int) ([]*Error, error) {
+	type key struct{ session, hash string }
+	stored := make(map[key]int)
+	kept := make(map[key]*Error)
+	folded := make(map[*Error]int)
+	overflow := make(map[key]int)
+
+	result := make([]*Error, 0, len(errs))
+	for _, e := range errs {
+		k := key{e.SessionID, e.ErrorHash}
+		if _, ok := stored[k]; !ok {
+			var n int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM errors WHERE session_id = ? AND error_hash = ?", k.session, k.hash).Scan(&n); err != nil {
+				return nil, err
+			}
+			stored[k] = n
+		}
+
+		if stored[k] < maxPerSession {
+			stored[k]++
+			kept[k] = e
+			result = append(result, e)
+			continue
+		}
+
+		if last, ok := kept[k]; ok {
+			folded[last] += max(e.Occurrences, 1)
+		} else {
+			overflow[k] += max(e.Occurrences, 1)
+		}
+	}
+
+	for k, n := range overflow {
+		_, err := tx.Exec(`
+			UPDATE errors SET occurrences = occurrences + ?
+			WHERE id = (SELECT id FROM errors WHERE session_id = ? AND error_hash = ? ORDER BY timestamp DESC LIMIT 1)
+		`, n, k.session, k.hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for e, n := range folded {
+		e.Occurrences = max(e.Occurrences, 1) + n
+	}
+	return result, nil
+}
+
+// sampleRateOrOne treats an unset sample rate as unsampled
+func sampleRateOrOne(rate float64) float64 {
+	if rate <= 0 || rate > 1 {
+		return 1
+	}
+	return rate
 }
 
 // GetEventCount returns total event count
@@ -564,22 +692,166 @@ func (db *DB) QueryAuditLog(page, perPage int, action, resourceType string) ([]A
 	return entries, total, nil
 }
 
-// CleanupOldData removes data older than retentionDays
-func (db *DB) CleanupOldData(retentionDays int) error {
+// CleanupOldData deletes rows older than each table's retention period
+func (db *DB) CleanupOldData(policy RetentionPolicy) error {
+	return db.CleanupOldDataPerDomain(policy, nil)
+}
+
+// CleanupOldDataPerDomain deletes rows older than each table's retention
+// period, using the domains' own policies for the domains in overrides
+func (db *DB) CleanupOldDataPerDomain(policy RetentionPolicy, overrides map[string]RetentionPolicy) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	cutoff := time.Now().AddDate(0, 0, -retentionDays).UnixMilli()
-
 	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	tx.Exec("DELETE FROM events WHERE timestamp < ?", cutoff)
-	tx.Exec("DELETE FROM performance WHERE timestamp < ?", cutoff)
-	tx.Exec("DELETE FROM errors WHERE timestamp < ?", cutoff)
+	domains := make([]interface{}, 0, len(overrides))
+	for domain := range overrides {
+		domains = append(domains, domain)
+	}
+	tables := func(p RetentionPolicy) []struct {
+		table string
+		days  int
+	} {
+		return []struct {
+			table string
+			days  int
+		}{
+			{"events", p.Events},
+			{"performance", p.Performance},
+			{"errors", p.Errors},
+			{"pre_consent_pageviews", p.Events},
+		}
+	}
+
+	// Everything but the overridden domains, then each of those
+	others := ""
+	if len(domains) > 0 {
+		others = " AND domain NOT IN (?" + strings.Repeat(", ?", len(domains)-1) + ")"
+	}
+	for _, t := range tables(policy) {
+		if t.days <= 0 {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -t.days).UnixMilli()
+		if _, err := tx.Exec("DELETE FROM "+t.table+" WHERE timestamp < ?"+others, append([]interface{}{cutoff}, domains...)...); err != nil {
+			return err
+		}
+	}
+	for domain, p := range overrides {
+		for _, t := range tables(p) {
+			if t.days <= 0 {
+				continue
+			}
+			cutoff := time.Now().AddDate(0, 0, -t.days).UnixMilli()
+			if _, err := tx.Exec("DELETE FROM "+t.table+" WHERE timestamp < ? AND domain = ?", cutoff, domain); err != nil {
+				return err
+			}
+		}
+	}
 
 	return tx.Commit()
 }
+
+// DomainRetentionOverrides returns the domains with their own
+// retention_days, in days
+func (db *DB) DomainRetentionOverrides() (map[string]int, error) {
+	rows, err := db.conn.Query("SELECT domain, retention_days FROM domains WHERE retention_days IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]int)
+	for rows.Next() {
+		var domain string
+		var days int
+		if err := rows.Scan(&domain, &days); err != nil {
+			return nil, err
+		}
+		overrides[domain] = days
+	}
+	return overrides, rows.Err()
+}
+
+// BackfillReferrerTypes recomputes referrer_type for events that have a
+// referrer_url but no stored classification. Rows are updated in batches of
+// batchSize, releasing the write lock between batches so ingestion isn't
+// blocked for the whole run. Returns the number of rows updated.
+func (db *DB) BackfillReferrerTypes(classify func(referrerURL string) string, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var total int64
+	for {
+		n, err := db.backfillReferrerBatch(classify, batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func (db *DB) backfillReferrerBatch(classify func(string) string, batchSize int) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	rows, err := db.conn.Query(`
+		SELECT id, referrer_url FROM events
+		WHERE referrer_type IS NULL AND referrer_url IS NOT NULL AND referrer_url != ''
+		LIMIT ?
+	`, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct{ id, url string }
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.url); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE events SET referrer_type = ? WHERE id = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, p := range batch {
+		if _, err := stmt.Exec(classify(p.url), p.id); err != nil {
+			return 0, fmt.Errorf("failed to update event %s: %w", p.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int64(len(batch)), nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "etiquetta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testErrors(session string, n int) []*Error {
+	errs := make([]*Error, n)
+	for i := range errs {
+		errs[i] = &Error{
+			ID:           fmt.Sprintf("%s-%d-%d", session, time.Now().UnixNano(), i),
+			Timestamp:    time.Now().Add(time.Duration(i) * time.Millisecond),
+			SessionID:    session,
+			Domain:       "example.com",
+			ErrorType:    "js",
+			ErrorMessage: "boom",
+			ErrorHash:    "hash",
+		}
+	}
+	return errs
+}
+
+func TestInsertBatchThrottled(t *testing.T) {
+	db := newTestDB(t)
+
+	stored, err := db.InsertBatchThrottled(nil, nil, testErrors("s1", 5), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("stored %d errors, want 2", len(stored))
+	}
+
+	// A later batch past the cap only bumps the newest stored row
+	stored, err = db.InsertBatchThrottled(nil, nil, testErrors("s1", 2), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("stored %d errors past the cap, want 0", len(stored))
+	}
+
+	var rows, occurrences int
+	err = db.Conn().QueryRow("SELECT COUNT(*), SUM(occurrences) FROM errors WHERE session_id = 's1'").Scan(&rows, &occurrences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 || occurrences != 7 {
+		t.Errorf("got %d rows with %d occurrences, want 2 rows with 7", rows, occurrences)
+	}
+
+	// Other sessions have their own cap
+	if stored, err := db.InsertBatchThrottled(nil, nil, testErrors("s2", 1), 2); err != nil || len(stored) != 1 {
+		t.Errorf("other session stored %d errors (err %v), want 1", len(stored), err)
+	}
+}
+
+func TestInsertBatchThrottledFailedInsertKeepsCounts(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.InsertBatchThrottled(nil, nil, testErrors("s1", 1), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repeated error would bump the stored row, but the batch fails on a
+	// duplicate event ID, so nothing may change
+	event := &Event{ID: "dup", Timestamp: time.Now(), EventType: "pageview", Domain: "example.com"}
+	if _, err := db.InsertBatchThrottled([]*Event{event, event}, nil, testErrors("s1", 1), 1); err == nil {
+		t.Fatal("batch with a duplicate ID was stored")
+	}
+
+	var occurrences int
+	if err := db.Conn().QueryRow("SELECT occurrences FROM errors WHERE session_id = 's1'").Scan(&occurrences); err != nil {
+		t.Fatal(err)
+	}
+	if occurrences != 1 {
+		t.Errorf("occurrences = %d after a failed batch, want 1", occurrences)
+	}
+}
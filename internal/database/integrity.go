@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxIntegrityProblems caps the problems reported by each check; a damaged
+// file can produce one per page or row
+const maxIntegrityProblems = 100
+
+// IntegrityRecovery is what to do when the integrity check finds corruption
+const IntegrityRecovery = "Stop the server and restore etiquetta.db from your latest backup " +
+	"(a copy of the data directory, or the file downloaded from GET /api/db). " +
+	"Without a backup, 'sqlite3 etiquetta.db .recover | sqlite3 recovered.db' salvages " +
+	"what is still readable into a new file to use instead."
+
+// ForeignKeyViolation is a row referring to a parent row that doesn't exist,
+// as reported by PRAGMA foreign_key_check
+type ForeignKeyViolation struct {
+	Table  string `json:"table"`
+	RowID  *int64 `json:"rowid"` // nil for WITHOUT ROWID tables
+	Parent string `json:"parent"`
+}
+
+// IntegrityReport is the result of SQLite's integrity and foreign key checks
+type IntegrityReport struct {
+	OK                   bool                  `json:"ok"`
+	Problems             []string              `json:"problems"` // from PRAGMA integrity_check
+	ForeignKeyViolations []ForeignKeyViolation `json:"foreign_key_violations"`
+	Truncated            bool                  `json:"truncated"`          // more problems than listed
+	Recovery             string                `json:"recovery,omitempty"` // set when the file is corrupt
+}
+
+// Corrupt reports whether the integrity check found damage. Foreign key
+// violations alone aren't damage: foreign keys aren't enforced, so deleting
+// a parent row can leave its children behind.
+func (r *IntegrityReport) Corrupt() bool {
+	return len(r.Problems) > 0
+}
+
+// CheckIntegrity runs PRAGMA integrity_check and PRAGMA foreign_key_check.
+// Both read the whole file, so this takes a while on large databases.
+func (db *DB) CheckIntegrity(ctx context.Context) (*IntegrityReport, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	report := &IntegrityReport{
+		Problems:             make([]string, 0),
+		ForeignKeyViolations: make([]ForeignKeyViolation, 0),
+	}
+
+	// integrity_check returns a single "ok" row when nothing is wrong
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("PRAGMA integrity_check(%d)", maxIntegrityProblems+1))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// The first message starts with a "*** in database main ***" line
+		for _, line := range strings.Split(problem, "\n") {
+			if line != "ok" && line != "" && !strings.HasPrefix(line, "*** ") {
+				report.Problems = append(report.Problems, line)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(report.Problems) > maxIntegrityProblems {
+		report.Problems = report.Problems[:maxIntegrityProblems]
+		report.Truncated = true
+	}
+
+	rows, err = db.conn.QueryContext(ctx, "SELECT \"table\", rowid, parent FROM pragma_foreign_key_check LIMIT ?", maxIntegrityProblems+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v ForeignKeyViolation
+		var rowID sql.NullInt64
+		if err := rows.Scan(&v.Table, &rowID, &v.Parent); err != nil {
+			return nil, err
+		}
+		if rowID.Valid {
+			v.RowID = &rowID.Int64
+		}
+		report.ForeignKeyViolations = append(report.ForeignKeyViolations, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(report.ForeignKeyViolations) > maxIntegrityProblems {
+		report.ForeignKeyViolations = report.ForeignKeyViolations[:maxIntegrityProblems]
+		report.Truncated = true
+	}
+
+	report.OK = len(report.Problems) == 0 && len(report.ForeignKeyViolations) == 0
+	if report.Corrupt() {
+		report.Recovery = IntegrityRecovery
+	}
+	return report, nil
+}
@@ -1,463 +1,1071 @@
 package database
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 )
 
-// Migrate runs database migrations
-func (db *DB) Migrate() error {
-	// Create migrations table
-	_, err := db.conn.Exec(`
-		CREATE TABLE IF NOT EXISTS migrations (
-			id INTEGER PRIMARY KEY,
-			version INTEGER UNIQUE NOT NULL,
-			applied_at INTEGER NOT NULL
-		)
-	`)
-	if err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// MigrationError is returned by Migrate when a migration fails to apply.
+// It is rolled back, while the migrations before it stay applied.
+type MigrationError struct {
+	Version     int
+	Description string
+	Rollback    string
+	Step        string // what failed, e.g. "add column events.bot_score"
+	Err         error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %d (%s) failed to %s: %v", e.Version, e.Description, e.Step, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Critical reports whether the failed migration is the base schema, without
+// which nothing can be served. Later migrations add features on top of it.
+func (e *MigrationError) Critical() bool {
+	return e.Version <= baseSchemaVersion
+}
+
+// baseSchemaVersion is the migration creating the core tables
+const baseSchemaVersion = 1
+
+// migration is a single versioned schema change;
+// rollback is a human-readable note on how to undo the change by hand.
+// columns are added before sql runs, skipping any that already exist.
+type migration struct {
+	version     int
+	description string
+	rollback    string
+	columns     []column
+	sql         string
+}
+
+// column is a column added by a migration.
+// SQLite has no ADD COLUMN IF NOT EXISTS, so these are checked against
+// PRAGMA table_info first, letting a hand-patched database migrate cleanly.
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// failed wraps an error from one step of applying m
+func (m migration) failed(step string, err error) *MigrationError {
+	return &MigrationError{Version: m.version, Description: m.description, Rollback: m.rollback, Step: step, Err: err}
+}
+
+// checksum fingerprints the migration's schema changes so edits to an
+// already-applied migration can be detected
+func (m migration) checksum() string {
+	h := sha256.New()
+	for _, c := range m.columns {
+		fmt.Fprintf(h, "ADD COLUMN %s.%s %s;", c.table, c.name, c.definition)
 	}
+	h.Write([]byte(m.sql))
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	// Get current version
-	var currentVersion int
-	row := db.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM migrations")
-	row.Scan(&currentVersion)
-
-	// Run migrations
-	migrations := []struct {
-		version int
-		sql     string
-	}{
-		{
-			version: 1,
-			sql: `
-				-- Events table (pageviews, custom events, clicks, scroll, identify)
-				CREATE TABLE IF NOT EXISTS events (
-					id TEXT PRIMARY KEY,
-					timestamp INTEGER NOT NULL,
-					event_type TEXT NOT NULL,
-					event_name TEXT,
-					session_id TEXT NOT NULL,
-					visitor_hash TEXT NOT NULL,
-					user_id TEXT,
-					domain TEXT NOT NULL,
-					url TEXT NOT NULL,
-					path TEXT NOT NULL,
-					page_title TEXT,
-					referrer_url TEXT,
-					referrer_type TEXT,
-					utm_source TEXT,
-					utm_medium TEXT,
-					utm_campaign TEXT,
-					geo_country TEXT,
-					geo_city TEXT,
-					geo_region TEXT,
-					browser_name TEXT,
-					os_name TEXT,
-					device_type TEXT,
-					is_bot INTEGER DEFAULT 0,
-					props TEXT DEFAULT '{}'
-				);
-
-				-- Indexes for events
-				CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
-				CREATE INDEX IF NOT EXISTS idx_events_session ON events(session_id);
-				CREATE INDEX IF NOT EXISTS idx_events_visitor ON events(visitor_hash);
-				CREATE INDEX IF NOT EXISTS idx_events_domain ON events(domain);
-				CREATE INDEX IF NOT EXISTS idx_events_path ON events(path);
-				CREATE INDEX IF NOT EXISTS idx_events_type ON events(event_type);
-				CREATE INDEX IF NOT EXISTS idx_events_country ON events(geo_country);
-			`,
+// MigrationInfo describes a migration and whether it has been applied
+type MigrationInfo struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Rollback    string `json:"rollback"`
+	Applied     bool   `json:"applied"`
+	Drifted     bool   `json:"drifted,omitempty"`
+}
+
+// migrations is the ordered list of schema migrations
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Create events table",
+		rollback:    "DROP TABLE events",
+		sql: `
+			-- Events table (pageviews, custom events, clicks, scroll, identify)
+			CREATE TABLE IF NOT EXISTS events (
+				id TEXT PRIMARY KEY,
+				timestamp INTEGER NOT NULL,
+				event_type TEXT NOT NULL,
+				event_name TEXT,
+				session_id TEXT NOT NULL,
+				visitor_hash TEXT NOT NULL,
+				user_id TEXT,
+				domain TEXT NOT NULL,
+				url TEXT NOT NULL,
+				path TEXT NOT NULL,
+				page_title TEXT,
+				referrer_url TEXT,
+				referrer_type TEXT,
+				utm_source TEXT,
+				utm_medium TEXT,
+				utm_campaign TEXT,
+				geo_country TEXT,
+				geo_city TEXT,
+				geo_region TEXT,
+				browser_name TEXT,
+				os_name TEXT,
+				device_type TEXT,
+				is_bot INTEGER DEFAULT 0,
+				props TEXT DEFAULT '{}'
+			);
+
+			-- Indexes for events
+			CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+			CREATE INDEX IF NOT EXISTS idx_events_session ON events(session_id);
+			CREATE INDEX IF NOT EXISTS idx_events_visitor ON events(visitor_hash);
+			CREATE INDEX IF NOT EXISTS idx_events_domain ON events(domain);
+			CREATE INDEX IF NOT EXISTS idx_events_path ON events(path);
+			CREATE INDEX IF NOT EXISTS idx_events_type ON events(event_type);
+			CREATE INDEX IF NOT EXISTS idx_events_country ON events(geo_country);
+		`,
+	},
+	{
+		version:     2,
+		description: "Create performance table",
+		rollback:    "DROP TABLE performance",
+		sql: `
+			-- Performance table (Core Web Vitals)
+			CREATE TABLE IF NOT EXISTS performance (
+				id TEXT PRIMARY KEY,
+				timestamp INTEGER NOT NULL,
+				session_id TEXT NOT NULL,
+				visitor_hash TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				url TEXT NOT NULL,
+				path TEXT NOT NULL,
+				lcp REAL,
+				cls REAL,
+				fcp REAL,
+				ttfb REAL,
+				inp REAL,
+				page_load_time REAL,
+				device_type TEXT,
+				connection_type TEXT,
+				geo_country TEXT
+			);
+
+			-- Indexes for performance
+			CREATE INDEX IF NOT EXISTS idx_perf_timestamp ON performance(timestamp);
+			CREATE INDEX IF NOT EXISTS idx_perf_session ON performance(session_id);
+			CREATE INDEX IF NOT EXISTS idx_perf_path ON performance(path);
+		`,
+	},
+	{
+		version:     3,
+		description: "Create errors table",
+		rollback:    "DROP TABLE errors",
+		sql: `
+			-- Errors table (JS errors, resource failures)
+			CREATE TABLE IF NOT EXISTS errors (
+				id TEXT PRIMARY KEY,
+				timestamp INTEGER NOT NULL,
+				session_id TEXT NOT NULL,
+				visitor_hash TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				url TEXT NOT NULL,
+				path TEXT NOT NULL,
+				error_type TEXT NOT NULL,
+				error_message TEXT NOT NULL,
+				error_stack TEXT,
+				error_hash TEXT NOT NULL,
+				script_url TEXT,
+				line_number INTEGER,
+				column_number INTEGER,
+				browser_name TEXT,
+				geo_country TEXT
+			);
+
+			-- Indexes for errors
+			CREATE INDEX IF NOT EXISTS idx_errors_timestamp ON errors(timestamp);
+			CREATE INDEX IF NOT EXISTS idx_errors_hash ON errors(error_hash);
+			CREATE INDEX IF NOT EXISTS idx_errors_type ON errors(error_type);
+			CREATE INDEX IF NOT EXISTS idx_errors_session ON errors(session_id);
+		`,
+	},
+	{
+		version:     4,
+		description: "Create settings table with defaults",
+		rollback:    "DROP TABLE settings",
+		sql: `
+			-- Settings table
+			CREATE TABLE IF NOT EXISTS settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL,
+				updated_at INTEGER NOT NULL
+			);
+
+			-- Insert default settings
+			INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
+				('track_performance', 'true', strftime('%s', 'now') * 1000),
+				('track_errors', 'true', strftime('%s', 'now') * 1000),
+				('session_timeout_minutes', '30', strftime('%s', 'now') * 1000),
+				('respect_dnt', 'true', strftime('%s', 'now') * 1000);
+		`,
+	},
+	{
+		version:     5,
+		description: "Create users and sessions tables",
+		rollback:    "DROP TABLE sessions; DROP TABLE users",
+		sql: `
+			-- Users table (for auth)
+			CREATE TABLE IF NOT EXISTS users (
+				id TEXT PRIMARY KEY,
+				email TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				name TEXT,
+				role TEXT DEFAULT 'viewer',
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL
+			);
+
+			-- Sessions table (for auth)
+			CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				expires_at INTEGER NOT NULL,
+				created_at INTEGER NOT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+			CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+		`,
+	},
+	{
+		version:     6,
+		description: "Create domains table",
+		rollback:    "DROP TABLE domains",
+		sql: `
+			-- Domains table (for multi-site tracking)
+			CREATE TABLE IF NOT EXISTS domains (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				domain TEXT UNIQUE NOT NULL,
+				created_by TEXT,
+				created_at INTEGER NOT NULL,
+				is_active INTEGER DEFAULT 1,
+				FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_domains_domain ON domains(domain);
+			CREATE INDEX IF NOT EXISTS idx_domains_active ON domains(is_active);
+
+			-- Add setup_complete setting
+			INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
+				('setup_complete', 'false', strftime('%s', 'now') * 1000);
+		`,
+	},
+	{
+		version:     7,
+		description: "Add site_id to domains",
+		rollback:    "DROP INDEX idx_domains_site_id; ALTER TABLE domains DROP COLUMN site_id",
+		// Add site_id to domains for tracking script authentication
+		columns: []column{
+			{"domains", "site_id", "TEXT"},
 		},
-		{
-			version: 2,
-			sql: `
-				-- Performance table (Core Web Vitals)
-				CREATE TABLE IF NOT EXISTS performance (
-					id TEXT PRIMARY KEY,
-					timestamp INTEGER NOT NULL,
-					session_id TEXT NOT NULL,
-					visitor_hash TEXT NOT NULL,
-					domain TEXT NOT NULL,
-					url TEXT NOT NULL,
-					path TEXT NOT NULL,
-					lcp REAL,
-					cls REAL,
-					fcp REAL,
-					ttfb REAL,
-					inp REAL,
-					page_load_time REAL,
-					device_type TEXT,
-					connection_type TEXT,
-					geo_country TEXT
-				);
-
-				-- Indexes for performance
-				CREATE INDEX IF NOT EXISTS idx_perf_timestamp ON performance(timestamp);
-				CREATE INDEX IF NOT EXISTS idx_perf_session ON performance(session_id);
-				CREATE INDEX IF NOT EXISTS idx_perf_path ON performance(path);
-			`,
+		sql: `
+			-- Generate site_id for existing domains
+			UPDATE domains SET site_id = 'site_' || lower(hex(randomblob(8))) WHERE site_id IS NULL;
+
+			-- Create unique index on site_id
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_site_id ON domains(site_id);
+		`,
+	},
+	{
+		version:     8,
+		description: "Add bot detection and behavioral columns to events",
+		rollback:    "Drop the bot_* and behavioral columns from events (restore from backup recommended)",
+		columns: []column{
+			// Bot detection columns
+			{"events", "bot_score", "INTEGER DEFAULT 0"},
+			{"events", "bot_signals", "TEXT DEFAULT '[]'"},
+			{"events", "bot_category", "TEXT DEFAULT 'human'"},
+
+			// Behavioral flags
+			{"events", "has_scroll", "INTEGER DEFAULT 0"},
+			{"events", "has_mouse_move", "INTEGER DEFAULT 0"},
+			{"events", "has_click", "INTEGER DEFAULT 0"},
+			{"events", "has_touch", "INTEGER DEFAULT 0"},
+
+			// Click tracking for fraud detection
+			{"events", "click_x", "INTEGER"},
+			{"events", "click_y", "INTEGER"},
+			{"events", "page_duration", "INTEGER"},
+
+			// IP classification
+			{"events", "datacenter_ip", "INTEGER DEFAULT 0"},
+			{"events", "ip_hash", "TEXT"},
+		},
+		sql: `
+			-- Indexes for bot filtering
+			CREATE INDEX IF NOT EXISTS idx_events_bot_category ON events(bot_category);
+			CREATE INDEX IF NOT EXISTS idx_events_bot_score ON events(bot_score);
+		`,
+	},
+	{
+		version:     9,
+		description: "Create campaigns and visitor_sessions tables",
+		rollback:    "DROP TABLE visitor_sessions; DROP TABLE campaigns",
+		sql: `
+			-- Campaigns table for ad fraud detection
+			CREATE TABLE IF NOT EXISTS campaigns (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				utm_source TEXT,
+				utm_medium TEXT,
+				utm_campaign TEXT,
+				cpc REAL DEFAULT 0,
+				cpm REAL DEFAULT 0,
+				budget REAL DEFAULT 0,
+				start_date INTEGER,
+				end_date INTEGER,
+				created_at INTEGER NOT NULL
+			);
+
+			-- Visitor sessions table (materialized)
+			CREATE TABLE IF NOT EXISTS visitor_sessions (
+				id TEXT PRIMARY KEY,
+				session_id TEXT UNIQUE,
+				visitor_hash TEXT,
+				domain TEXT,
+				start_time INTEGER,
+				end_time INTEGER,
+				duration INTEGER,
+				pageviews INTEGER,
+				entry_url TEXT,
+				exit_url TEXT,
+				is_bounce INTEGER,
+				bot_score INTEGER,
+				bot_category TEXT
+			);
+
+			-- Indexes for campaigns and sessions
+			CREATE INDEX IF NOT EXISTS idx_campaigns_utm ON campaigns(utm_source, utm_medium, utm_campaign);
+			CREATE INDEX IF NOT EXISTS idx_visitor_sessions_session ON visitor_sessions(session_id);
+			CREATE INDEX IF NOT EXISTS idx_visitor_sessions_domain ON visitor_sessions(domain);
+			CREATE INDEX IF NOT EXISTS idx_visitor_sessions_bot ON visitor_sessions(bot_category);
+		`,
+	},
+	{
+		version:     10,
+		description: "Add geo coordinates to events",
+		rollback:    "DROP INDEX idx_events_geo; drop geo_latitude/geo_longitude columns from events",
+		// Add geo coordinates for map plotting
+		columns: []column{
+			{"events", "geo_latitude", "REAL"},
+			{"events", "geo_longitude", "REAL"},
+		},
+		sql: `
+			-- Index for geo queries
+			CREATE INDEX IF NOT EXISTS idx_events_geo ON events(geo_latitude, geo_longitude);
+		`,
+	},
+	{
+		version:     11,
+		description: "Add configuration settings",
+		rollback:    "Settings rows are harmless to older binaries; no rollback needed",
+		sql: `
+			-- Add settings for configuration (replacing .env)
+			INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
+				('secret_key', '', strftime('%s', 'now') * 1000),
+				('maxmind_account_id', '', strftime('%s', 'now') * 1000),
+				('maxmind_license_key', '', strftime('%s', 'now') * 1000),
+				('geoip_path', './data/GeoLite2-City.mmdb', strftime('%s', 'now') * 1000),
+				('geoip_auto_update', 'false', strftime('%s', 'now') * 1000),
+				('geoip_last_updated', '', strftime('%s', 'now') * 1000),
+				('allowed_origins', '*', strftime('%s', 'now') * 1000),
+				('listen_addr', ':3456', strftime('%s', 'now') * 1000);
+		`,
+	},
+	{
+		version:     12,
+		description: "Add composite stats indexes",
+		rollback:    "DROP INDEX idx_events_ts_domain_bot, idx_events_ts_type_bot, idx_vsessions_domain_start",
+		sql: `
+			-- Composite index for the most common stat query pattern:
+			-- WHERE timestamp >= ? AND timestamp <= ? AND is_bot = 0 AND domain = ?
+			CREATE INDEX IF NOT EXISTS idx_events_ts_domain_bot
+				ON events(timestamp, domain, is_bot);
+
+			-- Composite index for pageview stats (adds event_type)
+			CREATE INDEX IF NOT EXISTS idx_events_ts_type_bot
+				ON events(timestamp, event_type, is_bot);
+
+			-- Composite for visitor_sessions queries
+			CREATE INDEX IF NOT EXISTS idx_vsessions_domain_start
+				ON visitor_sessions(domain, start_time);
+		`,
+	},
+	{
+		version:     13,
+		description: "Create consent and tag manager tables",
+		rollback:    "DROP the consent_* and tm_* tables",
+		sql: `
+			-- Consent tables
+			CREATE TABLE IF NOT EXISTS consent_configs (
+				id TEXT PRIMARY KEY,
+				domain_id TEXT NOT NULL,
+				version INTEGER NOT NULL DEFAULT 1,
+				is_active INTEGER NOT NULL DEFAULT 1,
+				categories TEXT NOT NULL DEFAULT '[]',
+				appearance TEXT NOT NULL DEFAULT '{}',
+				translations TEXT NOT NULL DEFAULT '{}',
+				cookie_name TEXT NOT NULL DEFAULT 'etiquetta_consent',
+				cookie_expiry_days INTEGER NOT NULL DEFAULT 365,
+				auto_language INTEGER NOT NULL DEFAULT 1,
+				geo_targeting TEXT NOT NULL DEFAULT '[]',
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
+			);
+			CREATE INDEX idx_consent_configs_domain ON consent_configs(domain_id);
+			CREATE UNIQUE INDEX idx_consent_configs_version ON consent_configs(domain_id, version);
+
+			CREATE TABLE IF NOT EXISTS consent_records (
+				id TEXT PRIMARY KEY,
+				domain_id TEXT NOT NULL,
+				visitor_hash TEXT NOT NULL,
+				ip_hash TEXT,
+				categories TEXT NOT NULL DEFAULT '{}',
+				config_version INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				user_agent TEXT,
+				geo_country TEXT,
+				timestamp INTEGER NOT NULL,
+				FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
+			);
+			CREATE INDEX idx_consent_records_domain_ts ON consent_records(domain_id, timestamp);
+			CREATE INDEX idx_consent_records_visitor ON consent_records(visitor_hash);
+
+			-- Tag Manager tables
+			CREATE TABLE IF NOT EXISTS tm_containers (
+				id TEXT PRIMARY KEY,
+				domain_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				published_version INTEGER DEFAULT 0,
+				draft_version INTEGER DEFAULT 1,
+				published_at INTEGER,
+				published_by TEXT,
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE,
+				UNIQUE(domain_id)
+			);
+
+			CREATE TABLE IF NOT EXISTS tm_tags (
+				id TEXT PRIMARY KEY,
+				container_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				tag_type TEXT NOT NULL,
+				config TEXT NOT NULL DEFAULT '{}',
+				consent_category TEXT NOT NULL DEFAULT 'marketing',
+				priority INTEGER DEFAULT 0,
+				is_enabled INTEGER DEFAULT 1,
+				version INTEGER DEFAULT 1,
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
+			);
+			CREATE INDEX idx_tm_tags_container ON tm_tags(container_id);
+
+			CREATE TABLE IF NOT EXISTS tm_triggers (
+				id TEXT PRIMARY KEY,
+				container_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				trigger_type TEXT NOT NULL,
+				config TEXT NOT NULL DEFAULT '{}',
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
+			);
+			CREATE INDEX idx_tm_triggers_container ON tm_triggers(container_id);
+
+			CREATE TABLE IF NOT EXISTS tm_tag_triggers (
+				tag_id TEXT NOT NULL,
+				trigger_id TEXT NOT NULL,
+				is_exception INTEGER DEFAULT 0,
+				PRIMARY KEY (tag_id, trigger_id),
+				FOREIGN KEY (tag_id) REFERENCES tm_tags(id) ON DELETE CASCADE,
+				FOREIGN KEY (trigger_id) REFERENCES tm_triggers(id) ON DELETE CASCADE
+			);
+
+			CREATE TABLE IF NOT EXISTS tm_variables (
+				id TEXT PRIMARY KEY,
+				container_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				variable_type TEXT NOT NULL,
+				config TEXT NOT NULL DEFAULT '{}',
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL,
+				FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
+			);
+			CREATE INDEX idx_tm_variables_container ON tm_variables(container_id);
+
+			CREATE TABLE IF NOT EXISTS tm_snapshots (
+				id TEXT PRIMARY KEY,
+				container_id TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				snapshot TEXT NOT NULL,
+				published_by TEXT,
+				published_at INTEGER NOT NULL,
+				FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE,
+				UNIQUE(container_id, version)
+			);
+		`,
+	},
+	{
+		version:     14,
+		description: "Create audit_log table",
+		rollback:    "DROP TABLE audit_log",
+		sql: `
+			-- Admin audit log for GDPR compliance
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id TEXT PRIMARY KEY,
+				timestamp INTEGER NOT NULL,
+				user_id TEXT NOT NULL,
+				user_email TEXT NOT NULL,
+				action TEXT NOT NULL,
+				resource_type TEXT NOT NULL,
+				resource_id TEXT,
+				detail TEXT,
+				ip_address TEXT
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+			CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id);
+			CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+			CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id);
+		`,
+	},
+	{
+		version:     15,
+		description: "Create user_invites table",
+		rollback:    "DROP TABLE user_invites",
+		sql: `
+			-- Pending user invitations
+			CREATE TABLE IF NOT EXISTS user_invites (
+				id TEXT PRIMARY KEY,
+				email TEXT NOT NULL,
+				name TEXT,
+				role TEXT NOT NULL DEFAULT 'viewer',
+				token_hash TEXT UNIQUE NOT NULL,
+				invited_by TEXT,
+				expires_at INTEGER NOT NULL,
+				accepted_at INTEGER,
+				created_at INTEGER NOT NULL,
+				FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_user_invites_email ON user_invites(email);
+			CREATE INDEX IF NOT EXISTS idx_user_invites_expires ON user_invites(expires_at);
+		`,
+	},
+	{
+		version:     16,
+		description: "Add sample_rate to performance and errors",
+		rollback:    "Drop sample_rate from performance and errors",
+		// Effective sampling rate at ingest, so counts can be scaled back up
+		columns: []column{
+			{"performance", "sample_rate", "REAL DEFAULT 1"},
+			{"errors", "sample_rate", "REAL DEFAULT 1"},
 		},
-		{
-			version: 3,
-			sql: `
-				-- Errors table (JS errors, resource failures)
-				CREATE TABLE IF NOT EXISTS errors (
-					id TEXT PRIMARY KEY,
-					timestamp INTEGER NOT NULL,
-					session_id TEXT NOT NULL,
-					visitor_hash TEXT NOT NULL,
-					domain TEXT NOT NULL,
-					url TEXT NOT NULL,
-					path TEXT NOT NULL,
-					error_type TEXT NOT NULL,
-					error_message TEXT NOT NULL,
-					error_stack TEXT,
-					error_hash TEXT NOT NULL,
-					script_url TEXT,
-					line_number INTEGER,
-					column_number INTEGER,
-					browser_name TEXT,
-					geo_country TEXT
-				);
-
-				-- Indexes for errors
-				CREATE INDEX IF NOT EXISTS idx_errors_timestamp ON errors(timestamp);
-				CREATE INDEX IF NOT EXISTS idx_errors_hash ON errors(error_hash);
-				CREATE INDEX IF NOT EXISTS idx_errors_type ON errors(error_type);
-				CREATE INDEX IF NOT EXISTS idx_errors_session ON errors(session_id);
-			`,
+	},
+	{
+		version:     17,
+		description: "Add occurrences counter to errors",
+		rollback:    "DROP INDEX idx_errors_session_hash; drop occurrences from errors",
+		// Repeats of an error beyond the per-session cap bump this counter
+		// on the representative row instead of inserting duplicates
+		columns: []column{
+			{"errors", "occurrences", "INTEGER NOT NULL DEFAULT 1"},
 		},
-		{
-			version: 4,
-			sql: `
-				-- Settings table
-				CREATE TABLE IF NOT EXISTS settings (
-					key TEXT PRIMARY KEY,
-					value TEXT NOT NULL,
-					updated_at INTEGER NOT NULL
-				);
-
-				-- Insert default settings
-				INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
-					('track_performance', 'true', strftime('%s', 'now') * 1000),
-					('track_errors', 'true', strftime('%s', 'now') * 1000),
-					('session_timeout_minutes', '30', strftime('%s', 'now') * 1000),
-					('respect_dnt', 'true', strftime('%s', 'now') * 1000);
-			`,
+		sql: `
+			CREATE INDEX IF NOT EXISTS idx_errors_session_hash ON errors(session_id, error_hash);
+		`,
+	},
+	{
+		version:     18,
+		description: "Add composite errors index for type breakdowns",
+		rollback:    "DROP INDEX idx_errors_ts_type",
+		sql: `
+			-- The type breakdown filters by time range and groups by error_type
+			CREATE INDEX IF NOT EXISTS idx_errors_ts_type ON errors(timestamp, error_type);
+		`,
+	},
+	{
+		version:     19,
+		description: "Create alert_rules and alert_history tables",
+		rollback:    "DROP TABLE alert_history; DROP TABLE alert_rules",
+		sql: `
+			-- Threshold alert rules evaluated on a schedule
+			CREATE TABLE IF NOT EXISTS alert_rules (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				domain TEXT,
+				metric TEXT NOT NULL,
+				operator TEXT NOT NULL,
+				threshold REAL NOT NULL,
+				window_minutes INTEGER NOT NULL DEFAULT 10,
+				cooldown_minutes INTEGER NOT NULL DEFAULT 60,
+				notify_emails TEXT,
+				webhook_url TEXT,
+				is_enabled INTEGER NOT NULL DEFAULT 1,
+				state TEXT NOT NULL DEFAULT 'ok',
+				last_value REAL,
+				last_evaluated_at INTEGER,
+				last_triggered_at INTEGER,
+				created_by TEXT,
+				created_at INTEGER NOT NULL,
+				updated_at INTEGER NOT NULL
+			);
+
+			-- Fired/resolved transitions for each rule
+			CREATE TABLE IF NOT EXISTS alert_history (
+				id TEXT PRIMARY KEY,
+				rule_id TEXT NOT NULL,
+				status TEXT NOT NULL,
+				value REAL NOT NULL,
+				threshold REAL NOT NULL,
+				message TEXT,
+				created_at INTEGER NOT NULL,
+				FOREIGN KEY (rule_id) REFERENCES alert_rules(id) ON DELETE CASCADE
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_alert_history_rule ON alert_history(rule_id, created_at);
+			CREATE INDEX IF NOT EXISTS idx_alert_history_created ON alert_history(created_at);
+		`,
+	},
+	{
+		version:     20,
+		description: "Create traffic_status table",
+		rollback:    "DROP TABLE traffic_status",
+		sql: `
+			-- Last traffic-drop check per domain, used to detect broken tracking snippets
+			CREATE TABLE IF NOT EXISTS traffic_status (
+				domain TEXT PRIMARY KEY,
+				status TEXT NOT NULL,
+				recent_pageviews INTEGER NOT NULL DEFAULT 0,
+				baseline_pageviews REAL NOT NULL DEFAULT 0,
+				last_pageview_at INTEGER,
+				changed_at INTEGER NOT NULL,
+				checked_at INTEGER NOT NULL
+			);
+		`,
+	},
+	{
+		version:     21,
+		description: "Create public_shares table",
+		rollback:    "DROP TABLE public_shares",
+		sql: `
+			-- Read-only dashboard links scoped to one domain and date range.
+			-- A fixed range uses start_at/end_at; otherwise the last period_days.
+			CREATE TABLE IF NOT EXISTS public_shares (
+				id TEXT PRIMARY KEY,
+				token TEXT UNIQUE NOT NULL,
+				name TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				start_at INTEGER,
+				end_at INTEGER,
+				period_days INTEGER,
+				password_hash TEXT,
+				expires_at INTEGER,
+				view_count INTEGER NOT NULL DEFAULT 0,
+				last_viewed_at INTEGER,
+				created_by TEXT,
+				created_at INTEGER NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_public_shares_domain ON public_shares(domain);
+		`,
+	},
+	{
+		version:     22,
+		description: "Add bot_client_signals column to events",
+		rollback:    "ALTER TABLE events DROP COLUMN bot_client_signals",
+		// Raw client-side bot checks, kept so scores can be recomputed exactly
+		columns: []column{
+			{"events", "bot_client_signals", "TEXT"},
 		},
-		{
-			version: 5,
-			sql: `
-				-- Users table (for auth)
-				CREATE TABLE IF NOT EXISTS users (
-					id TEXT PRIMARY KEY,
-					email TEXT UNIQUE NOT NULL,
-					password_hash TEXT NOT NULL,
-					name TEXT,
-					role TEXT DEFAULT 'viewer',
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL
-				);
-
-				-- Sessions table (for auth)
-				CREATE TABLE IF NOT EXISTS sessions (
-					id TEXT PRIMARY KEY,
-					user_id TEXT NOT NULL,
-					expires_at INTEGER NOT NULL,
-					created_at INTEGER NOT NULL,
-					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
-				CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
-			`,
+	},
+	{
+		version:     23,
+		description: "Add timezone column to domains",
+		rollback:    "ALTER TABLE domains DROP COLUMN timezone",
+		// IANA zone for the domain's daily report buckets; NULL uses the instance default
+		columns: []column{
+			{"domains", "timezone", "TEXT"},
 		},
-		{
-			version: 6,
-			sql: `
-				-- Domains table (for multi-site tracking)
-				CREATE TABLE IF NOT EXISTS domains (
-					id TEXT PRIMARY KEY,
-					name TEXT NOT NULL,
-					domain TEXT UNIQUE NOT NULL,
-					created_by TEXT,
-					created_at INTEGER NOT NULL,
-					is_active INTEGER DEFAULT 1,
-					FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_domains_domain ON domains(domain);
-				CREATE INDEX IF NOT EXISTS idx_domains_active ON domains(is_active);
-
-				-- Add setup_complete setting
-				INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
-					('setup_complete', 'false', strftime('%s', 'now') * 1000);
-			`,
+	},
+	{
+		version:     24,
+		description: "Add currency column to campaigns",
+		rollback:    "ALTER TABLE campaigns DROP COLUMN currency",
+		// ISO 4217 code of a campaign's costs, which are stored in its minor units
+		columns: []column{
+			{"campaigns", "currency", "TEXT NOT NULL DEFAULT 'USD'"},
 		},
-		{
-			version: 7,
-			sql: `
-				-- Add site_id to domains for tracking script authentication
-				ALTER TABLE domains ADD COLUMN site_id TEXT;
-
-				-- Generate site_id for existing domains
-				UPDATE domains SET site_id = 'site_' || lower(hex(randomblob(8))) WHERE site_id IS NULL;
-
-				-- Create unique index on site_id
-				CREATE UNIQUE INDEX IF NOT EXISTS idx_domains_site_id ON domains(site_id);
-			`,
+	},
+	{
+		version:     25,
+		description: "Add custom_dimensions column to domains",
+		rollback:    "ALTER TABLE domains DROP COLUMN custom_dimensions",
+		// JSON object of named dimensions to props keys, e.g. {"Plan": "plan"}
+		columns: []column{
+			{"domains", "custom_dimensions", "TEXT"},
 		},
-		{
-			version: 8,
-			sql: `
-				-- Bot detection columns
-				ALTER TABLE events ADD COLUMN bot_score INTEGER DEFAULT 0;
-				ALTER TABLE events ADD COLUMN bot_signals TEXT DEFAULT '[]';
-				ALTER TABLE events ADD COLUMN bot_category TEXT DEFAULT 'human';
-
-				-- Behavioral flags
-				ALTER TABLE events ADD COLUMN has_scroll INTEGER DEFAULT 0;
-				ALTER TABLE events ADD COLUMN has_mouse_move INTEGER DEFAULT 0;
-				ALTER TABLE events ADD COLUMN has_click INTEGER DEFAULT 0;
-				ALTER TABLE events ADD COLUMN has_touch INTEGER DEFAULT 0;
-
-				-- Click tracking for fraud detection
-				ALTER TABLE events ADD COLUMN click_x INTEGER;
-				ALTER TABLE events ADD COLUMN click_y INTEGER;
-				ALTER TABLE events ADD COLUMN page_duration INTEGER;
-
-				-- IP classification
-				ALTER TABLE events ADD COLUMN datacenter_ip INTEGER DEFAULT 0;
-				ALTER TABLE events ADD COLUMN ip_hash TEXT;
-
-				-- Indexes for bot filtering
-				CREATE INDEX IF NOT EXISTS idx_events_bot_category ON events(bot_category);
-				CREATE INDEX IF NOT EXISTS idx_events_bot_score ON events(bot_score);
-			`,
+	},
+	{
+		version:     26,
+		description: "Add consent_mode to domains and pre_consent_pageviews table",
+		rollback:    "DROP TABLE pre_consent_pageviews; ALTER TABLE domains DROP COLUMN consent_mode",
+		// What ingest keeps from events sent without analytics consent
+		columns: []column{
+			{"domains", "consent_mode", "TEXT NOT NULL DEFAULT 'off'"},
 		},
-		{
-			version: 9,
-			sql: `
-				-- Campaigns table for ad fraud detection
-				CREATE TABLE IF NOT EXISTS campaigns (
-					id TEXT PRIMARY KEY,
-					name TEXT NOT NULL,
-					utm_source TEXT,
-					utm_medium TEXT,
-					utm_campaign TEXT,
-					cpc REAL DEFAULT 0,
-					cpm REAL DEFAULT 0,
-					budget REAL DEFAULT 0,
-					start_date INTEGER,
-					end_date INTEGER,
-					created_at INTEGER NOT NULL
-				);
-
-				-- Visitor sessions table (materialized)
-				CREATE TABLE IF NOT EXISTS visitor_sessions (
-					id TEXT PRIMARY KEY,
-					session_id TEXT UNIQUE,
-					visitor_hash TEXT,
-					domain TEXT,
-					start_time INTEGER,
-					end_time INTEGER,
-					duration INTEGER,
-					pageviews INTEGER,
-					entry_url TEXT,
-					exit_url TEXT,
-					is_bounce INTEGER,
-					bot_score INTEGER,
-					bot_category TEXT
-				);
-
-				-- Indexes for campaigns and sessions
-				CREATE INDEX IF NOT EXISTS idx_campaigns_utm ON campaigns(utm_source, utm_medium, utm_campaign);
-				CREATE INDEX IF NOT EXISTS idx_visitor_sessions_session ON visitor_sessions(session_id);
-				CREATE INDEX IF NOT EXISTS idx_visitor_sessions_domain ON visitor_sessions(domain);
-				CREATE INDEX IF NOT EXISTS idx_visitor_sessions_bot ON visitor_sessions(bot_category);
-			`,
+		// Daily pageview counts per path, kept instead of events in the
+		// aggregate consent mode; timestamp is the start of the UTC day
+		sql: `
+			CREATE TABLE IF NOT EXISTS pre_consent_pageviews (
+				domain TEXT NOT NULL,
+				timestamp INTEGER NOT NULL,
+				path TEXT NOT NULL,
+				pageviews INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (domain, timestamp, path)
+			);
+		`,
+	},
+	{
+		version:     27,
+		description: "Create api_key_usage table",
+		rollback:    "DROP TABLE api_key_usage",
+		sql: `
+			-- Events ingested with each API key, per domain and UTC day
+			-- (YYYY-MM-DD). Written in batches, not per event.
+			CREATE TABLE IF NOT EXISTS api_key_usage (
+				key_id TEXT NOT NULL,
+				domain TEXT NOT NULL,
+				day TEXT NOT NULL,
+				events INTEGER NOT NULL DEFAULT 0,
+				last_used_at INTEGER NOT NULL,
+				PRIMARY KEY (key_id, domain, day)
+			);
+		`,
+	},
+	{
+		version:     28,
+		description: "Add event forwarding settings to domains",
+		rollback:    "ALTER TABLE domains DROP COLUMN forward_url; ALTER TABLE domains DROP COLUMN forward_fields",
+		// Where a domain's events are mirrored to, and which of their fields
+		// (a JSON array; NULL means the default set)
+		columns: []column{
+			{"domains", "forward_url", "TEXT"},
+			{"domains", "forward_fields", "TEXT"},
 		},
-		{
-			version: 10,
-			sql: `
-				-- Add geo coordinates for map plotting
-				ALTER TABLE events ADD COLUMN geo_latitude REAL;
-				ALTER TABLE events ADD COLUMN geo_longitude REAL;
-
-				-- Index for geo queries
-				CREATE INDEX IF NOT EXISTS idx_events_geo ON events(geo_latitude, geo_longitude);
-			`,
+	},
+	{
+		version:     29,
+		description: "Add path_patterns column to domains",
+		rollback:    "ALTER TABLE domains DROP COLUMN path_patterns",
+		// JSON array of path templates the pages report groups paths by,
+		// e.g. ["/products/:id"]
+		columns: []column{
+			{"domains", "path_patterns", "TEXT"},
 		},
-		{
-			version: 11,
-			sql: `
-				-- Add settings for configuration (replacing .env)
-				INSERT OR IGNORE INTO settings (key, value, updated_at) VALUES
-					('secret_key', '', strftime('%s', 'now') * 1000),
-					('maxmind_account_id', '', strftime('%s', 'now') * 1000),
-					('maxmind_license_key', '', strftime('%s', 'now') * 1000),
-					('geoip_path', './data/GeoLite2-City.mmdb', strftime('%s', 'now') * 1000),
-					('geoip_auto_update', 'false', strftime('%s', 'now') * 1000),
-					('geoip_last_updated', '', strftime('%s', 'now') * 1000),
-					('allowed_origins', '*', strftime('%s', 'now') * 1000),
-					('listen_addr', ':3456', strftime('%s', 'now') * 1000);
-			`,
+	},
+	{
+		version:     30,
+		description: "Add event_quota to domains and domain_usage table",
+		rollback:    "DROP TABLE domain_usage; ALTER TABLE domains DROP COLUMN event_quota",
+		// Events a domain may ingest per UTC month; NULL is unlimited
+		columns: []column{
+			{"domains", "event_quota", "INTEGER"},
 		},
-		{
-			version: 12,
-			sql: `
-				-- Composite index for the most common stat query pattern:
-				-- WHERE timestamp >= ? AND timestamp <= ? AND is_bot = 0 AND domain = ?
-				CREATE INDEX IF NOT EXISTS idx_events_ts_domain_bot
-					ON events(timestamp, domain, is_bot);
-
-				-- Composite index for pageview stats (adds event_type)
-				CREATE INDEX IF NOT EXISTS idx_events_ts_type_bot
-					ON events(timestamp, event_type, is_bot);
-
-				-- Composite for visitor_sessions queries
-				CREATE INDEX IF NOT EXISTS idx_vsessions_domain_start
-					ON visitor_sessions(domain, start_time);
-			`,
+		// Events ingested per domain and UTC month (YYYY-MM), for quotas.
+		// Kept apart from events, so retention doesn't lower them.
+		sql: `
+			CREATE TABLE IF NOT EXISTS domain_usage (
+				domain TEXT NOT NULL,
+				period TEXT NOT NULL,
+				events INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (domain, period)
+			);
+		`,
+	},
+	{
+		version:     31,
+		description: "Add retention_days to domains",
+		rollback:    "ALTER TABLE domains DROP COLUMN retention_days",
+		// Days of a domain's data kept, overriding the instance retention;
+		// NULL uses it
+		columns: []column{
+			{"domains", "retention_days", "INTEGER"},
 		},
-		{
-			version: 13,
-			sql: `
-				-- Consent tables
-				CREATE TABLE IF NOT EXISTS consent_configs (
-					id TEXT PRIMARY KEY,
-					domain_id TEXT NOT NULL,
-					version INTEGER NOT NULL DEFAULT 1,
-					is_active INTEGER NOT NULL DEFAULT 1,
-					categories TEXT NOT NULL DEFAULT '[]',
-					appearance TEXT NOT NULL DEFAULT '{}',
-					translations TEXT NOT NULL DEFAULT '{}',
-					cookie_name TEXT NOT NULL DEFAULT 'etiquetta_consent',
-					cookie_expiry_days INTEGER NOT NULL DEFAULT 365,
-					auto_language INTEGER NOT NULL DEFAULT 1,
-					geo_targeting TEXT NOT NULL DEFAULT '[]',
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL,
-					FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
-				);
-				CREATE INDEX idx_consent_configs_domain ON consent_configs(domain_id);
-				CREATE UNIQUE INDEX idx_consent_configs_version ON consent_configs(domain_id, version);
-
-				CREATE TABLE IF NOT EXISTS consent_records (
-					id TEXT PRIMARY KEY,
-					domain_id TEXT NOT NULL,
-					visitor_hash TEXT NOT NULL,
-					ip_hash TEXT,
-					categories TEXT NOT NULL DEFAULT '{}',
-					config_version INTEGER NOT NULL,
-					action TEXT NOT NULL,
-					user_agent TEXT,
-					geo_country TEXT,
-					timestamp INTEGER NOT NULL,
-					FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
-				);
-				CREATE INDEX idx_consent_records_domain_ts ON consent_records(domain_id, timestamp);
-				CREATE INDEX idx_consent_records_visitor ON consent_records(visitor_hash);
-
-				-- Tag Manager tables
-				CREATE TABLE IF NOT EXISTS tm_containers (
-					id TEXT PRIMARY KEY,
-					domain_id TEXT NOT NULL,
-					name TEXT NOT NULL,
-					published_version INTEGER DEFAULT 0,
-					draft_version INTEGER DEFAULT 1,
-					published_at INTEGER,
-					published_by TEXT,
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL,
-					FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE,
-					UNIQUE(domain_id)
-				);
-
-				CREATE TABLE IF NOT EXISTS tm_tags (
-					id TEXT PRIMARY KEY,
-					container_id TEXT NOT NULL,
-					name TEXT NOT NULL,
-					tag_type TEXT NOT NULL,
-					config TEXT NOT NULL DEFAULT '{}',
-					consent_category TEXT NOT NULL DEFAULT 'marketing',
-					priority INTEGER DEFAULT 0,
-					is_enabled INTEGER DEFAULT 1,
-					version INTEGER DEFAULT 1,
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL,
-					FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
-				);
-				CREATE INDEX idx_tm_tags_container ON tm_tags(container_id);
-
-				CREATE TABLE IF NOT EXISTS tm_triggers (
-					id TEXT PRIMARY KEY,
-					container_id TEXT NOT NULL,
-					name TEXT NOT NULL,
-					trigger_type TEXT NOT NULL,
-					config TEXT NOT NULL DEFAULT '{}',
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL,
-					FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
-				);
-				CREATE INDEX idx_tm_triggers_container ON tm_triggers(container_id);
-
-				CREATE TABLE IF NOT EXISTS tm_tag_triggers (
-					tag_id TEXT NOT NULL,
-					trigger_id TEXT NOT NULL,
-					is_exception INTEGER DEFAULT 0,
-					PRIMARY KEY (tag_id, trigger_id),
-					FOREIGN KEY (tag_id) REFERENCES tm_tags(id) ON DELETE CASCADE,
-					FOREIGN KEY (trigger_id) REFERENCES tm_triggers(id) ON DELETE CASCADE
-				);
-
-				CREATE TABLE IF NOT EXISTS tm_variables (
-					id TEXT PRIMARY KEY,
-					container_id TEXT NOT NULL,
-					name TEXT NOT NULL,
-					variable_type TEXT NOT NULL,
-					config TEXT NOT NULL DEFAULT '{}',
-					created_at INTEGER NOT NULL,
-					updated_at INTEGER NOT NULL,
-					FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE
-				);
-				CREATE INDEX idx_tm_variables_container ON tm_variables(container_id);
-
-				CREATE TABLE IF NOT EXISTS tm_snapshots (
-					id TEXT PRIMARY KEY,
-					container_id TEXT NOT NULL,
-					version INTEGER NOT NULL,
-					snapshot TEXT NOT NULL,
-					published_by TEXT,
-					published_at INTEGER NOT NULL,
-					FOREIGN KEY (container_id) REFERENCES tm_containers(id) ON DELETE CASCADE,
-					UNIQUE(container_id, version)
-				);
-			`,
+	},
+	{
+		version:     32,
+		description: "Add asn_org to events",
+		rollback:    "ALTER TABLE events DROP COLUMN asn_org",
+		// Organization of the visitor's network, from the optional ASN
+		// database; NULL without it
+		columns: []column{
+			{"events", "asn_org", "TEXT"},
 		},
-		{
-			version: 14,
-			sql: `
-				-- Admin audit log for GDPR compliance
-				CREATE TABLE IF NOT EXISTS audit_log (
-					id TEXT PRIMARY KEY,
-					timestamp INTEGER NOT NULL,
-					user_id TEXT NOT NULL,
-					user_email TEXT NOT NULL,
-					action TEXT NOT NULL,
-					resource_type TEXT NOT NULL,
-					resource_id TEXT,
-					detail TEXT,
-					ip_address TEXT
-				);
-
-				CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
-				CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id);
-				CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
-				CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_type, resource_id);
-			`,
+	},
+	{
+		version:     33,
+		description: "Add extended metrics to performance",
+		rollback:    "ALTER TABLE performance DROP COLUMN tti; ALTER TABLE performance DROP COLUMN tbt; ALTER TABLE performance DROP COLUMN long_tasks; ALTER TABLE performance DROP COLUMN resource_count; ALTER TABLE performance DROP COLUMN resource_bytes",
+		// Sent by trackers with track_extended_performance on; NULL otherwise
+		columns: []column{
+			{"performance", "tti", "REAL"},
+			{"performance", "tbt", "REAL"},
+			{"performance", "long_tasks", "INTEGER"},
+			{"performance", "resource_count", "INTEGER"},
+			{"performance", "resource_bytes", "INTEGER"},
 		},
+	},
+	{
+		version:     34,
+		description: "Create api_keys table",
+		rollback:    "DROP TABLE api_keys",
+		sql: `
+			-- Keys for scripted API access, acting as their user. Only a hash
+			-- of the key is stored; the key itself is shown once.
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL,
+				key_hash TEXT UNIQUE NOT NULL,
+				key_prefix TEXT NOT NULL,
+				created_at INTEGER NOT NULL,
+				last_used_at INTEGER,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+		`,
+	},
+}
+
+// LatestVersion returns the highest migration version known to this binary
+func LatestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// ensureMigrationsTable creates the migrations bookkeeping table
+func (db *DB) ensureMigrationsTable() error {
+	_, err := db.conn.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations (
+			id INTEGER PRIMARY KEY,
+			version INTEGER UNIQUE NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	// Databases created before checksums were recorded lack this column
+	if err := addColumnIfMissing(db.conn, column{"migrations", "checksum", "TEXT"}); err != nil {
+		return fmt.Errorf("failed to add checksum to migrations table: %w", err)
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// columnExists reports whether table has a column with the given name
+func columnExists(q execer, table, name string) (bool, error) {
+	rows, err := q.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var colName string
+		if err := rows.Scan(&colName); err != nil {
+			return false, err
+		}
+		if strings.EqualFold(colName, name) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumnIfMissing adds a column unless the table already has it
+func addColumnIfMissing(q execer, c column) error {
+	exists, err := columnExists(q, c.table, c.name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err = q.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition))
+	return err
+}
+
+// appliedChecksums returns the recorded checksum for each applied migration.
+// Migrations applied before checksums were tracked map to an empty string.
+func (db *DB) appliedChecksums() (map[int]string, error) {
+	rows, err := db.conn.Query("SELECT version, COALESCE(checksum, '') FROM migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var sum string
+		if err := rows.Scan(&version, &sum); err != nil {
+			return nil, err
+		}
+		applied[version] = sum
+	}
+	return applied, rows.Err()
+}
+
+// verifyChecksums compares applied migrations against this binary's definitions.
+// Missing checksums (from older releases) are backfilled; mismatches are logged
+// as drift and returned, but don't block startup.
+func (db *DB) verifyChecksums() ([]int, error) {
+	applied, err := db.appliedChecksums()
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []int
+	for _, m := range migrations {
+		recorded, ok := applied[m.version]
+		if !ok {
+			continue
+		}
+
+		sum := m.checksum()
+		if recorded == "" {
+			if _, err := db.conn.Exec("UPDATE migrations SET checksum = ? WHERE version = ?", sum, m.version); err != nil {
+				return nil, fmt.Errorf("failed to record checksum for migration %d: %w", m.version, err)
+			}
+			continue
+		}
+
+		if recorded != sum {
+			drifted = append(drifted, m.version)
+			log.Printf("[migrate] WARNING: migration %d (%s) differs from the version applied to this database; the schema may have drifted", m.version, m.description)
+		}
+	}
+	return drifted, nil
+}
+
+// SchemaVersion returns the highest applied migration version (0 for a fresh database)
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var currentVersion int
+	if err := db.conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM migrations").Scan(&currentVersion); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return currentVersion, nil
+}
+
+// AppliedVersion returns the schema version Migrate last left the database
+// at, without querying it; 0 before Migrate runs. For health probes.
+func (db *DB) AppliedVersion() int {
+	return int(db.appliedVersion.Load())
+}
+
+// SetMigrationFailure records a migration that failed at startup, after
+// which the server only serves reads. Call it before serving requests.
+func (db *DB) SetMigrationFailure(err *MigrationError) {
+	db.migrationFailure = err
+}
+
+// MigrationFailure returns the migration that failed at startup, or nil
+func (db *DB) MigrationFailure() *MigrationError {
+	return db.migrationFailure
+}
+
+// MigrationStatus lists all known migrations with their applied state
+func (db *DB) MigrationStatus() ([]MigrationInfo, error) {
+	currentVersion, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.appliedChecksums()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]MigrationInfo, 0, len(migrations))
+	for _, m := range migrations {
+		recorded, ok := applied[m.version]
+		infos = append(infos, MigrationInfo{
+			Version:     m.version,
+			Description: m.description,
+			Rollback:    m.rollback,
+			Applied:     m.version <= currentVersion,
+			Drifted:     ok && recorded != "" && recorded != m.checksum(),
+		})
+	}
+	return infos, nil
+}
+
+// PendingMigrations returns the migrations that Migrate would apply
+func (db *DB) PendingMigrations() ([]MigrationInfo, error) {
+	currentVersion, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if currentVersion > LatestVersion() {
+		return nil, fmt.Errorf("%w (database at v%d, binary knows up to v%d)", ErrSchemaTooNew, currentVersion, LatestVersion())
+	}
+
+	var pending []MigrationInfo
+	for _, m := range migrations {
+		if m.version > currentVersion {
+			pending = append(pending, MigrationInfo{Version: m.version, Description: m.description, Rollback: m.rollback})
+		}
+	}
+	return pending, nil
+}
+
+// Migrate runs database migrations.
+// It refuses to run against a database whose schema is newer than this binary,
+// since older code would silently issue incompatible queries.
+func (db *DB) Migrate() error {
+	currentVersion, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	db.appliedVersion.Store(int64(currentVersion))
+
+	if currentVersion > LatestVersion() {
+		return fmt.Errorf("%w (database at v%d, binary knows up to v%d); upgrade etiquetta or restore a backup", ErrSchemaTooNew, currentVersion, LatestVersion())
+	}
+
+	if _, err := db.verifyChecksums(); err != nil {
+		return err
 	}
 
 	for _, m := range migrations {
@@ -467,24 +1075,33 @@ func (db *DB) Migrate() error {
 
 		tx, err := db.conn.Begin()
 		if err != nil {
-			return fmt.Errorf("failed to begin transaction for migration %d: %w", m.version, err)
+			return m.failed("begin a transaction", err)
 		}
 
-		_, err = tx.Exec(m.sql)
-		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to run migration %d: %w", m.version, err)
+		for _, c := range m.columns {
+			if err := addColumnIfMissing(tx, c); err != nil {
+				tx.Rollback()
+				return m.failed(fmt.Sprintf("add column %s.%s", c.table, c.name), err)
+			}
+		}
+
+		if m.sql != "" {
+			if _, err := tx.Exec(m.sql); err != nil {
+				tx.Rollback()
+				return m.failed("run its SQL", err)
+			}
 		}
 
-		_, err = tx.Exec("INSERT INTO migrations (version, applied_at) VALUES (?, strftime('%s', 'now') * 1000)", m.version)
+		_, err = tx.Exec("INSERT INTO migrations (version, applied_at, checksum) VALUES (?, strftime('%s', 'now') * 1000, ?)", m.version, m.checksum())
 		if err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+			return m.failed("record its version", err)
 		}
 
 		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+			return m.failed("commit", err)
 		}
+		db.appliedVersion.Store(int64(m.version))
 	}
 
 	return nil
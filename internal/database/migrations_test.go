@@ -0,0 +1,16 @@
+package database
+
+import "testing"
+
+func TestAppliedVersionIsCached(t *testing.T) {
+	db := newTestDB(t)
+	if got := db.AppliedVersion(); got != LatestVersion() {
+		t.Fatalf("AppliedVersion after Migrate = %d, want %d", got, LatestVersion())
+	}
+
+	// Health probes read the cache, so they don't need the database at all
+	db.Conn().Close()
+	if got := db.AppliedVersion(); got != LatestVersion() {
+		t.Errorf("AppliedVersion with the database closed = %d, want %d", got, LatestVersion())
+	}
+}
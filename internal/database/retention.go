@@ -0,0 +1,50 @@
+package database
+
+// Settings keys for per-table retention, in days
+const (
+	RetentionEventsKey      = "retention_days_events"
+	RetentionPerformanceKey = "retention_days_performance"
+	RetentionErrorsKey      = "retention_days_errors"
+)
+
+// UnlimitedRetentionDays is the retention used when the license imposes no limit
+const UnlimitedRetentionDays = 365 * 10
+
+// RetentionPolicy is the number of days of data kept per table.
+// A value <= 0 disables cleanup for that table.
+type RetentionPolicy struct {
+	Events      int `json:"events"`
+	Performance int `json:"performance"`
+	Errors      int `json:"errors"`
+}
+
+// NewRetentionPolicy resolves per-table retention from settings, capped at the
+// license limit maxDays (-1 for unlimited). Events default to the cap;
+// performance and errors default to the events retention.
+func NewRetentionPolicy(maxDays int, getInt func(key string, defaultValue int) int) RetentionPolicy {
+	events := ClampRetentionDays(getInt(RetentionEventsKey, maxDays), maxDays)
+	return RetentionPolicy{
+		Events:      events,
+		Performance: ClampRetentionDays(getInt(RetentionPerformanceKey, events), maxDays),
+		Errors:      ClampRetentionDays(getInt(RetentionErrorsKey, events), maxDays),
+	}
+}
+
+// ClampRetentionDays caps days at the license limit maxDays (-1 for
+// unlimited); days <= 0 read as the limit
+func ClampRetentionDays(days, maxDays int) int {
+	if maxDays == -1 {
+		maxDays = UnlimitedRetentionDays
+	}
+	if days <= 0 || days > maxDays {
+		return maxDays
+	}
+	return days
+}
+
+// DomainRetentionPolicy is the policy for a domain with a retention_days
+// override, which applies to all of its tables, capped at the license limit
+func DomainRetentionPolicy(days, maxDays int) RetentionPolicy {
+	days = ClampRetentionDays(days, maxDays)
+	return RetentionPolicy{Events: days, Performance: days, Errors: days}
+}
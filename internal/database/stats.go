@@ -0,0 +1,196 @@
+package database
+
+import "sort"
+
+// TableStats describes row count and storage used by a table
+type TableStats struct {
+	Name       string `json:"name"`
+	Rows       int64  `json:"rows"`
+	SizeBytes  int64  `json:"size_bytes"`  // table data plus its indexes
+	IndexBytes int64  `json:"index_bytes"` // portion of SizeBytes used by indexes
+	Oldest     *int64 `json:"oldest,omitempty"`
+	Newest     *int64 `json:"newest,omitempty"`
+}
+
+// DomainStats describes the tracking data stored for a single domain
+type DomainStats struct {
+	Domain         string `json:"domain"`
+	Events         int64  `json:"events"`
+	Performance    int64  `json:"performance"`
+	Errors         int64  `json:"errors"`
+	EstimatedBytes int64  `json:"estimated_bytes"`
+	Oldest         *int64 `json:"oldest,omitempty"`
+	Newest         *int64 `json:"newest,omitempty"`
+}
+
+// StorageStats is a breakdown of database usage
+type StorageStats struct {
+	PageSize   int64         `json:"page_size"`
+	TotalBytes int64         `json:"total_bytes"`
+	FreeBytes  int64         `json:"free_bytes"`
+	Exact      bool          `json:"exact"` // false when sizes are estimated from row counts
+	Tables     []TableStats  `json:"tables"`
+	Domains    []DomainStats `json:"domains"`
+
+	// Tracking rows dated more than MaxClockSkew ahead, e.g. written while
+	// the server clock was wrong; they stay out of stats until their time
+	FutureRows int64 `json:"future_rows"`
+}
+
+// domainTables are the per-domain tracking tables included in DomainStats
+var domainTables = []string{"events", "performance", "errors"}
+
+// GetStorageStats returns row counts, sizes and time ranges per table and per domain.
+// Sizes come from the dbstat virtual table when available; otherwise the file
+// size is apportioned by row count.
+func (db *DB) GetStorageStats() (*StorageStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := &StorageStats{Exact: true}
+
+	var pageCount, freePages int64
+	db.conn.QueryRow("PRAGMA page_size").Scan(&stats.PageSize)
+	db.conn.QueryRow("PRAGMA page_count").Scan(&pageCount)
+	db.conn.QueryRow("PRAGMA freelist_count").Scan(&freePages)
+	stats.TotalBytes = pageCount * stats.PageSize
+	stats.FreeBytes = freePages * stats.PageSize
+
+	// Map every table and index to the table it belongs to
+	rows, err := db.conn.Query("SELECT name, tbl_name, type FROM sqlite_master WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, err
+	}
+	owner := make(map[string]string)
+	isIndex := make(map[string]bool)
+	var tables []string
+	for rows.Next() {
+		var name, tblName, objType string
+		if err := rows.Scan(&name, &tblName, &objType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		owner[name] = tblName
+		if objType == "table" {
+			tables = append(tables, name)
+		} else {
+			isIndex[name] = true
+		}
+	}
+	rows.Close()
+
+	byTable := make(map[string]*TableStats, len(tables))
+	for _, table := range tables {
+		ts := &TableStats{Name: table}
+		db.conn.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&ts.Rows)
+
+		if ok, _ := columnExists(db.conn, table, "timestamp"); ok {
+			var oldest, newest *int64
+			db.conn.QueryRow("SELECT MIN(timestamp), MAX(timestamp) FROM "+table).Scan(&oldest, &newest)
+			ts.Oldest, ts.Newest = oldest, newest
+		}
+		byTable[table] = ts
+	}
+
+	if sizeRows, err := db.conn.Query("SELECT name, SUM(pgsize) FROM dbstat GROUP BY name"); err == nil {
+		for sizeRows.Next() {
+			var name string
+			var size int64
+			if err := sizeRows.Scan(&name, &size); err != nil {
+				continue
+			}
+			if ts, ok := byTable[owner[name]]; ok {
+				ts.SizeBytes += size
+				if isIndex[name] {
+					ts.IndexBytes += size
+				}
+			}
+		}
+		sizeRows.Close()
+	} else {
+		// dbstat isn't compiled in: spread the used space by row count
+		stats.Exact = false
+		var totalRows int64
+		for _, ts := range byTable {
+			totalRows += ts.Rows
+		}
+		if totalRows > 0 {
+			used := stats.TotalBytes - stats.FreeBytes
+			for _, ts := range byTable {
+				ts.SizeBytes = used * ts.Rows / totalRows
+			}
+		}
+	}
+
+	for _, table := range tables {
+		stats.Tables = append(stats.Tables, *byTable[table])
+	}
+	sort.Slice(stats.Tables, func(i, j int) bool {
+		return stats.Tables[i].SizeBytes > stats.Tables[j].SizeBytes
+	})
+
+	stats.Domains = db.domainStorageStats(byTable)
+	stats.FutureRows = db.countFutureRows()
+	return stats, nil
+}
+
+// domainStorageStats counts tracking rows per domain and estimates their
+// size as rows × the table's average row size
+func (db *DB) domainStorageStats(byTable map[string]*TableStats) []DomainStats {
+	domains := make(map[string]*DomainStats)
+	get := func(domain string) *DomainStats {
+		ds, ok := domains[domain]
+		if !ok {
+			ds = &DomainStats{Domain: domain}
+			domains[domain] = ds
+		}
+		return ds
+	}
+
+	for _, table := range domainTables {
+		ts, ok := byTable[table]
+		if !ok || ts.Rows == 0 {
+			continue
+		}
+		avgRowBytes := ts.SizeBytes / ts.Rows
+
+		rows, err := db.conn.Query("SELECT domain, COUNT(*), MIN(timestamp), MAX(timestamp) FROM " + table + " GROUP BY domain")
+		if err != nil {
+			continue
+		}
+		for rows.Next() {
+			var domain string
+			var count, oldest, newest int64
+			if err := rows.Scan(&domain, &count, &oldest, &newest); err != nil {
+				continue
+			}
+
+			ds := get(domain)
+			switch table {
+			case "events":
+				ds.Events = count
+			case "performance":
+				ds.Performance = count
+			case "errors":
+				ds.Errors = count
+			}
+			ds.EstimatedBytes += count * avgRowBytes
+			if ds.Oldest == nil || oldest < *ds.Oldest {
+				ds.Oldest = &oldest
+			}
+			if ds.Newest == nil || newest > *ds.Newest {
+				ds.Newest = &newest
+			}
+		}
+		rows.Close()
+	}
+
+	result := make([]DomainStats, 0, len(domains))
+	for _, ds := range domains {
+		result = append(result, *ds)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].EstimatedBytes > result[j].EstimatedBytes
+	})
+	return result
+}
@@ -0,0 +1,291 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/settings"
+)
+
+const resendURL = "https://api.resend.com/emails"
+
+var (
+	ErrDisabled        = errors.New("email provider is disabled")
+	ErrUnknownProvider = errors.New("unknown email provider")
+	ErrNoSTARTTLS      = errors.New("SMTP server does not offer STARTTLS; turn off smtp_use_tls to send without encryption")
+)
+
+// Message is an email with a plain-text body, an HTML body or both
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	HTML    string // sent alongside Body when both are set; clients pick one
+}
+
+// Sender delivers email via the configured provider (smtp or resend)
+type Sender struct {
+	Provider    string
+	FromAddress string
+	SMTPHost    string
+	SMTPPort    int
+	SMTPUser    string
+	SMTPPass    string
+	SMTPUseTLS  bool
+	ResendKey   string
+}
+
+// NewFromSettings builds a Sender from the email settings
+func NewFromSettings(svc *settings.Service) *Sender {
+	smtpUser, _ := svc.Get("smtp_username")
+	smtpPass, _ := svc.Get("smtp_password")
+	resendKey, _ := svc.Get("resend_api_key")
+
+	return &Sender{
+		Provider:    svc.GetWithDefault("email_provider", "disabled"),
+		FromAddress: svc.GetWithDefault("email_from_address", "etiquetta@localhost"),
+		SMTPHost:    svc.GetWithDefault("smtp_host", ""),
+		SMTPPort:    svc.GetInt("smtp_port", 587),
+		SMTPUser:    smtpUser,
+		SMTPPass:    smtpPass,
+		SMTPUseTLS:  svc.GetBool("smtp_use_tls", true),
+		ResendKey:   resendKey,
+	}
+}
+
+// Enabled reports whether an email provider is configured
+func (s *Sender) Enabled() bool {
+	return s.Provider != "" && s.Provider != "disabled"
+}
+
+// Send delivers an HTML email using the configured provider
+func (s *Sender) Send(to, subject, htmlBody string) error {
+	return s.SendMessage(&Message{To: to, Subject: subject, HTML: htmlBody})
+}
+
+// SendMessage delivers a message, with its plain-text and/or HTML body,
+// using the configured provider
+func (s *Sender) SendMessage(msg *Message) error {
+	switch s.Provider {
+	case "", "disabled":
+		return ErrDisabled
+	case "smtp":
+		return s.sendSMTP(msg)
+	case "resend":
+		return s.sendResend(msg)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, s.Provider)
+	}
+}
+
+// Check verifies that the configured provider can be reached without
+// sending anything. For SMTP it connects, upgrades to TLS and
+// authenticates; for Resend it checks that a key is set and the API
+// answers, since the key itself can only be proven by sending.
+func (s *Sender) Check() error {
+	switch s.Provider {
+	case "", "disabled":
+		return ErrDisabled
+	case "smtp":
+		client, err := s.connectSMTP()
+		if err != nil {
+			return err
+		}
+		return client.Quit()
+	case "resend":
+		if s.ResendKey == "" {
+			return errors.New("Resend API key is not configured")
+		}
+		client := &http.Client{Timeout: 15 * time.Second}
+		resp, err := client.Head(resendURL)
+		if err != nil {
+			return fmt.Errorf("failed to reach Resend: %w", err)
+		}
+		resp.Body.Close()
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownProvider, s.Provider)
+	}
+}
+
+// sendSMTP delivers a message through an SMTP server
+func (s *Sender) sendSMTP(msg *Message) error {
+	client, err := s.connectSMTP()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(s.FromAddress); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(buildMIME(s.FromAddress, msg)); err != nil {
+		wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// connectSMTP opens an authenticated session with the SMTP server.
+// Port 465 uses implicit TLS; other ports upgrade via STARTTLS when enabled.
+func (s *Sender) connectSMTP() (*smtp.Client, error) {
+	if s.SMTPHost == "" {
+		return nil, errors.New("SMTP host is not configured")
+	}
+
+	addr := net.JoinHostPort(s.SMTPHost, strconv.Itoa(s.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: s.SMTPHost}
+
+	var client *smtp.Client
+	if s.SMTPPort == 465 {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+		}
+		client, err = smtp.NewClient(conn, s.SMTPHost)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start SMTP session: %w", err)
+		}
+	} else {
+		conn, err := net.DialTimeout("tcp", addr, 15*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+		}
+		client, err = smtp.NewClient(conn, s.SMTPHost)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start SMTP session: %w", err)
+		}
+		// Never fall back to plaintext when TLS is on: credentials and
+		// mail would cross the network readable
+		if s.SMTPUseTLS {
+			if ok, _ := client.Extension("STARTTLS"); !ok {
+				client.Close()
+				return nil, ErrNoSTARTTLS
+			}
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		}
+	}
+
+	if s.SMTPUser != "" {
+		auth := smtp.PlainAuth("", s.SMTPUser, s.SMTPPass, s.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// sendResend delivers a message through the Resend HTTP API
+func (s *Sender) sendResend(msg *Message) error {
+	if s.ResendKey == "" {
+		return errors.New("Resend API key is not configured")
+	}
+
+	fields := map[string]interface{}{
+		"from":    s.FromAddress,
+		"to":      []string{msg.To},
+		"subject": msg.Subject,
+	}
+	if msg.Body != "" {
+		fields["text"] = msg.Body
+	}
+	if msg.HTML != "" {
+		fields["html"] = msg.HTML
+	}
+	payload, _ := json.Marshal(fields)
+
+	req, err := http.NewRequest("POST", resendURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.ResendKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Resend: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Resend returned status: %s", resp.Status)
+	}
+	return nil
+}
+
+// buildMIME renders the message headers and body, as multipart/alternative
+// when it has both a plain-text and an HTML version. The subject is RFC 2047
+// encoded, so non-ASCII text survives.
+func buildMIME(from string, msg *Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + headerValue(from) + "\r\n")
+	b.WriteString("To: " + headerValue(msg.To) + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerValue(msg.Subject)) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" || msg.Body == "" {
+		contentType, body := "text/plain", msg.Body
+		if msg.Body == "" {
+			contentType, body = "text/html", msg.HTML
+		}
+		b.WriteString("Content-Type: " + contentType + "; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(crlf(body))
+		return []byte(b.String())
+	}
+
+	boundary := "etiquetta-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	b.WriteString("Content-Type: multipart/alternative; boundary=\"" + boundary + "\"\r\n")
+	b.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Body},
+		{"text/html", msg.HTML},
+	} {
+		b.WriteString("--" + boundary + "\r\n")
+		b.WriteString("Content-Type: " + part.contentType + "; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(crlf(part.body) + "\r\n")
+	}
+	b.WriteString("--" + boundary + "--\r\n")
+	return []byte(b.String())
+}
+
+// crlf converts line endings to the CRLF mail requires
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// headerValue strips line breaks to prevent header injection
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,107 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBuildMIMEEncodesSubject(t *testing.T) {
+	subject := "Relatório semanal: ünïcödé 📈"
+	raw := string(buildMIME("etiquetta@example.com", &Message{To: "a@example.com", Subject: subject, HTML: "<p>Olá</p>"}))
+
+	header, _, _ := strings.Cut(raw, "\r\n\r\n")
+	var encoded string
+	for _, line := range strings.Split(header, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "Subject: "); ok {
+			encoded = v
+		}
+	}
+	for _, r := range encoded {
+		if r > 127 {
+			t.Fatalf("Subject header %q isn't ASCII", encoded)
+		}
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(encoded)
+	if err != nil || decoded != subject {
+		t.Errorf("Subject decodes to %q (%v), want %q", decoded, err, subject)
+	}
+	if !strings.Contains(header, "Content-Type: text/html; charset=UTF-8") {
+		t.Errorf("HTML-only message isn't sent as text/html:\n%s", header)
+	}
+}
+
+// fakeSMTP answers EHLO without offering STARTTLS and reports whether the
+// client sent AUTH
+func fakeSMTP(t *testing.T) (port int, sawAuth chan bool) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sawAuth = make(chan bool, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 fake ESMTP\r\n"))
+		auth := false
+		defer func() { sawAuth <- auth }()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO":
+				conn.Write([]byte("250-fake\r\n250 AUTH PLAIN\r\n"))
+			case "AUTH":
+				auth = true
+				conn.Write([]byte("235 ok\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, sawAuth
+}
+
+func TestSMTPRequiresSTARTTLSWhenTLSIsOn(t *testing.T) {
+	port, sawAuth := fakeSMTP(t)
+	s := &Sender{
+		Provider:    "smtp",
+		FromAddress: "etiquetta@example.com",
+		SMTPHost:    "127.0.0.1",
+		SMTPPort:    port,
+		SMTPUser:    "user",
+		SMTPPass:    "secret",
+		SMTPUseTLS:  true,
+	}
+
+	err := s.Send("a@example.com", "Hi", "<p>Hi</p>")
+	if !errors.Is(err, ErrNoSTARTTLS) {
+		t.Errorf("Send = %v, want %v", err, ErrNoSTARTTLS)
+	}
+	if <-sawAuth {
+		t.Error("credentials were sent without TLS")
+	}
+}
+
+func TestSMTPPlaintextWhenTLSIsOff(t *testing.T) {
+	port, _ := fakeSMTP(t)
+	s := &Sender{Provider: "smtp", FromAddress: "etiquetta@example.com", SMTPHost: "127.0.0.1", SMTPPort: port}
+	if err := s.Check(); err != nil {
+		t.Errorf("Check with smtp_use_tls off = %v, want nil", err)
+	}
+}
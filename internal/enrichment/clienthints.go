@@ -0,0 +1,300 @@
+package enrichment
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ClientHintHeaders are the User-Agent Client Hints requested from browsers.
+// The low-entropy hints (Sec-CH-UA, -Mobile, -Platform) are sent by default;
+// the rest must be requested via Accept-CH.
+var ClientHintHeaders = []string{
+	"Sec-CH-UA",
+	"Sec-CH-UA-Mobile",
+	"Sec-CH-UA-Platform",
+	"Sec-CH-UA-Platform-Version",
+	"Sec-CH-UA-Full-Version-List",
+	"Sec-CH-UA-Model",
+}
+
+// ClientHints holds the parsed Sec-CH-UA-* request headers
+type ClientHints struct {
+	Brands          []Brand
+	FullVersions    []Brand
+	Mobile          *bool
+	Platform        string
+	PlatformVersion string
+	Model           string
+}
+
+// Brand is a single entry of a Sec-CH-UA brand list
+type Brand struct {
+	Name    string
+	Version string
+}
+
+// brandNames maps client-hint brands to the names the UA parser reports
+var brandNames = map[string]string{
+	"Google Chrome":    "Chrome",
+	"Microsoft Edge":   "Edge",
+	"Opera":            "Opera",
+	"Opera GX":         "Opera",
+	"Brave":            "Brave",
+	"Vivaldi":          "Vivaldi",
+	"Samsung Internet": "Samsung Internet",
+	"YaBrowser":        "Yandex Browser",
+	"Yandex":           "Yandex Browser",
+	"Chromium":         "Chromium",
+}
+
+// ClientHintsFromHeaders parses client hints from a header map.
+// Returns nil when the browser sent no hints.
+func ClientHintsFromHeaders(headers map[string]string) *ClientHints {
+	if headers == nil {
+		return nil
+	}
+
+	hints := &ClientHints{
+		Brands:          parseBrandList(headers["Sec-CH-UA"]),
+		FullVersions:    parseBrandList(headers["Sec-CH-UA-Full-Version-List"]),
+		Platform:        unquoteHint(headers["Sec-CH-UA-Platform"]),
+		PlatformVersion: unquoteHint(headers["Sec-CH-UA-Platform-Version"]),
+		Model:           unquoteHint(headers["Sec-CH-UA-Model"]),
+	}
+
+	switch strings.TrimSpace(headers["Sec-CH-UA-Mobile"]) {
+	case "?1":
+		mobile := true
+		hints.Mobile = &mobile
+	case "?0":
+		mobile := false
+		hints.Mobile = &mobile
+	}
+
+	if len(hints.Brands) == 0 && len(hints.FullVersions) == 0 && hints.Platform == "" && hints.Mobile == nil {
+		return nil
+	}
+	return hints
+}
+
+// Browser returns the most specific real brand and its version.
+// GREASE entries ("Not A;Brand") are ignored and "Chromium" is only used when
+// nothing more specific is present.
+func (c *ClientHints) Browser() (name, version string) {
+	list := c.FullVersions
+	if len(list) == 0 {
+		list = c.Brands
+	}
+
+	for _, b := range list {
+		if isGreaseBrand(b.Name) || b.Name == "Chromium" {
+			continue
+		}
+		if mapped, ok := brandNames[b.Name]; ok {
+			return mapped, b.Version
+		}
+		return b.Name, b.Version
+	}
+	for _, b := range list {
+		if b.Name == "Chromium" {
+			return "Chromium", b.Version
+		}
+	}
+	return "", ""
+}
+
+// OS returns the platform name, with the major version where it's meaningful
+func (c *ClientHints) OS() string {
+	if c.Platform == "" || c.Platform == "Unknown" {
+		return ""
+	}
+
+	major := c.PlatformVersion
+	if i := strings.Index(major, "."); i >= 0 {
+		major = major[:i]
+	}
+
+	switch c.Platform {
+	case "Windows":
+		// Sec-CH-UA-Platform-Version 13+ is Windows 11, 1-10 is Windows 10
+		if n, err := strconv.Atoi(major); err == nil && n > 0 {
+			if n >= 13 {
+				return "Windows 11"
+			}
+			return "Windows 10"
+		}
+		return "Windows"
+	case "Android", "macOS", "iOS":
+		if major != "" && major != "0" {
+			return c.Platform + " " + major
+		}
+	}
+	return c.Platform
+}
+
+// applyClientHints refines a parsed user agent with client hints, which are
+// more reliable than frozen UA strings
+func applyClientHints(result *UAResult, hints *ClientHints) {
+	if hints == nil {
+		return
+	}
+
+	if name, version := hints.Browser(); name != "" {
+		result.BrowserName = name
+		result.BrowserVersion = version
+	}
+
+	if osName := hints.OS(); osName != "" {
+		result.OSName = osName
+		result.OSVersion = hints.PlatformVersion
+	}
+
+	// Bots, TVs and tablets are classified from the UA; the mobile hint only
+	// settles the phone vs desktop question
+	if hints.Mobile != nil {
+		switch {
+		case *hints.Mobile && (result.DeviceType == DeviceDesktop || result.DeviceType == DeviceUnknown):
+			result.DeviceType = DeviceMobile
+		case !*hints.Mobile && result.DeviceType == DeviceMobile:
+			result.DeviceType = DeviceDesktop
+		}
+	}
+}
+
+// parseBrandList parses a structured-field list (RFC 8941) of brands such as
+// `"Chromium";v="120", "Google Chrome";v="120", "Not_A Brand";v="8"`.
+// Names are quoted strings, and Chromium's GREASE brands put list and
+// parameter delimiters inside them ("Not;A=Brand", "Not)A;Brand"), so the
+// header can't simply be split on ',' and ';'. Malformed items are skipped.
+func parseBrandList(header string) []Brand {
+	var brands []Brand
+	p := &sfParser{s: header}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return brands
+		}
+
+		name, ok := p.bareItem()
+		brand := Brand{Name: name}
+		for ok && p.consume(';') {
+			p.skipSpace()
+			key := p.key()
+			value := ""
+			if p.consume('=') {
+				value, ok = p.bareItem()
+			}
+			if key == "v" {
+				brand.Version = value
+			}
+		}
+
+		p.skipSpace()
+		if ok && (p.done() || p.consume(',')) {
+			if name != "" {
+				brands = append(brands, brand)
+			}
+			continue
+		}
+		p.skipItem()
+	}
+}
+
+// unquoteHint returns the value of a single structured-field string such as
+// `"Windows"`
+func unquoteHint(v string) string {
+	p := &sfParser{s: v}
+	p.skipSpace()
+	if value, ok := p.bareItem(); ok {
+		return value
+	}
+	return strings.Trim(strings.TrimSpace(v), `"`)
+}
+
+// sfParser reads the parts of structured-field headers the client hints use:
+// quoted strings, tokens and numbers, with their parameters
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) done() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) skipSpace() {
+	for !p.done() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// consume skips c if it is next
+func (p *sfParser) consume(c byte) bool {
+	if !p.done() && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// bareItem reads a quoted string (unescaping \" and \\) or a token/number
+func (p *sfParser) bareItem() (string, bool) {
+	if !p.consume('"') {
+		start := p.i
+		for !p.done() && !strings.ContainsRune(" \t,;=\"", rune(p.s[p.i])) {
+			p.i++
+		}
+		return p.s[start:p.i], p.i > start
+	}
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.i]
+		p.i++
+		switch c {
+		case '"':
+			return b.String(), true
+		case '\\':
+			if p.done() {
+				return "", false
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", false // unterminated string
+}
+
+// key reads a parameter name
+func (p *sfParser) key() string {
+	start := p.i
+	for !p.done() && !strings.ContainsRune(" \t,;=\"", rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// skipItem moves past the next comma outside a quoted string
+func (p *sfParser) skipItem() {
+	quoted := false
+	for !p.done() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case quoted && c == '\\':
+			p.i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ',':
+			return
+		}
+	}
+}
+
+// isGreaseBrand reports whether a brand is one of Chromium's randomised
+// placeholder entries, e.g. "Not_A Brand" or "Not/A)Brand"
+func isGreaseBrand(name string) bool {
+	return strings.HasPrefix(name, "Not") && strings.Contains(name, "Brand")
+}
@@ -0,0 +1,123 @@
+package enrichment
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClientHintsBrowser(t *testing.T) {
+	// Sec-CH-UA values sent by Chrome and Edge releases, each with its own
+	// GREASE brand
+	tests := []struct {
+		header      string
+		wantBrands  int
+		wantName    string
+		wantVersion string
+	}{
+		{`"Chromium";v="116", "Not)A;Brand";v="24", "Google Chrome";v="116"`, 3, "Chrome", "116"},
+		{`"Google Chrome";v="117", "Not;A=Brand";v="8", "Chromium";v="117"`, 3, "Chrome", "117"},
+		{`"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`, 3, "Chrome", "118"},
+		{`"Google Chrome";v="119", "Chromium";v="119", "Not?A_Brand";v="24"`, 3, "Chrome", "119"},
+		{`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`, 3, "Chrome", "120"},
+		{`"Not A(Brand";v="99", "Google Chrome";v="121", "Chromium";v="121"`, 3, "Chrome", "121"},
+		{`"Chromium";v="122", "Not(A:Brand";v="24", "Google Chrome";v="122"`, 3, "Chrome", "122"},
+		{`"Google Chrome";v="123", "Not:A-Brand";v="8", "Chromium";v="123"`, 3, "Chrome", "123"},
+		{`"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`, 3, "Chrome", "124"},
+		{`"Google Chrome";v="125", "Chromium";v="125", "Not.A/Brand";v="24"`, 3, "Chrome", "125"},
+		{`"Not/A)Brand";v="8", "Chromium";v="126", "Google Chrome";v="126"`, 3, "Chrome", "126"},
+		{`"Not)A;Brand";v="99", "Google Chrome";v="127", "Chromium";v="127"`, 3, "Chrome", "127"},
+		{`"Chromium";v="128", "Not;A=Brand";v="24", "Google Chrome";v="128"`, 3, "Chrome", "128"},
+		{`"Chromium";v="124", "Microsoft Edge";v="124", "Not-A.Brand";v="99"`, 3, "Edge", "124"},
+		{`"Not)A;Brand";v="99", "Chromium";v="127"`, 2, "Chromium", "127"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			hints := ClientHintsFromHeaders(map[string]string{"Sec-CH-UA": tt.header})
+			if hints == nil {
+				t.Fatal("no hints parsed")
+			}
+			if len(hints.Brands) != tt.wantBrands {
+				t.Errorf("parsed %d brands, want %d: %+v", len(hints.Brands), tt.wantBrands, hints.Brands)
+			}
+			name, version := hints.Browser()
+			if name != tt.wantName || version != tt.wantVersion {
+				t.Errorf("Browser() = %q %q, want %q %q", name, version, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestParseBrandList(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []Brand
+	}{
+		{
+			name:   "grease with delimiters",
+			header: `"Not)A;Brand";v="24", "Chromium";v="116"`,
+			want:   []Brand{{"Not)A;Brand", "24"}, {"Chromium", "116"}},
+		},
+		{
+			name:   "full version list",
+			header: `"Google Chrome";v="128.0.6613.120", "Not;A=Brand";v="24.0.0.0", "Chromium";v="128.0.6613.120"`,
+			want:   []Brand{{"Google Chrome", "128.0.6613.120"}, {"Not;A=Brand", "24.0.0.0"}, {"Chromium", "128.0.6613.120"}},
+		},
+		{
+			name:   "escaped quote and no spaces",
+			header: `"A \"quoted\" brand";v="1","Chromium";v="2"`,
+			want:   []Brand{{`A "quoted" brand`, "1"}, {"Chromium", "2"}},
+		},
+		{
+			name:   "token version and unknown parameter",
+			header: `"Chromium";x=1;v=120`,
+			want:   []Brand{{"Chromium", "120"}},
+		},
+		{
+			name:   "malformed item skipped",
+			header: `"Chromium";v="120", garbage=, "Google Chrome";v="120"`,
+			want:   []Brand{{"Chromium", "120"}, {"Google Chrome", "120"}},
+		},
+		{
+			name:   "unterminated string",
+			header: `"Chromium";v="120", "Google Chr`,
+			want:   []Brand{{"Chromium", "120"}},
+		},
+		{
+			name:   "empty",
+			header: ``,
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseBrandList(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBrandList(%s) = %+v, want %+v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientHintsOS(t *testing.T) {
+	tests := []struct {
+		platform, version, want string
+	}{
+		{`"Windows"`, `"15.0.0"`, "Windows 11"},
+		{`"Windows"`, `"10.0.0"`, "Windows 10"},
+		{`"macOS"`, `"14.5.0"`, "macOS 14"},
+		{`"Android"`, `"14.0.0"`, "Android 14"},
+		{`"Linux"`, `""`, "Linux"},
+	}
+
+	for _, tt := range tests {
+		hints := ClientHintsFromHeaders(map[string]string{
+			"Sec-CH-UA-Platform":         tt.platform,
+			"Sec-CH-UA-Platform-Version": tt.version,
+		})
+		if got := hints.OS(); got != tt.want {
+			t.Errorf("OS() for %s %s = %q, want %q", tt.platform, tt.version, got, tt.want)
+		}
+	}
+}
@@ -3,37 +3,105 @@ package enrichment
 import (
 	"crypto/md5"
 	"encoding/hex"
-	"net"
 	"net/url"
+	"strconv"
 	"strings"
-
-	"github.com/caioricciuti/etiquetta/internal/bot"
+	"sync"
 )
 
 // Enricher provides event enrichment
 type Enricher struct {
+	// geoMu guards geoIP. Lookups hold the read lock for their full duration
+	// so a reload never closes a reader that is still in use.
+	geoMu sync.RWMutex
 	geoIP *GeoIP
+	asn   *ASN // optional
+
+	// Enrichment pipeline: the built-in plugins, then any added with Use
+	pluginsMu sync.RWMutex
+	plugins   []Plugin
 }
 
 // New creates a new Enricher
 func New(geoipPath string) *Enricher {
 	geoIP, _ := NewGeoIP(geoipPath)
-	return &Enricher{geoIP: geoIP}
+	e := &Enricher{geoIP: geoIP}
+	e.plugins = builtinPlugins(e)
+	return e
 }
 
-// ReloadGeoIP reloads the GeoIP database from a new path
+// ReloadGeoIP swaps in the GeoIP database at path.
+// The new reader is opened before anything is replaced, so a bad file leaves
+// the current database serving lookups. The old reader is closed only after
+// in-flight lookups have drained.
 func (e *Enricher) ReloadGeoIP(path string) error {
-	if e.geoIP != nil {
-		e.geoIP.Close()
-	}
 	geoIP, err := NewGeoIP(path)
 	if err != nil {
 		return err
 	}
+
+	e.geoMu.Lock()
+	old := e.geoIP
 	e.geoIP = geoIP
+	e.geoMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
 	return nil
 }
 
+// ReloadASN swaps in the ASN database at path, like ReloadGeoIP. A missing
+// file unloads it, as the ASN database is optional.
+func (e *Enricher) ReloadASN(path string) error {
+	asn, err := NewASN(path)
+	if err != nil {
+		return err
+	}
+
+	e.geoMu.Lock()
+	old := e.asn
+	e.asn = asn
+	e.geoMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// lookupASNOrg resolves an IP's network organization against the current
+// ASN database, or "" without one
+func (e *Enricher) lookupASNOrg(ip string) string {
+	e.geoMu.RLock()
+	defer e.geoMu.RUnlock()
+
+	if e.asn == nil {
+		return ""
+	}
+	return e.asn.LookupOrg(ip)
+}
+
+// lookupGeo resolves an IP against the current GeoIP database
+func (e *Enricher) lookupGeo(ip string) *GeoResult {
+	e.geoMu.RLock()
+	defer e.geoMu.RUnlock()
+
+	if e.geoIP == nil {
+		return nil
+	}
+	return e.geoIP.Lookup(ip)
+}
+
+// LookupCountry returns an IP's ISO country code, or "" when it is unknown
+// or no GeoIP database is loaded
+func (e *Enricher) LookupCountry(ip string) string {
+	if geo := e.lookupGeo(ip); geo != nil {
+		return geo.Country
+	}
+	return ""
+}
+
 // EnrichmentResult contains enriched data
 type EnrichmentResult struct {
 	// Geo
@@ -43,6 +111,9 @@ type EnrichmentResult struct {
 	GeoLatitude  float64
 	GeoLongitude float64
 
+	// Network: the IP's autonomous system organization, with an ASN database
+	ASNOrg string
+
 	// Device
 	BrowserName string
 	OSName      string
@@ -58,6 +129,18 @@ type EnrichmentResult struct {
 	// Referrer
 	ReferrerDomain string
 	ReferrerType   string
+
+	// Props set by plugins, merged into each event's props
+	Props map[string]interface{}
+}
+
+// SetProp sets a prop merged into each event's props, overriding any the
+// tracker sent with the same name
+func (r *EnrichmentResult) SetProp(key string, value interface{}) {
+	if r.Props == nil {
+		r.Props = make(map[string]interface{})
+	}
+	r.Props[key] = value
 }
 
 // Enrich processes an event with additional data
@@ -65,74 +148,16 @@ func (e *Enricher) Enrich(ip, userAgent, referrerURL string) *EnrichmentResult {
 	return e.EnrichWithHeaders(ip, userAgent, referrerURL, nil)
 }
 
-// EnrichWithHeaders processes an event with additional data including headers
+// EnrichWithHeaders processes an event with additional data including
+// headers, running it through the plugin pipeline
 func (e *Enricher) EnrichWithHeaders(ip, userAgent, referrerURL string, headers map[string]string) *EnrichmentResult {
-	result := &EnrichmentResult{}
-
-	// GeoIP lookup
-	if e.geoIP != nil {
-		if geo := e.geoIP.Lookup(ip); geo != nil {
-			result.GeoCountry = geo.Country
-			result.GeoCity = geo.City
-			result.GeoRegion = geo.Region
-			result.GeoLatitude = geo.Latitude
-			result.GeoLongitude = geo.Longitude
-		}
-	}
-
-	// User-Agent parsing
-	ua := ParseUserAgent(userAgent)
-	result.BrowserName = ua.BrowserName
-	result.OSName = ua.OSName
-	result.DeviceType = ua.DeviceType
-
-	// Check datacenter IP
-	result.DatacenterIP = bot.IsDatacenterIP(ip)
-
-	// Bot scoring (server-side, without client signals)
-	// Client signals will be added in handlers.go
-	botResult := bot.CalculateScore(userAgent, nil, result.DatacenterIP, headers)
-	result.BotScore = botResult.Score
-	result.BotCategory = botResult.Category
-	result.BotSignals = bot.SignalsToJSON(botResult.Signals)
-	result.IsBot = botResult.IsBot
-
-	// Referrer classification
-	if referrerURL != "" {
-		result.ReferrerDomain = extractDomain(referrerURL)
-		result.ReferrerType = classifyReferrerInternal(referrerURL, result.ReferrerDomain)
-	}
-
-	return result
-}
-
-// ExtractClientIP gets the real client IP from request headers
-func ExtractClientIP(remoteAddr string, headers map[string]string) string {
-	// Check X-Forwarded-For first
-	if xff, ok := headers["X-Forwarded-For"]; ok && xff != "" {
-		// First IP in the list is the original client
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
-		}
-	}
-
-	// Check X-Real-IP
-	if xri, ok := headers["X-Real-IP"]; ok && xri != "" {
-		return xri
-	}
-
-	// Fall back to remote address
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		return remoteAddr
-	}
-	return host
+	return e.runPipeline(&Request{IP: ip, UserAgent: userAgent, ReferrerURL: referrerURL, Headers: headers})
 }
 
-// HashError creates a hash for error deduplication
+// HashError creates a hash for error deduplication. Errors recorded before
+// the line number was hashed as a decimal string need RehashErrors.
 func HashError(errorType, errorMessage, scriptURL string, lineNumber int) string {
-	data := errorType + "|" + errorMessage + "|" + scriptURL + "|" + string(rune(lineNumber))
+	data := errorType + "|" + errorMessage + "|" + scriptURL + "|" + strconv.Itoa(lineNumber)
 	hash := md5.Sum([]byte(data))
 	return hex.EncodeToString(hash[:8])
 }
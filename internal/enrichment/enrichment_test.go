@@ -0,0 +1,164 @@
+package enrichment
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// writeTestGeoIP writes a minimal GeoLite2-City database placing every IPv4
+// address in country
+func writeTestGeoIP(t *testing.T, path, country string) {
+	t.Helper()
+
+	var b bytes.Buffer
+	// One search tree node whose records both point at the first data entry:
+	// node_count + 16 (the data section separator) + offset 0
+	const nodeCount = 1
+	record := []byte{0, 0, nodeCount + 16}
+	b.Write(record)
+	b.Write(record)
+	b.Write(make([]byte, 16))
+
+	// Data section: {"country": {"iso_code": country}}
+	writeMap(&b, 1)
+	writeString(&b, "country")
+	writeMap(&b, 1)
+	writeString(&b, "iso_code")
+	writeString(&b, country)
+
+	b.WriteString("\xab\xcd\xefMaxMind.com")
+	writeMap(&b, 7)
+	writeString(&b, "binary_format_major_version")
+	writeUint(&b, 5, 2)
+	writeString(&b, "binary_format_minor_version")
+	writeUint(&b, 5, 0)
+	writeString(&b, "build_epoch")
+	b.Write([]byte{0x01, 0x02, 1}) // uint64 (extended type 9), one byte
+	writeString(&b, "database_type")
+	writeString(&b, "GeoLite2-City")
+	writeString(&b, "ip_version")
+	writeUint(&b, 5, 4)
+	writeString(&b, "node_count")
+	writeUint(&b, 6, nodeCount)
+	writeString(&b, "record_size")
+	writeUint(&b, 5, 24)
+
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeMap(b *bytes.Buffer, size int) {
+	b.WriteByte(7<<5 | byte(size))
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	b.WriteByte(2<<5 | byte(len(s)))
+	b.WriteString(s)
+}
+
+// writeUint writes a one-byte unsigned value of the given type (5 = uint16,
+// 6 = uint32)
+func writeUint(b *bytes.Buffer, typ byte, v byte) {
+	b.WriteByte(typ<<5 | 1)
+	b.WriteByte(v)
+}
+
+func TestReloadGeoIPDuringLookups(t *testing.T) {
+	dir := t.TempDir()
+	us := filepath.Join(dir, "us.mmdb")
+	de := filepath.Join(dir, "de.mmdb")
+	writeTestGeoIP(t, us, "US")
+	writeTestGeoIP(t, de, "DE")
+
+	e := New(us)
+	if got := e.LookupCountry("203.0.113.7"); got != "US" {
+		t.Fatalf("LookupCountry = %q, want US", got)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	errs := make(chan string, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if got := e.LookupCountry("203.0.113.7"); got != "US" && got != "DE" {
+					select {
+					case errs <- got:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		path := us
+		if i%2 == 0 {
+			path = de
+		}
+		if err := e.ReloadGeoIP(path); err != nil {
+			t.Fatalf("ReloadGeoIP: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for got := range errs {
+		t.Errorf("lookup during reload returned %q, want US or DE", got)
+	}
+	if got := e.LookupCountry("203.0.113.7"); got != "US" {
+		t.Errorf("after reloads LookupCountry = %q, want US", got)
+	}
+}
+
+func TestReloadGeoIPKeepsCurrentOnBadFile(t *testing.T) {
+	dir := t.TempDir()
+	us := filepath.Join(dir, "us.mmdb")
+	writeTestGeoIP(t, us, "US")
+	bad := filepath.Join(dir, "bad.mmdb")
+	if err := os.WriteFile(bad, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(us)
+	if err := e.ReloadGeoIP(bad); err == nil {
+		t.Fatal("ReloadGeoIP accepted a corrupt file")
+	}
+	if got := e.LookupCountry("203.0.113.7"); got != "US" {
+		t.Errorf("LookupCountry = %q, want US", got)
+	}
+}
+
+func TestHashErrorLineNumber(t *testing.T) {
+	// Line numbers used to be hashed as runes, 65 as "A"; they must hash as
+	// their decimal digits
+	a := HashError("TypeError", "x is undefined", "https://example.com/app.js", 65)
+	b := HashError("TypeError", "x is undefined", "https://example.com/app.js", 8280)
+	if a == b {
+		t.Fatalf("lines 65 and 8280 share hash %s", a)
+	}
+
+	for _, line := range []int{65, 8280} {
+		sum := md5.Sum([]byte("TypeError|x is undefined|https://example.com/app.js|" + strconv.Itoa(line)))
+		want := hex.EncodeToString(sum[:8])
+		if got := HashError("TypeError", "x is undefined", "https://example.com/app.js", line); got != want {
+			t.Errorf("HashError for line %d = %s, want %s (line hashed as %q)", line, got, want, strconv.Itoa(line))
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package enrichment
 
 import (
+	"errors"
 	"net"
+	"os"
 
 	"github.com/oschwald/geoip2-golang"
 )
@@ -75,6 +77,57 @@ func (g *GeoIP) Lookup(ipStr string) *GeoResult {
 	return result
 }
 
+// ASN provides IP to autonomous system lookups, from a GeoLite2-ASN
+// database
+type ASN struct {
+	db *geoip2.Reader
+}
+
+// NewASN opens the ASN database at path. The database is optional, so an
+// empty path or a missing file gives a nil ASN and no error.
+func NewASN(path string) (*ASN, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ASN{db: db}, nil
+}
+
+// Close closes the ASN database
+func (a *ASN) Close() error {
+	if a.db != nil {
+		return a.db.Close()
+	}
+	return nil
+}
+
+// LookupOrg returns the organization of the network an IP belongs to, or ""
+// when it is unknown
+func (a *ASN) LookupOrg(ipStr string) string {
+	if a.db == nil {
+		return ""
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return ""
+	}
+
+	record, err := a.db.ASN(ip)
+	if err != nil {
+		return ""
+	}
+	return record.AutonomousSystemOrganization
+}
+
 // MaskIP masks the last octet of an IPv4 address for privacy
 func MaskIP(ip string) string {
 	parsed := net.ParseIP(ip)
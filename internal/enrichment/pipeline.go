@@ -0,0 +1,146 @@
+package enrichment
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/caioricciuti/etiquetta/internal/bot"
+)
+
+// Request is what enrichers know about the tracking request being enriched
+type Request struct {
+	IP          string
+	UserAgent   string
+	ReferrerURL string
+	Headers     map[string]string
+}
+
+// Plugin is one step of the enrichment pipeline. Plugins run in the order
+// they were added, each seeing the result of those before it; they may set
+// any field of the result, and add Props, which are merged into the props
+// of every event in the request.
+type Plugin interface {
+	Name() string
+	Enrich(req *Request, result *EnrichmentResult) error
+}
+
+// PluginFunc adapts a function to a named Plugin
+func PluginFunc(name string, fn func(req *Request, result *EnrichmentResult) error) Plugin {
+	return funcPlugin{name: name, fn: fn}
+}
+
+type funcPlugin struct {
+	name string
+	fn   func(req *Request, result *EnrichmentResult) error
+}
+
+func (p funcPlugin) Name() string { return p.name }
+
+func (p funcPlugin) Enrich(req *Request, result *EnrichmentResult) error {
+	return p.fn(req, result)
+}
+
+// builtinPlugins are the enrichers every Enricher starts with
+func builtinPlugins(e *Enricher) []Plugin {
+	return []Plugin{
+		PluginFunc("geo", e.enrichGeo),
+		PluginFunc("asn", e.enrichASN),
+		PluginFunc("user_agent", enrichUserAgent),
+		PluginFunc("bot", enrichBot),
+		PluginFunc("referrer", enrichReferrer),
+	}
+}
+
+// Use appends plugins to the pipeline, after the built-in ones and any
+// added before
+func (e *Enricher) Use(plugins ...Plugin) {
+	e.pluginsMu.Lock()
+	defer e.pluginsMu.Unlock()
+	e.plugins = append(e.plugins, plugins...)
+}
+
+// Plugins returns the names of the plugins in the pipeline, in order
+func (e *Enricher) Plugins() []string {
+	e.pluginsMu.RLock()
+	defer e.pluginsMu.RUnlock()
+
+	names := make([]string, len(e.plugins))
+	for i, p := range e.plugins {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// runPipeline enriches req with each plugin in turn. A failing or
+// panicking plugin is logged and skipped, so it can't stop ingestion.
+func (e *Enricher) runPipeline(req *Request) *EnrichmentResult {
+	e.pluginsMu.RLock()
+	plugins := e.plugins
+	e.pluginsMu.RUnlock()
+
+	result := &EnrichmentResult{}
+	for _, p := range plugins {
+		if err := runPlugin(p, req, result); err != nil {
+			log.Printf("[enrichment] Plugin %s failed: %v", p.Name(), err)
+		}
+	}
+	return result
+}
+
+func runPlugin(p Plugin, req *Request, result *EnrichmentResult) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.Enrich(req, result)
+}
+
+// enrichGeo looks up the IP's location
+func (e *Enricher) enrichGeo(req *Request, result *EnrichmentResult) error {
+	if geo := e.lookupGeo(req.IP); geo != nil {
+		result.GeoCountry = geo.Country
+		result.GeoCity = geo.City
+		result.GeoRegion = geo.Region
+		result.GeoLatitude = geo.Latitude
+		result.GeoLongitude = geo.Longitude
+	}
+	return nil
+}
+
+// enrichASN looks up the IP's network, when an ASN database is loaded
+func (e *Enricher) enrichASN(req *Request, result *EnrichmentResult) error {
+	result.ASNOrg = e.lookupASNOrg(req.IP)
+	return nil
+}
+
+// enrichUserAgent parses the browser, OS and device, preferring client hints
+func enrichUserAgent(req *Request, result *EnrichmentResult) error {
+	ua := ParseUserAgentWithHints(req.UserAgent, ClientHintsFromHeaders(req.Headers))
+	result.BrowserName = ua.BrowserName
+	result.OSName = ua.OSName
+	result.DeviceType = ua.DeviceType
+	return nil
+}
+
+// enrichBot scores the request server-side; client signals are added per
+// event during ingest
+func enrichBot(req *Request, result *EnrichmentResult) error {
+	result.DatacenterIP = bot.IsDatacenterIP(req.IP)
+
+	botResult := bot.CalculateScore(req.UserAgent, nil, result.DatacenterIP, req.Headers)
+	result.BotScore = botResult.Score
+	result.BotCategory = botResult.Category
+	result.BotSignals = bot.SignalsToJSON(botResult.Signals)
+	result.IsBot = botResult.IsBot
+	return nil
+}
+
+// enrichReferrer classifies the request's referrer, if any
+func enrichReferrer(req *Request, result *EnrichmentResult) error {
+	if req.ReferrerURL != "" {
+		result.ReferrerDomain = extractDomain(req.ReferrerURL)
+		result.ReferrerType = classifyReferrerInternal(req.ReferrerURL, result.ReferrerDomain)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package enrichment
+
+import "database/sql"
+
+// RehashProgress reports how far an error rehash run has got
+type RehashProgress struct {
+	Processed int64 `json:"processed"`
+	Changed   int64 `json:"changed"`
+}
+
+type rehashRow struct {
+	rowid int64
+	hash  string
+	want  string
+}
+
+// RehashErrors recomputes error_hash for every stored error with the current
+// HashError, in batches of batchSize. Older versions hashed the line number
+// as a rune, which merged or split errors in the errors report. Each batch is
+// read fully before it is written so the single connection is never held by
+// an open cursor. progress, if set, is called after every batch.
+func RehashErrors(db *sql.DB, batchSize int, progress func(RehashProgress)) (RehashProgress, error) {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var p RehashProgress
+	var lastRowID int64
+	for {
+		batch, err := loadRehashBatch(db, lastRowID, batchSize)
+		if err != nil {
+			return p, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		changed, err := applyRehashBatch(db, batch)
+		if err != nil {
+			return p, err
+		}
+
+		p.Processed += int64(len(batch))
+		p.Changed += changed
+		lastRowID = batch[len(batch)-1].rowid
+		if progress != nil {
+			progress(p)
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+	return p, nil
+}
+
+func loadRehashBatch(db *sql.DB, afterRowID int64, limit int) ([]rehashRow, error) {
+	rows, err := db.Query(`
+		SELECT rowid, error_hash, error_type, error_message, COALESCE(script_url, ''), COALESCE(line_number, 0)
+		FROM errors
+		WHERE rowid > ?
+		ORDER BY rowid
+		LIMIT ?
+	`, afterRowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []rehashRow
+	for rows.Next() {
+		var r rehashRow
+		var errorType, message, scriptURL string
+		var line int
+		if err := rows.Scan(&r.rowid, &r.hash, &errorType, &message, &scriptURL, &line); err != nil {
+			return nil, err
+		}
+		r.want = HashError(errorType, message, scriptURL, line)
+		batch = append(batch, r)
+	}
+	return batch, rows.Err()
+}
+
+func applyRehashBatch(db *sql.DB, batch []rehashRow) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE errors SET error_hash = ? WHERE rowid = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var changed int64
+	for _, r := range batch {
+		if r.hash == r.want {
+			continue
+		}
+		if _, err := stmt.Exec(r.want, r.rowid); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+
+	return changed, tx.Commit()
+}
@@ -0,0 +1,62 @@
+package enrichment
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// runeLineHash is HashError as it was before RehashErrors, with the line
+// number hashed as a rune
+func runeLineHash(errorType, errorMessage, scriptURL string, lineNumber int) string {
+	hash := md5.Sum([]byte(errorType + "|" + errorMessage + "|" + scriptURL + "|" + string(rune(lineNumber))))
+	return hex.EncodeToString(hash[:8])
+}
+
+func TestRehashErrors(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "etiquetta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(id, hash string, line int) {
+		t.Helper()
+		_, err := db.Conn().Exec(`
+			INSERT INTO errors (id, timestamp, session_id, visitor_hash, domain, url, path,
+				error_type, error_message, error_hash, script_url, line_number)
+			VALUES (?, 0, 's', 'v', 'example.com', 'https://example.com/', '/', 'TypeError', 'x is undefined', ?, 'app.js', ?)
+		`, id, hash, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	current := HashError("TypeError", "x is undefined", "app.js", 12)
+	insert("old", runeLineHash("TypeError", "x is undefined", "app.js", 8280), 8280)
+	insert("current", current, 12)
+
+	p, err := RehashErrors(db.Conn(), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Processed != 2 || p.Changed != 1 {
+		t.Errorf("progress = %+v, want 2 processed and 1 changed", p)
+	}
+
+	for id, want := range map[string]string{
+		"old":     HashError("TypeError", "x is undefined", "app.js", 8280),
+		"current": current,
+	} {
+		var got string
+		db.Conn().QueryRow("SELECT error_hash FROM errors WHERE id = ?", id).Scan(&got)
+		if got != want {
+			t.Errorf("%s row error_hash = %s, want %s", id, got, want)
+		}
+	}
+}
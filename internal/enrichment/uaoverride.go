@@ -0,0 +1,91 @@
+package enrichment
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// UAOverride is an operator-defined rule that corrects how a user agent is
+// classified. Empty fields leave the built-in parser's value in place.
+type UAOverride struct {
+	Pattern string `json:"pattern"`
+	Browser string `json:"browser,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Device  string `json:"device,omitempty"`
+}
+
+// overrideDevices are the device types a rule may set, matching what the
+// parser itself reports
+var overrideDevices = map[string]bool{
+	DeviceDesktop: true,
+	DeviceMobile:  true,
+	DeviceTablet:  true,
+	DeviceTV:      true,
+	DeviceBot:     true,
+	DeviceUnknown: true,
+}
+
+type compiledOverride struct {
+	UAOverride
+	re *regexp.Regexp
+}
+
+var (
+	uaOverridesMu sync.RWMutex
+	uaOverrides   []compiledOverride
+)
+
+// CompileUAOverrides validates rules, returning an error naming the first bad
+// pattern or device
+func CompileUAOverrides(rules []UAOverride) error {
+	_, err := compileOverrides(rules)
+	return err
+}
+
+// SetUAOverrides replaces the active override rules.
+// Patterns are compiled once here; ParseUserAgent only matches.
+func SetUAOverrides(rules []UAOverride) error {
+	compiled, err := compileOverrides(rules)
+	if err != nil {
+		return err
+	}
+
+	uaOverridesMu.Lock()
+	uaOverrides = compiled
+	uaOverridesMu.Unlock()
+	return nil
+}
+
+func compileOverrides(rules []UAOverride) ([]compiledOverride, error) {
+	compiled := make([]compiledOverride, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: pattern is required", i+1)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid pattern: %w", i+1, err)
+		}
+		if rule.Device != "" && !overrideDevices[rule.Device] {
+			return nil, fmt.Errorf("rule %d: unknown device %q", i+1, rule.Device)
+		}
+		compiled = append(compiled, compiledOverride{UAOverride: rule, re: re})
+	}
+	return compiled, nil
+}
+
+// MatchUAOverride returns the index and rule of the first override matching ua,
+// or -1 and nil when none match
+func MatchUAOverride(ua string) (int, *UAOverride) {
+	uaOverridesMu.RLock()
+	defer uaOverridesMu.RUnlock()
+
+	for i := range uaOverrides {
+		if uaOverrides[i].re.MatchString(ua) {
+			rule := uaOverrides[i].UAOverride
+			return i, &rule
+		}
+	}
+	return -1, nil
+}
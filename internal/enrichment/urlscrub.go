@@ -0,0 +1,136 @@
+package enrichment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// URLRedacted replaces the parts of a URL matched by a scrub rule
+const URLRedacted = "[redacted]"
+
+// URLScrubRule is a pattern whose matches are redacted from stored URLs and
+// paths. When the pattern has capture groups only the groups are redacted,
+// so e.g. a query parameter's name can be kept while its value is not.
+type URLScrubRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// DefaultURLScrubRules catch the common ways PII and credentials end up in
+// page URLs
+var DefaultURLScrubRules = []URLScrubRule{
+	{Name: "email", Pattern: `[A-Za-z0-9._%+-]+(?:@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+	{Name: "secret query parameters", Pattern: `(?i)[?&;](?:access_token|id_token|refresh_token|token|auth|api_?key|key|password|passwd|pwd|secret|signature|sig|session_?id|sid|code|email)=([^&#]*)`},
+	{Name: "jwt", Pattern: `eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`},
+	{Name: "long hex token", Pattern: `\b[0-9A-Fa-f]{32,}\b`},
+}
+
+type compiledScrubRule struct {
+	URLScrubRule
+	re *regexp.Regexp
+}
+
+var (
+	urlScrubMu    sync.RWMutex
+	urlScrubRules = mustCompileScrubRules(DefaultURLScrubRules)
+)
+
+// CompileURLScrubRules validates rules, returning an error naming the first bad pattern
+func CompileURLScrubRules(rules []URLScrubRule) error {
+	_, err := compileScrubRules(rules)
+	return err
+}
+
+// SetURLScrubRules replaces the active scrub rules
+func SetURLScrubRules(rules []URLScrubRule) error {
+	compiled, err := compileScrubRules(rules)
+	if err != nil {
+		return err
+	}
+
+	urlScrubMu.Lock()
+	urlScrubRules = compiled
+	urlScrubMu.Unlock()
+	return nil
+}
+
+func compileScrubRules(rules []URLScrubRule) ([]compiledScrubRule, error) {
+	compiled := make([]compiledScrubRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: pattern is required", i+1)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid pattern: %w", i+1, err)
+		}
+		compiled = append(compiled, compiledScrubRule{URLScrubRule: rule, re: re})
+	}
+	return compiled, nil
+}
+
+func mustCompileScrubRules(rules []URLScrubRule) []compiledScrubRule {
+	compiled, err := compileScrubRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// ScrubURL redacts the matches of the active rules from s, returning the
+// result and the names of the rules that matched
+func ScrubURL(s string) (string, []string) {
+	urlScrubMu.RLock()
+	rules := urlScrubRules
+	urlScrubMu.RUnlock()
+	return scrub(s, rules)
+}
+
+// ScrubURLWith is ScrubURL with the given rules instead of the active ones
+func ScrubURLWith(s string, rules []URLScrubRule) (string, []string, error) {
+	compiled, err := compileScrubRules(rules)
+	if err != nil {
+		return "", nil, err
+	}
+	scrubbed, matched := scrub(s, compiled)
+	return scrubbed, matched, nil
+}
+
+func scrub(s string, rules []compiledScrubRule) (string, []string) {
+	var matched []string
+	for _, rule := range rules {
+		matches := rule.re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		matched = append(matched, rule.Name)
+
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			// Redact the capture groups if there are any, else the whole match
+			spans := [][2]int{{m[0], m[1]}}
+			if len(m) > 2 {
+				spans = spans[:0]
+				for g := 2; g < len(m); g += 2 {
+					if m[g] >= 0 && m[g] < m[g+1] {
+						spans = append(spans, [2]int{m[g], m[g+1]})
+					}
+				}
+			}
+			for _, span := range spans {
+				if span[0] < last {
+					continue
+				}
+				b.WriteString(s[last:span[0]])
+				b.WriteString(URLRedacted)
+				last = span[1]
+			}
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+	}
+	return s, matched
+}
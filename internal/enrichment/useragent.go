@@ -1,7 +1,22 @@
 package enrichment
 
 import (
+	"regexp"
+	"strings"
+
 	"github.com/mssola/useragent"
+
+	"github.com/caioricciuti/etiquetta/internal/bot"
+)
+
+// Device types reported in UAResult.DeviceType
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceTV      = "tv"
+	DeviceBot     = "bot"
+	DeviceUnknown = "Unknown"
 )
 
 // UAResult contains parsed user-agent data
@@ -17,6 +32,12 @@ type UAResult struct {
 
 // ParseUserAgent parses a user-agent string
 func ParseUserAgent(uaString string) *UAResult {
+	return ParseUserAgentWithHints(uaString, nil)
+}
+
+// ParseUserAgentWithHints parses a user-agent string, preferring client hints
+// for browser, platform and mobile detection when the browser sent them
+func ParseUserAgentWithHints(uaString string, hints *ClientHints) *UAResult {
 	ua := useragent.New(uaString)
 
 	browserName, browserVersion := ua.Browser()
@@ -26,42 +47,125 @@ func ParseUserAgent(uaString string) *UAResult {
 		BrowserName:    browserName,
 		BrowserVersion: browserVersion,
 		OSName:         osName,
-		IsMobile:       ua.Mobile(),
-		IsBot:          ua.Bot(),
+		IsBot:          ua.Bot() || isBotUA(uaString),
 	}
 
-	// Determine device type
-	if ua.Mobile() {
-		result.DeviceType = "mobile"
-	} else if isTablet(uaString) {
-		result.DeviceType = "tablet"
-	} else {
-		result.DeviceType = "desktop"
+	result.DeviceType = classifyDevice(uaString, osName, result.IsBot)
+	applyClientHints(result, hints)
+
+	// Operator overrides take precedence over the built-in classification
+	if _, rule := MatchUAOverride(uaString); rule != nil {
+		if rule.Browser != "" {
+			result.BrowserName = rule.Browser
+		}
+		if rule.OS != "" {
+			result.OSName = rule.OS
+		}
+		if rule.Device != "" {
+			result.DeviceType = rule.Device
+			result.IsBot = rule.Device == DeviceBot
+		}
 	}
 
+	result.IsMobile = result.DeviceType == DeviceMobile
 	return result
 }
 
-func isTablet(ua string) bool {
-	// Simple tablet detection
-	tablets := []string{
-		"iPad", "Android", "Tablet", "PlayBook", "Silk",
+// classifyDevice buckets a user agent into desktop/mobile/tablet/tv/bot.
+// Order matters: TVs and tablets often carry "Android" or "Mobile" tokens,
+// so they're checked before the generic mobile rules.
+func classifyDevice(uaString, osName string, isBot bool) string {
+	if strings.TrimSpace(uaString) == "" {
+		return DeviceUnknown
 	}
-	for _, t := range tablets {
-		if contains(ua, t) && !contains(ua, "Mobile") {
+	if isBot {
+		return DeviceBot
+	}
+
+	lower := strings.ToLower(uaString)
+
+	if isTV(uaString, lower) {
+		return DeviceTV
+	}
+	if isTablet(lower) {
+		return DeviceTablet
+	}
+	if isMobile(lower) {
+		return DeviceMobile
+	}
+
+	// Without a recognisable OS there's nothing to base a guess on
+	if osName == "" {
+		return DeviceUnknown
+	}
+	return DeviceDesktop
+}
+
+// botUAPatterns are lowercase tokens of crawlers, scripts and automation
+// tools that the UA library doesn't flag on its own
+var botUAPatterns = []string{
+	"bot", "crawler", "spider", "slurp", "headlesschrome", "phantomjs",
+	"puppeteer", "selenium", "webdriver", "playwright", "lighthouse",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+	"java/", "libwww-perl", "httpclient", "axios/", "node-fetch",
+}
+
+func isBotUA(ua string) bool {
+	if bot.IsGoodBot(ua) {
+		return true
+	}
+	// Cubot is a phone brand, not a crawler
+	lower := strings.ReplaceAll(strings.ToLower(ua), "cubot", "")
+	for _, p := range botUAPatterns {
+		if strings.Contains(lower, p) {
 			return true
 		}
 	}
 	return false
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && findSubstring(s, substr)
+// fireTVPattern matches Amazon Fire TV model codes (AFTB, AFTMM, AFTSSS, ...)
+var fireTVPattern = regexp.MustCompile(`\bAFT[A-Z]`)
+
+func isTV(ua, lower string) bool {
+	tvTokens := []string{
+		"smart-tv", "smarttv", "smart tv", "googletv", "google tv", "android tv",
+		"appletv", "apple tv", "hbbtv", "netcast", "web0s", "webos.tv", "roku",
+		"bravia", "crkey", "aquos", "viera", "philipstv", "playstation", "xbox",
+		"nintendo", "opera tv", "vidaa",
+	}
+	for _, t := range tvTokens {
+		if strings.Contains(lower, t) {
+			return true
+		}
+	}
+	if strings.Contains(lower, "tizen") && strings.Contains(lower, "tv") {
+		return true
+	}
+	return fireTVPattern.MatchString(ua)
+}
+
+func isTablet(lower string) bool {
+	tablets := []string{
+		"ipad", "tablet", "playbook", "kindle", "silk/", "nexus 7", "nexus 9",
+		"nexus 10", "sm-t", "sm-x", "gt-p", "lenovo tab", "mediapad", "matepad",
+	}
+	for _, t := range tablets {
+		if strings.Contains(lower, t) {
+			return true
+		}
+	}
+	// Android tablets omit the "Mobile" token that Android phones send
+	return strings.Contains(lower, "android") && !strings.Contains(lower, "mobile")
 }
 
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
+func isMobile(lower string) bool {
+	mobiles := []string{
+		"mobile", "iphone", "ipod", "android", "windows phone", "blackberry",
+		"bb10", "opera mini", "kaios",
+	}
+	for _, m := range mobiles {
+		if strings.Contains(lower, m) {
 			return true
 		}
 	}
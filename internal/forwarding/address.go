@@ -0,0 +1,89 @@
+package forwarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for outbound URLs that reach the server's own
+// machine or network, which settings must not be able to target
+var ErrBlockedAddress = errors.New("loopback, link-local and private addresses are not allowed")
+
+// lookupTimeout bounds resolving an outbound URL's host when it's saved
+const lookupTimeout = 5 * time.Second
+
+// blockedIP reports whether ip is loopback, link-local, private or
+// otherwise not a public unicast address
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// ValidateURL checks that raw is an absolute http(s) URL whose host resolves
+// only to public addresses. Resolution can change later, so requests to it
+// must go through PublicClient, which checks the address again when it
+// connects. Errors don't name the setting; callers prefix it.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("must be an absolute http(s) URL")
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedIP(ip) {
+			return ErrBlockedAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("host %q does not resolve", host)
+	}
+	for _, addr := range addrs {
+		if blockedIP(addr.IP) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// checkDialAddress refuses connections to blocked addresses. It runs on the
+// resolved address right before connecting, so a host that resolved to a
+// public address when saved can't be rebound to an internal one.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// PublicClient returns an HTTP client for requests to URLs taken from
+// settings. It connects directly, without environment proxies, and only to
+// public addresses.
+func PublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: checkDialAddress}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+	}
+}
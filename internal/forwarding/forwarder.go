@@ -0,0 +1,443 @@
+// Package forwarding mirrors ingested events to external endpoints, such as
+// a webhook or another analytics system during a migration.
+package forwarding
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+// Forwarding limits. Each domain has its own queue and sender, so a slow or
+// failing endpoint only delays that domain's forwarding, and ingest only
+// ever does a non-blocking enqueue.
+const (
+	queueSize     = 10000           // events waiting per domain; more are dropped
+	batchSize     = 100             // events per request
+	flushInterval = 5 * time.Second // longest an event waits for a batch to fill
+	maxAttempts   = 4               // per batch, with exponential backoff
+	retryBackoff  = time.Second     // first retry delay, doubled each time
+	reloadEvery   = time.Minute     // re-read the domains' forwarding settings
+	postTimeout   = 10 * time.Second
+	drainTimeout  = 30 * time.Second // longest Stop waits for queued events
+)
+
+// DefaultFields are the event fields forwarded when a domain doesn't choose
+// its own. They leave out visitor, session and IP identifiers and precise
+// location; add those explicitly if the receiving side needs them.
+var DefaultFields = []string{
+	"id", "timestamp", "event_type", "event_name", "domain", "url", "path",
+	"page_title", "referrer_url", "referrer_type", "utm_source", "utm_medium",
+	"utm_campaign", "geo_country", "browser_name", "os_name", "device_type",
+	"bot_category", "props",
+}
+
+// Fields lists every event field that can be forwarded: the JSON names of
+// database.Event's fields
+func Fields() []string {
+	t := reflect.TypeOf(database.Event{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFields checks a field list, returning an error naming the first
+// unknown field
+func ValidateFields(fields []string) error {
+	known := map[string]bool{}
+	for _, f := range Fields() {
+		known[f] = true
+	}
+	for _, f := range fields {
+		if !known[f] {
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+	return nil
+}
+
+// Target is where a domain's events are forwarded
+type Target struct {
+	URL    string
+	Fields []string
+}
+
+// Payload is the JSON body POSTed to a forwarding URL
+type Payload struct {
+	Domain string                   `json:"domain"`
+	Events []map[string]interface{} `json:"events"`
+	SentAt int64                    `json:"sent_at"`
+}
+
+// Status is a domain's forwarding counters since the server started
+type Status struct {
+	Queued        int    `json:"queued"`
+	Forwarded     int64  `json:"forwarded"`
+	Failed        int64  `json:"failed"`  // dropped after all retries
+	Dropped       int64  `json:"dropped"` // dropped because the queue was full
+	LastSuccessAt *int64 `json:"last_success_at"`
+	LastErrorAt   *int64 `json:"last_error_at"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+// Forwarder sends events to each domain's forwarding URL in the background
+type Forwarder struct {
+	db     *sql.DB
+	client *http.Client
+	stopCh chan struct{}
+
+	mu      sync.RWMutex
+	targets map[string]Target // by domain
+	sinks   map[string]*sink  // by domain
+}
+
+// sink is one domain's queue and counters
+type sink struct {
+	domain   string
+	queue    chan *database.Event
+	done     chan struct{} // closed to stop the sender
+	finished chan struct{} // closed once the sender has returned
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a Forwarder reading its settings from db
+func New(db *sql.DB) *Forwarder {
+	return &Forwarder{
+		db:      db,
+		client:  PublicClient(postTimeout),
+		stopCh:  make(chan struct{}),
+		targets: make(map[string]Target),
+		sinks:   make(map[string]*sink),
+	}
+}
+
+// Start loads the forwarding settings and keeps them fresh until Stop
+func (f *Forwarder) Start() {
+	f.Reload()
+
+	ticker := time.NewTicker(reloadEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.Reload()
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// Stop halts the settings reload loop and the senders, sending what's
+// still queued first. It waits up to drainTimeout, without retries.
+func (f *Forwarder) Stop() {
+	close(f.stopCh)
+
+	f.mu.Lock()
+	sinks := f.sinks
+	f.sinks = make(map[string]*sink)
+	f.mu.Unlock()
+
+	for _, s := range sinks {
+		close(s.done)
+	}
+	deadline := time.After(drainTimeout)
+	for _, s := range sinks {
+		select {
+		case <-s.finished:
+		case <-deadline:
+			log.Printf("[forwarding] Gave up waiting for queued events after %s", drainTimeout)
+			return
+		}
+	}
+}
+
+// Reload re-reads which domains forward events, and where
+func (f *Forwarder) Reload() {
+	rows, err := f.db.Query(`
+		SELECT domain, forward_url, forward_fields
+		FROM domains
+		WHERE is_active = 1 AND forward_url IS NOT NULL AND forward_url != ''
+	`)
+	if err != nil {
+		log.Printf("[forwarding] Failed to load settings: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	targets := make(map[string]Target)
+	for rows.Next() {
+		var domain, url string
+		var fields *string
+		if rows.Scan(&domain, &url, &fields) != nil {
+			continue
+		}
+		target := Target{URL: url, Fields: DefaultFields}
+		if fields != nil {
+			var list []string
+			if json.Unmarshal([]byte(*fields), &list) == nil && len(list) > 0 {
+				target.Fields = list
+			}
+		}
+		targets[domain] = target
+	}
+
+	// Senders of domains that no longer forward are stopped; what they
+	// still hold is discarded, as it has nowhere to go
+	f.mu.Lock()
+	f.targets = targets
+	for domain, s := range f.sinks {
+		if _, ok := targets[domain]; !ok {
+			delete(f.sinks, domain)
+			close(s.done)
+		}
+	}
+	f.mu.Unlock()
+}
+
+// Enqueue hands events to their domains' senders without blocking. Events
+// of domains that don't forward are skipped; events that don't fit in a
+// full queue are dropped and counted.
+func (f *Forwarder) Enqueue(events []*database.Event) {
+	for _, e := range events {
+		s := f.sinkFor(e.Domain)
+		if s == nil {
+			continue
+		}
+		select {
+		case s.queue <- e:
+		default:
+			s.mu.Lock()
+			s.status.Dropped++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// sinkFor returns the domain's sink, starting it on first use, or nil when
+// the domain doesn't forward or the forwarder is stopped
+func (f *Forwarder) sinkFor(domain string) *sink {
+	f.mu.RLock()
+	_, forwards := f.targets[domain]
+	s := f.sinks[domain]
+	f.mu.RUnlock()
+	if !forwards {
+		return nil
+	}
+	if s != nil {
+		return s
+	}
+
+	select {
+	case <-f.stopCh:
+		return nil
+	default:
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, forwards = f.targets[domain]; !forwards {
+		return nil
+	}
+	if s = f.sinks[domain]; s == nil {
+		s = &sink{
+			domain:   domain,
+			queue:    make(chan *database.Event, queueSize),
+			done:     make(chan struct{}),
+			finished: make(chan struct{}),
+		}
+		f.sinks[domain] = s
+		go f.run(s)
+	}
+	return s
+}
+
+// Status returns the domain's forwarding counters
+func (f *Forwarder) Status(domain string) Status {
+	f.mu.RLock()
+	s := f.sinks[domain]
+	f.mu.RUnlock()
+	if s == nil {
+		return Status{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Queued = len(s.queue)
+	return status
+}
+
+// run batches a domain's events and sends them until the sink is stopped,
+// then sends what's left in its queue
+func (f *Forwarder) run(s *sink) {
+	defer close(s.finished)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*database.Event, 0, batchSize)
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-s.done:
+			f.drain(s, batch)
+			return
+		}
+		f.flush(s, batch)
+		batch = make([]*database.Event, 0, batchSize)
+	}
+}
+
+// drain sends batch and everything still queued for a stopped sink
+func (f *Forwarder) drain(s *sink, batch []*database.Event) {
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) < batchSize {
+				continue
+			}
+		default:
+			if len(batch) > 0 {
+				f.flush(s, batch)
+			}
+			return
+		}
+		f.flush(s, batch)
+		batch = make([]*database.Event, 0, batchSize)
+	}
+}
+
+// stopping reports whether the sink has been told to stop
+func (s *sink) stopping() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush sends a batch to the domain's current target, retrying with
+// backoff. Batches of domains that stopped forwarding are discarded.
+func (f *Forwarder) flush(s *sink, batch []*database.Event) {
+	f.mu.RLock()
+	target, ok := f.targets[s.domain]
+	f.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	body, err := json.Marshal(Payload{
+		Domain: s.domain,
+		Events: project(batch, target.Fields),
+		SentAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		s.recordFailure(len(batch), err)
+		return
+	}
+
+	delay := retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := f.post(target.URL, body)
+		if err == nil {
+			s.recordSuccess(len(batch))
+			return
+		}
+		// A stopping sink doesn't retry, so shutdown isn't held up by backoff
+		if !retry || attempt == maxAttempts || s.stopping() {
+			log.Printf("[forwarding] Dropped %d event(s) for %s after %d attempt(s): %v", len(batch), s.domain, attempt, err)
+			s.recordFailure(len(batch), err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends one request, reporting whether a failure is worth retrying
+func (f *Forwarder) post(url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Etiquetta-Forwarder")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// project keeps only the given fields of each event
+func project(events []*database.Event, fields []string) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(events))
+	for _, e := range events {
+		var all map[string]interface{}
+		encoded, _ := json.Marshal(e)
+		json.Unmarshal(encoded, &all)
+
+		picked := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			if v, ok := all[field]; ok {
+				picked[field] = v
+			}
+		}
+		out = append(out, picked)
+	}
+	return out
+}
+
+func (s *sink) recordSuccess(n int) {
+	now := time.Now().UnixMilli()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Forwarded += int64(n)
+	s.status.LastSuccessAt = &now
+}
+
+func (s *sink) recordFailure(n int, err error) {
+	now := time.Now().UnixMilli()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Failed += int64(n)
+	s.status.LastErrorAt = &now
+	s.status.LastError = err.Error()
+}
@@ -0,0 +1,134 @@
+package forwarding
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/database"
+)
+
+func TestValidateURL(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		blocked bool
+		invalid bool
+	}{
+		{url: "https://203.0.113.10/hook"},
+		{url: "http://[2001:db8::1]:8080/hook"},
+		{url: "http://127.0.0.1:8080/hook", blocked: true},
+		{url: "http://localhost/hook", blocked: true},
+		{url: "http://169.254.169.254/latest/meta-data", blocked: true},
+		{url: "http://10.0.0.5/hook", blocked: true},
+		{url: "http://172.16.3.4/hook", blocked: true},
+		{url: "http://192.168.1.1/hook", blocked: true},
+		{url: "http://[::1]/hook", blocked: true},
+		{url: "http://[fe80::1]/hook", blocked: true},
+		{url: "http://[fd00::1]/hook", blocked: true},
+		{url: "http://0.0.0.0/hook", blocked: true},
+		{url: "ftp://203.0.113.10/hook", invalid: true},
+		{url: "/hook", invalid: true},
+	} {
+		err := ValidateURL(tt.url)
+		switch {
+		case tt.blocked && !errors.Is(err, ErrBlockedAddress):
+			t.Errorf("ValidateURL(%q) = %v, want %v", tt.url, err, ErrBlockedAddress)
+		case tt.invalid && (err == nil || errors.Is(err, ErrBlockedAddress)):
+			t.Errorf("ValidateURL(%q) = %v, want an invalid URL error", tt.url, err)
+		case !tt.blocked && !tt.invalid && err != nil:
+			t.Errorf("ValidateURL(%q) = %v, want nil", tt.url, err)
+		}
+	}
+}
+
+func TestPostRefusesBlockedAddresses(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	// The saved URL may have resolved elsewhere; the dial-time check is
+	// what stops it
+	f := New(nil)
+	if _, err := f.post(srv.URL, []byte("{}")); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("post to %s: err = %v, want %v", srv.URL, err, ErrBlockedAddress)
+	}
+	if hits != 0 {
+		t.Errorf("server got %d request(s), want none", hits)
+	}
+}
+
+func TestReloadStopsSinksOfDomainsThatStopForwarding(t *testing.T) {
+	db, err := database.New(filepath.Join(t.TempDir(), "etiquetta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Conn().Exec(
+		"INSERT INTO domains (id, name, domain, site_id, created_at, is_active, forward_url) VALUES ('d1', 'A', 'a.com', 'site_a', 0, 1, 'https://203.0.113.10/hook')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := New(db.Conn())
+	f.Reload()
+	s := f.sinkFor("a.com")
+	if s == nil {
+		t.Fatal("no sink for a forwarding domain")
+	}
+
+	db.Conn().Exec("UPDATE domains SET forward_url = NULL WHERE id = 'd1'")
+	f.Reload()
+	select {
+	case <-s.finished:
+	case <-time.After(time.Second):
+		t.Fatal("sink still running after its domain stopped forwarding")
+	}
+	if f.sinkFor("a.com") != nil || len(f.sinks) != 0 {
+		t.Errorf("sinks = %v after forwarding was turned off, want none", f.sinks)
+	}
+}
+
+func TestStopSendsQueuedEvents(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		received += len(p.Events)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	f := New(nil)
+	f.client = srv.Client() // the test server is on loopback
+	f.targets["a.com"] = Target{URL: srv.URL, Fields: DefaultFields}
+
+	// A batch and a half: the second half would wait for the flush
+	// interval if Stop didn't send it
+	events := make([]*database.Event, batchSize+batchSize/2)
+	for i := range events {
+		events[i] = &database.Event{Domain: "a.com"}
+	}
+	f.Enqueue(events)
+	f.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received != len(events) {
+		t.Errorf("received %d event(s), want %d", received, len(events))
+	}
+	if s := f.sinkFor("a.com"); s != nil {
+		t.Error("stopped forwarder started a new sink")
+	}
+}
@@ -3,25 +3,69 @@ package geoip
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/oschwald/geoip2-golang"
 )
 
 const (
 	// MaxMind GeoLite2 download URL
 	downloadURL = "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz"
+
+	// MaxMind publishes the archive's SHA256 alongside it
+	checksumURL = "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz.sha256"
+)
+
+// Download phases reported via Progress
+const (
+	PhaseDownloading = "downloading"
+	PhaseVerifying   = "verifying"
+	PhaseExtracting  = "extracting"
+	PhaseDone        = "done"
 )
 
+// ErrDownloadInProgress is returned by Download while another download in
+// this process hasn't finished
+var ErrDownloadInProgress = errors.New("a GeoIP download is already in progress")
+
+// downloading is held for the length of a download, so the scheduled update
+// and a download started from the UI never write the database at once
+var downloading sync.Mutex
+
+// Progress reports how far a download has got.
+// Total is -1 when the server doesn't send a Content-Length.
+type Progress struct {
+	Phase      string `json:"phase"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+}
+
 // Downloader handles downloading and extracting the MaxMind GeoIP database
 type Downloader struct {
 	AccountID  string
 	LicenseKey string
 	DataDir    string
+
+	// Path is where the database is written, DataDir/GeoLite2-City.mmdb
+	// when empty
+	Path string
+
+	// Progress, when set, receives progress updates during Download. Byte
+	// counts are dropped if the receiver is slow, but every phase change,
+	// including the final done, is delivered, so the receiver must keep
+	// reading until Download returns.
+	Progress chan<- Progress
 }
 
 // Status represents the current state of the GeoIP database
@@ -41,19 +85,43 @@ func NewDownloader(accountID, licenseKey, dataDir string) *Downloader {
 	}
 }
 
-// Download downloads and extracts the GeoLite2-City database
+// dbPath returns where the database is written
+func (d *Downloader) dbPath() string {
+	if d.Path != "" {
+		return d.Path
+	}
+	return filepath.Join(d.DataDir, "GeoLite2-City.mmdb")
+}
+
+// Download downloads, verifies and extracts the GeoLite2-City database.
+// The existing database is only replaced once the new archive matches
+// MaxMind's published checksum and the extracted file opens cleanly.
 func (d *Downloader) Download() error {
+	return d.DownloadContext(context.Background())
+}
+
+// DownloadContext is Download, stopping when ctx is cancelled
+func (d *Downloader) DownloadContext(ctx context.Context) error {
 	if d.AccountID == "" || d.LicenseKey == "" {
 		return fmt.Errorf("MaxMind credentials not configured")
 	}
+	if !downloading.TryLock() {
+		return ErrDownloadInProgress
+	}
+	defer downloading.Unlock()
 
 	// Create HTTP client with timeout
 	client := &http.Client{
 		Timeout: 5 * time.Minute,
 	}
 
+	expectedSum, err := d.fetchChecksum(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to fetch checksum: %w", err)
+	}
+
 	// Create request with basic auth
-	req, err := http.NewRequest("GET", downloadURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -70,29 +138,44 @@ func (d *Downloader) Download() error {
 		return fmt.Errorf("download failed with status: %s", resp.Status)
 	}
 
-	// Create temp file for download
-	tmpFile, err := os.CreateTemp(d.DataDir, "geoip-*.tar.gz")
+	// Create temp file for download next to the database, so the final
+	// rename stays on one filesystem
+	tmpFile, err := os.CreateTemp(filepath.Dir(d.dbPath()), "geoip-*.tar.gz")
 	if err != nil {
 		return fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	defer os.Remove(tmpPath)
 
-	// Copy response to temp file
-	_, err = io.Copy(tmpFile, resp.Body)
+	// Copy response to temp file, hashing and reporting progress as we go
+	hasher := sha256.New()
+	counter := &progressWriter{d: d, total: resp.ContentLength}
+	d.report(Progress{Phase: PhaseDownloading, Total: resp.ContentLength})
+	_, err = io.Copy(io.MultiWriter(tmpFile, hasher, counter), resp.Body)
 	tmpFile.Close()
 	if err != nil {
 		return fmt.Errorf("failed to save download: %w", err)
 	}
 
+	d.report(Progress{Phase: PhaseVerifying, Downloaded: counter.n, Total: resp.ContentLength})
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expectedSum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expectedSum, actual)
+	}
+
 	// Extract the database
+	d.report(Progress{Phase: PhaseExtracting, Downloaded: counter.n, Total: resp.ContentLength})
 	dbPath, err := d.extractDatabase(tmpPath)
 	if err != nil {
 		return fmt.Errorf("failed to extract database: %w", err)
 	}
 
+	if err := validateDatabase(dbPath); err != nil {
+		os.Remove(dbPath)
+		return fmt.Errorf("downloaded database is invalid: %w", err)
+	}
+
 	// Move to final location
-	finalPath := filepath.Join(d.DataDir, "GeoLite2-City.mmdb")
+	finalPath := d.dbPath()
 	if err := os.Rename(dbPath, finalPath); err != nil {
 		// If rename fails (cross-device), try copy
 		if err := copyFile(dbPath, finalPath); err != nil {
@@ -101,6 +184,81 @@ func (d *Downloader) Download() error {
 		os.Remove(dbPath)
 	}
 
+	d.report(Progress{Phase: PhaseDone, Downloaded: counter.n, Total: resp.ContentLength})
+	return nil
+}
+
+// fetchChecksum retrieves the published SHA256 of the current archive.
+// The file has the sha256sum format: "<hex>  <filename>".
+func (d *Downloader) fetchChecksum(ctx context.Context, client *http.Client) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", checksumURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(d.AccountID, d.LicenseKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum file")
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// report sends a progress update. Byte counts mid-download don't block the
+// download; phase changes wait for the receiver so none is lost.
+func (d *Downloader) report(p Progress) {
+	if d.Progress == nil {
+		return
+	}
+	if p.Phase != PhaseDownloading || p.Downloaded == 0 {
+		d.Progress <- p
+		return
+	}
+	select {
+	case d.Progress <- p:
+	default:
+	}
+}
+
+// progressWriter counts bytes written and reports download progress
+type progressWriter struct {
+	d     *Downloader
+	total int64
+	n     int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.d.report(Progress{Phase: PhaseDownloading, Downloaded: w.n, Total: w.total})
+	return len(p), nil
+}
+
+// validateDatabase checks that the file is a readable GeoIP City database
+func validateDatabase(path string) error {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if dbType := reader.Metadata().DatabaseType; !strings.Contains(dbType, "City") {
+		return fmt.Errorf("unexpected database type %q", dbType)
+	}
 	return nil
 }
 
@@ -134,7 +292,7 @@ func (d *Downloader) extractDatabase(archivePath string) (string, error) {
 		// Look for the .mmdb file
 		if strings.HasSuffix(header.Name, ".mmdb") {
 			// Create temp file for the database
-			outPath := filepath.Join(d.DataDir, "GeoLite2-City.mmdb.tmp")
+			outPath := d.dbPath() + ".tmp"
 			outFile, err := os.Create(outPath)
 			if err != nil {
 				return "", err
@@ -156,7 +314,7 @@ func (d *Downloader) extractDatabase(archivePath string) (string, error) {
 
 // GetStatus returns the current status of the GeoIP database
 func (d *Downloader) GetStatus() Status {
-	path := filepath.Join(d.DataDir, "GeoLite2-City.mmdb")
+	path := d.dbPath()
 	info, err := os.Stat(path)
 
 	if err != nil {
@@ -0,0 +1,37 @@
+package geoip
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetStatusPath(t *testing.T) {
+	dataDir, otherDir := t.TempDir(), t.TempDir()
+	custom := filepath.Join(otherDir, "city.mmdb")
+	if err := os.WriteFile(custom, []byte("mmdb"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader("id", "key", dataDir)
+	if status := d.GetStatus(); status.Exists || status.Path != filepath.Join(dataDir, "GeoLite2-City.mmdb") {
+		t.Errorf("default status = %+v, want missing database in the data directory", status)
+	}
+
+	d.Path = custom
+	status := d.GetStatus()
+	if !status.Exists || status.Path != custom || status.FileSize != 4 {
+		t.Errorf("custom path status = %+v, want the database at %s", status, custom)
+	}
+}
+
+func TestDownloadRefusesConcurrentDownloads(t *testing.T) {
+	downloading.Lock()
+	defer downloading.Unlock()
+
+	d := NewDownloader("id", "key", t.TempDir())
+	if err := d.Download(); !errors.Is(err, ErrDownloadInProgress) {
+		t.Errorf("Download during another download = %v, want %v", err, ErrDownloadInProgress)
+	}
+}
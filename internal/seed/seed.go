@@ -0,0 +1,410 @@
+// Package seed generates synthetic traffic for load testing and demos. It
+// writes only to domains under the reserved .example TLD so seeded data is
+// easy to tell apart from, and delete alongside, real traffic.
+package seed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/caioricciuti/etiquetta/internal/bot"
+	"github.com/caioricciuti/etiquetta/internal/database"
+	"github.com/caioricciuti/etiquetta/internal/enrichment"
+)
+
+// DomainSuffix ends every seeded domain name
+const DomainSuffix = ".example"
+
+// Options controls the amount and shape of seeded traffic
+type Options struct {
+	Events  int // total events to insert
+	Domains int // demo domains to spread them over
+	Days    int // events are spread over the last Days days
+
+	Pages            int     // distinct paths per domain, visited with a Zipf skew
+	PageviewsPerSess float64 // mean pageviews per session
+	BotRatio         float64 // share of sessions from bots (good, suspicious or bad)
+	CampaignRatio    float64 // share of human sessions arriving from a UTM campaign
+	MobileRatio      float64 // share of human sessions on phones
+	ConversionRatio  float64 // share of human sessions firing a "signup" event
+
+	BatchSize int   // events per InsertBatch call
+	Seed      int64 // random seed; the same options and seed give the same data
+}
+
+// DefaultOptions returns plausible distributions for a small marketing site
+func DefaultOptions() Options {
+	return Options{
+		Events:           100000,
+		Domains:          1,
+		Days:             30,
+		Pages:            40,
+		PageviewsPerSess: 2.5,
+		BotRatio:         0.15,
+		CampaignRatio:    0.2,
+		MobileRatio:      0.45,
+		ConversionRatio:  0.03,
+		BatchSize:        1000,
+		Seed:             1,
+	}
+}
+
+// Validate checks that options describe a run that can be generated
+func (o Options) Validate() error {
+	switch {
+	case o.Events < 1:
+		return errors.New("events must be at least 1")
+	case o.Domains < 1:
+		return errors.New("domains must be at least 1")
+	case o.Days < 1:
+		return errors.New("days must be at least 1")
+	case o.Pages < 1:
+		return errors.New("pages must be at least 1")
+	case o.PageviewsPerSess < 1:
+		return errors.New("pageviews per session must be at least 1")
+	}
+	for name, ratio := range map[string]float64{
+		"bot ratio": o.BotRatio, "campaign ratio": o.CampaignRatio,
+		"mobile ratio": o.MobileRatio, "conversion ratio": o.ConversionRatio,
+	} {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	return nil
+}
+
+// DomainNames returns the demo domains a run with n domains writes to
+func DomainNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("demo%d%s", i+1, DomainSuffix)
+	}
+	return names
+}
+
+// Progress reports how many events have been inserted so far
+type Progress struct {
+	Inserted int
+	Sessions int
+}
+
+// weighted picks values by relative weight
+type weighted[T any] struct {
+	values  []T
+	weights []float64
+	total   float64
+}
+
+func newWeighted[T any](pairs ...any) *weighted[T] {
+	w := &weighted[T]{}
+	for i := 0; i < len(pairs); i += 2 {
+		w.values = append(w.values, pairs[i].(T))
+		weight := float64(pairs[i+1].(int))
+		w.weights = append(w.weights, weight)
+		w.total += weight
+	}
+	return w
+}
+
+func (w *weighted[T]) pick(rng *rand.Rand) T {
+	x := rng.Float64() * w.total
+	for i, weight := range w.weights {
+		if x < weight {
+			return w.values[i]
+		}
+		x -= weight
+	}
+	return w.values[len(w.values)-1]
+}
+
+type country struct{ code, city string }
+
+var (
+	referrers = newWeighted[string](
+		"", 40,
+		"https://www.google.com/", 30,
+		"https://www.bing.com/", 4,
+		"https://duckduckgo.com/", 4,
+		"https://t.co/x", 4,
+		"https://www.facebook.com/", 3,
+		"https://www.linkedin.com/", 3,
+		"https://news.ycombinator.com/", 3,
+		"https://github.com/", 3,
+		"https://www.reddit.com/", 3,
+		"https://blog.partner.example/review", 3,
+	)
+	countries = newWeighted[country](
+		country{"US", "New York"}, 30,
+		country{"GB", "London"}, 10,
+		country{"DE", "Berlin"}, 10,
+		country{"BR", "São Paulo"}, 8,
+		country{"FR", "Paris"}, 6,
+		country{"IN", "Bengaluru"}, 6,
+		country{"PT", "Lisbon"}, 4,
+		country{"ES", "Madrid"}, 4,
+		country{"CA", "Toronto"}, 4,
+		country{"JP", "Tokyo"}, 3,
+		country{"AU", "Sydney"}, 3,
+		country{"NL", "Amsterdam"}, 3,
+	)
+	desktopBrowsers = newWeighted[[2]string](
+		[2]string{"Chrome", "Windows"}, 40,
+		[2]string{"Chrome", "macOS"}, 15,
+		[2]string{"Safari", "macOS"}, 15,
+		[2]string{"Edge", "Windows"}, 12,
+		[2]string{"Firefox", "Windows"}, 8,
+		[2]string{"Firefox", "Linux"}, 5,
+		[2]string{"Chrome", "Linux"}, 5,
+	)
+	mobileBrowsers = newWeighted[[2]string](
+		[2]string{"Safari", "iOS"}, 45,
+		[2]string{"Chrome", "Android"}, 40,
+		[2]string{"Samsung Internet", "Android"}, 10,
+		[2]string{"Firefox", "Android"}, 5,
+	)
+	campaigns = newWeighted[[3]string](
+		[3]string{"google", "cpc", "brand"}, 30,
+		[3]string{"google", "cpc", "generic"}, 25,
+		[3]string{"facebook", "paid_social", "retargeting"}, 20,
+		[3]string{"newsletter", "email", "monthly"}, 15,
+		[3]string{"linkedin", "paid_social", "launch"}, 10,
+	)
+	botKinds = newWeighted[string](bot.CategoryGoodBot, 50, bot.CategorySuspicious, 25, bot.CategoryBadBot, 25)
+	// hourWeights shapes traffic over the day (UTC), peaking in the afternoon
+	hourWeights = []int{2, 1, 1, 1, 1, 2, 3, 5, 7, 8, 9, 9, 9, 9, 10, 10, 9, 8, 7, 6, 5, 4, 3, 2}
+)
+
+var pathWords = []string{"pricing", "features", "blog", "docs", "about", "contact", "careers", "integrations", "security", "changelog", "customers", "guides"}
+
+// paths returns n plausible paths, most popular first
+func paths(n int) []string {
+	out := []string{"/"}
+	for i := 0; len(out) < n; i++ {
+		word := pathWords[i%len(pathWords)]
+		if i < len(pathWords) {
+			out = append(out, "/"+word)
+		} else {
+			out = append(out, fmt.Sprintf("/%s/post-%d", word, i/len(pathWords)))
+		}
+	}
+	return out[:n]
+}
+
+// Run inserts synthetic events, registers the demo domains and materializes
+// session aggregates. progress, if set, is called after every batch.
+func Run(db *database.DB, opts Options, progress func(Progress)) (Progress, error) {
+	if err := opts.Validate(); err != nil {
+		return Progress{}, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	domains := DomainNames(opts.Domains)
+	if err := registerDomains(db, domains); err != nil {
+		return Progress{}, err
+	}
+
+	g := &generator{
+		rng:     rand.New(rand.NewSource(opts.Seed)),
+		opts:    opts,
+		domains: domains,
+		paths:   paths(opts.Pages),
+		end:     time.Now().Add(-time.Minute),
+	}
+	g.zipf = rand.NewZipf(g.rng, 1.2, 1, uint64(len(g.paths)-1))
+	// Roughly three sessions per visitor over the period
+	g.visitors = int(math.Max(1, float64(opts.Events)/(opts.PageviewsPerSess*3)))
+	start := g.end.AddDate(0, 0, -opts.Days)
+
+	var p Progress
+	batch := make([]*database.Event, 0, opts.BatchSize)
+	for p.Inserted < opts.Events {
+		session := g.session(start)
+		p.Sessions++
+		if remaining := opts.Events - p.Inserted - len(batch); len(session) > remaining {
+			session = session[:remaining]
+		}
+		batch = append(batch, session...)
+		if len(batch) >= opts.BatchSize || p.Inserted+len(batch) >= opts.Events {
+			if err := db.InsertBatch(batch, nil, nil); err != nil {
+				return p, err
+			}
+			p.Inserted += len(batch)
+			batch = batch[:0]
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}
+
+	analyzer := bot.NewBatchAnalyzer(db.Conn(), 0)
+	return p, analyzer.MaterializeSessions(start)
+}
+
+// registerDomains adds the demo domains so they appear in the dashboard
+func registerDomains(db *database.DB, domains []string) error {
+	now := time.Now().UnixMilli()
+	for _, d := range domains {
+		_, err := db.Conn().Exec(
+			"INSERT OR IGNORE INTO domains (id, name, domain, site_id, created_at, is_active) VALUES (?, ?, ?, ?, ?, 1)",
+			"seed_"+d, "Demo "+strings.TrimSuffix(d, DomainSuffix), d, "site_seed_"+strings.TrimSuffix(d, DomainSuffix), now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register domain %s: %w", d, err)
+		}
+	}
+	return nil
+}
+
+type generator struct {
+	rng      *rand.Rand
+	opts     Options
+	domains  []string
+	paths    []string
+	zipf     *rand.Zipf
+	visitors int
+	end      time.Time
+}
+
+func (g *generator) id() string {
+	return fmt.Sprintf("%016x%016x", g.rng.Uint64(), g.rng.Uint64())
+}
+
+// sessionStart picks a time following hourWeights, early enough for the
+// session to end before g.end
+func (g *generator) sessionStart(start time.Time) time.Time {
+	for {
+		t := start.Add(time.Duration(g.rng.Int63n(int64(g.end.Sub(start) - time.Hour))))
+		if g.rng.Intn(10) < hourWeights[t.UTC().Hour()] {
+			return t
+		}
+	}
+}
+
+// session generates the events of one visit
+func (g *generator) session(start time.Time) []*database.Event {
+	rng := g.rng
+	base := &database.Event{
+		SessionID:   g.id(),
+		VisitorHash: fmt.Sprintf("seed%012x", rng.Intn(g.visitors)),
+		Domain:      g.domains[rng.Intn(len(g.domains))],
+		BotSignals:  "[]",
+		BotCategory: bot.CategoryHuman,
+	}
+	c := countries.pick(rng)
+	base.GeoCountry, base.GeoCity = &c.code, &c.city
+
+	device, browser := "desktop", desktopBrowsers.pick(rng)
+	if rng.Float64() < g.opts.MobileRatio {
+		device, browser = "mobile", mobileBrowsers.pick(rng)
+	}
+	base.DeviceType, base.BrowserName, base.OSName = &device, &browser[0], &browser[1]
+
+	pageviews := 1 + int(rng.ExpFloat64()*(g.opts.PageviewsPerSess-1))
+	isBot := rng.Float64() < g.opts.BotRatio
+	if isBot {
+		g.botify(base)
+		pageviews = 1 + rng.Intn(8)
+	}
+
+	var referrer string
+	var campaign *[3]string
+	if !isBot {
+		base.HasScroll = rng.Float64() < 0.8
+		base.HasMouseMove = device == "desktop"
+		base.HasTouch = device == "mobile"
+		referrer = referrers.pick(rng)
+		if rng.Float64() < g.opts.CampaignRatio {
+			cmp := campaigns.pick(rng)
+			campaign = &cmp
+		}
+	}
+
+	t := g.sessionStart(start)
+	events := make([]*database.Event, 0, pageviews*2)
+	for i := 0; i < pageviews; i++ {
+		path := g.paths[g.zipf.Uint64()]
+		pv := g.event(base, "pageview", t, path)
+		if i == 0 && referrer != "" {
+			ref, refType := referrer, enrichment.ClassifyReferrer(referrer)
+			pv.ReferrerURL, pv.ReferrerType = &ref, &refType
+		}
+		if i == 0 && campaign != nil {
+			pv.URL += fmt.Sprintf("?utm_source=%s&utm_medium=%s&utm_campaign=%s", campaign[0], campaign[1], campaign[2])
+			pv.UTMSource, pv.UTMMedium, pv.UTMCampaign = &campaign[0], &campaign[1], &campaign[2]
+		}
+		events = append(events, pv)
+
+		dwell := time.Duration(5+rng.ExpFloat64()*45) * time.Second
+		if !isBot {
+			engagement := g.event(base, "engagement", t.Add(dwell), path)
+			engagement.Props, _ = json.Marshal(map[string]int64{"visible_time_ms": dwell.Milliseconds()})
+			events = append(events, engagement)
+		}
+		t = t.Add(dwell + time.Duration(rng.Intn(5))*time.Second)
+	}
+
+	if !isBot && rng.Float64() < g.opts.ConversionRatio {
+		signup := g.event(base, "custom", t, "/signup")
+		name := "signup"
+		signup.EventName = &name
+		signup.Props, _ = json.Marshal(map[string]string{"plan": []string{"free", "pro", "team"}[rng.Intn(3)]})
+		events = append(events, signup)
+	}
+	return events
+}
+
+// botify turns a session's base event into bot traffic of a random kind
+func (g *generator) botify(e *database.Event) {
+	desktop, crawler := "desktop", "Googlebot"
+	e.DeviceType = &desktop
+	var signals []bot.Signal
+	switch kind := botKinds.pick(g.rng); kind {
+	case bot.CategoryGoodBot:
+		e.BrowserName = &crawler
+		signals = []bot.Signal{{Name: "known_good_bot", Value: crawler}}
+	case bot.CategorySuspicious:
+		signals = []bot.Signal{
+			{Name: "missing_accept_language", Weight: bot.WeightMissingHeaders},
+			{Name: "datacenter_ip", Weight: bot.WeightDatacenterIP},
+		}
+		e.DatacenterIP = true
+	default:
+		signals = []bot.Signal{
+			{Name: "headless_browser", Weight: bot.WeightHeadlessBrowser},
+			{Name: "webdriver", Weight: bot.WeightWebdriver},
+			{Name: "datacenter_ip", Weight: bot.WeightDatacenterIP},
+		}
+		e.DatacenterIP = true
+	}
+
+	score := 0
+	for _, s := range signals {
+		score += s.Weight
+	}
+	e.BotScore = score
+	e.BotSignals = bot.SignalsToJSON(signals)
+	e.BotCategory = bot.ScoreToCategory(score)
+	if signals[0].Name == "known_good_bot" {
+		e.BotCategory = bot.CategoryGoodBot
+	}
+	e.IsBot = e.BotCategory == bot.CategoryGoodBot || e.BotCategory == bot.CategoryBadBot
+}
+
+// event copies the session's shared fields into a new event
+func (g *generator) event(base *database.Event, eventType string, t time.Time, path string) *database.Event {
+	e := *base
+	e.ID = g.id()
+	e.Timestamp = t
+	e.EventType = eventType
+	e.Path = path
+	e.URL = "https://" + base.Domain + path
+	return &e
+}
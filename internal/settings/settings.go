@@ -82,6 +82,25 @@ func (s *Service) Get(key string) (string, error) {
 	return value, nil
 }
 
+// PublicURLKey is the externally reachable base URL of this instance, used
+// for every absolute link it generates (snippets, share and invite links,
+// emails). email_base_url is its email-only predecessor, still honoured.
+const (
+	PublicURLKey          = "public_url"
+	legacyEmailBaseURLKey = "email_base_url"
+)
+
+// PublicURL returns the configured public base URL without a trailing slash,
+// or "" when none is set. Background jobs with no request to derive a host
+// from can only build links when this is set.
+func (s *Service) PublicURL() string {
+	u := s.GetWithDefault(PublicURLKey, "")
+	if u == "" {
+		u = s.GetWithDefault(legacyEmailBaseURLKey, "")
+	}
+	return strings.TrimSuffix(strings.TrimSpace(u), "/")
+}
+
 // GetWithDefault retrieves a setting value with a default fallback
 func (s *Service) GetWithDefault(key, defaultValue string) string {
 	val, err := s.Get(key)
@@ -104,6 +123,19 @@ func (s *Service) GetInt(key string, defaultValue int) int {
 	return i
 }
 
+// GetFloat retrieves a setting as a float
+func (s *Service) GetFloat(key string, defaultValue float64) float64 {
+	val, err := s.Get(key)
+	if err != nil || val == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return f
+}
+
 // GetBool retrieves a setting as a boolean
 func (s *Service) GetBool(key string, defaultValue bool) bool {
 	val, err := s.Get(key)
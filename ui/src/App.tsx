@@ -23,6 +23,9 @@ import { Explorer } from './pages/Explorer'
 import { Login } from './pages/Login'
 import { BotAnalysis } from './pages/BotAnalysis'
 import { AdFraud } from './pages/AdFraud'
+import { SharedReport } from './pages/SharedReport'
+import { ResetPassword } from './pages/ResetPassword'
+import { AcceptInvite } from './pages/AcceptInvite'
 import { DomainPicker } from './components/DomainPicker'
 import { FeatureBadge } from './components/FeatureGate'
 import {
@@ -433,6 +436,9 @@ function App() {
                   <Login />
                 </PublicRoute>
               } />
+              <Route path="/share/:token" element={<SharedReport />} />
+              <Route path="/reset-password" element={<ResetPassword />} />
+              <Route path="/accept-invite" element={<AcceptInvite />} />
               <Route path="/*" element={
                 <ProtectedRoute>
                   <AppLayout />
@@ -1,6 +1,7 @@
 import {
   DashboardHeader,
   FilterBar,
+  TrafficAlertBanner,
   StatsGrid,
   TrafficChart,
   TopPages,
@@ -14,12 +15,14 @@ import {
   CampaignsCard,
   CustomEventsCard,
   OutboundLinksCard,
+  DownloadsCard,
 } from './index'
 
 export function Dashboard() {
   return (
     <div className="p-6 space-y-6 overflow-y-auto h-full">
       <DashboardHeader />
+      <TrafficAlertBanner />
       <FilterBar />
       <StatsGrid />
       <TrafficChart />
@@ -37,10 +40,11 @@ export function Dashboard() {
         <WebVitalsCard />
         <ErrorsCard />
       </div>
-      <div className="grid grid-cols-1 lg:grid-cols-3 gap-6">
+      <div className="grid grid-cols-1 lg:grid-cols-2 gap-6">
         <CampaignsCard />
         <CustomEventsCard />
         <OutboundLinksCard />
+        <DownloadsCard />
       </div>
     </div>
   )
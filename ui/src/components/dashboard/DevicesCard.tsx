@@ -1,4 +1,4 @@
-import { Monitor, Smartphone, Tablet } from 'lucide-react'
+import { Bot, Monitor, Smartphone, Tablet, Tv } from 'lucide-react'
 import { useDevices } from '../../hooks/useAnalyticsQueries'
 import { useFilterStore } from '../../stores/useFilterStore'
 import { formatNumber } from '../../lib/utils'
@@ -9,6 +9,8 @@ const DEVICE_ICONS: Record<string, typeof Monitor> = {
   desktop: Monitor,
   mobile: Smartphone,
   tablet: Tablet,
+  tv: Tv,
+  bot: Bot,
 }
 
 export function DevicesCard() {
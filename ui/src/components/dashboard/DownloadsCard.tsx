@@ -0,0 +1,37 @@
+import { FileDown } from 'lucide-react'
+import { useDownloads } from '../../hooks/useAnalyticsQueries'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '../ui/card'
+import { ProgressList } from './ProgressList'
+import { ProgressListSkeleton } from './skeletons'
+
+export function DownloadsCard() {
+  const { data, isLoading, isPlaceholderData } = useDownloads()
+
+  const items = (data ?? []).slice(0, 5).map((file) => {
+    const maxDownloads = data?.[0]?.downloads || 1
+    let displayName = file.url || 'Unknown'
+    try {
+      displayName = decodeURIComponent(new URL(file.url).pathname.split('/').pop() || file.url)
+    } catch { /* keep original */ }
+    return {
+      label: displayName,
+      value: file.downloads,
+      percentage: (file.downloads / maxDownloads) * 100,
+    }
+  })
+
+  return (
+    <Card className={`transition-opacity ${isPlaceholderData ? 'opacity-60' : ''}`}>
+      <CardHeader>
+        <div className="flex items-center gap-2">
+          <FileDown className="h-5 w-5 text-muted-foreground" />
+          <CardTitle className="text-lg font-semibold">Downloads</CardTitle>
+        </div>
+        <CardDescription>Files downloaded by visitors</CardDescription>
+      </CardHeader>
+      <CardContent>
+        {isLoading && !data ? <ProgressListSkeleton count={3} /> : <ProgressList items={items} colorClass="bg-teal-500" />}
+      </CardContent>
+    </Card>
+  )
+}
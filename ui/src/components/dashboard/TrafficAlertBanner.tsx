@@ -0,0 +1,43 @@
+import { formatDistanceToNow } from 'date-fns'
+import { AlertTriangle } from 'lucide-react'
+import { useTrafficStatus } from '../../hooks/useAnalyticsQueries'
+import { useSelectedDomain } from '../../hooks/useSelectedDomain'
+
+// Flags domains whose pageviews dropped to zero or far below their usual
+// level, which usually means the tracking snippet was removed or broke
+export function TrafficAlertBanner() {
+  const { data } = useTrafficStatus()
+  const { selectedDomain } = useSelectedDomain()
+
+  const alerts = (data ?? []).filter(
+    (s) =>
+      (s.status === 'zero' || s.status === 'low') &&
+      (!selectedDomain || s.domain === selectedDomain.domain)
+  )
+  if (alerts.length === 0) return null
+
+  return (
+    <div className="space-y-2">
+      {alerts.map((s) => (
+        <div
+          key={s.domain}
+          className="flex items-start gap-3 rounded-lg border border-amber-500/40 bg-amber-500/10 px-4 py-3 text-sm"
+        >
+          <AlertTriangle className="h-4 w-4 text-amber-500 mt-0.5 shrink-0" />
+          <div>
+            <p className="font-medium">
+              {s.status === 'zero' ? 'No traffic' : 'Traffic far below normal'} on {s.domain}
+            </p>
+            <p className="text-muted-foreground">
+              {s.recent_pageviews} pageviews in the last hour, usually around{' '}
+              {Math.round(s.baseline_pageviews)}.
+              {s.last_pageview_at &&
+                ` Last pageview ${formatDistanceToNow(new Date(s.last_pageview_at), { addSuffix: true })}.`}{' '}
+              Check that the tracking snippet is still installed.
+            </p>
+          </div>
+        </div>
+      ))}
+    </div>
+  )
+}
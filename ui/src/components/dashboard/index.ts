@@ -1,5 +1,6 @@
 export { DashboardHeader } from './DashboardHeader'
 export { FilterBar } from './FilterBar'
+export { TrafficAlertBanner } from './TrafficAlertBanner'
 export { StatsGrid } from './StatsGrid'
 export { TrafficChart } from './TrafficChart'
 export { TopPages } from './TopPages'
@@ -13,5 +14,6 @@ export { ErrorsCard } from './ErrorsCard'
 export { CampaignsCard } from './CampaignsCard'
 export { CustomEventsCard } from './CustomEventsCard'
 export { OutboundLinksCard } from './OutboundLinksCard'
+export { DownloadsCard } from './DownloadsCard'
 export { ProgressList } from './ProgressList'
 export { StatCard, calcTrend } from './StatCard'
@@ -15,13 +15,16 @@ import type {
   BrowserData,
   WebVitals,
   ErrorSummary,
+  TrafficStatus,
   Campaign,
   CustomEvent,
   OutboundLink,
+  DownloadStat,
   BotData,
   FraudSummary,
-  SourceQuality,
+  SourceQualityReport,
   AdFraudCampaign,
+  CampaignOverlap,
 } from '../lib/types'
 
 function useAnalyticsParams() {
@@ -176,6 +179,17 @@ export function useCustomEvents() {
   })
 }
 
+export function useDownloads() {
+  const { qs, enabled } = useAnalyticsParams()
+  return useQuery({
+    queryKey: ['stats', 'downloads', qs],
+    queryFn: () => fetchAPI<DownloadStat[]>(`/api/stats/downloads?${qs}`),
+    enabled,
+    meta: { silent: true },
+    placeholderData: keepPreviousData,
+  })
+}
+
 export function useOutboundLinks() {
   const { qs, enabled } = useAnalyticsParams()
   return useQuery({
@@ -187,6 +201,15 @@ export function useOutboundLinks() {
   })
 }
 
+export function useTrafficStatus() {
+  return useQuery({
+    queryKey: ['alerts', 'traffic'],
+    queryFn: () => fetchAPI<TrafficStatus[]>('/api/alerts/traffic'),
+    refetchInterval: 5 * 60 * 1000,
+    meta: { silent: true },
+  })
+}
+
 // --- Bot Analysis ---
 
 function useBotParams() {
@@ -233,7 +256,7 @@ export function useSourceQuality() {
   const { qs, enabled } = useBotParams()
   return useQuery({
     queryKey: ['sources', 'quality', qs],
-    queryFn: () => fetchAPI<SourceQuality[]>(`/api/sources/quality?${qs}`),
+    queryFn: () => fetchAPI<SourceQualityReport>(`/api/sources/quality?${qs}`),
     enabled,
     placeholderData: keepPreviousData,
   })
@@ -249,8 +272,8 @@ export function useAdFraudCampaigns() {
 export function useCreateCampaign() {
   const qc = useQueryClient()
   return useMutation({
-    mutationFn: (data: { name: string; cpc: number; cpm: number; budget: number }) =>
-      fetchAPI<AdFraudCampaign>('/api/campaigns', {
+    mutationFn: (data: { name: string; cpc: number; cpm: number; budget: number; currency: string }) =>
+      fetchAPI<AdFraudCampaign & { overlaps: CampaignOverlap[] }>('/api/campaigns', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(data),
@@ -259,6 +282,42 @@ export function useCreateCampaign() {
   })
 }
 
+export interface CampaignImportResult {
+  created: number
+  duplicates: number
+  skipped: number
+  errors: number
+  overlapping: number
+  rows: {
+    line: number
+    name?: string
+    status: 'created' | 'duplicate' | 'skipped' | 'error'
+    id?: string
+    error?: string
+    overlaps?: CampaignOverlap[]
+  }[]
+}
+
+export function useCampaignOverlaps() {
+  return useQuery({
+    queryKey: ['campaigns', 'overlaps'],
+    queryFn: () => fetchAPI<CampaignOverlap[]>('/api/campaigns/overlaps'),
+  })
+}
+
+export function useImportCampaigns() {
+  const qc = useQueryClient()
+  return useMutation({
+    mutationFn: (file: File) =>
+      fetchAPI<CampaignImportResult>('/api/campaigns/import', {
+        method: 'POST',
+        headers: { 'Content-Type': 'text/csv' },
+        body: file,
+      }),
+    onSuccess: () => qc.invalidateQueries({ queryKey: ['campaigns'] }),
+  })
+}
+
 export function useDeleteCampaign() {
   const qc = useQueryClient()
   return useMutation({
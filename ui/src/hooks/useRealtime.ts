@@ -5,10 +5,60 @@ const MAX_RETRIES = 10
 const BASE_DELAY = 1000
 const MAX_DELAY = 60_000
 
-export function useRealtime() {
+// Some proxies buffer or drop SSE; WebSocket can be chosen instead
+export type RealtimeTransport = 'sse' | 'ws'
+
+export const REALTIME_TRANSPORT_KEY = 'etiquetta_realtime_transport'
+
+export function getRealtimeTransport(): RealtimeTransport {
+  return localStorage.getItem(REALTIME_TRANSPORT_KEY) === 'ws' ? 'ws' : 'sse'
+}
+
+export function setRealtimeTransport(transport: RealtimeTransport) {
+  localStorage.setItem(REALTIME_TRANSPORT_KEY, transport)
+}
+
+interface RealtimeConnection {
+  close: () => void
+}
+
+function openConnection(
+  transport: RealtimeTransport,
+  lastEventId: string,
+  onOpen: () => void,
+  onMessage: (data: string, id: string) => void,
+  onError: () => void,
+): RealtimeConnection {
+  if (transport === 'ws') {
+    const scheme = window.location.protocol === 'https:' ? 'wss:' : 'ws:'
+    const ws = new WebSocket(`${scheme}//${window.location.host}/api/events/ws`)
+    ws.onopen = onOpen
+    ws.onmessage = (event) => onMessage(event.data, '')
+    ws.onclose = onError
+    return {
+      close: () => {
+        ws.onclose = null
+        ws.close()
+      },
+    }
+  }
+
+  // Reconnects are manual, so pass the last ID to replay missed notifications
+  const url = lastEventId
+    ? `/api/events/stream?last_event_id=${encodeURIComponent(lastEventId)}`
+    : '/api/events/stream'
+  const es = new EventSource(url, { withCredentials: true })
+  es.onopen = onOpen
+  es.onmessage = (event) => onMessage(event.data, event.lastEventId)
+  es.onerror = onError
+  return { close: () => es.close() }
+}
+
+export function useRealtime(transport: RealtimeTransport = getRealtimeTransport()) {
   const queryClient = useQueryClient()
-  const esRef = useRef<EventSource | null>(null)
+  const connRef = useRef<RealtimeConnection | null>(null)
   const retriesRef = useRef(0)
+  const lastEventIdRef = useRef('')
   const timerRef = useRef<number | null>(null)
   const debounceRef = useRef<number | null>(null)
 
@@ -20,52 +70,53 @@ export function useRealtime() {
   }, [queryClient])
 
   const connect = useCallback(() => {
-    if (esRef.current) {
-      esRef.current.close()
-      esRef.current = null
+    if (connRef.current) {
+      connRef.current.close()
+      connRef.current = null
     }
 
-    const es = new EventSource('/api/events/stream', { withCredentials: true })
-    esRef.current = es
-
-    es.onopen = () => {
-      retriesRef.current = 0
-    }
-
-    es.onmessage = (event) => {
-      try {
-        const data = JSON.parse(event.data)
-        if (data.type === 'batch') {
-          invalidateAnalytics()
+    const conn = openConnection(
+      transport,
+      lastEventIdRef.current,
+      () => {
+        retriesRef.current = 0
+      },
+      (raw, id) => {
+        if (id) lastEventIdRef.current = id
+        try {
+          const data = JSON.parse(raw)
+          if (data.type === 'batch') {
+            invalidateAnalytics()
+          }
+        } catch {
+          // ignore parse errors
         }
-      } catch {
-        // ignore parse errors
-      }
-    }
-
-    es.onerror = () => {
-      es.close()
-      esRef.current = null
+      },
+      () => {
+        conn.close()
+        connRef.current = null
 
-      if (retriesRef.current < MAX_RETRIES) {
-        const delay = Math.min(
-          BASE_DELAY * Math.pow(2, retriesRef.current) + Math.random() * 1000,
-          MAX_DELAY,
-        )
-        retriesRef.current++
-        timerRef.current = window.setTimeout(connect, delay)
-      }
-    }
-  }, [invalidateAnalytics])
+        if (retriesRef.current < MAX_RETRIES) {
+          const delay = Math.min(
+            BASE_DELAY * Math.pow(2, retriesRef.current) + Math.random() * 1000,
+            MAX_DELAY,
+          )
+          retriesRef.current++
+          timerRef.current = window.setTimeout(connect, delay)
+        }
+      },
+    )
+    connRef.current = conn
+  }, [transport, invalidateAnalytics])
 
   useEffect(() => {
     connect()
     return () => {
       if (timerRef.current) clearTimeout(timerRef.current)
       if (debounceRef.current) clearTimeout(debounceRef.current)
-      if (esRef.current) {
-        esRef.current.close()
-        esRef.current = null
+      if (connRef.current) {
+        connRef.current.close()
+        connRef.current = null
       }
     }
   }, [connect])
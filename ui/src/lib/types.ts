@@ -65,6 +65,16 @@ export interface ErrorSummary {
   affected_sessions: number
 }
 
+export interface TrafficStatus {
+  domain: string
+  status: 'ok' | 'low' | 'zero' | 'insufficient_data'
+  recent_pageviews: number
+  baseline_pageviews: number
+  last_pageview_at?: number
+  changed_at: number
+  checked_at: number
+}
+
 export interface Campaign {
   utm_source: string
   utm_medium: string
@@ -85,6 +95,13 @@ export interface OutboundLink {
   unique_visitors: number
 }
 
+export interface DownloadStat {
+  url: string
+  extension: string
+  downloads: number
+  unique_visitors: number
+}
+
 export interface MapPoint {
   city: string
   country: string
@@ -133,11 +150,16 @@ export interface BotData {
 }
 
 // Ad Fraud types
+export interface Money {
+  amount: number
+  currency: string
+}
+
 export interface FraudSummary {
   total_clicks: number
   invalid_clicks: number
   invalid_rate: number
-  wasted_spend: number
+  estimated_waste: Money[]
   datacenter_traffic: number
   suspicious_sessions: number
 }
@@ -151,6 +173,12 @@ export interface SourceQuality {
   human_rate: number
 }
 
+export interface SourceQualityReport {
+  sources: SourceQuality[]
+  excluded_sources: number
+  min_visits: number
+}
+
 export interface AdFraudCampaign {
   id: string
   name: string
@@ -161,6 +189,16 @@ export interface AdFraudCampaign {
   cpm: number
   budget: number
   created_at: number
+  currency: string
+}
+
+export interface CampaignOverlap {
+  campaign_id: string
+  campaign_name: string
+  other_id: string
+  other_name: string
+  matcher: string
+  identical: boolean
 }
 
 export interface AnalyticsFilters {
@@ -179,6 +217,9 @@ export interface Domain {
   site_id: string
   is_active: boolean
   created_at: number
+  event_count?: number
+  last_event_at?: number | null
+  retention_days?: { events: number; performance: number; errors: number }
 }
 
 export interface License {
@@ -1,7 +1,7 @@
-import { useState } from 'react'
+import { useRef, useState } from 'react'
 import { fetchAPI } from '@/lib/api'
 import { useDateRangeStore } from '../stores/useDateRangeStore'
-import { useFraudSummary, useSourceQuality, useAdFraudCampaigns, useCreateCampaign, useDeleteCampaign } from '../hooks/useAnalyticsQueries'
+import { useFraudSummary, useSourceQuality, useAdFraudCampaigns, useCampaignOverlaps, useCreateCampaign, useImportCampaigns, useDeleteCampaign } from '../hooks/useAnalyticsQueries'
 import { FeatureGate } from '../components/FeatureGate'
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '../components/ui/card'
 import { Button } from '../components/ui/button'
@@ -14,7 +14,7 @@ import {
   ChartTooltipContent,
   type ChartConfig,
 } from '../components/ui/chart'
-import { ShieldAlert, DollarSign, AlertTriangle, TrendingDown, Plus, Trash2, Download } from 'lucide-react'
+import { ShieldAlert, DollarSign, AlertTriangle, TrendingDown, Plus, Trash2, Download, Upload } from 'lucide-react'
 import { BarChart, Bar, XAxis, YAxis, CartesianGrid, Cell } from 'recharts'
 import { toast } from 'sonner'
 import { formatNumber } from '@/lib/utils'
@@ -23,8 +23,21 @@ const qualityChartConfig = {
   quality_score: { label: 'Quality Score', color: 'var(--chart-2)' },
 } satisfies ChartConfig
 
-function formatCurrency(num: number): string {
-  return new Intl.NumberFormat('en-US', { style: 'currency', currency: 'USD' }).format(num)
+function formatCurrency(num: number, currency = 'USD'): string {
+  return new Intl.NumberFormat('en-US', { style: 'currency', currency }).format(num)
+}
+
+// Campaign costs are stored in minor units (cents for USD, yen for JPY)
+function minorUnitDigits(currency: string): number {
+  try {
+    return new Intl.NumberFormat('en-US', { style: 'currency', currency }).resolvedOptions().maximumFractionDigits ?? 2
+  } catch {
+    return 2
+  }
+}
+
+function formatMinorUnits(minor: number, currency = 'USD'): string {
+  return formatCurrency(minor / 10 ** minorUnitDigits(currency), currency)
 }
 
 function getQualityColor(score: number): string {
@@ -49,30 +62,43 @@ function StatCardSkeleton() {
 function AdFraudContent() {
   const { dateRange, setDateRange } = useDateRangeStore()
   const { data: fraudData, isLoading: fraudLoading, isPlaceholderData: fraudStale } = useFraudSummary()
-  const { data: sourceQuality, isLoading: qualityLoading, isPlaceholderData: qualityStale } = useSourceQuality()
+  const { data: qualityReport, isLoading: qualityLoading, isPlaceholderData: qualityStale } = useSourceQuality()
+  const sourceQuality = qualityReport?.sources
   const { data: campaigns, isLoading: campaignsLoading } = useAdFraudCampaigns()
+  const { data: overlaps } = useCampaignOverlaps()
   const createCampaign = useCreateCampaign()
   const deleteCampaign = useDeleteCampaign()
+  const importCampaigns = useImportCampaigns()
+  const importInput = useRef<HTMLInputElement>(null)
 
   const [showAddCampaign, setShowAddCampaign] = useState(false)
-  const [newCampaign, setNewCampaign] = useState({ name: '', cpc: '', cpm: '', budget: '' })
+  const [newCampaign, setNewCampaign] = useState({ name: '', cpc: '', cpm: '', budget: '', currency: 'USD' })
 
   const isLoading = fraudLoading || qualityLoading
   const isStale = fraudStale || qualityStale
 
   const handleAddCampaign = () => {
+    const currency = newCampaign.currency.trim().toUpperCase() || 'USD'
+    const toMinor = (value: string) => Math.round((parseFloat(value) || 0) * 10 ** minorUnitDigits(currency) * 1e6) / 1e6
     createCampaign.mutate(
       {
         name: newCampaign.name,
-        cpc: parseFloat(newCampaign.cpc) || 0,
-        cpm: parseFloat(newCampaign.cpm) || 0,
-        budget: parseFloat(newCampaign.budget) || 0,
+        cpc: toMinor(newCampaign.cpc),
+        cpm: toMinor(newCampaign.cpm),
+        budget: toMinor(newCampaign.budget),
+        currency,
       },
       {
-        onSuccess: () => {
+        onSuccess: (campaign) => {
           setShowAddCampaign(false)
-          setNewCampaign({ name: '', cpc: '', cpm: '', budget: '' })
-          toast.success('Campaign created')
+          setNewCampaign({ name: '', cpc: '', cpm: '', budget: '', currency })
+          if (campaign.overlaps.length > 0) {
+            toast.warning('Campaign created, but it overlaps other campaigns', {
+              description: `Traffic matching ${campaign.overlaps[0].matcher} is counted for ${campaign.overlaps.map((o) => o.other_name).join(', ')} too.`,
+            })
+          } else {
+            toast.success('Campaign created')
+          }
         },
         onError: (err) => {
           toast.error('Failed to create campaign', { description: err.message })
@@ -81,6 +107,29 @@ function AdFraudContent() {
     )
   }
 
+  const handleImportCampaigns = (e: React.ChangeEvent<HTMLInputElement>) => {
+    const file = e.target.files?.[0]
+    e.target.value = ''
+    if (!file) return
+    importCampaigns.mutate(file, {
+      onSuccess: (result) => {
+        const failed = result.rows.filter((row) => row.status === 'error')
+        let summary = `${result.created} created, ${result.duplicates} duplicates, ${result.skipped} skipped`
+        if (result.overlapping > 0) {
+          summary += `, ${result.overlapping} overlapping other campaigns`
+        }
+        if (failed.length > 0) {
+          toast.warning(`Imported with ${failed.length} errors`, {
+            description: `${summary}. ${failed.slice(0, 3).map((row) => `Line ${row.line}: ${row.error}`).join('; ')}`,
+          })
+        } else {
+          toast.success('Campaigns imported', { description: summary })
+        }
+      },
+      onError: (err) => toast.error('Failed to import campaigns', { description: err.message }),
+    })
+  }
+
   const handleDeleteCampaign = (id: string) => {
     deleteCampaign.mutate(id, {
       onSuccess: () => toast.success('Campaign deleted'),
@@ -150,7 +199,9 @@ function AdFraudContent() {
                 <div>
                   <p className="text-xs font-medium text-muted-foreground uppercase tracking-wider">Wasted Spend</p>
                   <p className="text-2xl font-bold mt-1 text-orange-500">
-                    {formatCurrency(fraudData?.wasted_spend || 0)}
+                    {fraudData?.estimated_waste?.length
+                      ? fraudData.estimated_waste.map((m) => formatCurrency(m.amount, m.currency)).join(' + ')
+                      : formatCurrency(0)}
                   </p>
                 </div>
                 <div className="h-10 w-10 rounded-xl bg-orange-500/10 flex items-center justify-center">
@@ -180,7 +231,12 @@ function AdFraudContent() {
       <Card>
         <CardHeader>
           <CardTitle className="text-lg font-semibold">Traffic Source Quality</CardTitle>
-          <CardDescription>Quality scores for your traffic sources (higher is better)</CardDescription>
+          <CardDescription>
+            Quality scores for your traffic sources (higher is better)
+            {qualityReport && qualityReport.excluded_sources > 0 && (
+              <> &middot; {qualityReport.excluded_sources} source{qualityReport.excluded_sources === 1 ? '' : 's'} with fewer than {qualityReport.min_visits} visits hidden</>
+            )}
+          </CardDescription>
         </CardHeader>
         <CardContent>
           {qualityLoading ? (
@@ -252,16 +308,28 @@ function AdFraudContent() {
               <CardTitle className="text-lg font-semibold">Campaign Manager</CardTitle>
               <CardDescription>Track ad spend and calculate wasted budget</CardDescription>
             </div>
-            <Button onClick={() => setShowAddCampaign(true)} size="sm">
-              <Plus className="h-4 w-4 mr-2" />
-              Add Campaign
-            </Button>
+            <div className="flex gap-2">
+              <input ref={importInput} type="file" accept=".csv,.tsv,text/csv" className="hidden" onChange={handleImportCampaigns} />
+              <Button
+                onClick={() => importInput.current?.click()}
+                size="sm"
+                variant="outline"
+                disabled={importCampaigns.isPending}
+              >
+                <Upload className="h-4 w-4 mr-2" />
+                Import CSV
+              </Button>
+              <Button onClick={() => setShowAddCampaign(true)} size="sm">
+                <Plus className="h-4 w-4 mr-2" />
+                Add Campaign
+              </Button>
+            </div>
           </div>
         </CardHeader>
         <CardContent>
           {showAddCampaign && (
             <div className="mb-6 p-4 border border-border rounded-lg space-y-4 bg-muted/30">
-              <div className="grid grid-cols-2 md:grid-cols-4 gap-4">
+              <div className="grid grid-cols-2 md:grid-cols-5 gap-4">
                 <Input
                   placeholder="Campaign Name"
                   value={newCampaign.name}
@@ -269,22 +337,28 @@ function AdFraudContent() {
                 />
                 <Input
                   type="number"
-                  placeholder="CPC ($)"
+                  placeholder={`CPC (${newCampaign.currency || 'USD'})`}
                   value={newCampaign.cpc}
                   onChange={(e) => setNewCampaign({ ...newCampaign, cpc: e.target.value })}
                 />
                 <Input
                   type="number"
-                  placeholder="CPM ($)"
+                  placeholder={`CPM (${newCampaign.currency || 'USD'})`}
                   value={newCampaign.cpm}
                   onChange={(e) => setNewCampaign({ ...newCampaign, cpm: e.target.value })}
                 />
                 <Input
                   type="number"
-                  placeholder="Budget ($)"
+                  placeholder={`Budget (${newCampaign.currency || 'USD'})`}
                   value={newCampaign.budget}
                   onChange={(e) => setNewCampaign({ ...newCampaign, budget: e.target.value })}
                 />
+                <Input
+                  placeholder="Currency (e.g. EUR)"
+                  maxLength={3}
+                  value={newCampaign.currency}
+                  onChange={(e) => setNewCampaign({ ...newCampaign, currency: e.target.value.toUpperCase() })}
+                />
               </div>
               <div className="flex gap-2">
                 <Button onClick={handleAddCampaign} disabled={!newCampaign.name || createCampaign.isPending}>
@@ -297,6 +371,20 @@ function AdFraudContent() {
             </div>
           )}
 
+          {overlaps && overlaps.length > 0 && (
+            <div className="mb-4 p-3 rounded-lg border border-amber-500/40 bg-amber-500/10 text-sm space-y-1">
+              <p className="flex items-center gap-2 font-medium">
+                <AlertTriangle className="h-4 w-4 text-amber-500" />
+                Overlapping campaigns share traffic, so their reports count the same clicks and spend
+              </p>
+              {overlaps.map((o) => (
+                <p key={`${o.campaign_id}-${o.other_id}`} className="text-muted-foreground">
+                  {o.campaign_name} and {o.other_name}: {o.identical ? `identical UTM values (${o.matcher})` : o.matcher}
+                </p>
+              ))}
+            </div>
+          )}
+
           {campaignsLoading ? (
             <div className="space-y-3">
               <Skeleton className="h-10 w-full" />
@@ -318,9 +406,9 @@ function AdFraudContent() {
                   {campaigns!.map((campaign) => (
                     <tr key={campaign.id} className="border-b border-border last:border-0 hover:bg-muted/50 transition-colors">
                       <td className="py-3 px-4 font-medium">{campaign.name}</td>
-                      <td className="text-right py-3 px-4 tabular-nums">{formatCurrency(campaign.cpc)}</td>
-                      <td className="text-right py-3 px-4 tabular-nums">{formatCurrency(campaign.cpm)}</td>
-                      <td className="text-right py-3 px-4 tabular-nums">{formatCurrency(campaign.budget)}</td>
+                      <td className="text-right py-3 px-4 tabular-nums">{formatMinorUnits(campaign.cpc, campaign.currency)}</td>
+                      <td className="text-right py-3 px-4 tabular-nums">{formatMinorUnits(campaign.cpm, campaign.currency)}</td>
+                      <td className="text-right py-3 px-4 tabular-nums">{formatMinorUnits(campaign.budget, campaign.currency)}</td>
                       <td className="text-right py-3 px-4">
                         <div className="flex items-center justify-end gap-2">
                           <Button
@@ -7,6 +7,7 @@ import { Label } from '@/components/ui/label'
 import {
   Loader2,
   AlertCircle,
+  CheckCircle2,
   Mail,
   Lock,
   Zap,
@@ -19,7 +20,7 @@ import {
 
 const emailRegex = /^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$/
 
-type Mode = 'login' | 'onboarding'
+type Mode = 'login' | 'onboarding' | 'forgot'
 
 export function Login() {
   const [mode, setMode] = useState<Mode>('login')
@@ -30,6 +31,7 @@ export function Login() {
   const [loading, setLoading] = useState(false)
   const [showPassword, setShowPassword] = useState(false)
   const [showConfirmPassword, setShowConfirmPassword] = useState(false)
+  const [resetSent, setResetSent] = useState(false)
   const { login, refresh } = useAuth()
   const navigate = useNavigate()
   const [searchParams] = useSearchParams()
@@ -37,9 +39,17 @@ export function Login() {
   useEffect(() => {
     if (searchParams.get('onboarding') === 'true') {
       setMode('onboarding')
+    } else if (searchParams.get('forgot') === 'true') {
+      setMode('forgot')
     }
   }, [searchParams])
 
+  const switchMode = (next: Mode) => {
+    setMode(next)
+    setError('')
+    setResetSent(false)
+  }
+
   const handleLogin = async (e: React.FormEvent) => {
     e.preventDefault()
     setError('')
@@ -55,6 +65,40 @@ export function Login() {
     }
   }
 
+  // The server answers the same whether or not the email has an account, so
+  // the confirmation doesn't reveal which addresses are registered
+  const handleForgot = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+
+    if (!email || !emailRegex.test(email)) {
+      setError('Please enter a valid email address')
+      return
+    }
+
+    setLoading(true)
+
+    try {
+      const response = await fetch('/api/auth/forgot-password', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        body: JSON.stringify({ email }),
+      })
+
+      if (!response.ok) {
+        const data = await response.json().catch(() => ({}))
+        throw new Error(data.error || 'Request failed')
+      }
+
+      setResetSent(true)
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Request failed')
+    } finally {
+      setLoading(false)
+    }
+  }
+
   const handleOnboarding = async (e: React.FormEvent) => {
     e.preventDefault()
     setError('')
@@ -154,9 +198,18 @@ export function Login() {
                   </div>
 
                   <div className="space-y-2">
-                    <Label htmlFor="password" className="text-sm text-foreground">
-                      Password
-                    </Label>
+                    <div className="flex items-center justify-between">
+                      <Label htmlFor="password" className="text-sm text-foreground">
+                        Password
+                      </Label>
+                      <button
+                        type="button"
+                        onClick={() => switchMode('forgot')}
+                        className="text-xs text-muted-foreground hover:text-foreground transition-colors"
+                      >
+                        Forgot password?
+                      </button>
+                    </div>
                     <div className="relative">
                       <Lock className="absolute left-3 top-1/2 -translate-y-1/2 h-4 w-4 text-muted-foreground" />
                       <Input
@@ -187,6 +240,75 @@ export function Login() {
               </>
             )}
 
+            {mode === 'forgot' && (
+              <>
+                <div className="mb-8">
+                  <h2 className="text-2xl font-semibold tracking-tight text-foreground">
+                    Forgot your password?
+                  </h2>
+                  <p className="text-muted-foreground mt-1">
+                    Enter your email and we'll send you a link to reset it
+                  </p>
+                </div>
+
+                {resetSent ? (
+                  <div className="space-y-5">
+                    <div className="flex items-start gap-2 text-sm text-foreground bg-secondary/50 border border-border p-3 rounded-lg">
+                      <CheckCircle2 className="h-4 w-4 shrink-0 mt-0.5 text-green-500" />
+                      <span>
+                        If an account exists for {email}, a reset link is on its way.
+                      </span>
+                    </div>
+                    <Button variant="outline" className="w-full h-11" onClick={() => switchMode('login')}>
+                      Back to sign in
+                    </Button>
+                  </div>
+                ) : (
+                  <form onSubmit={handleForgot} className="space-y-5">
+                    {error && (
+                      <div className="flex items-center gap-2 text-sm text-destructive bg-destructive/10 border border-destructive/20 p-3 rounded-lg">
+                        <AlertCircle className="h-4 w-4 shrink-0" />
+                        <span>{error}</span>
+                      </div>
+                    )}
+
+                    <div className="space-y-2">
+                      <Label htmlFor="forgot-email" className="text-sm text-foreground">
+                        Email address
+                      </Label>
+                      <div className="relative">
+                        <Mail className="absolute left-3 top-1/2 -translate-y-1/2 h-4 w-4 text-muted-foreground" />
+                        <Input
+                          id="forgot-email"
+                          type="email"
+                          value={email}
+                          onChange={(e) => setEmail(e.target.value)}
+                          placeholder="admin@example.com"
+                          required
+                          autoFocus
+                          autoComplete="email"
+                          className="pl-10 h-11"
+                        />
+                      </div>
+                    </div>
+
+                    <Button type="submit" className="w-full h-11" disabled={loading}>
+                      {loading && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}
+                      Send reset link
+                    </Button>
+
+                    <button
+                      type="button"
+                      onClick={() => switchMode('login')}
+                      className="w-full text-sm text-muted-foreground hover:text-foreground transition-colors"
+                    >
+                      Back to sign in
+                    </button>
+                  </form>
+                )}
+              </>
+            )}
+
             {mode === 'onboarding' && (
               <>
                 <div className="mb-8">
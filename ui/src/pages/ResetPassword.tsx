@@ -0,0 +1,130 @@
+import { useState } from 'react'
+import { Link, useSearchParams } from 'react-router-dom'
+import { AlertCircle, CheckCircle2, Loader2, Lock } from 'lucide-react'
+import { fetchAPI, ApiError } from '../lib/api'
+import { Button } from '../components/ui/button'
+import { Input } from '../components/ui/input'
+import { Label } from '../components/ui/label'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '../components/ui/card'
+
+// Sets a new password from the link in a password reset email. No login
+// required; the token in the link identifies the account.
+export function ResetPassword() {
+  const [searchParams] = useSearchParams()
+  const token = searchParams.get('token') || ''
+  const [password, setPassword] = useState('')
+  const [confirmPassword, setConfirmPassword] = useState('')
+  const [error, setError] = useState('')
+  const [loading, setLoading] = useState(false)
+  const [done, setDone] = useState(false)
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+
+    if (password.length < 8) {
+      setError('Password must be at least 8 characters')
+      return
+    }
+    if (password !== confirmPassword) {
+      setError('Passwords do not match')
+      return
+    }
+
+    setLoading(true)
+    try {
+      await fetchAPI('/api/auth/reset-password', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token, password }),
+      })
+      setDone(true)
+    } catch (err) {
+      if (err instanceof ApiError && err.status === 410) {
+        setError('This reset link has expired. Request a new one from the sign-in page.')
+      } else {
+        setError(err instanceof Error ? err.message : 'Failed to reset password')
+      }
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-background p-6">
+      <Card className="w-full max-w-sm">
+        <CardHeader>
+          <CardTitle>Reset your password</CardTitle>
+          <CardDescription>Choose a new password for your Etiquetta account</CardDescription>
+        </CardHeader>
+        <CardContent>
+          {!token ? (
+            <p className="text-sm text-muted-foreground">
+              This reset link is incomplete. Open the link from the email again, or{' '}
+              <Link to="/login?forgot=true" className="text-primary hover:underline">request a new one</Link>.
+            </p>
+          ) : done ? (
+            <div className="space-y-4">
+              <div className="flex items-center gap-2 text-sm">
+                <CheckCircle2 className="h-4 w-4 text-green-500 shrink-0" />
+                <span>Your password has been changed.</span>
+              </div>
+              <Button asChild className="w-full">
+                <Link to="/login">Sign in</Link>
+              </Button>
+            </div>
+          ) : (
+            <form onSubmit={handleSubmit} className="space-y-4">
+              {error && (
+                <div className="flex items-center gap-2 text-sm text-destructive bg-destructive/10 border border-destructive/20 p-3 rounded-lg">
+                  <AlertCircle className="h-4 w-4 shrink-0" />
+                  <span>{error}</span>
+                </div>
+              )}
+
+              <div className="space-y-2">
+                <Label htmlFor="reset-password">New password</Label>
+                <div className="relative">
+                  <Lock className="absolute left-3 top-1/2 -translate-y-1/2 h-4 w-4 text-muted-foreground" />
+                  <Input
+                    id="reset-password"
+                    type="password"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    required
+                    autoFocus
+                    autoComplete="new-password"
+                    minLength={8}
+                    className="pl-10"
+                  />
+                </div>
+                <p className="text-xs text-muted-foreground">Minimum 8 characters</p>
+              </div>
+
+              <div className="space-y-2">
+                <Label htmlFor="reset-confirm">Confirm password</Label>
+                <div className="relative">
+                  <Lock className="absolute left-3 top-1/2 -translate-y-1/2 h-4 w-4 text-muted-foreground" />
+                  <Input
+                    id="reset-confirm"
+                    type="password"
+                    value={confirmPassword}
+                    onChange={(e) => setConfirmPassword(e.target.value)}
+                    required
+                    autoComplete="new-password"
+                    className="pl-10"
+                  />
+                </div>
+              </div>
+
+              <Button type="submit" className="w-full" disabled={loading}>
+                {loading && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}
+                Set new password
+              </Button>
+            </form>
+          )}
+        </CardContent>
+      </Card>
+    </div>
+  )
+}
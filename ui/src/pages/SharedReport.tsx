@@ -0,0 +1,148 @@
+import { useState } from 'react'
+import { useParams } from 'react-router-dom'
+import { useQuery } from '@tanstack/react-query'
+import { format } from 'date-fns'
+import { Eye, Users, MousePointerClick, Lock } from 'lucide-react'
+import { fetchAPI, ApiError } from '../lib/api'
+import { StatCard, ProgressList } from '../components/dashboard'
+import { Card, CardContent, CardHeader, CardTitle } from '../components/ui/card'
+import { Input } from '../components/ui/input'
+import { Button } from '../components/ui/button'
+
+interface ShareInfo {
+  name: string
+  domain: string
+  start: number
+  end: number
+  requires_password: boolean
+}
+
+interface ShareReport {
+  name: string
+  domain: string
+  start: number
+  end: number
+  overview: { pageviews: number; unique_visitors: number; sessions: number; bounce_rate: number }
+  pages: { path: string; views: number }[]
+  referrers: { source: string; visits: number }[]
+  countries: { country: string; visitors: number }[]
+  devices: { device: string; visitors: number }[]
+  browsers: { browser: string; visitors: number }[]
+}
+
+// Read-only report for a public share link. No login required; the server
+// scopes everything to the share's domain and period.
+export function SharedReport() {
+  const { token } = useParams<{ token: string }>()
+  const [password, setPassword] = useState('')
+  const [submitted, setSubmitted] = useState('')
+
+  const info = useQuery({
+    queryKey: ['share', token],
+    queryFn: () => fetchAPI<ShareInfo>(`/api/public/shares/${token}`),
+    retry: false,
+  })
+
+  const report = useQuery({
+    queryKey: ['share', token, 'report', submitted],
+    queryFn: () =>
+      fetchAPI<ShareReport>(`/api/public/shares/${token}/report`, {
+        headers: submitted ? { 'X-Share-Password': submitted } : undefined,
+      }),
+    enabled: !!info.data && (!info.data.requires_password || !!submitted),
+    retry: false,
+  })
+
+  if (info.isError) {
+    return <Centered>This share link is invalid or has expired.</Centered>
+  }
+  if (!info.data) {
+    return <Centered>Loading…</Centered>
+  }
+
+  const wrongPassword = report.error instanceof ApiError && report.error.status === 401
+  if (info.data.requires_password && (!submitted || wrongPassword)) {
+    return (
+      <Centered>
+        <form
+          className="w-full max-w-sm space-y-3"
+          onSubmit={(e) => {
+            e.preventDefault()
+            setSubmitted(password)
+          }}
+        >
+          <div className="flex items-center gap-2 font-medium">
+            <Lock className="h-4 w-4" /> {info.data.name} is password protected
+          </div>
+          <Input type="password" value={password} onChange={(e) => setPassword(e.target.value)} autoFocus />
+          {wrongPassword && <p className="text-sm text-destructive">Incorrect password</p>}
+          <Button type="submit" className="w-full">View stats</Button>
+        </form>
+      </Centered>
+    )
+  }
+
+  const data = report.data
+  if (!data) {
+    return <Centered>{report.isError ? 'Failed to load stats.' : 'Loading…'}</Centered>
+  }
+
+  const list = (items: { label: string; value: number }[]) => {
+    const max = Math.max(1, ...items.map((i) => i.value))
+    return items.map((i) => ({ ...i, percentage: (i.value / max) * 100 }))
+  }
+
+  return (
+    <div className="min-h-screen bg-background p-6 space-y-6 max-w-6xl mx-auto">
+      <div>
+        <h1 className="text-2xl font-bold">{data.name}</h1>
+        <p className="text-muted-foreground">
+          {data.domain} · {format(new Date(data.start), 'MMM d, yyyy')} – {format(new Date(data.end), 'MMM d, yyyy')}
+        </p>
+      </div>
+      <div className="grid grid-cols-1 md:grid-cols-3 gap-4">
+        <StatCard title="Visitors" value={data.overview.unique_visitors.toLocaleString()} subtitle="unique" icon={Users} />
+        <StatCard title="Pageviews" value={data.overview.pageviews.toLocaleString()} subtitle="total" icon={Eye} />
+        <StatCard
+          title="Bounce rate"
+          value={`${data.overview.bounce_rate.toFixed(1)}%`}
+          subtitle={`${data.overview.sessions.toLocaleString()} sessions`}
+          icon={MousePointerClick}
+        />
+      </div>
+      <div className="grid grid-cols-1 lg:grid-cols-2 gap-6">
+        <Section title="Top pages">
+          <ProgressList items={list(data.pages.map((p) => ({ label: p.path, value: p.views })))} />
+        </Section>
+        <Section title="Sources">
+          <ProgressList items={list(data.referrers.map((r) => ({ label: r.source, value: r.visits })))} />
+        </Section>
+        <Section title="Countries">
+          <ProgressList items={list(data.countries.map((c) => ({ label: c.country, value: c.visitors })))} />
+        </Section>
+        <Section title="Devices">
+          <ProgressList items={list(data.devices.map((d) => ({ label: d.device, value: d.visitors })))} />
+        </Section>
+      </div>
+    </div>
+  )
+}
+
+function Section({ title, children }: { title: string; children: React.ReactNode }) {
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle className="text-base">{title}</CardTitle>
+      </CardHeader>
+      <CardContent>{children}</CardContent>
+    </Card>
+  )
+}
+
+function Centered({ children }: { children: React.ReactNode }) {
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-background p-6 text-muted-foreground">
+      {children}
+    </div>
+  )
+}
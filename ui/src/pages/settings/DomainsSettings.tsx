@@ -5,15 +5,24 @@ import { fetchAPI } from '@/lib/api'
 import { Button } from '@/components/ui/button'
 import { Input } from '@/components/ui/input'
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
-import { Globe, Copy, Trash2, Plus, Check } from 'lucide-react'
+import { Globe, Copy, Trash2, Plus, Check, Activity } from 'lucide-react'
 import { SettingsLayout } from './SettingsLayout'
 
+interface DomainVerification {
+  status: 'receiving' | 'no_events' | 'origin_mismatch' | 'wrong_site_id' | 'inactive'
+  last_event_at: number | null
+  last_origin: string
+  window_minutes: number
+  issues: { type: string; message: string }[]
+}
+
 export function DomainsSettings() {
   const { data: domains, isLoading } = useDomains()
   const createDomain = useCreateDomain()
   const deleteDomain = useDeleteDomain()
   const [newDomain, setNewDomain] = useState({ name: '', domain: '' })
   const [copiedId, setCopiedId] = useState<string | null>(null)
+  const [verifyingId, setVerifyingId] = useState<string | null>(null)
 
   async function handleAddDomain(e: React.FormEvent) {
     e.preventDefault()
@@ -40,6 +49,35 @@ export function DomainsSettings() {
     }
   }
 
+  async function verifyInstallation(id: string) {
+    setVerifyingId(id)
+    try {
+      const data = await fetchAPI<DomainVerification>(`/api/domains/${id}/verify`)
+      const issue = data.issues[0]?.message
+      switch (data.status) {
+        case 'receiving':
+          toast.success(`Receiving events${data.last_origin ? ` from ${data.last_origin}` : ''}`, {
+            description: issue,
+          })
+          break
+        case 'inactive':
+          toast.warning('This domain is inactive, so its events are ignored')
+          break
+        case 'no_events':
+          toast.info(`No events in the last ${data.window_minutes} minutes`, {
+            description: issue ?? 'Install the snippet, then open your site in a browser and check again.',
+          })
+          break
+        default:
+          toast.error('Events are being rejected', { description: issue })
+      }
+    } catch {
+      toast.error('Failed to verify installation')
+    } finally {
+      setVerifyingId(null)
+    }
+  }
+
   return (
     <SettingsLayout title="Domains" description="Manage your tracked domains">
       <Card>
@@ -91,6 +129,13 @@ export function DomainsSettings() {
                       <p className="font-medium">{domain.name}</p>
                       <p className="text-sm text-muted-foreground">{domain.domain}</p>
                       <p className="text-xs text-muted-foreground font-mono">{domain.site_id}</p>
+                      {domain.event_count !== undefined && (
+                        <p className="text-xs text-muted-foreground">
+                          {domain.event_count.toLocaleString()} events
+                          {domain.last_event_at ? `, last ${new Date(domain.last_event_at).toLocaleString()}` : ''}
+                          {domain.retention_days ? ` · kept ${domain.retention_days.events} days` : ''}
+                        </p>
+                      )}
                     </div>
                   </div>
                   <div className="flex items-center gap-2">
@@ -101,6 +146,14 @@ export function DomainsSettings() {
                         <><Copy className="h-4 w-4 mr-1" />Copy Snippet</>
                       )}
                     </Button>
+                    <Button
+                      variant="outline"
+                      size="sm"
+                      disabled={verifyingId === domain.id}
+                      onClick={() => verifyInstallation(domain.id)}
+                    >
+                      <Activity className="h-4 w-4 mr-1" />Verify
+                    </Button>
                     <Button variant="outline" size="sm" onClick={() => handleDeleteDomain(domain.id)}>
                       <Trash2 className="h-4 w-4" />
                     </Button>
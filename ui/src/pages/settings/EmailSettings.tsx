@@ -190,7 +190,7 @@ export function EmailSettings() {
             </div>
 
             <div className="space-y-2">
-              <Label htmlFor="email_base_url">Base URL</Label>
+              <Label htmlFor="email_base_url">Public URL</Label>
               <Input
                 id="email_base_url"
                 type="text"
@@ -204,7 +204,7 @@ export function EmailSettings() {
                 }}
               />
               <p className="text-xs text-muted-foreground">
-                Base URL for password reset links
+                Public address of this instance, used in emails, invite and share links, and tracking snippets
               </p>
             </div>
           </div>
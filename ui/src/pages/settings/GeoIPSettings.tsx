@@ -27,6 +27,13 @@ interface GeoIPStatus {
   configured: boolean
 }
 
+interface GeoIPDownloadProgress {
+  running: boolean
+  phase?: string
+  downloaded: number
+  total: number
+}
+
 export function GeoIPSettings() {
   const { isAdmin } = useAuth()
   const [geoipSettings, setGeoipSettings] = useState<GeoIPSettingsData | null>(null)
@@ -35,6 +42,7 @@ export function GeoIPSettings() {
   const [savingGeoip, setSavingGeoip] = useState(false)
   const [downloadingGeoip, setDownloadingGeoip] = useState(false)
   const [geoipResult, setGeoipResult] = useState<string | null>(null)
+  const [downloadProgress, setDownloadProgress] = useState<GeoIPDownloadProgress | null>(null)
 
   const hasGeoipChanges = useMemo(
     () => Object.keys(editedGeoipSettings).length > 0,
@@ -60,6 +68,22 @@ export function GeoIPSettings() {
     }
   }, [fetchGeoipSettings, isAdmin])
 
+  // Poll download progress while a download is running
+  useEffect(() => {
+    if (!downloadingGeoip) {
+      setDownloadProgress(null)
+      return
+    }
+    const timer = setInterval(async () => {
+      try {
+        setDownloadProgress(await fetchAPI<GeoIPDownloadProgress>('/api/settings/geoip/download/progress'))
+      } catch {
+        // Ignore transient polling errors
+      }
+    }, 1000)
+    return () => clearInterval(timer)
+  }, [downloadingGeoip])
+
   if (!isAdmin) {
     return <Navigate to="/settings/domains" replace />
   }
@@ -118,6 +142,16 @@ export function GeoIPSettings() {
     return `${(bytes / (1024 * 1024)).toFixed(1)} MB`
   }
 
+  function formatDownloadProgress(p: GeoIPDownloadProgress): string {
+    if (p.phase && p.phase !== 'downloading') {
+      return p.phase.charAt(0).toUpperCase() + p.phase.slice(1) + '...'
+    }
+    if (p.total > 0) {
+      return `Downloading ${Math.floor((p.downloaded * 100) / p.total)}%`
+    }
+    return `Downloading ${formatFileSize(p.downloaded)}`
+  }
+
   return (
     <SettingsLayout title="GeoIP" description="Configure MaxMind GeoIP for visitor location data">
       <Card>
@@ -250,7 +284,7 @@ export function GeoIPSettings() {
               ) : (
                 <Download className="mr-2 h-4 w-4" />
               )}
-              Download Database
+              {downloadProgress?.running ? formatDownloadProgress(downloadProgress) : 'Download Database'}
             </Button>
             <Button onClick={handleSaveGeoipSettings} disabled={savingGeoip || !hasGeoipChanges}>
               {savingGeoip && <Loader2 className="mr-2 h-4 w-4 animate-spin" />}